@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/NuLink-network/watcher/watcher/chains/ethereum"
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+var verifyAttestationCommand = cli.Command{
+	Name:      "verify-attestation",
+	Usage:     "verify the signed stake info sets of an attestation log",
+	ArgsUsage: "<attestation log> [<stake info file>]",
+	Description: "The verify-attestation command checks the signature of every attestation in an attestation\n" +
+		"\tlog written with --attestation-log. Given a stake info file, it also reports the epoch whose\n" +
+		"\tattested set the file holds. With --public-key the attestations must be signed with that key.",
+	Flags:  []cli.Flag{config.PublicKeyFlag},
+	Action: handleVerifyAttestationCmd,
+}
+
+func handleVerifyAttestationCmd(ctx *cli.Context) error {
+	if ctx.NArg() < 1 || ctx.NArg() > 2 {
+		return fmt.Errorf("verify-attestation requires an attestation log and optionally a stake info file")
+	}
+	atts, err := ethereum.ReadAttestations(ctx.Args().Get(0))
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", ctx.Args().Get(0), err)
+	}
+	var infos substrate.StakeInfos
+	if ctx.NArg() == 2 {
+		if infos, err = ethereum.ReadStakeInfos(ctx.Args().Get(1)); err != nil {
+			return fmt.Errorf("failed to read %s: %w", ctx.Args().Get(1), err)
+		}
+	}
+	key := ctx.String(config.PublicKeyFlag.Name)
+
+	w := ctx.App.Writer
+	invalid, matched := 0, false
+	for _, att := range atts {
+		err := ethereum.VerifyAttestation(att, nil)
+		if err == nil && key != "" && !strings.EqualFold(att.PublicKey, key) {
+			err = fmt.Errorf("signed by %s, want %s", att.PublicKey, key)
+		}
+		if err != nil {
+			invalid++
+			fmt.Fprintf(w, "epoch %d block %s invalid: %v\n", att.Epoch, att.Block, err)
+			continue
+		}
+		fmt.Fprintf(w, "epoch %d block %s ok, %d stakers signed by %s\n", att.Epoch, att.Block, att.Count, att.PublicKey)
+		if infos == nil {
+			continue
+		}
+		if err := ethereum.VerifyAttestation(att, infos); err == nil {
+			matched = true
+			fmt.Fprintf(w, "%s holds the set attested for epoch %d\n", ctx.Args().Get(1), att.Epoch)
+		} else if !errors.Is(err, ethereum.ErrAttestationInvalid) {
+			return err
+		}
+	}
+	fmt.Fprintf(w, "%d attestations, %d invalid\n", len(atts), invalid)
+	if invalid > 0 {
+		return fmt.Errorf("%d invalid attestations", invalid)
+	}
+	if infos != nil && !matched {
+		return fmt.Errorf("%s holds no attested set", ctx.Args().Get(1))
+	}
+	return nil
+}
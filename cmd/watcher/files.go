@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/urfave/cli/v2"
+
+	"github.com/NuLink-network/watcher/watcher/chains/ethereum"
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+var diffFilesCommand = cli.Command{
+	Name:      "diff-files",
+	Usage:     "show membership and balance changes between two stake info files",
+	ArgsUsage: "<a> <b>",
+	Description: "The diff-files command reads two stake info files and prints the stakers added, removed\n" +
+		"\tand whose locked balance changed going from <a> to <b>. Files written before balances were\n" +
+		"\tpersisted report a zero balance.",
+	Flags:  []cli.Flag{config.JSONFlag},
+	Action: handleDiffFilesCmd,
+}
+
+type stakerBalance struct {
+	Staker  string `json:"staker"`
+	Balance string `json:"balance"`
+}
+
+type stakerBalanceChange struct {
+	Staker     string `json:"staker"`
+	OldBalance string `json:"oldBalance"`
+	NewBalance string `json:"newBalance"`
+}
+
+type stakeInfoFileDiff struct {
+	Added   []stakerBalance       `json:"added"`
+	Removed []stakerBalance       `json:"removed"`
+	Changed []stakerBalanceChange `json:"changed"`
+}
+
+func newStakeInfoFileDiff(a, b substrate.StakeInfos) stakeInfoFileDiff {
+	old := make(map[string]*substrate.StakeInfo, len(a))
+	for _, info := range a {
+		old[string(info.WorkBase)] = info
+	}
+
+	d := substrate.DiffStakeInfos(a, b)
+	diff := stakeInfoFileDiff{
+		Added:   make([]stakerBalance, 0, len(d.Joined)),
+		Removed: make([]stakerBalance, 0, len(d.Left)),
+		Changed: make([]stakerBalanceChange, 0, len(d.Updated)),
+	}
+	for _, info := range d.Joined {
+		diff.Added = append(diff.Added, stakerBalance{Staker: stakerHex(info), Balance: info.LockedBalance.String()})
+	}
+	for _, info := range d.Left {
+		diff.Removed = append(diff.Removed, stakerBalance{Staker: stakerHex(info), Balance: info.LockedBalance.String()})
+	}
+	for _, info := range d.Updated {
+		diff.Changed = append(diff.Changed, stakerBalanceChange{
+			Staker:     stakerHex(info),
+			OldBalance: old[string(info.WorkBase)].LockedBalance.String(),
+			NewBalance: info.LockedBalance.String(),
+		})
+	}
+	return diff
+}
+
+func stakerHex(info *substrate.StakeInfo) string {
+	return ethcommon.BytesToAddress(info.WorkBase).Hex()
+}
+
+func handleDiffFilesCmd(ctx *cli.Context) error {
+	if ctx.NArg() != 2 {
+		return fmt.Errorf("diff-files requires exactly two stake info files")
+	}
+	a, err := ethereum.ReadStakeInfos(ctx.Args().Get(0))
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", ctx.Args().Get(0), err)
+	}
+	b, err := ethereum.ReadStakeInfos(ctx.Args().Get(1))
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", ctx.Args().Get(1), err)
+	}
+
+	diff := newStakeInfoFileDiff(a, b)
+	w := ctx.App.Writer
+	if ctx.Bool(config.JSONFlag.Name) {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(diff)
+	}
+
+	for _, s := range diff.Added {
+		fmt.Fprintf(w, "+ %s %s\n", s.Staker, s.Balance)
+	}
+	for _, s := range diff.Removed {
+		fmt.Fprintf(w, "- %s %s\n", s.Staker, s.Balance)
+	}
+	for _, s := range diff.Changed {
+		fmt.Fprintf(w, "~ %s %s -> %s\n", s.Staker, s.OldBalance, s.NewBalance)
+	}
+	fmt.Fprintf(w, "%d added, %d removed, %d changed\n", len(diff.Added), len(diff.Removed), len(diff.Changed))
+	return nil
+}
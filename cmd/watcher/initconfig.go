@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+var initConfigCommand = cli.Command{
+	Name:      "init-config",
+	Usage:     "write a sample config file",
+	ArgsUsage: "[file]",
+	Description: "The init-config command writes a sample config holding the default of every field and\n" +
+		"\tplaceholders for the endpoints and the deposit contract to [file], the --config file or the\n" +
+		"\tdefault config file. An existing file is only overwritten with --force.",
+	Flags:  []cli.Flag{config.FormatFlag, config.ForceFlag},
+	Action: handleInitConfigCmd,
+}
+
+func handleInitConfigCmd(ctx *cli.Context) error {
+	if ctx.NArg() > 1 {
+		return fmt.Errorf("init-config takes at most one file")
+	}
+	path := ctx.Args().First()
+	if path == "" {
+		path = ctx.String(config.ConfigFileFlag.Name)
+	}
+	if path == "" {
+		path = config.DefaultConfigFile()
+	}
+	sample, err := config.Sample(ctx.String(config.FormatFlag.Name))
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	flag := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if ctx.Bool(config.ForceFlag.Name) {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flag, 0600)
+	if os.IsExist(err) {
+		return fmt.Errorf("%s already exists, use --force to overwrite it", path)
+	} else if err != nil {
+		return err
+	}
+	if _, err := f.Write(sample); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(ctx.App.Writer, "wrote a sample config to %s, fill in the ethereum and NuLink endpoints and the deposit contract\n", path)
+	return nil
+}
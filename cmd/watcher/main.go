@@ -8,10 +8,16 @@ For configuration and CLI commands see the README: https://github.com/ChainSafe/
 package main
 
 import (
+	"context"
+	"fmt"
+	"math/big"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"syscall"
+	"time"
 
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/urfave/cli/v2"
@@ -19,7 +25,9 @@ import (
 	"github.com/NuLink-network/watcher/watcher/chains/ethereum"
 	"github.com/NuLink-network/watcher/watcher/chains/substrate"
 	"github.com/NuLink-network/watcher/watcher/config"
+	"github.com/NuLink-network/watcher/watcher/notify"
 	"github.com/NuLink-network/watcher/watcher/params"
+	"github.com/NuLink-network/watcher/watcher/sink"
 )
 
 var (
@@ -30,17 +38,43 @@ var (
 var cliFlags = []cli.Flag{
 	config.MockFlag,
 	config.VerbosityFlag,
+	config.QuietFlag,
+	config.TraceFlag,
 	config.ConfigFileFlag,
+	config.NetworkFlag,
+	config.NetworksFileFlag,
 	config.StakeInfoFileFlag,
+	config.StartBlockFileFlag,
+	config.AuditLogFlag,
+	config.AttestationLogFlag,
+	config.DumpScaleFlag,
+	config.MetricsFileFlag,
+	config.StatusAddrFlag,
+	config.CheckpointFileFlag,
+	config.HistoryDirFlag,
+	config.NoPersistFlag,
+	config.MaintenanceFlag,
+	config.AcceptChurnFlag,
+	config.CapBalanceFlag,
+	config.RecordFlag,
+	config.ReplayFlag,
 }
 
 func init() {
-	app.Action = run
+	app.Action = wrapConnHandler(run)
 	app.Version = Version
 	app.Copyright = "Copyright 2021 Watcher Systems Authors"
 	app.Name = "watcher"
 
 	app.Flags = append(app.Flags, cliFlags...)
+	app.Commands = []*cli.Command{
+		&diffFilesCommand,
+		&resubmitCommand,
+		&topnAtCommand,
+		&initConfigCommand,
+		&verifyAttestationCommand,
+		&verifyFileCommand,
+	}
 
 	//app.Before = func(ctx *cli.Context) error {
 	//	return setup(ctx)
@@ -57,34 +91,70 @@ func main() {
 	}
 }
 
-func InitializeChain(cfg *config.Config) (*ethereum.Listener, error) {
+// wrapConnHandler hands a shared ethereum connection pool to the action and closes all its connections
+// once the action returns
+func wrapConnHandler(hdl func(*cli.Context, *ethereum.ConnectionPool) error) cli.ActionFunc {
+	return func(ctx *cli.Context) error {
+		pool := ethereum.NewConnectionPool()
+		defer pool.CloseAll()
+		return hdl(ctx, pool)
+	}
+}
+
+func InitializeChain(cfg *config.Config, pool *ethereum.ConnectionPool) (*ethereum.Listener, error) {
 	stop := make(chan struct{}, 1)
-	ethconn := ethereum.NewConnection(cfg.EthereumConfig.URL, cfg.EthereumConfig.Http, stop)
-	if err := ethconn.Connect(); err != nil {
+	ethconn, err := pool.Get(cfg.EthereumConfig.URL, cfg.EthereumConfig.Http)
+	if err != nil {
 		return nil, err
 	}
+	ethconn.UseFinalizedTag = cfg.EthereumConfig.UseFinalizedTag
+	if pool.Replay != nil {
+		log.Warn("Replaying a recording, stake infos are submitted to a mock instead of the NuLink chain")
+		return ethereum.NewListener(cfg, ethconn, &substrate.MockSubmitter{}, stop), nil
+	}
+	if want := cfg.EthereumConfig.ChainID; want != nil {
+		got, err := ethconn.Client.ChainID(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the chain id: %w", err)
+		}
+		if got.Cmp(want) != 0 {
+			return nil, fmt.Errorf("ethereum node is on chain %s, want chain %s", got, want)
+		}
+	}
 
 	//kp, err := signature.KeyringPairFromSecret(cfg.NuLinkChainConfig.Seed, cfg.NuLinkChainConfig.Network)
 	//if err != nil {
 	//	return nil, err
 	//}
 
-	subconn := substrate.NewConnection(cfg.NuLinkChainConfig.URL, params.Watcher, stop)
+	keys, err := ethereum.NewSigningKeys(cfg.NuLinkChainConfig, params.Watcher)
+	if err != nil {
+		return nil, err
+	}
+	subconn := substrate.NewConnection(cfg.NuLinkChainConfig.URL, keys.Current, stop)
+	if cfg.NuLinkChainConfig.UpgradeRetries > 0 {
+		subconn.UpgradeRetries = cfg.NuLinkChainConfig.UpgradeRetries
+	}
+	subconn.DryRun = cfg.NuLinkChainConfig.DryRun
+	subconn.Tip = cfg.NuLinkChainConfig.Tip
+	if cfg.NuLinkChainConfig.StakeInfoItem != "" {
+		subconn.StakeInfoItem = cfg.NuLinkChainConfig.StakeInfoItem
+	}
 	if err := subconn.Connect(); err != nil {
 		return nil, err
 	}
+	if err := subconn.RegisterWatcher(); err != nil {
+		return nil, fmt.Errorf("failed to register watcher: %w", err)
+	}
 
-	return &ethereum.Listener{
-		Config:  cfg,
-		Ethconn: ethconn,
-		Subconn: subconn,
-		Stop:    stop,
-	}, nil
+	l := ethereum.NewListener(cfg, ethconn, subconn, stop)
+	l.SigningKeys = keys
+	return l, nil
 }
 
 var listener *ethereum.Listener
 
-func run(ctx *cli.Context) error {
+func run(ctx *cli.Context, pool *ethereum.ConnectionPool) error {
 	if !ctx.Bool(config.MockFlag.Name) {
 		panic("only supports start in mock mode")
 	}
@@ -108,18 +178,153 @@ func run(ctx *cli.Context) error {
 	//	cfg.EthereumConfig.StartBlock = number
 	//}
 
-	listener, err = InitializeChain(cfg)
+	record, replay := ctx.String(config.RecordFlag.Name), ctx.String(config.ReplayFlag.Name)
+	if record != "" && replay != "" {
+		return fmt.Errorf("--%s and --%s can't be combined", config.RecordFlag.Name, config.ReplayFlag.Name)
+	}
+	if record != "" {
+		if pool.Recorder, err = ethereum.NewRecorder(record); err != nil {
+			return fmt.Errorf("failed to start recording: %w", err)
+		}
+		defer func() {
+			if err := pool.Recorder.Close(); err != nil {
+				log.Error("failed to write the recording", "dir", record, "error", err)
+			}
+		}()
+	}
+	if replay != "" {
+		if pool.Replay, err = ethereum.LoadRecording(replay); err != nil {
+			return fmt.Errorf("failed to load the recording: %w", err)
+		}
+	}
+
+	listener, err = InitializeChain(cfg, pool)
 	if err != nil {
 		log.Error("failed to initialize chain", "error", err)
 		return err
 	}
 	//listener.LatestBlockPath = lp
 	listener.LastStakeInfoPath = ctx.String(config.StakeInfoFileFlag.Name)
-
-	if err := listener.Subconn.RegisterWatcher(); err != nil {
-		log.Error("failed to register watcher", "error", err)
+	listener.StartBlockPath = ctx.String(config.StartBlockFileFlag.Name)
+	listener.DumpScale = ctx.Bool(config.DumpScaleFlag.Name)
+	listener.MetricsPath = ctx.String(config.MetricsFileFlag.Name)
+	listener.DepositCheckpointPath = ctx.String(config.CheckpointFileFlag.Name)
+	listener.HistoryDir = ctx.String(config.HistoryDirFlag.Name)
+	for _, f := range []*cli.BoolFlag{config.MockFlag, config.DumpScaleFlag, config.NoPersistFlag, config.MaintenanceFlag} {
+		if ctx.Bool(f.Name) {
+			listener.Modes = append(listener.Modes, f.Name)
+		}
+	}
+	if path := ctx.String(config.AuditLogFlag.Name); path != "" {
+		listener.Audit = ethereum.NewAuditLog(path)
+	}
+	if listener.Sinks, err = sink.FromConfig(cfg.Sinks); err != nil {
 		return err
 	}
+	listener.Events = sink.NewEventCSV(cfg.EventExport)
+	if path := ctx.String(config.AttestationLogFlag.Name); path != "" {
+		key := params.Watcher
+		if listener.SigningKeys != nil {
+			key = listener.SigningKeys.Current
+		}
+		listener.Attester = ethereum.NewAttester(key, path)
+	}
+	if record != "" {
+		listener.Modes = append(listener.Modes, "record")
+	} else if replay != "" {
+		listener.Modes = append(listener.Modes, "replay")
+	}
+	if cfg.Replica.Enabled {
+		if primary := cfg.Replica.PrimaryAuditLog; primary != "" && filepath.Clean(primary) == filepath.Clean(ctx.String(config.AuditLogFlag.Name)) {
+			return fmt.Errorf("replica primaryAuditLog %s is the replica's own --%s", primary, config.AuditLogFlag.Name)
+		}
+		listener.Modes = append(listener.Modes, "replica")
+	}
+	if ctx.Bool(config.NoPersistFlag.Name) {
+		log.Warn("persistence disabled, all state is kept in memory and lost on exit")
+		listener.DisablePersistence()
+	}
+	if cfg.Notify.URL != "" {
+		webhook, err := notify.NewWebhook(cfg.Notify.URL, cfg.Notify.Template)
+		if err != nil {
+			return err
+		}
+		listener.Alerts = notify.NewAlerter(webhook, cfg.Notify.FailureThreshold, cfg.Notify.Timeout.Duration)
+	}
+
+	if s := ctx.String(config.CapBalanceFlag.Name); s != "" {
+		max, ok := new(big.Int).SetString(s, 10)
+		if !ok || max.Sign() < 0 {
+			return fmt.Errorf("invalid --%s %q, expected a non-negative integer", config.CapBalanceFlag.Name, s)
+		}
+		listener.Transform = ethereum.ChainTransforms(listener.Transform, ethereum.CapBalance(max))
+	}
+	if cfg.StakeSource.Type == config.StakeSourceSubgraph {
+		listener.Source = ethereum.NewSubgraphSource(cfg)
+	}
+
+	if listener.HistoryDir != "" && cfg.Retention.Enabled() {
+		pruner := &ethereum.Pruner{
+			Dir:       listener.HistoryDir,
+			MaxFiles:  cfg.Retention.MaxFiles,
+			MaxAge:    cfg.Retention.MaxAge.Duration,
+			Protected: []string{listener.LastStakeInfoPath, listener.StartBlockPath, listener.DepositCheckpointPath, listener.MetricsPath},
+			Clock:     listener.Clock,
+		}
+		pruneCtx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go pruner.Run(pruneCtx, cfg.Retention.Interval.Duration)
+	}
+
+	if h := cfg.NuLinkChainConfig.Halt; h.Enabled() {
+		if subconn, ok := listener.Subconn.(*substrate.Connection); ok {
+			key, err := subconn.HaltKey(h.Key, h.Pallet, h.Item)
+			if err != nil {
+				return fmt.Errorf("failed to resolve the halt flag: %w", err)
+			}
+			haltCtx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go subconn.WatchHalt(haltCtx, key, h.Interval.Duration)
+		}
+	}
+
+	listener.SetMaintenance(ctx.Bool(config.MaintenanceFlag.Name))
+	if len(maintenanceSignals) > 0 {
+		toggle := make(chan os.Signal, 1)
+		signal.Notify(toggle, maintenanceSignals...)
+		defer signal.Stop(toggle)
+		go func() {
+			for range toggle {
+				listener.SetMaintenance(!listener.InMaintenance())
+			}
+		}()
+	}
+	if ctx.Bool(config.AcceptChurnFlag.Name) {
+		listener.AcceptChurn()
+	}
+	if len(acceptChurnSignals) > 0 {
+		accept := make(chan os.Signal, 1)
+		signal.Notify(accept, acceptChurnSignals...)
+		defer signal.Stop(accept)
+		go func() {
+			for range accept {
+				listener.AcceptChurn()
+			}
+		}()
+	}
+
+	if addr := ctx.String(config.StatusAddrFlag.Name); addr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/status", listener.StatusHandler())
+		srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+		defer srv.Close()
+		go func() {
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Error("status endpoint failed", "addr", addr, "error", err)
+			}
+		}()
+		log.Info("Serving the status", "url", "http://"+addr+"/status")
+	}
 
 	go func() {
 		if err := listener.PollBlocks(); err != nil {
@@ -153,22 +358,36 @@ func setup(ctx *cli.Context) error {
 
 func exit(ctx *cli.Context) error {
 	log.Info("exit watcher...")
-	listener.Ethconn.Close()
 	//return ethereum.WriteStakeInfoToFile(ctx.String(config.StakeInfoFileFlag.Name))
 	return nil
 }
 
 func startLogger(ctx *cli.Context) error {
-	var lvl log.Lvl
-	glogger := log.NewGlogHandler(log.StreamHandler(os.Stderr, log.TerminalFormat(true)))
-
-	if lvlToInt, err := strconv.Atoi(ctx.String(config.VerbosityFlag.Name)); err == nil {
-		lvl = log.Lvl(lvlToInt)
-	} else if lvl, err = log.LvlFromString(ctx.String(config.VerbosityFlag.Name)); err != nil {
+	lvl, err := logLevel(ctx)
+	if err != nil {
 		return err
 	}
+	glogger := log.NewGlogHandler(log.StreamHandler(os.Stderr, log.TerminalFormat(true)))
 	glogger.Verbosity(lvl)
 	log.Root().SetHandler(glogger)
 
 	return nil
 }
+
+// logLevel resolves the log level, --quiet and --trace take precedence over --verbosity and can't be combined
+func logLevel(ctx *cli.Context) (log.Lvl, error) {
+	quiet, trace := ctx.Bool(config.QuietFlag.Name), ctx.Bool(config.TraceFlag.Name)
+	switch {
+	case quiet && trace:
+		return 0, fmt.Errorf("--%s and --%s can't be used together", config.QuietFlag.Name, config.TraceFlag.Name)
+	case quiet:
+		return log.LvlError, nil
+	case trace:
+		return log.LvlTrace, nil
+	}
+
+	if lvlToInt, err := strconv.Atoi(ctx.String(config.VerbosityFlag.Name)); err == nil {
+		return log.Lvl(lvlToInt), nil
+	}
+	return log.LvlFromString(ctx.String(config.VerbosityFlag.Name))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/NuLink-network/watcher/watcher/chains/ethereum"
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+var resubmitCommand = cli.Command{
+	Name:  "resubmit",
+	Usage: "submit the stake infos of a past epoch again",
+	Description: "The resubmit command reads the stake infos recorded for --epoch in the --history-dir, selects\n" +
+		"\tthe top stakers and submits them to the NuLink chain without waiting for the live loop.\n" +
+		"\tEpochs that haven't started yet are refused. With --dry-run the stakers are only printed.",
+	Flags:  []cli.Flag{config.EpochFlag, config.DryRunFlag, config.JSONFlag},
+	Action: wrapConnHandler(handleResubmitCmd),
+}
+
+func handleResubmitCmd(ctx *cli.Context, pool *ethereum.ConnectionPool) error {
+	if err := setup(ctx); err != nil {
+		return err
+	}
+	cfg, err := config.GetConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	dryRun := ctx.Bool(config.DryRunFlag.Name)
+	var l *ethereum.Listener
+	if dryRun {
+		ethconn, err := pool.Get(cfg.EthereumConfig.URL, cfg.EthereumConfig.Http)
+		if err != nil {
+			return err
+		}
+		ethconn.UseFinalizedTag = cfg.EthereumConfig.UseFinalizedTag
+		l = ethereum.NewListener(cfg, ethconn, nil, nil)
+	} else if l, err = InitializeChain(cfg, pool); err != nil {
+		return err
+	}
+	l.HistoryDir = ctx.String(config.HistoryDirFlag.Name)
+	if path := ctx.String(config.AuditLogFlag.Name); path != "" {
+		l.Audit = ethereum.NewAuditLog(path)
+	}
+
+	epoch := ctx.Uint64(config.EpochFlag.Name)
+	infos, err := l.Resubmit(epoch, dryRun)
+	if err != nil {
+		return err
+	}
+
+	w := ctx.App.Writer
+	stakers := make([]stakerBalance, 0, len(infos))
+	for _, info := range infos {
+		stakers = append(stakers, stakerBalance{Staker: stakerHex(info), Balance: info.LockedBalance.String()})
+	}
+	if ctx.Bool(config.JSONFlag.Name) {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(stakers)
+	}
+	for _, s := range stakers {
+		fmt.Fprintf(w, "%s %s\n", s.Staker, s.Balance)
+	}
+	if dryRun {
+		fmt.Fprintf(w, "%d stakers of epoch %d, not submitted\n", len(stakers), epoch)
+	} else {
+		fmt.Fprintf(w, "%d stakers of epoch %d resubmitted\n", len(stakers), epoch)
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// maintenanceSignals toggle the maintenance mode of the listener
+var maintenanceSignals = []os.Signal{syscall.SIGUSR1}
+
+// acceptChurnSignals confirm the stake info set held back by maxChurnPercent
+var acceptChurnSignals = []os.Signal{syscall.SIGUSR2}
@@ -0,0 +1,9 @@
+package main
+
+import "os"
+
+// maintenanceSignals is empty on windows, which has no SIGUSR1; use --maintenance and restart instead
+var maintenanceSignals []os.Signal
+
+// acceptChurnSignals is empty on windows, which has no SIGUSR2; use --accept-churn and restart instead
+var acceptChurnSignals []os.Signal
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/NuLink-network/watcher/watcher/chains/ethereum"
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+var topnAtCommand = cli.Command{
+	Name:  "topn-at",
+	Usage: "print the top stakers as of a past block",
+	Description: "The topn-at command reads the stake infos of the deposit contract as of --block and prints\n" +
+		"\tthe top stakers the watcher would select, without submitting them. Blocks whose state the\n" +
+		"\tethereum node pruned can't be read, use an archive node for old blocks.",
+	Flags:  []cli.Flag{config.BlockFlag, config.JSONFlag},
+	Action: wrapConnHandler(handleTopnAtCmd),
+}
+
+func handleTopnAtCmd(ctx *cli.Context, pool *ethereum.ConnectionPool) error {
+	if err := setup(ctx); err != nil {
+		return err
+	}
+	cfg, err := config.GetConfig(ctx)
+	if err != nil {
+		return err
+	}
+	ethconn, err := pool.Get(cfg.EthereumConfig.URL, cfg.EthereumConfig.Http)
+	if err != nil {
+		return err
+	}
+	l := ethereum.NewListener(cfg, ethconn, nil, nil)
+
+	block := new(big.Int).SetUint64(ctx.Uint64(config.BlockFlag.Name))
+	infos, err := l.TopAt(block)
+	if err != nil {
+		return err
+	}
+
+	w := ctx.App.Writer
+	stakers := make([]stakerBalance, 0, len(infos))
+	for _, info := range infos {
+		stakers = append(stakers, stakerBalance{Staker: stakerHex(info), Balance: info.LockedBalance.String()})
+	}
+	if ctx.Bool(config.JSONFlag.Name) {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(stakers)
+	}
+	for _, s := range stakers {
+		fmt.Fprintf(w, "%s %s\n", s.Staker, s.Balance)
+	}
+	fmt.Fprintf(w, "%d stakers at block %s\n", len(stakers), block)
+	return nil
+}
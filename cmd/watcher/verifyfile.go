@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/NuLink-network/watcher/watcher/chains/ethereum"
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+var verifyFileCommand = cli.Command{
+	Name:  "verify-file",
+	Usage: "compare a stake info file with the top stakers on chain",
+	Description: "The verify-file command reads the stake info file --file and the top stakers the watcher\n" +
+		"\twould select at the current safe head, and prints the stakers that agree, the ones only on\n" +
+		"\tchain or only in the file and the ones whose locked balance differs. It fails if the membership\n" +
+		"\tor the balance diverge by more than --max-divergence percent. Nothing is submitted or written.",
+	Flags:  []cli.Flag{config.FileFlag, config.MaxDivergenceFlag, config.JSONFlag},
+	Action: wrapConnHandler(handleVerifyFileCmd),
+}
+
+type fileVerification struct {
+	Block  string          `json:"block"`
+	Agreed []stakerBalance `json:"agreed"`
+	stakeInfoFileDiff
+	Members float64 `json:"membershipDivergence"`
+	Balance float64 `json:"balanceDivergence"`
+}
+
+func handleVerifyFileCmd(ctx *cli.Context, pool *ethereum.ConnectionPool) error {
+	if err := setup(ctx); err != nil {
+		return err
+	}
+	cfg, err := config.GetConfig(ctx)
+	if err != nil {
+		return err
+	}
+	path := ctx.String(config.FileFlag.Name)
+	infos, err := ethereum.ReadStakeInfos(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	ethconn, err := pool.Get(cfg.EthereumConfig.URL, cfg.EthereumConfig.Http)
+	if err != nil {
+		return err
+	}
+	ethconn.UseFinalizedTag = cfg.EthereumConfig.UseFinalizedTag
+	l := ethereum.NewListener(cfg, ethconn, nil, nil)
+
+	v, err := l.VerifyStakeInfos(infos)
+	if err != nil {
+		return err
+	}
+	out := fileVerification{
+		Block:             v.Block.String(),
+		Agreed:            make([]stakerBalance, 0, len(v.Agreed)),
+		stakeInfoFileDiff: newStakeInfoFileDiff(infos, v.Top),
+		Members:           v.Members,
+		Balance:           v.Balance,
+	}
+	for _, info := range v.Agreed {
+		out.Agreed = append(out.Agreed, stakerBalance{Staker: stakerHex(info), Balance: info.LockedBalance.String()})
+	}
+
+	w := ctx.App.Writer
+	if ctx.Bool(config.JSONFlag.Name) {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(out); err != nil {
+			return err
+		}
+	} else {
+		for _, s := range out.Agreed {
+			fmt.Fprintf(w, "= %s %s\n", s.Staker, s.Balance)
+		}
+		for _, s := range out.Added {
+			fmt.Fprintf(w, "+ %s %s only on chain\n", s.Staker, s.Balance)
+		}
+		for _, s := range out.Removed {
+			fmt.Fprintf(w, "- %s %s only in the file\n", s.Staker, s.Balance)
+		}
+		for _, s := range out.Changed {
+			fmt.Fprintf(w, "~ %s %s in the file, %s on chain\n", s.Staker, s.OldBalance, s.NewBalance)
+		}
+		fmt.Fprintf(w, "%d agree, %d only on chain, %d only in the file, %d changed at block %s: %.1f%% of the stakers and %.1f%% of the locked balance diverge\n",
+			len(out.Agreed), len(out.Added), len(out.Removed), len(out.Changed), out.Block, out.Members, out.Balance)
+	}
+	if max := ctx.Float64(config.MaxDivergenceFlag.Name); v.Diverges(max) {
+		return fmt.Errorf("%s diverges from the chain by %.1f%% of the stakers and %.1f%% of the locked balance, over %g%%", path, v.Members, v.Balance, max)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package ethereum
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	schnorrkel "github.com/ChainSafe/go-schnorrkel"
+	"github.com/centrifuge/go-substrate-rpc-client/v4/signature"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+)
+
+// attestationDomain prefixes the signed message of an attestation, so its signature can't be replayed as one
+// over anything else the key signs
+const attestationDomain = "nulink-watcher/attestation/v1"
+
+// ErrAttestationInvalid is returned by VerifyAttestation for an attestation whose signature doesn't hold
+var ErrAttestationInvalid = errors.New("invalid attestation")
+
+// Attestation is a top-N set computed at an epoch boundary signed with the sr25519 key of the watcher
+// account, so third parties can verify the watcher produced it. The hash is the payloadHash of the set
+// encoded in PayloadVersion, the signature covers the epoch, the block and the hash.
+type Attestation struct {
+	Epoch          uint64    `json:"epoch"`
+	Block          *big.Int  `json:"block"`
+	Count          int       `json:"count"`
+	PayloadVersion int       `json:"payloadVersion"`
+	PayloadHash    string    `json:"payloadHash"`
+	PublicKey      string    `json:"publicKey"`
+	Address        string    `json:"address,omitempty"`
+	Signature      string    `json:"signature"`
+	Time           time.Time `json:"time"`
+}
+
+// message returns the bytes signed for a
+func (a *Attestation) message() []byte {
+	return []byte(fmt.Sprintf("%s:%d:%s:%s", attestationDomain, a.Epoch, a.Block, a.PayloadHash))
+}
+
+// Attester signs the set of every epoch with Key and appends the attestations as json lines to a file,
+// reopened per line like the AuditLog
+type Attester struct {
+	Key  *signature.KeyringPair
+	path string
+	mu   sync.Mutex
+}
+
+func NewAttester(key *signature.KeyringPair, path string) *Attester {
+	return &Attester{Key: key, path: path}
+}
+
+// Sign returns the attestation of infos computed at block for epoch
+func (a *Attester) Sign(epoch uint64, block *big.Int, version int, infos substrate.StakeInfos) (*Attestation, error) {
+	payload, err := substrate.EncodePayload(version, infos)
+	if err != nil {
+		return nil, err
+	}
+	att := &Attestation{
+		Epoch:          epoch,
+		Block:          block,
+		Count:          len(infos),
+		PayloadVersion: version,
+		PayloadHash:    payloadHash(payload),
+		PublicKey:      hexutil.Encode(a.Key.PublicKey),
+		Address:        a.Key.Address,
+	}
+	sig, err := signature.Sign(att.message(), a.Key.URI)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign the attestation: %w", err)
+	}
+	att.Signature = hexutil.Encode(sig)
+	return att, nil
+}
+
+// Append writes att as a single line. A nil Attester discards it.
+func (a *Attester) Append(att *Attestation) error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return appendJSONLine(a.path, att)
+}
+
+// VerifyAttestation checks the signature of att against its public key and, given infos, that att attests
+// exactly that set
+func VerifyAttestation(att *Attestation, infos substrate.StakeInfos) error {
+	pub, err := hexutil.Decode(att.PublicKey)
+	if err != nil || len(pub) != 32 {
+		return fmt.Errorf("%w: malformed public key %q", ErrAttestationInvalid, att.PublicKey)
+	}
+	sig, err := hexutil.Decode(att.Signature)
+	if err != nil || len(sig) != 64 {
+		return fmt.Errorf("%w: malformed signature", ErrAttestationInvalid)
+	}
+	var pubKey [32]byte
+	var sigBytes [64]byte
+	copy(pubKey[:], pub)
+	copy(sigBytes[:], sig)
+	s := new(schnorrkel.Signature)
+	if err := s.Decode(sigBytes); err != nil {
+		return fmt.Errorf("%w: %v", ErrAttestationInvalid, err)
+	}
+	if !schnorrkel.NewPublicKey(pubKey).Verify(s, schnorrkel.NewSigningContext([]byte("substrate"), att.message())) {
+		return fmt.Errorf("%w: signature doesn't match epoch %d", ErrAttestationInvalid, att.Epoch)
+	}
+	if infos == nil {
+		return nil
+	}
+	payload, err := substrate.EncodePayload(att.PayloadVersion, infos)
+	if err != nil {
+		return err
+	}
+	if hash := payloadHash(payload); hash != att.PayloadHash {
+		return fmt.Errorf("%w: set hashes to %s, attested %s", ErrAttestationInvalid, hash, att.PayloadHash)
+	}
+	return nil
+}
+
+// ReadAttestations reads the attestations appended to the file at path
+func ReadAttestations(path string) ([]*Attestation, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var atts []*Attestation
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 4096), 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		att := new(Attestation)
+		if err := json.Unmarshal(scanner.Bytes(), att); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		atts = append(atts, att)
+	}
+	return atts, scanner.Err()
+}
+
+// attest signs and appends the attestation of the set computed at the epoch boundary block, a failure is
+// only logged
+func (l *Listener) attest(block *big.Int, infos substrate.StakeInfos) {
+	if l.Attester == nil {
+		return
+	}
+	// signed with the key its submission is
+	l.rotateKey(block)
+	att, err := l.Attester.Sign(l.Config.Epoch(block.Uint64()), block, l.Config.PayloadVersion, infos)
+	if err == nil {
+		att.Time = l.clock().Now().UTC()
+		err = l.Attester.Append(att)
+	}
+	if err != nil {
+		log.Error("failed to write the attestation", "block", block, "error", err)
+		return
+	}
+	log.Debug("attested the stake info set", "block", block, "epoch", att.Epoch, "payloadHash", att.PayloadHash)
+}
@@ -0,0 +1,83 @@
+package ethereum
+
+import (
+	"errors"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+	"github.com/NuLink-network/watcher/watcher/params"
+)
+
+func TestAttester_SignVerify(t *testing.T) {
+	infos := substrate.StakeInfos{{Coinbase: Coinbase[0], WorkBase: WorkBase[0], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(10))}}
+	other := substrate.StakeInfos{{Coinbase: Coinbase[0], WorkBase: WorkBase[0], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(11))}}
+	a := NewAttester(params.Watcher, filepath.Join(t.TempDir(), "attestations.jsonl"))
+	att, err := a.Sign(3, big.NewInt(3000), 1, infos)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	if err := VerifyAttestation(att, infos); err != nil {
+		t.Fatalf("VerifyAttestation() error = %v", err)
+	}
+	if err := VerifyAttestation(att, other); !errors.Is(err, ErrAttestationInvalid) {
+		t.Errorf("VerifyAttestation() of another set error = %v, want %v", err, ErrAttestationInvalid)
+	}
+
+	tampered := *att
+	tampered.Epoch = 4
+	if err := VerifyAttestation(&tampered, nil); !errors.Is(err, ErrAttestationInvalid) {
+		t.Errorf("VerifyAttestation() of a changed epoch error = %v, want %v", err, ErrAttestationInvalid)
+	}
+	forged := *att
+	forged.PublicKey = "0x" + ethcommon.Bytes2Hex(make([]byte, 32))
+	if err := VerifyAttestation(&forged, nil); !errors.Is(err, ErrAttestationInvalid) {
+		t.Errorf("VerifyAttestation() under another key error = %v, want %v", err, ErrAttestationInvalid)
+	}
+}
+
+// The set computed at an epoch boundary is attested and the attestation verifies against the stake info file
+func TestListener_attest(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = false
+	resetStakeInfoList()
+
+	staker := ethcommon.BytesToAddress(WorkBase[0])
+	var tags []string
+	conn := newTestConnection(t, map[string]rpcHandler{"eth_call": stakingContract(t, map[string]map[ethcommon.Address]int64{"latest": {staker: 10}}, &tags)})
+	dir := t.TempDir()
+	path := filepath.Join(dir, "attestations.jsonl")
+	l := &Listener{
+		Config: &config.Config{EpochSize: 1000, SubmitMode: config.SubmitModeFull,
+			EthereumConfig: config.EthereumConfig{BlockConfirmations: big.NewInt(10)}},
+		Ethconn:           conn,
+		Subconn:           &substrate.MockSubmitter{},
+		LastStakeInfoPath: filepath.Join(dir, "stake-info.json"),
+		Attester:          NewAttester(params.Watcher, path),
+		Clock:             &fakeClock{now: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	if err := l.syncStakeInfos(big.NewInt(2000)); err != nil {
+		t.Fatal(err)
+	}
+
+	atts, err := ReadAttestations(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(atts) != 1 || atts[0].Epoch != 2 || atts[0].Count != 1 || !atts[0].Time.Equal(l.Clock.Now()) {
+		t.Fatalf("attestations = %+v, want one of epoch 2", atts)
+	}
+	infos, err := ReadStakeInfos(l.LastStakeInfoPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyAttestation(atts[0], infos); err != nil {
+		t.Errorf("VerifyAttestation() of the persisted set error = %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package ethereum
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+)
+
+const (
+	AuditResultSuccess = "success"
+	AuditResultFailure = "failure"
+)
+
+// AuditRecord is a single line of the audit log, describing one stake info submission
+type AuditRecord struct {
+	Epoch          uint64    `json:"epoch"`
+	Epochs         []uint64  `json:"epochs,omitempty"`
+	Block          *big.Int  `json:"block"`
+	Count          int       `json:"count"`
+	PayloadVersion int       `json:"payloadVersion"`
+	PayloadHash    string    `json:"payloadHash"`
+	Extrinsic      string    `json:"extrinsic,omitempty"`
+	Result         string    `json:"result"`
+	Error          string    `json:"error,omitempty"`
+	Time           time.Time `json:"time"`
+}
+
+// AuditLog appends AuditRecords as json lines to a file. The file is opened for every record and synced
+// before it is closed again, so records survive crashes and the file can be rotated at any time.
+type AuditLog struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewAuditLog(path string) *AuditLog {
+	return &AuditLog{path: path}
+}
+
+// Append writes r as a single line. A nil AuditLog discards the record.
+func (a *AuditLog) Append(r AuditRecord) error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return appendJSONLine(a.path, r)
+}
+
+// appendJSONLine appends v as a json line to the file at path, synced before the file is closed again
+func appendJSONLine(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// payloadHash is the keccak256 hash of the SCALE encoded payload, as it is sent to the chain
+func payloadHash(payload interface{}) string {
+	data, err := types.EncodeToBytes(payload)
+	if err != nil {
+		return ""
+	}
+	return crypto.Keccak256Hash(data).Hex()
+}
+
+// dumpScale logs the SCALE encoding of the UpdateStakeInfo argument instead of submitting it
+func dumpScale(block *big.Int, version, count int, payload interface{}) error {
+	data, err := types.EncodeToBytes(payload)
+	if err != nil {
+		return fmt.Errorf("failed to SCALE encode stake info: %w", err)
+	}
+	log.Info("SCALE encoded stake info, not submitted", "method", substrate.UpdateStakeInfo, "block", block, "count", count, "payloadVersion", version, "hex", hexutil.Encode(data))
+	return nil
+}
+
+// submitStakeInfos submits infos to the NuLink chain in the configured PayloadVersion and records the
+// submission in the audit log. With DumpScale the payload is only logged. A non zero deadline abandons the
+// submission with ErrSubmissionLate once it passes before the extrinsic is sent.
+func (l *Listener) submitStakeInfos(block *big.Int, infos substrate.StakeInfos, deadline time.Time) error {
+	return l.submitEpochs(block, nil, infos, deadline)
+}
+
+// submitEpochs is submitStakeInfos for a batched submission, its audit record lists the epochs it covers
+func (l *Listener) submitEpochs(block *big.Int, epochs []uint64, infos substrate.StakeInfos, deadline time.Time) error {
+	version := l.Config.PayloadVersion
+	payload, err := substrate.EncodePayload(version, infos)
+	if err != nil {
+		return err
+	}
+	if l.DumpScale {
+		return dumpScale(block, version, len(infos), payload)
+	}
+	log.Debug("submitting stake info", "block", block, "count", len(infos), "payloadVersion", version)
+	l.rotateKey(block)
+	hash, err := l.submitBefore(deadline, payload)
+	if errors.Is(err, ErrSubmissionLate) {
+		l.stats.LateSubmissions++
+	}
+	l.Alerts.Record(err)
+
+	r := AuditRecord{
+		Block:          block,
+		Epochs:         epochs,
+		Count:          len(infos),
+		PayloadVersion: version,
+		PayloadHash:    payloadHash(payload),
+		Result:         AuditResultSuccess,
+		Time:           l.clock().Now().UTC(),
+	}
+	if l.Config.EpochSize > 0 {
+		r.Epoch = l.Config.Epoch(block.Uint64())
+	}
+	if err != nil {
+		r.Result = AuditResultFailure
+		r.Error = err.Error()
+		l.stats.SubmitErrors++
+	} else {
+		r.Extrinsic = hash.Hex()
+		l.stats.LastSubmissionEpoch = r.Epoch
+		l.stats.LastSubmissionTime = r.Time
+		l.recordOwnSubmission(payload)
+	}
+	if aerr := l.Audit.Append(r); aerr != nil {
+		log.Error("failed to write audit record", "block", block, "error", aerr)
+	}
+	return err
+}
+
+// submitBefore submits payload, abandoning it with ErrSubmissionLate when deadline passes before its extrinsic
+// is sent. A submission sent in time can't be called back, it is waited for and counted late if it only
+// returns after deadline.
+func (l *Listener) submitBefore(deadline time.Time, payload interface{}) (types.Hash, error) {
+	if deadline.IsZero() {
+		return l.Subconn.SubmitTxHash(context.Background(), substrate.UpdateStakeInfo, payload)
+	}
+	remaining := deadline.Sub(l.clock().Now())
+	if remaining <= 0 {
+		return types.Hash{}, fmt.Errorf("%w: deadline passed %s ago before submitting", ErrSubmissionLate, -remaining)
+	}
+
+	ctx, cancel := l.withTimeout(context.Background(), remaining)
+	defer cancel()
+	hash, err := l.Subconn.SubmitTxHash(ctx, substrate.UpdateStakeInfo, payload)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return types.Hash{}, fmt.Errorf("%w: not sent within %s: %v", ErrSubmissionLate, remaining, err)
+	}
+	if err == nil && ctx.Err() != nil {
+		log.Warn("stake info update sent in time returned after its deadline", "deadline", deadline, "hash", hash.Hex())
+		l.stats.LateSubmissions++
+	}
+	return hash, err
+}
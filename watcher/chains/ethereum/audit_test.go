@@ -0,0 +1,136 @@
+package ethereum
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+func TestAuditLog_Append(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "audit.jsonl")
+	a := NewAuditLog(path)
+
+	records := []AuditRecord{
+		{Epoch: 1, Block: big.NewInt(1000), Count: 2, PayloadHash: "0x01", Extrinsic: "0x02", Result: AuditResultSuccess, Time: time.Unix(1, 0).UTC()},
+		{Epoch: 2, Block: big.NewInt(2000), Result: AuditResultFailure, Error: "submit failed", Time: time.Unix(2, 0).UTC()},
+	}
+	if err := a.Append(records[0]); err != nil {
+		t.Fatal(err)
+	}
+	// a rotated file is not written to anymore
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Append(records[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, file := range []string{path + ".1", path} {
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var lines []AuditRecord
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var r AuditRecord
+			if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				t.Fatal(err)
+			}
+			lines = append(lines, r)
+		}
+		f.Close()
+		if len(lines) != 1 || lines[0].Epoch != records[i].Epoch || lines[0].Result != records[i].Result || lines[0].Error != records[i].Error {
+			t.Errorf("%s holds %+v, want %+v", file, lines, records[i])
+		}
+	}
+
+	var nilLog *AuditLog
+	if err := nilLog.Append(records[0]); err != nil {
+		t.Errorf("Append() on nil AuditLog error = %v", err)
+	}
+}
+
+func TestPayloadHash(t *testing.T) {
+	a := substrate.StakeInfos{{Coinbase: Coinbase[0], WorkBase: WorkBase[0], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(1))}}
+	b := substrate.StakeInfos{{Coinbase: Coinbase[0], WorkBase: WorkBase[0], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(2))}}
+	if payloadHash(a) == "" || payloadHash(a) != payloadHash(a) {
+		t.Errorf("payloadHash() is not stable: %s", payloadHash(a))
+	}
+	if payloadHash(a) == payloadHash(b) {
+		t.Errorf("payloadHash() is equal for different payloads")
+	}
+}
+
+func TestListener_submitStakeInfosDumpScale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l := &Listener{
+		Config:    &config.Config{EpochSize: 1000},
+		Audit:     NewAuditLog(path),
+		DumpScale: true,
+	}
+	infos := substrate.StakeInfos{{Coinbase: Coinbase[0], WorkBase: WorkBase[0], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(1))}}
+	// Subconn is nil, so this fails if anything is submitted
+	if err := l.submitStakeInfos(big.NewInt(1000), infos, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("submitStakeInfos() wrote an audit record for a dumped payload")
+	}
+}
+
+func TestListener_submitStakeInfos(t *testing.T) {
+	infos := substrate.StakeInfos{{Coinbase: Coinbase[0], WorkBase: WorkBase[0], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(1))}}
+	failed := errors.New("pool full")
+	tests := []struct {
+		name       string
+		err        error
+		wantResult string
+	}{
+		{name: "success", wantResult: AuditResultSuccess},
+		{name: "failure", err: failed, wantResult: AuditResultFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "audit.jsonl")
+			sub := &substrate.MockSubmitter{Err: tt.err, Hash: types.NewHash([]byte{1})}
+			l := &Listener{Config: &config.Config{EpochSize: 1000}, Subconn: sub, Audit: NewAuditLog(path)}
+
+			err := l.submitStakeInfos(big.NewInt(2000), infos, time.Time{})
+			if (tt.err != nil) != errors.Is(err, substrate.ErrSubmitFailed) {
+				t.Fatalf("submitStakeInfos() error = %v, want %v", err, tt.err)
+			}
+			calls := sub.Calls()
+			if len(calls) != 1 || calls[0].Method != substrate.UpdateStakeInfo || !reflect.DeepEqual(calls[0].Args, []interface{}{infos}) {
+				t.Errorf("submitStakeInfos() calls = %+v", calls)
+			}
+
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var r AuditRecord
+			if err := json.Unmarshal(data, &r); err != nil {
+				t.Fatal(err)
+			}
+			if r.Epoch != 2 || r.Result != tt.wantResult || r.Count != 1 {
+				t.Errorf("audit record = %+v", r)
+			}
+			if tt.err == nil && r.Extrinsic != sub.Hash.Hex() {
+				t.Errorf("audit record extrinsic = %s, want %s", r.Extrinsic, sub.Hash.Hex())
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package ethereum
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/notify"
+)
+
+// checkOperatorBalance compares the free balance of the signing account with MinOperatorBalance, at
+// startup for a nil block and otherwise at every BalanceCheckEpochs-th epoch boundary. A balance falling
+// below it is warned about and alerted once, until it is topped up again. A failed read is only logged.
+func (l *Listener) checkOperatorBalance(block *big.Int) {
+	nc := l.Config.NuLinkChainConfig
+	if nc.MinOperatorBalance == nil {
+		return
+	}
+	if block != nil && (nc.BalanceCheckEpochs == 0 || l.Config.Epoch(block.Uint64())%nc.BalanceCheckEpochs != 0) {
+		return
+	}
+	r, ok := l.Subconn.(substrate.BalanceReader)
+	if !ok {
+		return
+	}
+	free, err := r.FreeBalance()
+	if err != nil {
+		log.Warn("Failed to read the balance of the signing account", "block", block, "error", err)
+		return
+	}
+	l.operatorBalance = free
+
+	low := free.Cmp(nc.MinOperatorBalance) < 0
+	switch {
+	case low && !l.balanceLow:
+		log.Warn("Signing account balance below minOperatorBalance, submissions may fail soon", "balance", free, "min", nc.MinOperatorBalance)
+		l.Alerts.Alert(notify.KindBalance, fmt.Sprintf("nulink watcher: signing account balance %s below %s", free, nc.MinOperatorBalance))
+	case !low && l.balanceLow:
+		log.Info("Signing account balance back above minOperatorBalance", "balance", free, "min", nc.MinOperatorBalance)
+	default:
+		log.Debug("Checked the signing account balance", "balance", free, "min", nc.MinOperatorBalance)
+	}
+	l.balanceLow = low
+}
@@ -0,0 +1,59 @@
+package ethereum
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+	"github.com/NuLink-network/watcher/watcher/notify"
+)
+
+func TestListener_checkOperatorBalance(t *testing.T) {
+	sub := &substrate.MockSubmitter{}
+	events := make(testNotifier, 4)
+	l := &Listener{
+		Config: &config.Config{EpochSize: 1000, NuLinkChainConfig: config.NuLinkChainConfig{
+			MinOperatorBalance: big.NewInt(100), BalanceCheckEpochs: 2,
+		}},
+		Subconn: sub,
+		Alerts:  notify.NewAlerter(events, 1, time.Second),
+	}
+
+	steps := []struct {
+		name      string
+		block     *big.Int // nil for the startup check
+		balance   *big.Int // nil fails the read
+		wantLow   bool
+		wantAlert bool
+	}{
+		{name: "startup above", balance: big.NewInt(150)},
+		{name: "startup read failed"},
+		{name: "below at a check epoch", block: big.NewInt(2000), balance: big.NewInt(99), wantLow: true, wantAlert: true},
+		{name: "still below, alerted once", block: big.NewInt(4000), balance: big.NewInt(50), wantLow: true},
+		{name: "topped up between checks", block: big.NewInt(5000), balance: big.NewInt(500), wantLow: true},
+		{name: "topped up at a check epoch", block: big.NewInt(6000), balance: big.NewInt(500)},
+		{name: "below again at startup", balance: big.NewInt(10), wantLow: true, wantAlert: true},
+	}
+	for _, s := range steps {
+		sub.SetFreeBalance(s.balance)
+		l.checkOperatorBalance(s.block)
+		if l.balanceLow != s.wantLow || l.metricsSnapshot(0).OperatorBalanceLow != s.wantLow {
+			t.Errorf("%s: balanceLow = %v, want %v", s.name, l.balanceLow, s.wantLow)
+		}
+		select {
+		case e := <-events:
+			if !s.wantAlert || e.Kind != notify.KindBalance {
+				t.Errorf("%s: alert %+v, want alert %v", s.name, e, s.wantAlert)
+			}
+		case <-time.After(100 * time.Millisecond):
+			if s.wantAlert {
+				t.Errorf("%s: no balance alert sent", s.name)
+			}
+		}
+	}
+	if got := l.metricsSnapshot(0).OperatorBalance; got == nil || got.Int64() != 10 {
+		t.Errorf("OperatorBalance = %v, want 10", got)
+	}
+}
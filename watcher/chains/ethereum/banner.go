@@ -0,0 +1,97 @@
+package ethereum
+
+import (
+	"math/big"
+	"net/url"
+	"strings"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+)
+
+// redacted replaces the credentials of an endpoint in the startup banner
+const redacted = "xxxxx"
+
+// logBanner logs what the run is about to do, from the validated config and the resolved blocks: polling
+// starts at start, or after resume when checkpointed deposits were restored
+func (l *Listener) logBanner(start, resume *big.Int) {
+	ec := l.Config.EthereumConfig
+	network := l.Config.Network
+	if network == "" {
+		network = "custom"
+	}
+	contracts := make([]string, 0, len(ec.DepositContracts()))
+	for _, c := range ec.DepositContracts() {
+		contracts = append(contracts, ethcommon.HexToAddress(c.Address).Hex())
+	}
+	confirmations := ec.BlockConfirmations.String()
+	if ec.UseFinalizedTag {
+		confirmations = "finalized"
+	}
+	modes := "none"
+	if len(l.Modes) > 0 {
+		modes = strings.Join(l.Modes, ",")
+	}
+	log.Info("Starting watcher",
+		"network", network,
+		"chainId", ec.ChainID,
+		"ethereum", redactURL(ec.URL),
+		"nulink", redactURL(l.Config.NuLinkChainConfig.URL),
+		"contracts", strings.Join(contracts, ","),
+		"startBlock", start,
+		"resumeBlock", resume,
+		"epochSize", l.Config.EpochSize,
+		"epochOffset", l.Config.EpochOffset,
+		"topN", substrate.TopN,
+		"confirmations", confirmations,
+		"stoppedConfirmations", l.Config.StoppedConfirmations,
+		"submitMode", l.Config.SubmitMode,
+		"modes", modes)
+}
+
+// redactURL hides the credentials of an endpoint: the password of its user info, the values of its query
+// and the path segments that look like an api key, as used by hosted nodes
+func redactURL(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return redacted
+	}
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+		}
+	}
+	if u.RawQuery != "" {
+		q := u.Query()
+		for k := range q {
+			q.Set(k, redacted)
+		}
+		u.RawQuery = q.Encode()
+	}
+	segments := strings.Split(u.Path, "/")
+	for i, s := range segments {
+		if isAPIKey(s) {
+			segments[i] = redacted
+		}
+	}
+	u.Path, u.RawPath = strings.Join(segments, "/"), ""
+	return u.String()
+}
+
+// isAPIKey reports whether a path segment is a long run of letters, digits, - and _ holding a digit
+func isAPIKey(s string) bool {
+	if len(s) < 20 || !strings.ContainsAny(s, "0123456789") {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
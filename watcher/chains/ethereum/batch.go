@@ -0,0 +1,35 @@
+package ethereum
+
+import (
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// batchEpoch applies SubmitEveryNEpochs to the set computed at an epoch boundary: the epoch joins the batch
+// of those computed since the last submission and the set is returned to be submitted once the batch holds
+// SubmitEveryNEpochs epochs. It carries the final state of the batch, the sets of the earlier epochs are
+// superseded by it. It returns nil while the batch fills up. The batch is kept in memory only, a restart
+// starts a new one.
+func (l *Listener) batchEpoch(set *pendingSet) *pendingSet {
+	n := l.Config.SubmitEveryNEpochs
+	if n <= 1 {
+		return set
+	}
+	epoch := l.Config.Epoch(set.block.Uint64())
+	if len(l.batchEpochs) == 0 || l.batchEpochs[len(l.batchEpochs)-1] != epoch {
+		l.batchEpochs = append(l.batchEpochs, epoch)
+	}
+	if uint64(len(l.batchEpochs)) < n {
+		log.Info("batching the stake info update", "block", set.block, "epoch", epoch, "batched", len(l.batchEpochs), "submitEveryNEpochs", n)
+		return nil
+	}
+	set.epochs = append([]uint64(nil), l.batchEpochs...)
+	log.Info("submitting the batched stake info update", "block", set.block, "epochs", set.epochs)
+	return set
+}
+
+// endBatch starts a new batch once the set closing the current one is submitted or found unchanged
+func (l *Listener) endBatch(set *pendingSet) {
+	if len(set.epochs) > 0 {
+		l.batchEpochs = nil
+	}
+}
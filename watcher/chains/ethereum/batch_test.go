@@ -0,0 +1,113 @@
+package ethereum
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+func TestListener_submitEveryNEpochs(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = false
+
+	staker := ethcommon.BytesToAddress(WorkBase[0])
+	balances := map[string]map[ethcommon.Address]int64{}
+	var tags []string
+	conn := newTestConnection(t, map[string]rpcHandler{"eth_call": stakingContract(t, balances, &tags)})
+	auditPath := filepath.Join(t.TempDir(), "audit.jsonl")
+	sub := &substrate.MockSubmitter{}
+	l := &Listener{
+		Config: &config.Config{EpochSize: 1000, SubmitMode: config.SubmitModeFull, SubmitEveryNEpochs: 2,
+			EthereumConfig: config.EthereumConfig{BlockConfirmations: big.NewInt(10)}},
+		Ethconn:           conn,
+		Subconn:           sub,
+		LastStakeInfoPath: filepath.Join(t.TempDir(), "stake-info.json"),
+		Audit:             NewAuditLog(auditPath),
+	}
+
+	// the locked balance read at a boundary is 10 times its epoch, the submitted balance tells its epoch
+	steps := []struct {
+		name       string
+		epoch      int64
+		fail       bool
+		wantEpochs []uint64 // the epochs the submission covers, nil for none
+	}{
+		{name: "batch fills up", epoch: 1},
+		{name: "batch full", epoch: 2, wantEpochs: []uint64{1, 2}},
+		{name: "next batch", epoch: 3},
+		{name: "failed submission", epoch: 4, fail: true, wantEpochs: []uint64{3, 4}},
+		// the failed batch is submitted at the next epoch with its final state
+		{name: "failed batch retried", epoch: 5, wantEpochs: []uint64{3, 4, 5}},
+		{name: "after the retry", epoch: 6},
+	}
+	for _, s := range steps {
+		resetStakeInfoList()
+		balances["latest"] = map[ethcommon.Address]int64{staker: 10 * s.epoch}
+		sub.Err = nil
+		if s.fail {
+			sub.Err = errors.New("pool full")
+		}
+		before := len(sub.Calls())
+		err := l.syncStakeInfos(big.NewInt(s.epoch * 1000))
+		if s.fail != errors.Is(err, substrate.ErrSubmitFailed) {
+			t.Fatalf("%s: syncStakeInfos() error = %v", s.name, err)
+		}
+		calls := sub.Calls()[before:]
+		if s.wantEpochs == nil {
+			if len(calls) != 0 {
+				t.Errorf("%s: submitted %d times, want none", s.name, len(calls))
+			}
+			continue
+		}
+		if len(calls) != 1 {
+			t.Fatalf("%s: submitted %d times, want once", s.name, len(calls))
+		}
+		infos := calls[0].Args[0].(substrate.StakeInfos)
+		if len(infos) != 1 || infos[0].LockedBalance.Int64() != 10*s.epoch {
+			t.Errorf("%s: submitted %v, want the final state of epoch %d", s.name, infos, s.epoch)
+		}
+	}
+
+	f, err := os.Open(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var records []AuditRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var r AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+	want := []struct {
+		epoch  uint64
+		epochs []uint64
+		result string
+	}{
+		{epoch: 2, epochs: []uint64{1, 2}, result: AuditResultSuccess},
+		{epoch: 4, epochs: []uint64{3, 4}, result: AuditResultFailure},
+		{epoch: 5, epochs: []uint64{3, 4, 5}, result: AuditResultSuccess},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("audit log holds %d records, want %d", len(records), len(want))
+	}
+	for i, w := range want {
+		r := records[i]
+		if r.Epoch != w.epoch || !reflect.DeepEqual(r.Epochs, w.epochs) || r.Result != w.result {
+			t.Errorf("audit record %d = epoch %d epochs %v %s, want epoch %d epochs %v %s", i, r.Epoch, r.Epochs, r.Result, w.epoch, w.epochs, w.result)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package ethereum
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// depositCheckpoint persists the deposits accumulated since the last epoch flush, up to and including Block.
+// Deposits are kept per contract and staker, so they are aggregated by the current policy when restored.
+// Deposits spilled to disk are not repeated, they are the first SpillSize bytes of the spill file.
+type depositCheckpoint struct {
+	Contracts []string        `json:"contracts"`
+	Block     *big.Int        `json:"block"`
+	Deposits  []depositRecord `json:"deposits"`
+	SpillSize int64           `json:"spillSize,omitempty"`
+}
+
+type depositRecord struct {
+	Contract string   `json:"contract"`
+	Staker   string   `json:"staker"`
+	Value    *big.Int `json:"value"`
+}
+
+func (l *Listener) checkpointContracts() []string {
+	contracts := l.Config.EthereumConfig.DepositContracts()
+	addrs := make([]string, 0, len(contracts))
+	for _, c := range contracts {
+		addrs = append(addrs, ethcommon.HexToAddress(c.Address).Hex())
+	}
+	return addrs
+}
+
+// checkpointDeposits writes the accumulated deposits to DepositCheckpointPath, an empty path disables it
+func (l *Listener) checkpointDeposits(block *big.Int) error {
+	if l.DepositCheckpointPath == "" {
+		return nil
+	}
+	cp := depositCheckpoint{Contracts: l.checkpointContracts(), Block: block, Deposits: make([]depositRecord, 0, len(contractTotals)), SpillSize: l.spill.size}
+	for key, total := range contractTotals {
+		cp.Deposits = append(cp.Deposits, depositRecord{Contract: key.contract.Hex(), Staker: key.staker.Hex(), Value: total})
+	}
+	sort.Slice(cp.Deposits, func(i, j int) bool {
+		if cp.Deposits[i].Contract != cp.Deposits[j].Contract {
+			return cp.Deposits[i].Contract < cp.Deposits[j].Contract
+		}
+		return cp.Deposits[i].Staker < cp.Deposits[j].Staker
+	})
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(l.DepositCheckpointPath), os.ModePerm); err != nil {
+		return err
+	}
+	return writeFileAtomic(l.DepositCheckpointPath, data, 0664)
+}
+
+// clearDepositCheckpoint removes the checkpoint once its deposits were flushed at an epoch boundary
+func (l *Listener) clearDepositCheckpoint() {
+	if l.DepositCheckpointPath == "" {
+		return
+	}
+	if err := os.Remove(l.DepositCheckpointPath); err != nil && !os.IsNotExist(err) {
+		log.Warn("Failed to remove deposit checkpoint", "path", l.DepositCheckpointPath, "error", err)
+	}
+}
+
+// restoreDeposits reloads the deposits checkpointed by a previous run and returns the block to resume from.
+// The checkpoint only holds deposits of the blocks up to its Block, so it is restored when the resumed
+// range starts at or before that block and polling continues after it. A checkpoint of other contracts or
+// behind start is discarded and start is returned unchanged.
+func (l *Listener) restoreDeposits(start *big.Int) *big.Int {
+	if l.DepositCheckpointPath == "" {
+		return start
+	}
+	data, err := ioutil.ReadFile(l.DepositCheckpointPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn("Failed to read deposit checkpoint", "path", l.DepositCheckpointPath, "error", err)
+		}
+		return start
+	}
+	var cp depositCheckpoint
+	if err := json.Unmarshal(data, &cp); err != nil || cp.Block == nil {
+		log.Warn("Ignore invalid deposit checkpoint", "path", l.DepositCheckpointPath, "error", err)
+		return start
+	}
+	if !equalStrings(cp.Contracts, l.checkpointContracts()) {
+		log.Warn("Ignore deposit checkpoint of other contracts", "path", l.DepositCheckpointPath, "contracts", cp.Contracts)
+		return start
+	}
+	if cp.Block.Cmp(start) < 0 {
+		log.Warn("Ignore deposit checkpoint before the start block", "path", l.DepositCheckpointPath, "block", cp.Block, "start", start)
+		return start
+	}
+
+	var spill depositSpill
+	if cp.SpillSize > 0 {
+		spill = depositSpill{path: l.DepositCheckpointPath + ".spill", size: cp.SpillSize}
+		if fi, err := os.Stat(spill.path); err != nil || fi.Size() < spill.size {
+			log.Warn("Ignore deposit checkpoint without its spilled deposits", "path", spill.path, "size", spill.size, "error", err)
+			return start
+		}
+	}
+
+	resetStakeInfoList()
+	for _, d := range cp.Deposits {
+		if d.Value == nil || !ethcommon.IsHexAddress(d.Contract) || !ethcommon.IsHexAddress(d.Staker) {
+			log.Warn("Ignore invalid deposit checkpoint", "path", l.DepositCheckpointPath, "staker", d.Staker)
+			resetStakeInfoList()
+			return start
+		}
+		addDeposit(l.Config.EthereumConfig.CrossContractAggregation, ethcommon.HexToAddress(d.Contract), ethcommon.HexToAddress(d.Staker), d.Value)
+	}
+	l.spill = spill
+	log.Info("Restored checkpointed deposits", "block", cp.Block, "count", len(stakeInfoList), "spilled", spill.size)
+	return new(big.Int).Set(cp.Block)
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
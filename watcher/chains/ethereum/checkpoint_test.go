@@ -0,0 +1,79 @@
+package ethereum
+
+import (
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+func TestListener_depositCheckpoint(t *testing.T) {
+	contract := common.HexToAddress("0xa1")
+	staker := common.HexToAddress("0x01")
+	data := append(common.BigToHash(big.NewInt(10)).Bytes(), common.BigToHash(big.NewInt(1)).Bytes()...)
+	logs := []*ethtypes.Log{{Address: contract, Topics: []common.Hash{Deposited.GetTopic(), common.BytesToHash(staker[:])}, Data: data}}
+	conn := newTestConnection(t, map[string]rpcHandler{
+		"eth_getLogs": func(params []json.RawMessage) (interface{}, *rpcError) { return logs, nil },
+	})
+	cfg := &config.Config{EpochSize: 1000, EpochSource: config.EpochSourceEvents, MaxEventsPerBlock: config.MaxEventsPerBlock, EthereumConfig: config.EthereumConfig{
+		DepositContractAddr: contract.Hex(),
+		StakerTopic:         &config.TopicSlice{Index: 1, Offset: 12, Length: 20},
+	}}
+	path := filepath.Join(t.TempDir(), "deposits.json")
+	newListener := func() *Listener {
+		return &Listener{Config: cfg, Ethconn: conn, DepositCheckpointPath: path}
+	}
+	defer resetStakeInfoList()
+	resetStakeInfoList()
+
+	l := newListener()
+	for _, block := range []int64{1001, 1002} {
+		if err := l.getDepositEventsForBlock(big.NewInt(block)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a restart resumes the deposits after the checkpointed block
+	resetStakeInfoList()
+	l = newListener()
+	if got := l.restoreDeposits(big.NewInt(1)); got.Int64() != 1002 {
+		t.Errorf("restoreDeposits() = %s, want 1002", got)
+	}
+	if len(stakeInfoList) != 1 || stakeInfoList[0].LockedBalance.Int.Int64() != 20 {
+		t.Fatalf("restored stakeInfoList = %+v", stakeInfoList)
+	}
+
+	// a start block after the checkpoint discards it
+	resetStakeInfoList()
+	if got := l.restoreDeposits(big.NewInt(1500)); got.Int64() != 1500 || len(stakeInfoList) != 0 {
+		t.Errorf("restoreDeposits() after the checkpoint = %s with %d deposits, want 1500 and none", got, len(stakeInfoList))
+	}
+
+	// so does a checkpoint of another contract
+	other := *cfg
+	other.EthereumConfig.DepositContractAddr = "0xb2"
+	l.Config = &other
+	if got := l.restoreDeposits(big.NewInt(1)); got.Int64() != 1 || len(stakeInfoList) != 0 {
+		t.Errorf("restoreDeposits() of another contract = %s with %d deposits, want 1 and none", got, len(stakeInfoList))
+	}
+
+	// the flush at the epoch boundary removes the checkpoint
+	l = newListener()
+	l.restoreDeposits(big.NewInt(1))
+	l.DumpScale = true
+	if err := l.getDepositEventsForBlock(big.NewInt(2000)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("deposit checkpoint still exists after the epoch flush: %v", err)
+	}
+	if len(stakeInfoList) != 0 {
+		t.Errorf("stakeInfoList holds %d deposits after the epoch flush", len(stakeInfoList))
+	}
+}
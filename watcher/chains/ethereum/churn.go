@@ -0,0 +1,111 @@
+package ethereum
+
+import (
+	"fmt"
+	"math/big"
+	"sync/atomic"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/notify"
+)
+
+// AcceptChurn confirms the set held back by MaxChurnPercent, it is submitted at the next epoch boundary. A
+// confirmation given while no set is held accepts the next one. It is safe to call while the listener runs.
+func (l *Listener) AcceptChurn() {
+	atomic.StoreInt32(&l.churnAccepted, 1)
+	log.Warn("Accepting the next stake info set beyond maxChurnPercent")
+}
+
+// checkChurn reports whether top may be submitted after last. With MaxChurnPercent a set replacing more of
+// the stakers of last, or changing their total locked balance by more, is held back with an alert. It is
+// accepted once AcceptChurn confirms it or once it stayed within MaxChurnPercent of the held set for
+// ChurnStableEpochs epochs, a set back within bounds of last clears the hold.
+func (l *Listener) checkChurn(block *big.Int, top, last substrate.StakeInfos) bool {
+	max := l.Config.MaxChurnPercent
+	if max <= 0 || len(last) == 0 {
+		return true
+	}
+	members, balance := churn(last, top)
+	if members <= max && balance <= max {
+		if l.churnHeld != nil {
+			log.Info("stake info set back within maxChurnPercent, hold cleared", "block", block, "membership", members, "balance", balance)
+			l.churnHeld, l.churnStable = nil, 0
+		}
+		return true
+	}
+	if atomic.SwapInt32(&l.churnAccepted, 0) == 1 {
+		log.Warn("submitting the stake info set beyond maxChurnPercent, confirmed manually", "block", block, "membership", members, "balance", balance)
+		l.churnHeld, l.churnStable = nil, 0
+		return true
+	}
+
+	if l.churnHeld != nil {
+		if m, b := churn(l.churnHeld, top); m <= max && b <= max {
+			l.churnStable++
+		} else {
+			l.churnStable = 0
+		}
+		if n := l.Config.ChurnStableEpochs; n > 0 && l.churnStable >= n {
+			log.Warn("submitting the stake info set beyond maxChurnPercent, stable since it was held", "block", block, "epochs", l.churnStable, "membership", members, "balance", balance)
+			l.churnHeld, l.churnStable = nil, 0
+			return true
+		}
+	} else {
+		l.Alerts.Alert(notify.KindChurn, fmt.Sprintf("nulink watcher: stake info set at block %s changed %.1f%% of the stakers and %.1f%% of the locked balance, over %g%%, submission held back",
+			block, members, balance, max))
+	}
+	l.churnHeld = top
+	log.Error("stake info set changed beyond maxChurnPercent, holding the submission back", "block", block, "membership", members, "balance", balance,
+		"max", max, "stableEpochs", l.churnStable)
+	return false
+}
+
+// churn returns the percentage of the stakers of last replaced in next, the larger of the stakers that left
+// and joined relative to the size of last, and the change of the total locked balance relative to the one
+// of last. The balance change of a last set without a balance is 0 if next has none either, 100 otherwise.
+func churn(last, next substrate.StakeInfos) (members, balance float64) {
+	in := make(map[string]struct{}, len(last))
+	for _, info := range last {
+		in[ethcommon.Bytes2Hex(info.WorkBase)] = struct{}{}
+	}
+	var joined int
+	for _, info := range next {
+		key := ethcommon.Bytes2Hex(info.WorkBase)
+		if _, ok := in[key]; ok {
+			delete(in, key)
+			continue
+		}
+		joined++
+	}
+	replaced := len(in)
+	if joined > replaced {
+		replaced = joined
+	}
+	members = float64(replaced) * 100 / float64(len(last))
+
+	before, after := totalLocked(last), totalLocked(next)
+	if before.Sign() == 0 {
+		if after.Sign() == 0 {
+			return members, 0
+		}
+		return members, 100
+	}
+	delta := new(big.Int).Sub(after, before)
+	ratio := new(big.Rat).SetFrac(delta.Abs(delta).Mul(delta, big.NewInt(100)), before)
+	balance, _ = ratio.Float64()
+	return members, balance
+}
+
+// totalLocked sums the locked balances of infos
+func totalLocked(infos substrate.StakeInfos) *big.Int {
+	total := new(big.Int)
+	for _, info := range infos {
+		if info.LockedBalance.Int != nil {
+			total.Add(total, info.LockedBalance.Int)
+		}
+	}
+	return total
+}
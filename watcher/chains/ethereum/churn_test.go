@@ -0,0 +1,120 @@
+package ethereum
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+	"github.com/NuLink-network/watcher/watcher/notify"
+)
+
+// churnSet returns stakers first..first+n-1, each locking balance
+func churnSet(first, n int, balance int64) substrate.StakeInfos {
+	infos := make(substrate.StakeInfos, n)
+	for i := range infos {
+		infos[i] = &substrate.StakeInfo{WorkBase: []byte{byte(first + i)}, IsWork: true, LockedBalance: types.NewU128(*big.NewInt(balance))}
+	}
+	return infos
+}
+
+func churnListener(stableEpochs uint64) (*Listener, testNotifier) {
+	n := make(testNotifier, 4)
+	l := &Listener{
+		Config: &config.Config{MaxChurnPercent: 20, ChurnStableEpochs: stableEpochs},
+		Alerts: notify.NewAlerter(n, 3, time.Second),
+	}
+	return l, n
+}
+
+func TestChurn(t *testing.T) {
+	last := churnSet(0, 10, 10)
+	tests := []struct {
+		name             string
+		next             substrate.StakeInfos
+		members, balance float64
+	}{
+		{name: "unchanged", next: churnSet(0, 10, 10)},
+		{name: "2 replaced", next: append(churnSet(0, 8, 10), churnSet(100, 2, 10)...), members: 20},
+		{name: "3 replaced", next: append(churnSet(0, 7, 10), churnSet(100, 3, 10)...), members: 30},
+		{name: "3 left", next: churnSet(0, 7, 10), members: 30, balance: 30},
+		{name: "5 joined", next: churnSet(0, 15, 10), members: 50, balance: 50},
+		{name: "balance +20", next: append(churnSet(0, 9, 10), churnSet(9, 1, 30)...), balance: 20},
+		{name: "balance +21", next: append(churnSet(0, 9, 10), churnSet(9, 1, 31)...), balance: 21},
+	}
+	for _, tt := range tests {
+		members, balance := churn(last, tt.next)
+		if members != tt.members || balance != tt.balance {
+			t.Errorf("%s: churn = %v%%, %v%%, want %v%%, %v%%", tt.name, members, balance, tt.members, tt.balance)
+		}
+	}
+}
+
+func TestListener_checkChurn(t *testing.T) {
+	last := churnSet(0, 10, 10)
+	block := big.NewInt(1000)
+
+	l, n := churnListener(0)
+	if !l.checkChurn(block, append(churnSet(0, 8, 10), churnSet(100, 2, 10)...), last) {
+		t.Error("held back a set at the threshold")
+	}
+	if !l.checkChurn(block, append(churnSet(0, 9, 10), churnSet(9, 1, 30)...), last) {
+		t.Error("held back a balance change at the threshold")
+	}
+	if l.checkChurn(block, append(churnSet(0, 9, 10), churnSet(9, 1, 31)...), last) {
+		t.Error("submitted a balance change beyond the threshold")
+	}
+	if l.checkChurn(block, churnSet(100, 10, 10), last) || l.churnHeld == nil {
+		t.Fatal("submitted a replaced set")
+	}
+	select {
+	case e := <-n:
+		if e.Kind != notify.KindChurn {
+			t.Errorf("alert kind = %s, want %s", e.Kind, notify.KindChurn)
+		}
+	case <-time.After(time.Second):
+		t.Error("no churn alert sent")
+	}
+
+	// without churnStableEpochs the held set waits for a confirmation
+	for i := 0; i < 5; i++ {
+		if l.checkChurn(block, churnSet(100, 10, 10), last) {
+			t.Fatal("submitted the held set without a confirmation")
+		}
+	}
+	l.AcceptChurn()
+	if !l.checkChurn(block, churnSet(100, 10, 10), last) || l.churnHeld != nil {
+		t.Fatal("didn't submit the confirmed set")
+	}
+	if l.checkChurn(block, churnSet(200, 10, 10), last) {
+		t.Error("the confirmation accepted a second set")
+	}
+
+	// a set back within bounds clears the hold
+	if !l.checkChurn(block, last, last) || l.churnHeld != nil {
+		t.Error("didn't clear the hold of a set back within bounds")
+	}
+}
+
+func TestListener_checkChurnStable(t *testing.T) {
+	last := churnSet(0, 10, 10)
+	block := big.NewInt(1000)
+	l, _ := churnListener(2)
+
+	if l.checkChurn(block, churnSet(100, 10, 10), last) {
+		t.Fatal("submitted a replaced set")
+	}
+	// a set moving on from the held one restarts the count
+	if l.checkChurn(block, churnSet(200, 10, 10), last) {
+		t.Fatal("submitted a set changing from the held one")
+	}
+	if l.checkChurn(block, churnSet(200, 10, 10), last) {
+		t.Fatal("submitted the held set after one stable epoch")
+	}
+	if !l.checkChurn(block, churnSet(200, 10, 10), last) || l.churnHeld != nil {
+		t.Fatal("didn't submit the held set after churnStableEpochs")
+	}
+}
@@ -0,0 +1,109 @@
+package ethereum
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Clock is the time source of the listener for its timestamps, deadlines, polling waits and retry backoffs.
+// Tests inject a fake one, and a seeded Rand, to run deterministically.
+type Clock interface {
+	Now() time.Time
+	// After sends the time on the returned channel once d passed on the clock
+	After(d time.Duration) <-chan time.Time
+}
+
+// SystemClock is the Clock of the running process
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (SystemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// clock returns the Clock of the listener, the SystemClock if none is set
+func (l *Listener) clock() Clock {
+	if l.Clock == nil {
+		return SystemClock{}
+	}
+	return l.Clock
+}
+
+// applyClock hands the Clock of the listener to its alerter and event export, so their timestamps follow it
+func (l *Listener) applyClock() {
+	if l.Alerts != nil {
+		l.Alerts.Now = l.clock().Now
+	}
+	if l.Events != nil {
+		l.Events.Now = l.clock().Now
+	}
+}
+
+// context returns the context of the running Run, the background context outside of it
+func (l *Listener) context() context.Context {
+	if l.ctx == nil {
+		return context.Background()
+	}
+	return l.ctx
+}
+
+// sleep waits for d on the Clock of the listener or until ctx is done, whichever comes first
+func (l *Listener) sleep(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-l.clock().After(d):
+	}
+}
+
+// withTimeout is context.WithTimeout on the Clock of the listener: the returned context is done once d
+// passed on it, failing with context.DeadlineExceeded, or once ctx is done
+func (l *Listener) withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	parent, cancel := context.WithCancel(ctx)
+	tctx := &timeoutContext{Context: parent}
+	expired := l.clock().After(d)
+	go func() {
+		select {
+		case <-expired:
+			tctx.expire()
+			cancel()
+		case <-parent.Done():
+		}
+	}()
+	return tctx, cancel
+}
+
+// timeoutContext is a cancelable context reporting context.DeadlineExceeded once its timeout expired
+type timeoutContext struct {
+	context.Context
+
+	mu      sync.Mutex
+	expired bool
+}
+
+func (c *timeoutContext) expire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expired = true
+}
+
+func (c *timeoutContext) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.expired {
+		return context.DeadlineExceeded
+	}
+	return c.Context.Err()
+}
+
+// rand returns the random source of the listener, seeding one from the Clock on first use
+func (l *Listener) rand() *rand.Rand {
+	if l.Rand == nil {
+		l.Rand = rand.New(rand.NewSource(l.clock().Now().UnixNano()))
+	}
+	return l.Rand
+}
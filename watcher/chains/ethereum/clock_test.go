@@ -0,0 +1,143 @@
+package ethereum
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"math/rand"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"github.com/NuLink-network/watcher/watcher/bindings/nucypher"
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+	"github.com/NuLink-network/watcher/watcher/sink"
+)
+
+// fakeClock is a Clock whose time only moves when it is waited on, right away by the time waited for
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+// Two runs with the same clock and seed submit, audit and publish the same, down to the coinbases drawn for
+// the new stakers and the timestamps, whatever the retries of the run waited for
+func TestListener_deterministicRun(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = false
+
+	parsed, err := abi.JSON(strings.NewReader(nucypher.NucypherABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	selector := parsed.Methods["stakerInfo"].ID
+	a, b := ethcommon.BytesToAddress(WorkBase[0]), ethcommon.BytesToAddress(WorkBase[1])
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	run := func() ([]byte, []sink.Update, time.Time) {
+		resetStakeInfoList()
+		var tags []string
+		contract := stakingContract(t, map[string]map[ethcommon.Address]int64{"latest": {a: 20, b: 30}}, &tags)
+		// the first stakerInfo call of every epoch fails and is retried after the backoff
+		failed := false
+		// the run is retried after a failure to get the head, 0, and cancelled once the heads are served
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		heads := []int64{1000, 0, 1001, 2000, 0, 0, 3000}
+		conn := newTestConnection(t, map[string]rpcHandler{
+			"eth_getBlockByNumber": func(params []json.RawMessage) (interface{}, *rpcError) {
+				if len(heads) == 0 {
+					cancel()
+					return testHeader(3000), nil
+				}
+				head := heads[0]
+				heads = heads[1:]
+				if head == 0 {
+					return nil, &rpcError{Code: -32000, Message: "upstream timeout"}
+				}
+				failed = false
+				return testHeader(head), nil
+			},
+			"eth_call": func(params []json.RawMessage) (interface{}, *rpcError) {
+				var call struct {
+					Data hexutil.Bytes `json:"data"`
+				}
+				if err := json.Unmarshal(params[0], &call); err == nil && len(call.Data) >= 4 && bytes.Equal(call.Data[:4], selector) && !failed {
+					failed = true
+					return nil, &rpcError{Code: -32000, Message: "upstream timeout"}
+				}
+				return contract(params)
+			},
+		})
+		dir := t.TempDir()
+		published := &fakeSink{}
+		clock := &fakeClock{now: start}
+		l := &Listener{
+			Config: &config.Config{EpochSize: 1000, SubmitMode: config.SubmitModeFull, StakerFetchRetries: 1,
+				PollInterval: config.Duration{Duration: time.Hour}, RetryInterval: config.Duration{Duration: time.Hour},
+				EthereumConfig: config.EthereumConfig{BlockConfirmations: big.NewInt(0), StartBlock: big.NewInt(999)}},
+			Ethconn:           conn,
+			Subconn:           &substrate.MockSubmitter{},
+			Stop:              make(chan struct{}, 1),
+			LastStakeInfoPath: filepath.Join(dir, "stake-info.json"),
+			Audit:             NewAuditLog(filepath.Join(dir, "audit.log")),
+			Sinks:             []sink.Sink{published},
+			Clock:             clock,
+			Rand:              rand.New(rand.NewSource(42)),
+		}
+		if _, err := l.Run(ctx); !errors.Is(err, context.Canceled) {
+			t.Fatalf("Run() error = %v, want %v", err, context.Canceled)
+		}
+		audit, err := ioutil.ReadFile(filepath.Join(dir, "audit.log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return audit, published.updates, clock.Now()
+	}
+
+	audit1, updates1, end1 := run()
+	audit2, updates2, end2 := run()
+	if len(updates1) != 3 {
+		t.Fatalf("published %d updates, want one per epoch", len(updates1))
+	}
+	if end1.Sub(start) < 3*time.Hour {
+		t.Errorf("the run waited %s on the injected clock, want at least the 3 hour long head retries", end1.Sub(start))
+	}
+	if !bytes.Equal(audit1, audit2) {
+		t.Errorf("the audit logs of two runs differ:\n%s\n%s", audit1, audit2)
+	}
+	if !reflect.DeepEqual(updates1, updates2) || !end1.Equal(end2) {
+		t.Errorf("two runs published different updates: %+v, %+v", updates1, updates2)
+	}
+	for _, u := range updates1 {
+		if u.Time.Before(start) || u.Time.After(end1) {
+			t.Errorf("update of epoch %d published at %s, outside the injected clock's %s to %s", u.Epoch, u.Time, start, end1)
+		}
+	}
+}
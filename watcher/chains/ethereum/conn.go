@@ -2,8 +2,16 @@ package ethereum
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"math/big"
+	"net/http"
+	"strings"
+	"time"
 
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/rpc"
@@ -14,6 +22,19 @@ type Connection struct {
 	Http   bool
 	Client *ethclient.Client
 	Stop   chan struct{}
+	// UseFinalizedTag makes SafeHead use the node's "finalized" block instead of counting confirmations
+	UseFinalizedTag bool
+	// HTTPClient dials the http endpoints instead of the default client, e.g. to wrap its transport for
+	// tracing or to tune its connection pool
+	HTTPClient *http.Client
+	// Recorder records every answer of the node for a replay, Replay answers from a recording instead of
+	// dialing the node
+	Recorder *Recorder
+	Replay   *Recording
+
+	rpcClient            *rpc.Client
+	upstream             *rpc.Client
+	finalizedUnsupported bool
 }
 
 func NewConnection(endpoint string, http bool, stop chan struct{}) *Connection {
@@ -26,11 +47,25 @@ func NewConnection(endpoint string, http bool, stop chan struct{}) *Connection {
 
 // Connect starts the ethereum WS connection
 func (c *Connection) Connect() error {
+	if c.Replay != nil {
+		log.Info("Replaying the ethereum chain from a recording...")
+		rpcClient, err := dialProxy(func(ctx context.Context, method string, params []json.RawMessage) (json.RawMessage, error) {
+			return c.Replay.next(method, params)
+		})
+		if err != nil {
+			return err
+		}
+		c.rpcClient = rpcClient
+		c.Client = ethclient.NewClient(rpcClient)
+		return nil
+	}
 	log.Info("Connecting to ethereum chain...", "url", c.URL)
 	var rpcClient *rpc.Client
 	var err error
 	// Start http or ws client
-	if c.Http {
+	if c.Http && c.HTTPClient != nil {
+		rpcClient, err = rpc.DialHTTPWithClient(c.URL, c.HTTPClient)
+	} else if c.Http {
 		rpcClient, err = rpc.DialHTTP(c.URL)
 	} else {
 		rpcClient, err = rpc.DialContext(context.Background(), c.URL)
@@ -38,10 +73,34 @@ func (c *Connection) Connect() error {
 	if err != nil {
 		return err
 	}
+	if c.Recorder != nil {
+		c.upstream = rpcClient
+		if rpcClient, err = dialProxy(recordingCall(c.upstream, c.Recorder)); err != nil {
+			return err
+		}
+	}
+	c.rpcClient = rpcClient
 	c.Client = ethclient.NewClient(rpcClient)
 	return nil
 }
 
+// closeClient closes the client and the node connection a recording client forwards to
+func (c *Connection) closeClient() {
+	if c.Client != nil {
+		c.Client.Close()
+	}
+	if c.upstream != nil {
+		c.upstream.Close()
+		c.upstream = nil
+	}
+}
+
+// Reconnect closes the client and dials the endpoint again, unlike Close it leaves the stop channel open
+func (c *Connection) Reconnect() error {
+	c.closeClient()
+	return c.Connect()
+}
+
 func (c *Connection) LatestBlock() (*big.Int, error) {
 	header, err := c.Client.HeaderByNumber(context.Background(), nil)
 	if err != nil {
@@ -50,10 +109,126 @@ func (c *Connection) LatestBlock() (*big.Int, error) {
 	return header.Number, nil
 }
 
+// BlockHash returns the hash of block number
+func (c *Connection) BlockHash(number *big.Int) (common.Hash, error) {
+	header, err := c.Client.HeaderByNumber(context.Background(), number)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return header.Hash(), nil
+}
+
+// BlockTime returns the timestamp of block number
+func (c *Connection) BlockTime(number *big.Int) (time.Time, error) {
+	header, err := c.Client.HeaderByNumber(context.Background(), number)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(header.Time), 0).UTC(), nil
+}
+
+// FinalizedBlock returns the number of the block the node reports with the "finalized" tag
+func (c *Connection) FinalizedBlock() (*big.Int, error) {
+	var header *ethtypes.Header
+	if err := c.rpcClient.CallContext(context.Background(), &header, "eth_getBlockByNumber", "finalized", false); err != nil {
+		return nil, err
+	}
+	if header == nil {
+		return nil, errors.New("finalized block not found")
+	}
+	return header.Number, nil
+}
+
+// tagUnsupported reports whether err is the endpoint rejecting the "finalized" tag itself, as an unknown
+// method, invalid params or an unknown block tag, rather than a transient failure of the call
+func tagUnsupported(err error) bool {
+	var rpcErr rpc.Error
+	if !errors.As(err, &rpcErr) {
+		return false
+	}
+	switch rpcErr.ErrorCode() {
+	case -32601, -32602:
+		return true
+	}
+	return strings.Contains(strings.ToLower(rpcErr.Error()), "unknown block tag")
+}
+
+// IsFinalized reports whether the node reports number finalized. An endpoint that doesn't support the tag
+// reports every block finalized for good, leaving the safety to the confirmations SafeHead counts. Any other
+// failure to get the finalized block is returned, for the caller to retry.
+func (c *Connection) IsFinalized(number *big.Int) (bool, error) {
+	if c.finalizedUnsupported {
+		return true, nil
+	}
+	finalized, err := c.FinalizedBlock()
+	if err != nil {
+		if tagUnsupported(err) {
+			log.Warn("Endpoint doesn't support the finalized tag, fall back to block confirmations", "url", c.URL, "err", err)
+			c.finalizedUnsupported = true
+			return true, nil
+		}
+		return false, err
+	}
+	return finalized.Cmp(number) >= 0, nil
+}
+
+// SafeHead returns the highest block considered safe to process. With UseFinalizedTag it is the finalized
+// block, falling back to the latest block minus confirmations for good once the endpoint turns out not to
+// support the tag. Any other failure to get the finalized block is returned, for the poll to retry.
+func (c *Connection) SafeHead(confirmations *big.Int) (*big.Int, error) {
+	if c.UseFinalizedTag && !c.finalizedUnsupported {
+		number, err := c.FinalizedBlock()
+		if err == nil {
+			return number, nil
+		}
+		if !tagUnsupported(err) {
+			return nil, fmt.Errorf("unable to get finalized block: %w", err)
+		}
+		log.Warn("Endpoint doesn't support the finalized tag, fall back to block confirmations", "url", c.URL, "err", err)
+		c.finalizedUnsupported = true
+	}
+
+	latest, err := c.LatestBlock()
+	if err != nil {
+		return nil, err
+	}
+	head := new(big.Int).Sub(latest, confirmations)
+	if head.Sign() < 0 {
+		head.SetInt64(0)
+	}
+	return head, nil
+}
+
+// DeploymentBlock binary searches for the earliest block at or below latest where addr has code. It needs
+// an archive node since it queries the code at historical blocks.
+func (c *Connection) DeploymentBlock(addr common.Address, latest *big.Int) (*big.Int, error) {
+	code, err := c.Client.CodeAt(context.Background(), addr, latest)
+	if err != nil {
+		return nil, err
+	}
+	if len(code) == 0 {
+		return nil, fmt.Errorf("no contract code at %s in block %s", addr.Hex(), latest)
+	}
+
+	lo, hi := big.NewInt(0), new(big.Int).Set(latest)
+	one := big.NewInt(1)
+	for lo.Cmp(hi) < 0 {
+		mid := new(big.Int).Rsh(new(big.Int).Add(lo, hi), 1)
+		code, err := c.Client.CodeAt(context.Background(), addr, mid)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get code at block %s: %w", mid, err)
+		}
+		if len(code) > 0 {
+			hi = mid
+		} else {
+			lo = mid.Add(mid, one)
+		}
+	}
+	return lo, nil
+}
+
 // Close terminates the client connection and stops any running routines
 func (c *Connection) Close() {
-	if c.Client != nil {
-		c.Client.Close()
-	}
+	c.closeClient()
 	close(c.Stop)
 }
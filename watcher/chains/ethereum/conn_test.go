@@ -0,0 +1,293 @@
+package ethereum
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+)
+
+type rpcRequest struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// rpcHandler answers a single json-rpc call, returning either a result or an error
+type rpcHandler func(params []json.RawMessage) (interface{}, *rpcError)
+
+// newTestRPCServer starts a json-rpc server answering the given methods, unknown methods return -32601
+func newTestRPCServer(t *testing.T, handlers map[string]rpcHandler) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req rpcRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+		if h, ok := handlers[req.Method]; ok {
+			result, rpcErr := h(req.Params)
+			if rpcErr != nil {
+				resp["error"] = rpcErr
+			} else {
+				resp["result"] = result
+			}
+		} else {
+			resp["error"] = &rpcError{Code: -32601, Message: "the method " + req.Method + " does not exist"}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func testHeader(number int64) *ethtypes.Header {
+	return &ethtypes.Header{Number: big.NewInt(number), Difficulty: big.NewInt(0)}
+}
+
+// blockByNumber serves eth_getBlockByNumber, with finalized nil meaning the tag is unsupported
+func blockByNumber(latest int64, finalized *int64) rpcHandler {
+	return func(params []json.RawMessage) (interface{}, *rpcError) {
+		var tag string
+		_ = json.Unmarshal(params[0], &tag)
+		switch tag {
+		case "latest":
+			return testHeader(latest), nil
+		case "finalized":
+			if finalized == nil {
+				return nil, &rpcError{Code: -32602, Message: "invalid argument 0: hex string without 0x prefix"}
+			}
+			return testHeader(*finalized), nil
+		}
+		return nil, &rpcError{Code: -32602, Message: "unexpected block tag " + tag}
+	}
+}
+
+// codeFrom serves eth_getCode, reporting contract code from the deployed block onwards
+func codeFrom(deployed int64, calls *int) rpcHandler {
+	return func(params []json.RawMessage) (interface{}, *rpcError) {
+		*calls++
+		var tag string
+		_ = json.Unmarshal(params[1], &tag)
+		n, err := hexutil.DecodeBig(tag)
+		if err != nil {
+			return nil, &rpcError{Code: -32602, Message: err.Error()}
+		}
+		if n.Int64() < deployed {
+			return "0x", nil
+		}
+		return "0x6080", nil
+	}
+}
+
+func newTestConnection(t *testing.T, handlers map[string]rpcHandler) *Connection {
+	srv := newTestRPCServer(t, handlers)
+	conn := NewConnection(srv.URL, true, make(chan struct{}))
+	if err := conn.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestConnection_SafeHead(t *testing.T) {
+	finalized := int64(90)
+	tests := []struct {
+		name            string
+		useFinalizedTag bool
+		finalized       *int64
+		confirmations   int64
+		want            int64
+	}{
+		{name: "confirmations", useFinalizedTag: false, finalized: &finalized, confirmations: 10, want: 990},
+		{name: "finalized-tag", useFinalizedTag: true, finalized: &finalized, confirmations: 10, want: 90},
+		{name: "finalized-tag-unsupported", useFinalizedTag: true, finalized: nil, confirmations: 10, want: 990},
+		{name: "confirmations-above-latest", useFinalizedTag: false, confirmations: 2000, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := newTestConnection(t, map[string]rpcHandler{
+				"eth_getBlockByNumber": blockByNumber(1000, tt.finalized),
+			})
+			conn.UseFinalizedTag = tt.useFinalizedTag
+
+			got, err := conn.SafeHead(big.NewInt(tt.confirmations))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Int64() != tt.want {
+				t.Errorf("SafeHead() = %v, want %v", got, tt.want)
+			}
+			if tt.useFinalizedTag && tt.finalized == nil && !conn.finalizedUnsupported {
+				t.Errorf("SafeHead() should stop using the unsupported finalized tag")
+			}
+		})
+	}
+}
+
+func TestConnection_DeploymentBlock(t *testing.T) {
+	addr := common.HexToAddress("0xa7f6c9a5052a08a14ff0e3349094b6efbc591ea4")
+	tests := []struct {
+		name     string
+		deployed int64
+		want     int64
+		wantErr  bool
+	}{
+		{name: "genesis", deployed: 0, want: 0},
+		{name: "middle", deployed: 617, want: 617},
+		{name: "latest", deployed: 1000, want: 1000},
+		{name: "not-deployed", deployed: 1001, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			conn := newTestConnection(t, map[string]rpcHandler{
+				"eth_getCode": codeFrom(tt.deployed, &calls),
+			})
+			got, err := conn.DeploymentBlock(addr, big.NewInt(1000))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DeploymentBlock() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got.Int64() != tt.want {
+				t.Errorf("DeploymentBlock() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// A transient failure of the finalized tag is returned for the poll to retry, the tag is used again after it
+func TestConnection_SafeHeadTransient(t *testing.T) {
+	for _, failure := range []*rpcError{
+		{Code: -32000, Message: "header not found"},
+		{Code: -32005, Message: "rate limit exceeded"},
+		{Code: -32603, Message: "internal error"},
+	} {
+		t.Run(failure.Message, func(t *testing.T) {
+			failing := true
+			finalized := blockByNumber(1000, new(int64))
+			conn := newTestConnection(t, map[string]rpcHandler{
+				"eth_getBlockByNumber": func(params []json.RawMessage) (interface{}, *rpcError) {
+					if failing {
+						return nil, failure
+					}
+					return finalized(params)
+				},
+			})
+			conn.UseFinalizedTag = true
+
+			if _, err := conn.SafeHead(big.NewInt(10)); err == nil {
+				t.Fatal("SafeHead() error = nil, want the transient failure")
+			}
+			if conn.finalizedUnsupported {
+				t.Fatal("SafeHead() stopped using the finalized tag after a transient failure")
+			}
+			failing = false
+			if got, err := conn.SafeHead(big.NewInt(10)); err != nil || got.Int64() != 0 {
+				t.Errorf("SafeHead() = %v, %v, want the finalized block 0", got, err)
+			}
+		})
+	}
+}
+
+func TestConnection_IsFinalized(t *testing.T) {
+	tests := []struct {
+		name        string
+		failure     *rpcError
+		wantErr     bool
+		wantLatched bool
+	}{
+		{name: "invalid-params", failure: &rpcError{Code: -32602, Message: "invalid argument 0"}, wantLatched: true},
+		{name: "unknown-method", failure: &rpcError{Code: -32601, Message: "the method does not exist"}, wantLatched: true},
+		{name: "unknown-tag", failure: &rpcError{Code: -32000, Message: "Unknown block tag finalized"}, wantLatched: true},
+		{name: "header-not-found", failure: &rpcError{Code: -32000, Message: "header not found"}, wantErr: true},
+		{name: "rate-limit", failure: &rpcError{Code: -32005, Message: "rate limit exceeded"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failing := true
+			finalized := int64(1000)
+			conn := newTestConnection(t, map[string]rpcHandler{
+				"eth_getBlockByNumber": func(params []json.RawMessage) (interface{}, *rpcError) {
+					if failing {
+						return nil, tt.failure
+					}
+					return blockByNumber(1010, &finalized)(params)
+				},
+			})
+
+			ok, err := conn.IsFinalized(big.NewInt(1005))
+			if (err != nil) != tt.wantErr || conn.finalizedUnsupported != tt.wantLatched {
+				t.Fatalf("IsFinalized() error = %v with the tag unsupported = %v, want error %v and %v", err, conn.finalizedUnsupported, tt.wantErr, tt.wantLatched)
+			}
+			if !tt.wantErr && !ok {
+				t.Fatal("IsFinalized() = false on an endpoint without the tag, want every block finalized")
+			}
+
+			// once the endpoint answers, a transient failure is followed by the finalized tag again
+			failing = false
+			if ok, err := conn.IsFinalized(big.NewInt(1005)); err != nil || ok != tt.wantLatched {
+				t.Errorf("IsFinalized(1005) = %v, %v, want %v", ok, err, tt.wantLatched)
+			}
+			if ok, err := conn.IsFinalized(big.NewInt(1000)); err != nil || !ok {
+				t.Errorf("IsFinalized(1000) = %v, %v, want true", ok, err)
+			}
+		})
+	}
+}
+
+// countingTransport counts the requests it forwards to the default transport
+type countingTransport struct {
+	requests int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests++
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestConnection_HTTPClient(t *testing.T) {
+	srv := newTestRPCServer(t, map[string]rpcHandler{
+		"eth_getBlockByNumber": blockByNumber(1000, nil),
+	})
+	transport := &countingTransport{}
+	pool := NewConnectionPool()
+	pool.HTTPClient = &http.Client{Transport: transport}
+	defer pool.CloseAll()
+
+	conn, err := pool.Get(srv.URL, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest, err := conn.LatestBlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Int64() != 1000 {
+		t.Errorf("LatestBlock() = %v, want 1000", latest)
+	}
+	if transport.requests == 0 {
+		t.Errorf("LatestBlock() didn't go through the custom transport")
+	}
+
+	// reconnecting dials with the same client
+	before := transport.requests
+	if err := conn.Reconnect(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.LatestBlock(); err != nil {
+		t.Fatal(err)
+	}
+	if transport.requests == before {
+		t.Errorf("LatestBlock() after Reconnect() didn't go through the custom transport")
+	}
+}
@@ -0,0 +1,165 @@
+package ethereum
+
+import (
+	"math/big"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+// cutoffStakers returns the stakers 1 to len(balances) with balances, read in reverse order with reversed
+func cutoffStakers(balances []int64, reversed bool) substrate.StakeInfos {
+	infos := make(substrate.StakeInfos, 0, len(balances))
+	for i, b := range balances {
+		infos = append(infos, &substrate.StakeInfo{WorkBase: common.BigToAddress(big.NewInt(int64(i + 1))).Bytes(), IsWork: true,
+			LockedBalance: types.NewU128(*big.NewInt(b))})
+	}
+	if reversed {
+		for i, j := 0, len(infos)-1; i < j; i, j = i+1, j-1 {
+			infos[i], infos[j] = infos[j], infos[i]
+		}
+	}
+	return infos
+}
+
+// descending returns n balances from top down, one apart
+func descending(top int64, n int) []int64 {
+	balances := make([]int64, n)
+	for i := range balances {
+		balances[i] = top - int64(i)
+	}
+	return balances
+}
+
+// stakerIDs returns the numbers of the stakers of infos submitted working and stopped, placeholders left out
+func stakerIDs(infos substrate.StakeInfos) (working, stopped []int64) {
+	for _, info := range infos {
+		if len(info.WorkBase) == 0 {
+			continue
+		}
+		id := new(big.Int).SetBytes(info.WorkBase).Int64()
+		if info.IsWork {
+			working = append(working, id)
+		} else {
+			stopped = append(stopped, id)
+		}
+	}
+	sort.Slice(working, func(i, j int) bool { return working[i] < working[j] })
+	sort.Slice(stopped, func(i, j int) bool { return stopped[i] < stopped[j] })
+	return working, stopped
+}
+
+func ids(from, to int64) []int64 {
+	var s []int64
+	for i := from; i <= to; i++ {
+		s = append(s, i)
+	}
+	return s
+}
+
+// The selection and the stopped stakers of a diff around the TopN cutoff
+func TestListener_topNCutoff(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+
+	tied := append(descending(100, substrate.TopN-1), 50, 50)
+	tests := []struct {
+		name       string
+		undersized string
+		ties       string
+		// the balances of the stakers read at the first and the second epoch, reversed reads the second
+		// epoch in reverse order
+		first, second []int64
+		reversed      bool
+		// the stakers submitted at the first epoch, and the ones joined or changed and stopped by the diff of
+		// the second, nil without a second submission
+		wantMembers                []int64
+		wantWorking, wantStopped   []int64
+		wantSecondSubmission       bool
+		wantFirstSubmissionStakers int
+	}{
+		{
+			name: "exactly TopN", first: descending(100, substrate.TopN), second: descending(100, substrate.TopN), reversed: true,
+			wantMembers: ids(1, 20), wantFirstSubmissionStakers: 20,
+		},
+		{
+			name: "TopN-1 growing to TopN", undersized: config.UndersizedPad,
+			first: descending(100, substrate.TopN-1), second: descending(100, substrate.TopN),
+			wantMembers: ids(1, 19), wantFirstSubmissionStakers: 20, wantSecondSubmission: true, wantWorking: []int64{20},
+		},
+		{
+			name: "TopN shrinking to TopN-1", undersized: config.UndersizedPad,
+			first: descending(100, substrate.TopN), second: descending(100, substrate.TopN-1),
+			wantMembers: ids(1, 20), wantFirstSubmissionStakers: 20, wantSecondSubmission: true, wantStopped: []int64{20},
+		},
+		{
+			name: "TopN-1", first: descending(100, substrate.TopN-1), second: descending(100, substrate.TopN-1),
+			wantMembers: ids(1, 19), wantFirstSubmissionStakers: 19,
+		},
+		{
+			// the 21st staker outbids the 20th
+			name: "TopN+1", first: descending(100, substrate.TopN), second: append(descending(100, substrate.TopN), 90),
+			wantMembers: ids(1, 20), wantFirstSubmissionStakers: 20, wantSecondSubmission: true,
+			wantWorking: []int64{21}, wantStopped: []int64{20},
+		},
+		{
+			// of the two stakers tied at the cutoff the lower work base is selected in either read order
+			name: "TopN+1 tied", first: tied, second: tied, reversed: true,
+			wantMembers: ids(1, 20), wantFirstSubmissionStakers: 20,
+		},
+		{
+			name: "TopN+1 tied excluded", ties: config.TopNTiesExclude,
+			first: tied, second: append(descending(100, substrate.TopN-1), 50, 60),
+			wantMembers: ids(1, 19), wantFirstSubmissionStakers: 19, wantSecondSubmission: true, wantWorking: []int64{21},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first = false
+			sub := &substrate.MockSubmitter{}
+			cfg := &config.Config{EpochSize: 1000, SubmitMode: config.SubmitModeDiff, FullResyncEpochs: 10,
+				UndersizedPolicy: tt.undersized, TopNTies: tt.ties, EthereumConfig: config.EthereumConfig{BlockConfirmations: big.NewInt(0)}}
+			l := &Listener{Config: cfg, Ethconn: newTestConnection(t, map[string]rpcHandler{}), Subconn: sub}
+
+			reads := []substrate.StakeInfos{cutoffStakers(tt.first, false), cutoffStakers(tt.second, tt.reversed)}
+			for i, block := range []int64{1000, 2000} {
+				read := func(*big.Int) (substrate.StakeInfos, error) { return reads[i], nil }
+				if err := l.runEpoch(big.NewInt(block), read); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			calls := sub.Calls()
+			want := 1
+			if tt.wantSecondSubmission {
+				want = 2
+			}
+			if len(calls) != want {
+				t.Fatalf("made %d submissions, want %d", len(calls), want)
+			}
+			firstSet := calls[0].Args[0].(substrate.StakeInfos)
+			if len(firstSet) != tt.wantFirstSubmissionStakers {
+				t.Errorf("first submission holds %d stake infos, want %d", len(firstSet), tt.wantFirstSubmissionStakers)
+			}
+			members, _ := stakerIDs(firstSet)
+			if !reflect.DeepEqual(members, tt.wantMembers) {
+				t.Errorf("first submission selected %v, want %v", members, tt.wantMembers)
+			}
+			if !tt.wantSecondSubmission {
+				return
+			}
+			working, stopped := stakerIDs(calls[1].Args[0].(substrate.StakeInfos))
+			if !reflect.DeepEqual(working, tt.wantWorking) || !reflect.DeepEqual(stopped, tt.wantStopped) {
+				t.Errorf("second submission reported %v working and %v stopped, want %v and %v", working, stopped, tt.wantWorking, tt.wantStopped)
+			}
+			if n := len(calls[1].Args[0].(substrate.StakeInfos)); n != len(tt.wantWorking)+len(tt.wantStopped) {
+				t.Errorf("second submission holds %d stake infos, want only the %d changed stakers", n, len(tt.wantWorking)+len(tt.wantStopped))
+			}
+		})
+	}
+}
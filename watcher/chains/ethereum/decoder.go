@@ -0,0 +1,77 @@
+package ethereum
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+// StakerDecoder derives the canonical staker address from the bytes of the staker topic of a deposit event,
+// as selected by stakerTopic. The address it returns identifies the staker everywhere after: deposits are
+// merged by it, the staker filter and the top selection see it and the coinbase is derived from it.
+type StakerDecoder interface {
+	Name() string
+	Decode(raw []byte) (ethcommon.Address, error)
+}
+
+// RawAddressDecoder is the default StakerDecoder, the bytes are the address itself, left padded when
+// shorter than an address
+type RawAddressDecoder struct{}
+
+func (RawAddressDecoder) Name() string {
+	return config.StakerDecoderRaw
+}
+
+func (RawAddressDecoder) Decode(raw []byte) (ethcommon.Address, error) {
+	if len(raw) > ethcommon.AddressLength {
+		return ethcommon.Address{}, fmt.Errorf("raw staker address of %d bytes, expected at most %d", len(raw), ethcommon.AddressLength)
+	}
+	return ethcommon.BytesToAddress(raw), nil
+}
+
+var (
+	decodersMu sync.RWMutex
+	decoders   = map[string]StakerDecoder{config.StakerDecoderRaw: RawAddressDecoder{}}
+)
+
+// RegisterStakerDecoder makes d selectable by its name with the stakerDecoder setting, replacing a decoder
+// registered under the same name. Programs embedding the listener register their decoders before it runs.
+func RegisterStakerDecoder(d StakerDecoder) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	decoders[d.Name()] = d
+}
+
+// LookupStakerDecoder returns the decoder registered as name, the RawAddressDecoder for an empty name
+func LookupStakerDecoder(name string) (StakerDecoder, error) {
+	if name == "" {
+		name = config.StakerDecoderRaw
+	}
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+	if d, ok := decoders[name]; ok {
+		return d, nil
+	}
+	names := make([]string, 0, len(decoders))
+	for n := range decoders {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unknown stakerDecoder %q, registered are %v", name, names)
+}
+
+// stakerDecoder returns the Decoder of the listener, looking the configured one up on first use
+func (l *Listener) stakerDecoder() (StakerDecoder, error) {
+	if l.Decoder == nil {
+		d, err := LookupStakerDecoder(l.Config.EthereumConfig.StakerDecoder)
+		if err != nil {
+			return nil, err
+		}
+		l.Decoder = d
+	}
+	return l.Decoder, nil
+}
@@ -0,0 +1,132 @@
+package ethereum
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+// hashedDecoder identifies a staker published as a hash by the last 20 bytes of its keccak256
+type hashedDecoder struct{}
+
+func (hashedDecoder) Name() string { return "test-hashed" }
+
+func (hashedDecoder) Decode(raw []byte) (common.Address, error) {
+	return common.BytesToAddress(crypto.Keccak256(raw)), nil
+}
+
+// namespacedDecoder reads an address prefixed with a 4 byte namespace, rejecting other namespaces
+type namespacedDecoder struct{ namespace []byte }
+
+func (namespacedDecoder) Name() string { return "test-namespaced" }
+
+func (d namespacedDecoder) Decode(raw []byte) (common.Address, error) {
+	if len(raw) != 24 || !bytes.Equal(raw[:4], d.namespace) {
+		return common.Address{}, errors.New("staker of another namespace")
+	}
+	return common.BytesToAddress(raw[4:]), nil
+}
+
+func TestListener_stakerDecoder(t *testing.T) {
+	RegisterStakerDecoder(hashedDecoder{})
+	RegisterStakerDecoder(namespacedDecoder{namespace: []byte("nlk1")})
+
+	contract := common.HexToAddress("0xa1")
+	staker := common.HexToAddress("0x01")
+	data := append(common.BigToHash(big.NewInt(10)).Bytes(), common.BigToHash(big.NewInt(1)).Bytes()...)
+	namespaced := func(ns string) common.Hash {
+		var h common.Hash
+		copy(h[8:], ns)
+		copy(h[12:], staker[:])
+		return h
+	}
+	tests := []struct {
+		name    string
+		decoder string
+		topic   *config.TopicSlice
+		topics  []common.Hash
+		want    common.Address
+		// the balance of the deposits merged under want
+		wantBalance int64
+	}{
+		{
+			name:    "raw",
+			decoder: config.StakerDecoderRaw,
+			topic:   &config.TopicSlice{Index: 1, Offset: 12, Length: 20},
+			topics:  []common.Hash{common.BytesToHash(staker[:]), common.BytesToHash(staker[:])},
+			want:    staker, wantBalance: 20,
+		},
+		{
+			// both deposits hash to the same identity and are merged
+			name:    "hashed",
+			decoder: "test-hashed",
+			topic:   &config.TopicSlice{Index: 1, Offset: 0, Length: 32},
+			topics:  []common.Hash{common.BytesToHash(staker[:]), common.BytesToHash(staker[:])},
+			want:    common.BytesToAddress(crypto.Keccak256(common.BytesToHash(staker[:]).Bytes())), wantBalance: 20,
+		},
+		{
+			// the deposit of another namespace is skipped
+			name:    "namespaced",
+			decoder: "test-namespaced",
+			topic:   &config.TopicSlice{Index: 1, Offset: 8, Length: 24},
+			topics:  []common.Hash{namespaced("nlk1"), namespaced("nlk2")},
+			want:    staker, wantBalance: 10,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := make([]*ethtypes.Log, 0, len(tt.topics))
+			for _, topic := range tt.topics {
+				logs = append(logs, &ethtypes.Log{Address: contract, Topics: []common.Hash{Deposited.GetTopic(), topic}, Data: data})
+			}
+			conn := newTestConnection(t, map[string]rpcHandler{
+				"eth_getLogs": func(params []json.RawMessage) (interface{}, *rpcError) { return logs, nil },
+			})
+			l := &Listener{
+				Config: &config.Config{EpochSize: 1000, MaxEventsPerBlock: config.MaxEventsPerBlock, EthereumConfig: config.EthereumConfig{
+					DepositContractAddr: contract.Hex(),
+					StakerTopic:         tt.topic,
+					StakerDecoder:       tt.decoder,
+				}},
+				Ethconn: conn,
+			}
+			defer resetStakeInfoList()
+			resetStakeInfoList()
+
+			if err := l.getDepositEventsForBlock(big.NewInt(1001)); err != nil {
+				t.Fatal(err)
+			}
+			if len(stakeInfoList) != 1 {
+				t.Fatalf("accumulated %d stakers, want 1", len(stakeInfoList))
+			}
+			info := stakeInfoList[0]
+			if common.BytesToAddress(info.WorkBase) != tt.want || info.Coinbase != substrate.EthAddrToAccountID(tt.want) {
+				t.Errorf("staker = %x with coinbase %x, want %s", info.WorkBase, info.Coinbase, tt.want.Hex())
+			}
+			if info.LockedBalance.Int64() != tt.wantBalance {
+				t.Errorf("staker balance = %s, want %d", info.LockedBalance, tt.wantBalance)
+			}
+		})
+	}
+}
+
+func TestLookupStakerDecoder(t *testing.T) {
+	if d, err := LookupStakerDecoder(""); err != nil || d.Name() != config.StakerDecoderRaw {
+		t.Errorf("LookupStakerDecoder(\"\") = %v, %v, want the raw decoder", d, err)
+	}
+	if _, err := LookupStakerDecoder("unknown"); err == nil {
+		t.Error("LookupStakerDecoder() of an unregistered name succeeded")
+	}
+	if _, err := (RawAddressDecoder{}).Decode(make([]byte, 21)); err == nil {
+		t.Error("RawAddressDecoder.Decode() of 21 bytes succeeded")
+	}
+}
@@ -0,0 +1,130 @@
+package ethereum
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/notify"
+)
+
+// epochState is the state an epoch update advances, restored when an attempt of the update fails. The batch
+// of SubmitEveryNEpochs keeps the epoch of a failed update, the set of its next epoch supersedes it anyway.
+type epochState struct {
+	first               bool
+	pending             *pendingSet
+	lastSubmitted       substrate.StakeInfos
+	lastInfos           substrate.StakeInfos
+	lastAbsent          map[string]uint64
+	deferredStopped     substrate.StakeInfos
+	deferredLoaded      bool
+	epochsSinceFullSync uint64
+	idleEpochs          uint64
+	churnHeld           substrate.StakeInfos
+	churnStable         uint64
+	epochQueue          []*pendingSet
+	locks               *lockState
+	locksDirty          bool
+}
+
+func (l *Listener) saveEpochState() epochState {
+	s := epochState{
+		first:               first,
+		pending:             l.pending,
+		lastSubmitted:       l.lastSubmitted,
+		lastInfos:           l.lastInfos,
+		lastAbsent:          l.lastAbsent,
+		deferredStopped:     l.deferredStopped,
+		deferredLoaded:      l.deferredLoaded,
+		epochsSinceFullSync: l.epochsSinceFullSync,
+		idleEpochs:          l.idleEpochs,
+		churnHeld:           l.churnHeld,
+		churnStable:         l.churnStable,
+		epochQueue:          append([]*pendingSet(nil), l.epochQueue...),
+		locksDirty:          l.locksDirty,
+	}
+	if l.locks != nil {
+		locks := *l.locks
+		locks.Locks = make(map[string]uint64, len(l.locks.Locks))
+		for k, v := range l.locks.Locks {
+			locks.Locks[k] = v
+		}
+		s.locks = &locks
+	}
+	return s
+}
+
+// restoreEpochState rolls the listener back to s. The epoch queue, persisted as soon as it changes, is
+// written back too.
+func (l *Listener) restoreEpochState(s epochState) {
+	first = s.first
+	l.pending = s.pending
+	l.lastSubmitted = s.lastSubmitted
+	l.lastInfos = s.lastInfos
+	l.lastAbsent = s.lastAbsent
+	l.deferredStopped = s.deferredStopped
+	l.deferredLoaded = s.deferredLoaded
+	l.epochsSinceFullSync = s.epochsSinceFullSync
+	l.idleEpochs = s.idleEpochs
+	l.churnHeld = s.churnHeld
+	l.churnStable = s.churnStable
+	l.locks, l.locksDirty = s.locks, s.locksDirty
+	if l.Config.EpochSubmissionOffset > 0 {
+		l.epochQueue = s.epochQueue
+		if err := l.writeEpochQueue(); err != nil {
+			log.Warn("failed to restore the epoch queue", "error", err)
+		}
+	}
+}
+
+// runEpoch runs the stake info update of the epoch boundary block as a transaction: when any of its steps
+// fails, reading the stake infos, comparing them with the last set, submitting or persisting them, the
+// state it advanced is rolled back and the whole update is tried again, up to EpochRetries more times with a
+// backoff doubling from EpochRetryBackoff up to MaxEpochRetryBackoff. The staker locks are persisted only once
+// the update succeeded. An update failing every attempt is alerted and its error returned; a run cancelled or
+// stopped during a backoff returns without trying again.
+func (l *Listener) runEpoch(block *big.Int, read func(*big.Int) (substrate.StakeInfos, error)) error {
+	backoff := l.Config.EpochRetryBackoff.Duration
+	for attempt := 0; ; attempt++ {
+		saved := l.saveEpochState()
+		err := l.syncEpoch(block, read)
+		if err == nil {
+			l.saveLocks()
+			return nil
+		}
+		l.restoreEpochState(saved)
+		if errors.Is(err, ErrStateUnavailable) {
+			return err
+		}
+		if attempt >= l.Config.EpochRetries {
+			l.Alerts.Alert(notify.KindEpoch, fmt.Sprintf("nulink watcher: the stake info update of the epoch at block %s failed after %d attempts: %v", block, attempt+1, err))
+			return err
+		}
+		l.stats.EpochRetries++
+		log.Warn("stake info update of the epoch failed, retrying it", "block", block, "attempt", attempt+1, "retries", l.Config.EpochRetries, "backoff", backoff, "error", err)
+		if err := l.waitRetry(backoff); err != nil {
+			return err
+		}
+		if backoff *= 2; backoff > l.Config.MaxEpochRetryBackoff.Duration {
+			backoff = l.Config.MaxEpochRetryBackoff.Duration
+		}
+	}
+}
+
+// waitRetry waits d on the Clock of the listener before the next attempt of an epoch update. It returns the
+// error of the running Run when its context is done or the listener is stopped first.
+func (l *Listener) waitRetry(d time.Duration) error {
+	ctx := l.context()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-l.Stop:
+		return ErrPollingTerminated
+	case <-l.clock().After(d):
+		return nil
+	}
+}
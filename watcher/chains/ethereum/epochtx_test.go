@@ -0,0 +1,194 @@
+package ethereum
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+	"github.com/NuLink-network/watcher/watcher/notify"
+)
+
+// A failure at any step of the epoch update rolls it back and the whole update is tried again
+func TestListener_runEpoch(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	errRead := errors.New("read failed")
+	infos := substrate.StakeInfos{{Coinbase: Coinbase[0], WorkBase: WorkBase[0], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(10))}}
+
+	tests := []struct {
+		name string
+		// fail sets the step up to fail on the first attempt, fix repairs it before the second one
+		fail, fix func(l *Listener, sub *substrate.MockSubmitter, dir string)
+	}{
+		{name: "snapshot"},
+		{
+			name: "diff",
+			fail: func(l *Listener, sub *substrate.MockSubmitter, dir string) {
+				_ = ioutil.WriteFile(l.LastStakeInfoPath, []byte("{"), 0664)
+			},
+			fix: func(l *Listener, sub *substrate.MockSubmitter, dir string) {
+				_ = ioutil.WriteFile(l.LastStakeInfoPath, nil, 0664)
+			},
+		},
+		{
+			name: "submit",
+			fail: func(l *Listener, sub *substrate.MockSubmitter, dir string) { sub.Err = errors.New("submit failed") },
+			fix:  func(l *Listener, sub *substrate.MockSubmitter, dir string) { sub.Err = nil },
+		},
+		{
+			// the temporary file next to a name this long exceeds the name limit, reading it finds no file
+			name: "persist",
+			fail: func(l *Listener, sub *substrate.MockSubmitter, dir string) {
+				l.LastStakeInfoPath = filepath.Join(dir, strings.Repeat("s", 245))
+			},
+			fix: func(l *Listener, sub *substrate.MockSubmitter, dir string) {
+				l.LastStakeInfoPath = filepath.Join(dir, "stake-info.json")
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first = false
+			dir := t.TempDir()
+			sub := &substrate.MockSubmitter{}
+			clock := &fakeClock{now: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)}
+			l := &Listener{
+				Config: &config.Config{EpochSize: 1000, SubmitMode: config.SubmitModeFull, EpochRetries: 1,
+					EpochRetryBackoff: config.Duration{Duration: time.Second}, EthereumConfig: config.EthereumConfig{BlockConfirmations: big.NewInt(0)}},
+				Ethconn:           newTestConnection(t, map[string]rpcHandler{}),
+				Subconn:           sub,
+				LastStakeInfoPath: filepath.Join(dir, "stake-info.json"),
+				Clock:             clock,
+			}
+			if tt.fail != nil {
+				tt.fail(l, sub, dir)
+			}
+			attempts := 0
+			read := func(block *big.Int) (substrate.StakeInfos, error) {
+				attempts++
+				if attempts == 1 && tt.fail == nil {
+					return nil, errRead
+				}
+				if attempts == 2 {
+					if tt.fix != nil {
+						tt.fix(l, sub, dir)
+					}
+					// the failed attempt left no state behind
+					if l.lastSubmitted != nil {
+						t.Errorf("second attempt started with %v submitted", l.lastSubmitted)
+					}
+					if persisted, err := ReadStakeInfos(l.LastStakeInfoPath); err != nil || len(persisted) != 0 {
+						t.Errorf("second attempt found %v, %v persisted, want nothing", persisted, err)
+					}
+				}
+				return infos, nil
+			}
+
+			if err := l.runEpoch(big.NewInt(1000), read); err != nil {
+				t.Fatalf("runEpoch() error = %v", err)
+			}
+			if attempts != 2 || l.stats.EpochRetries != 1 {
+				t.Errorf("runEpoch() took %d attempts, %d retries, want 2 and 1", attempts, l.stats.EpochRetries)
+			}
+			if persisted, err := ReadStakeInfos(l.LastStakeInfoPath); err != nil || len(persisted) != 1 {
+				t.Errorf("persisted %v, %v, want the set of the epoch", persisted, err)
+			}
+			if got := clock.Now().Sub(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)); got < time.Second {
+				t.Errorf("waited %s between the attempts, want the backoff of 1s", got)
+			}
+		})
+	}
+}
+
+func TestListener_runEpochExhausted(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = false
+	n := make(testNotifier, 1)
+	clock := &fakeClock{now: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := &Listener{
+		Config: &config.Config{EpochSize: 1000, EpochRetries: 2, EpochRetryBackoff: config.Duration{Duration: time.Second},
+			MaxEpochRetryBackoff: config.Duration{Duration: 1500 * time.Millisecond},
+			EthereumConfig:       config.EthereumConfig{BlockConfirmations: big.NewInt(0)}},
+		Ethconn: newTestConnection(t, map[string]rpcHandler{}),
+		Subconn: &substrate.MockSubmitter{},
+		Alerts:  notify.NewAlerter(n, 3, time.Second),
+		Clock:   clock,
+	}
+	errRead := errors.New("read failed")
+	attempts := 0
+	err := l.runEpoch(big.NewInt(1000), func(*big.Int) (substrate.StakeInfos, error) {
+		attempts++
+		return nil, errRead
+	})
+	if !errors.Is(err, errRead) || attempts != 3 {
+		t.Fatalf("runEpoch() = %v after %d attempts, want %v after 3", err, attempts, errRead)
+	}
+	// the backoff doubles from 1s, capped at 1.5s
+	if got := clock.Now().Sub(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)); got != 2500*time.Millisecond {
+		t.Errorf("waited %s between the attempts, want 2.5s", got)
+	}
+	select {
+	case e := <-n:
+		if e.Kind != notify.KindEpoch {
+			t.Errorf("alert kind = %s, want %s", e.Kind, notify.KindEpoch)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no alert after the retries were exhausted")
+	}
+}
+
+// A cancelled or stopped run doesn't wait the backoff of a failed epoch update out
+func TestListener_runEpochInterrupted(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = false
+	errRead := errors.New("read failed")
+
+	tests := []struct {
+		name      string
+		interrupt func(l *Listener, cancel context.CancelFunc)
+		wantErr   error
+	}{
+		{name: "cancel", interrupt: func(l *Listener, cancel context.CancelFunc) { cancel() }, wantErr: context.Canceled},
+		{name: "stop", interrupt: func(l *Listener, cancel context.CancelFunc) { l.Stop <- struct{}{} }, wantErr: ErrPollingTerminated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			l := &Listener{
+				Config: &config.Config{EpochSize: 1000, EpochRetries: 3, EpochRetryBackoff: config.Duration{Duration: time.Hour},
+					MaxEpochRetryBackoff: config.Duration{Duration: time.Hour}, EthereumConfig: config.EthereumConfig{BlockConfirmations: big.NewInt(0)}},
+				Ethconn: newTestConnection(t, map[string]rpcHandler{}),
+				Subconn: &substrate.MockSubmitter{},
+				Stop:    make(chan struct{}, 1),
+				ctx:     ctx,
+			}
+			attempts := 0
+			done := make(chan error, 1)
+			go func() {
+				done <- l.runEpoch(big.NewInt(1000), func(*big.Int) (substrate.StakeInfos, error) {
+					attempts++
+					return nil, errRead
+				})
+			}()
+			time.Sleep(50 * time.Millisecond)
+			tt.interrupt(l, cancel)
+			select {
+			case err := <-done:
+				if !errors.Is(err, tt.wantErr) || attempts != 1 {
+					t.Errorf("runEpoch() = %v after %d attempts, want %v after 1", err, attempts, tt.wantErr)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("runEpoch() still waiting the backoff out")
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package ethereum
+
+import "errors"
+
+var (
+	// ErrPollingTerminated is returned by PollBlocks when the stop channel is closed
+	ErrPollingTerminated = errors.New("polling terminated")
+	// ErrRetriesExceeded is returned by PollBlocks when the latest block can't be fetched within BlockRetryLimit attempts
+	ErrRetriesExceeded = errors.New("polling failed, retries exceeded")
+	// ErrReorgTooDeep is returned when a reorg reaches below the blocks the listener can rescan
+	ErrReorgTooDeep = errors.New("reorg too deep")
+	// ErrStateStale is returned when persisted state is older than the configured maximum age
+	ErrStateStale = errors.New("persisted state is stale")
+	// ErrFutureTimestamp is returned when a persisted timestamp is further ahead than the allowed clock skew
+	ErrFutureTimestamp = errors.New("persisted timestamp is in the future")
+	// ErrSubmissionLate is returned when a submission misses the SubmissionDeadline after its epoch boundary
+	ErrSubmissionLate = errors.New("submission deadline exceeded")
+	// ErrFutureEpoch is returned when resubmitting an epoch that hasn't started yet
+	ErrFutureEpoch = errors.New("epoch not started yet")
+	// ErrNoHistory is returned when no stake infos were recorded for the epoch to resubmit
+	ErrNoHistory = errors.New("no stake info history for epoch")
+	// ErrTooManyEvents is returned when a block holds more deposit events than MaxEventsPerBlock and HaltOnEventLimit is set
+	ErrTooManyEvents = errors.New("too many deposit events in block")
+	// ErrIncompleteStakeInfos is returned when more stakers than MaxStakerFetchFailures couldn't be read
+	ErrIncompleteStakeInfos = errors.New("too many stakers couldn't be read")
+	// ErrZeroStakers is returned when the deposit contract reports no stakers at all
+	ErrZeroStakers = errors.New("deposit contract reports no stakers")
+	// ErrStateUnavailable is returned when the ethereum node doesn't hold the state of a requested block, e.g. a pruned node
+	ErrStateUnavailable = errors.New("state not available, an archive node is required")
+	// ErrUnknownStateVersion is returned when a stake info file was written by a newer version of the watcher
+	ErrUnknownStateVersion = errors.New("unknown stake info file version")
+)
@@ -0,0 +1,35 @@
+package ethereum
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// exportEvents writes the deposit events found in the polled block to the Events csv. It only runs once all
+// contracts were read, so a block retried after a failed read isn't exported twice, and like the sinks its
+// failures are only logged. An event whose block time can't be read is exported without a timestamp.
+func (l *Listener) exportEvents() {
+	if l.Events == nil || len(l.exported) == 0 {
+		return
+	}
+	// the contracts may lag the polled block by different confirmations, each block is read once
+	times := make(map[uint64]time.Time)
+	for i := range l.exported {
+		e := &l.exported[i]
+		t, ok := times[e.Block.Uint64()]
+		if !ok {
+			var err error
+			if t, err = l.Ethconn.BlockTime(e.Block); err != nil {
+				log.Warn("Failed to read the block time of deposit events", "block", e.Block, "error", err)
+			}
+			times[e.Block.Uint64()] = t
+		}
+		e.Time = t
+	}
+	if err := l.Events.Write(l.exported); err != nil {
+		log.Warn("failed to export deposit events", "sink", l.Events.Name(), "count", len(l.exported), "error", err)
+		return
+	}
+	log.Debug("exported deposit events", "sink", l.Events.Name(), "count", len(l.exported))
+}
@@ -0,0 +1,60 @@
+package ethereum
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+// snapshotError is the failure to read the stake infos of an epoch boundary, handled by OnSnapshotFailure
+type snapshotError struct {
+	err error
+}
+
+func (e *snapshotError) Error() string { return e.err.Error() }
+
+func (e *snapshotError) Unwrap() error { return e.err }
+
+// recoverSnapshot applies OnSnapshotFailure to the stake info update of the boundary block that failed
+// with err. Any other failure than reading its stake infos is returned as is.
+func (l *Listener) recoverSnapshot(block *big.Int, err error) error {
+	var se *snapshotError
+	if !errors.As(err, &se) {
+		return err
+	}
+	l.stats.SnapshotFailures++
+	switch l.Config.OnSnapshotFailure {
+	case config.SnapshotFailureAbort:
+		return err
+	case config.SnapshotFailureResubmitLast:
+		return l.resubmitLast(block, err)
+	default:
+		log.Warn("skip the stake info update of the epoch, its stake infos couldn't be read", "block", block, "error", err)
+		return nil
+	}
+}
+
+// resubmitLast submits the last persisted stake infos again for the boundary block, keeping the validator
+// set of the pallet through a failed read. Like any update it is held while submissions are paused or the
+// boundary isn't finalized. Without a persisted set the epoch is skipped.
+func (l *Listener) resubmitLast(block *big.Int, cause error) error {
+	infos, absent, err := l.readLastStakeInfos()
+	if err != nil {
+		log.Warn("skip the stake info update of the epoch, the last stake infos couldn't be read", "block", block, "error", err, "cause", cause)
+		return nil
+	}
+	if len(infos) == 0 {
+		log.Warn("skip the stake info update of the epoch, no stake infos persisted to resubmit", "block", block, "cause", cause)
+		return nil
+	}
+	submit, ok := l.fillTopN(infos)
+	if !ok {
+		return nil
+	}
+	log.Warn("stake infos couldn't be read, resubmitting the last stake infos", "block", block, "count", len(infos), "error", cause)
+	set := &pendingSet{block: block, top: infos, absent: absent, submit: submit}
+	return l.submitOrHold(set, l.submissionDeadline(l.clock().Now()))
+}
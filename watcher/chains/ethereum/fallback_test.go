@@ -0,0 +1,99 @@
+package ethereum
+
+import (
+	"encoding/json"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+func TestListener_onSnapshotFailure(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+
+	a := common.BytesToAddress(WorkBase[0])
+	tests := []struct {
+		name   string
+		policy string
+		// the first boundary whose stake infos can't be read
+		failFrom int64
+		// pauses the submissions before the failing boundary
+		pause        func(l *Listener)
+		wantErr      bool
+		wantFailures uint64
+		// the balances of the submissions of the boundaries 1000 and 2000
+		want []int64
+		// the resubmission is held for after the pause
+		wantPending bool
+	}{
+		{name: "skip", policy: config.SnapshotFailureSkip, failFrom: 2000, wantFailures: 1, want: []int64{10}},
+		{name: "resubmit last", policy: config.SnapshotFailureResubmitLast, failFrom: 2000, wantFailures: 1, want: []int64{10, 10}},
+		{name: "abort", policy: config.SnapshotFailureAbort, failFrom: 2000, wantErr: true, wantFailures: 1, want: []int64{10}},
+		// nothing persisted yet to resubmit
+		{name: "resubmit nothing", policy: config.SnapshotFailureResubmitLast, failFrom: 1000, wantFailures: 2},
+		{name: "resubmit in maintenance", policy: config.SnapshotFailureResubmitLast, failFrom: 2000, wantFailures: 1, want: []int64{10},
+			pause: func(l *Listener) { l.SetMaintenance(true) }, wantPending: true},
+		{name: "resubmit in replica standby", policy: config.SnapshotFailureResubmitLast, failFrom: 2000, wantFailures: 1, want: []int64{10},
+			pause: func(l *Listener) { l.Config.Replica.Enabled = true }, wantPending: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first = false
+			var tags []string
+			contract := stakingContract(t, map[string]map[common.Address]int64{"latest": {a: 10}}, &tags)
+			failing := false
+			conn := newTestConnection(t, map[string]rpcHandler{
+				"eth_call": func(params []json.RawMessage) (interface{}, *rpcError) {
+					if failing {
+						return nil, &rpcError{Code: -32000, Message: "upstream unavailable"}
+					}
+					return contract(params)
+				},
+			})
+			sub := &substrate.MockSubmitter{}
+			l := &Listener{
+				Config: &config.Config{EpochSize: 1000, SubmitMode: config.SubmitModeFull, OnSnapshotFailure: tt.policy,
+					EthereumConfig: config.EthereumConfig{BlockConfirmations: big.NewInt(0), DepositContractAddr: a.Hex()}},
+				Ethconn:           conn,
+				Subconn:           sub,
+				LastStakeInfoPath: filepath.Join(t.TempDir(), "stake-info.json"),
+			}
+
+			var err error
+			for _, block := range []int64{1000, 2000} {
+				failing = block >= tt.failFrom
+				if failing && tt.pause != nil {
+					tt.pause(l)
+				}
+				if err = l.syncStakeInfos(big.NewInt(block)); err != nil {
+					break
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("syncStakeInfos() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			calls := sub.Calls()
+			if len(calls) != len(tt.want) {
+				t.Fatalf("made %d submissions, want %d", len(calls), len(tt.want))
+			}
+			for i, c := range calls {
+				infos := c.Args[0].(substrate.StakeInfos)
+				if len(infos) != 1 || infos[0].LockedBalance.Int64() != tt.want[i] || common.BytesToAddress(infos[0].WorkBase) != a {
+					t.Errorf("submission %d = %v, want the staker with %d", i, infos, tt.want[i])
+				}
+			}
+			if got := l.pending != nil; got != tt.wantPending {
+				t.Errorf("holds a pending update = %v, want %v", got, tt.wantPending)
+			} else if got && (len(l.pending.submit) != 1 || l.pending.submit[0].LockedBalance.Int64() != 10) {
+				t.Errorf("holds %v, want the last stake infos", l.pending.submit)
+			}
+			if l.stats.SnapshotFailures != tt.wantFailures {
+				t.Errorf("counted %d snapshot failures, want %d", l.stats.SnapshotFailures, tt.wantFailures)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package ethereum
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+// pollsDeposits reports whether the stake info updates take the deposit events polled block by block
+func (l *Listener) pollsDeposits() bool {
+	return l.Config.EpochSource == config.EpochSourceEvents || l.Config.EpochSource == config.EpochSourceMerged
+}
+
+// pollDeposits accumulates the deposit events of the blocks after current up to latest, flushing the epoch
+// at every boundary among them. It does nothing with the snapshot source.
+func (l *Listener) pollDeposits(current, latest *big.Int) error {
+	if !l.pollsDeposits() {
+		return nil
+	}
+	for block := new(big.Int).Add(current, big.NewInt(1)); block.Cmp(latest) <= 0; block.Add(block, big.NewInt(1)) {
+		if err := l.getDepositEventsForBlock(new(big.Int).Set(block)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flushEpoch is the single decision on the stake info update of the epoch boundary block, reached both
+// from polling the deposit events and from syncing the stake infos. The first path reaching a boundary
+// submits it, the other finds it, or a later one, flushed. The EpochSource picks what is submitted: the
+// snapshot read at the boundary, the deposits polled during the epoch, or the two merged.
+func (l *Listener) flushEpoch(block *big.Int) error {
+	if l.lastFlush != nil && l.lastFlush.Cmp(block) >= 0 {
+		log.Debug("epoch boundary already flushed", "block", block, "source", l.Config.EpochSource)
+		return nil
+	}
+	if l.pollsDeposits() {
+		if !l.Config.IsEpochBoundary(block.Uint64()) {
+			// the deposits are flushed at the boundary ending their epoch, a startup has nothing to submit
+			first = false
+			return nil
+		}
+		if err := l.flushDeposits(block); err != nil {
+			return err
+		}
+	} else if err := l.recoverSnapshot(block, l.runEpoch(block, l.epochStakeInfos)); err != nil {
+		return err
+	}
+	l.lastFlush = new(big.Int).Set(block)
+	return nil
+}
+
+// flushDeposits runs the stake info update of the boundary block from the deposits accumulated during the
+// epoch and starts accumulating the next one. A failed update keeps the deposits for its retry.
+func (l *Listener) flushDeposits(block *big.Int) error {
+	err := l.mergeSpilled()
+	if err != nil {
+		return err
+	}
+	// the spilled deposits are in memory now, merging them again would count them twice
+	l.clearSpill()
+	read := l.depositStakeInfos
+	if l.Config.EpochSource == config.EpochSourceMerged {
+		read = l.mergedStakeInfos
+	}
+	if err := l.recoverSnapshot(block, l.runEpoch(block, read)); err != nil {
+		return err
+	}
+	resetStakeInfoList()
+	l.clearDepositCheckpoint()
+	return nil
+}
+
+// depositStakeInfos returns a copy of the stake infos accumulated from the deposit events, ErrZeroStakers
+// when the epoch had none
+func (l *Listener) depositStakeInfos(*big.Int) (substrate.StakeInfos, error) {
+	if len(stakeInfoList) == 0 {
+		return nil, fmt.Errorf("%w: no deposit events in the epoch", ErrZeroStakers)
+	}
+	infos := make(substrate.StakeInfos, len(stakeInfoList))
+	for i, info := range stakeInfoList {
+		c := *info
+		infos[i] = &c
+	}
+	return infos, nil
+}
+
+// mergedStakeInfos returns the snapshot of the boundary block with the stakers only the deposit events of
+// the epoch hold added, a staker in both keeping its snapshot balance
+func (l *Listener) mergedStakeInfos(block *big.Int) (substrate.StakeInfos, error) {
+	infos, err := l.epochStakeInfos(block)
+	if err != nil && !errors.Is(err, ErrZeroStakers) {
+		return nil, err
+	}
+	deposits, derr := l.depositStakeInfos(block)
+	if derr != nil {
+		return infos, err
+	}
+	seen := make(map[string]bool, len(infos))
+	for _, info := range infos {
+		seen[string(info.WorkBase)] = true
+	}
+	merged := append(substrate.StakeInfos{}, infos...)
+	for _, info := range deposits {
+		if !seen[string(info.WorkBase)] {
+			merged = append(merged, info)
+		}
+	}
+	return merged, nil
+}
@@ -0,0 +1,98 @@
+package ethereum
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+// Both the deposit event polling and the stake info sync reach every boundary, each is submitted once
+func TestListener_flushEpochOnce(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	defer resetStakeInfoList()
+
+	a := common.BytesToAddress(WorkBase[0])
+	e := common.HexToAddress("0x02")
+	data := append(common.BigToHash(big.NewInt(1)).Bytes(), common.BigToHash(big.NewInt(1)).Bytes()...)
+	tests := []struct {
+		name   string
+		source string
+		// the blocks polled as the safe head, the last one ends the run
+		heads []int64
+		// the balances per staker of each submission
+		want []map[common.Address]int64
+	}{
+		{name: "snapshot", source: config.EpochSourceSnapshot, heads: []int64{151, 253}, want: []map[common.Address]int64{{a: 10}, {a: 10}}},
+		// staker e deposits 1 in every block, 100 per epoch
+		{name: "events", source: config.EpochSourceEvents, heads: []int64{151, 253}, want: []map[common.Address]int64{{e: 100}, {e: 100}}},
+		{name: "merged", source: config.EpochSourceMerged, heads: []int64{151, 253}, want: []map[common.Address]int64{{a: 10, e: 100}, {a: 10, e: 100}}},
+		// both boundaries crossed in a single poll
+		{name: "events in one poll", source: config.EpochSourceEvents, heads: []int64{253}, want: []map[common.Address]int64{{e: 100}, {e: 100}}},
+		{name: "merged in one poll", source: config.EpochSourceMerged, heads: []int64{253}, want: []map[common.Address]int64{{a: 10, e: 100}, {a: 10, e: 100}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first = false
+			resetStakeInfoList()
+			var tags []string
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			polls := 0
+			conn := newTestConnection(t, map[string]rpcHandler{
+				"eth_getBlockByNumber": func(params []json.RawMessage) (interface{}, *rpcError) {
+					var tag string
+					_ = json.Unmarshal(params[0], &tag)
+					if tag != "latest" {
+						n, _ := new(big.Int).SetString(tag[2:], 16)
+						return testHeader(n.Int64()), nil
+					}
+					if polls == len(tt.heads) {
+						cancel()
+						return testHeader(tt.heads[len(tt.heads)-1]), nil
+					}
+					polls++
+					return testHeader(tt.heads[polls-1]), nil
+				},
+				"eth_getLogs": func(params []json.RawMessage) (interface{}, *rpcError) {
+					return []*ethtypes.Log{{Address: a, Topics: []common.Hash{Deposited.GetTopic(), common.BytesToHash(e[:])}, Data: data}}, nil
+				},
+				"eth_call":    stakingContract(t, map[string]map[common.Address]int64{"latest": {a: 10}}, &tags),
+				"eth_getCode": func(params []json.RawMessage) (interface{}, *rpcError) { return "0x01", nil },
+			})
+			sub := &substrate.MockSubmitter{}
+			l := &Listener{
+				Config: &config.Config{EpochSize: 100, SubmitMode: config.SubmitModeFull, CatchUpEpochs: true, EpochSource: tt.source,
+					MaxEventsPerBlock: config.MaxEventsPerBlock, PollInterval: config.Duration{Duration: time.Millisecond},
+					EthereumConfig: config.EthereumConfig{BlockConfirmations: big.NewInt(0), StartBlock: big.NewInt(0),
+						DepositContractAddr: a.Hex(), StakerTopic: &config.TopicSlice{Index: 1, Offset: 12, Length: 20}}},
+				Ethconn: conn,
+				Subconn: sub,
+				Stop:    make(chan struct{}, 1),
+			}
+			if _, err := l.Run(ctx); !errors.Is(err, context.Canceled) {
+				t.Fatalf("Run() error = %v, want %v", err, context.Canceled)
+			}
+			var got []map[common.Address]int64
+			for _, c := range sub.Calls() {
+				balances := make(map[common.Address]int64)
+				for _, info := range c.Args[0].(substrate.StakeInfos) {
+					balances[common.BytesToAddress(info.WorkBase)] = info.LockedBalance.Int64()
+				}
+				got = append(got, balances)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Run() submitted %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package ethereum
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/notify"
+)
+
+// tripGuard puts the listener in the safe state for reason: every submission is held back until the watcher
+// is restarted. Only the first tripped guard is alerted, the listener keeps polling meanwhile.
+func (l *Listener) tripGuard(reason string, ctx ...interface{}) {
+	if l.unsafe != "" {
+		return
+	}
+	l.unsafe = reason
+	log.Error("Safety guard tripped, submissions are held back until the watcher is restarted", append([]interface{}{"reason", reason}, ctx...)...)
+	l.Alerts.Alert(notify.KindSafety, fmt.Sprintf("nulink watcher: %s, submissions are held back", reason))
+}
+
+// guardEpochSize trips when the epochs are shorter than the MinEpochSize of the SafetyGuards
+func (l *Listener) guardEpochSize() {
+	g := l.Config.SafetyGuards
+	if g.Disabled || l.Config.EpochSize >= g.MinEpochSize {
+		return
+	}
+	l.tripGuard(fmt.Sprintf("epoch size %d below the minimum of %d", l.Config.EpochSize, g.MinEpochSize), "epochSize", l.Config.EpochSize, "min", g.MinEpochSize)
+}
+
+// guardStartBlock trips when the block polling waits for is more than MaxStartBlockAhead blocks above the
+// safe head, a start block the chain won't reach for a long time
+func (l *Listener) guardStartBlock(current, safeHead *big.Int) {
+	g := l.Config.SafetyGuards
+	if g.Disabled || g.MaxStartBlockAhead == 0 {
+		return
+	}
+	ahead := new(big.Int).Sub(current, safeHead)
+	if ahead.Cmp(new(big.Int).SetUint64(g.MaxStartBlockAhead)) <= 0 {
+		return
+	}
+	l.tripGuard(fmt.Sprintf("start block %s is %s blocks ahead of the safe head %s", current, ahead, safeHead), "max", g.MaxStartBlockAhead)
+}
+
+// guardSetSize trips when the set of the epoch at block shrinks below the MinSetSize of the SafetyGuards
+// from a last submitted set that held at least as many stakers
+func (l *Listener) guardSetSize(block *big.Int, top, last substrate.StakeInfos) {
+	g := l.Config.SafetyGuards
+	if g.Disabled || g.MinSetSize == 0 || len(top) >= g.MinSetSize || len(last) < g.MinSetSize {
+		return
+	}
+	l.tripGuard(fmt.Sprintf("stake info set at block %s shrank from %d to %d stakers, below the minimum of %d", block, len(last), len(top), g.MinSetSize), "block", block)
+}
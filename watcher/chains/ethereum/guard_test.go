@@ -0,0 +1,148 @@
+package ethereum
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+	"github.com/NuLink-network/watcher/watcher/notify"
+)
+
+func TestListener_guardEpochSize(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = false
+
+	tests := []struct {
+		name      string
+		guards    config.SafetyGuardConfig
+		epochSize uint64
+		wantTrip  bool
+	}{
+		{name: "long-epochs", guards: config.SafetyGuardConfig{MinEpochSize: 100}, epochSize: 100},
+		{name: "short-epochs", guards: config.SafetyGuardConfig{MinEpochSize: 100}, epochSize: 10, wantTrip: true},
+		{name: "disabled", guards: config.SafetyGuardConfig{Disabled: true, MinEpochSize: 100}, epochSize: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := make(testNotifier, 4)
+			sub := &substrate.MockSubmitter{}
+			l := &Listener{
+				Config:  &config.Config{EpochSize: tt.epochSize, SubmitMode: config.SubmitModeFull, SafetyGuards: tt.guards},
+				Ethconn: newTestConnection(t, nil),
+				Source:  emptySource{},
+				Subconn: sub,
+				Alerts:  notify.NewAlerter(n, 3, time.Second),
+			}
+			l.guardEpochSize()
+			if err := l.syncStakeInfos(big.NewInt(1000)); err != nil {
+				t.Fatal(err)
+			}
+			if tripped := l.unsafe != ""; tripped != tt.wantTrip {
+				t.Fatalf("tripped = %v (%q), want %v", tripped, l.unsafe, tt.wantTrip)
+			}
+			if submitted := len(sub.Calls()) > 0; submitted == tt.wantTrip {
+				t.Errorf("submitted = %v, want %v", submitted, !tt.wantTrip)
+			}
+			if !tt.wantTrip {
+				return
+			}
+			if l.pending == nil {
+				t.Errorf("the update of the unsafe epoch wasn't held")
+			}
+			select {
+			case e := <-n:
+				if e.Kind != notify.KindSafety {
+					t.Errorf("alert kind = %q, want %q", e.Kind, notify.KindSafety)
+				}
+			case <-time.After(time.Second):
+				t.Errorf("no alert for the tripped guard")
+			}
+		})
+	}
+}
+
+func TestListener_guardSetSize(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = false
+	resetStakeInfoList()
+
+	staker := ethcommon.BytesToAddress(WorkBase[0])
+	var tags []string
+	conn := newTestConnection(t, map[string]rpcHandler{
+		"eth_call": stakingContract(t, map[string]map[ethcommon.Address]int64{"latest": {staker: 100}}, &tags),
+	})
+	tests := []struct {
+		name     string
+		last     substrate.StakeInfos
+		wantTrip bool
+	}{
+		{name: "first-set", last: nil},
+		{name: "shrank-to-min", last: churnSet(0, 10, 10), wantTrip: true},
+		{name: "already-small", last: churnSet(0, 1, 10)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := &substrate.MockSubmitter{}
+			l := &Listener{
+				Config: &config.Config{EpochSize: 1000, SubmitMode: config.SubmitModeFull, SafetyGuards: config.SafetyGuardConfig{MinSetSize: 2},
+					EthereumConfig: config.EthereumConfig{BlockConfirmations: big.NewInt(0)}},
+				Ethconn: conn,
+				Subconn: sub,
+			}
+			l.lastInfos = tt.last
+			if err := l.syncStakeInfos(big.NewInt(1000)); err != nil {
+				t.Fatal(err)
+			}
+			if tripped := l.unsafe != ""; tripped != tt.wantTrip {
+				t.Fatalf("tripped = %v (%q), want %v", tripped, l.unsafe, tt.wantTrip)
+			}
+			if submitted := len(sub.Calls()) > 0; submitted == tt.wantTrip {
+				t.Errorf("submitted = %v, want %v", submitted, !tt.wantTrip)
+			}
+		})
+	}
+}
+
+func TestListener_guardStartBlock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var calls int
+	conn := newTestConnection(t, map[string]rpcHandler{
+		"eth_getBlockByNumber": func(params []json.RawMessage) (interface{}, *rpcError) {
+			if calls++; calls == 3 {
+				cancel()
+			}
+			return testHeader(50), nil
+		},
+	})
+	l := &Listener{
+		Config: &config.Config{
+			EpochSize:    1000,
+			PollInterval: config.Duration{Duration: time.Millisecond},
+			SafetyGuards: config.SafetyGuardConfig{MinEpochSize: 100, MaxStartBlockAhead: 1000},
+			EthereumConfig: config.EthereumConfig{
+				BlockConfirmations: big.NewInt(0),
+				StartBlock:         big.NewInt(1000000),
+			},
+		},
+		Ethconn: conn,
+		Subconn: &substrate.MockSubmitter{},
+		Stop:    make(chan struct{}, 1),
+	}
+	if _, err := l.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run() error = %v, want %v", err, context.Canceled)
+	}
+	if l.unsafe == "" || !l.submissionsPaused() {
+		t.Errorf("Run() from a start block far ahead of the chain didn't trip the guard")
+	}
+	if s := l.metricsSnapshot(0); s.Unsafe != l.unsafe {
+		t.Errorf("metrics unsafe = %q, want %q", s.Unsafe, l.unsafe)
+	}
+}
@@ -0,0 +1,78 @@
+package ethereum
+
+import (
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+)
+
+// HistoryFile returns the file in dir holding the stake infos submitted for epoch
+func HistoryFile(dir string, epoch uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("epoch-%d.json", epoch))
+}
+
+// writeHistory keeps the full set submitted at block in HistoryDir, an empty HistoryDir disables it
+func (l *Listener) writeHistory(block *big.Int, infos substrate.StakeInfos) {
+	if l.HistoryDir == "" {
+		return
+	}
+	file := HistoryFile(l.HistoryDir, l.Config.Epoch(block.Uint64()))
+	if err := writeStakeInfoFile(file, infos, nil, nil, l.Config.CompressState); err != nil {
+		log.Warn("Failed to write stake info history", "path", file, "error", err)
+	}
+}
+
+// Resubmit submits the top stakers of the stake infos recorded in the history for epoch again, without
+// waiting for the epoch boundary. Epochs after the one of the safe head are refused. With dryRun the
+// stakers are only returned.
+func (l *Listener) Resubmit(epoch uint64, dryRun bool) (substrate.StakeInfos, error) {
+	head, err := l.Ethconn.SafeHead(l.Config.EthereumConfig.BlockConfirmations)
+	if err != nil {
+		return nil, err
+	}
+	if current := l.Config.Epoch(head.Uint64()); epoch > current {
+		return nil, fmt.Errorf("%w: epoch %d is after the current epoch %d", ErrFutureEpoch, epoch, current)
+	}
+	if l.HistoryDir == "" {
+		return nil, fmt.Errorf("%w: no history directory configured", ErrNoHistory)
+	}
+
+	file := HistoryFile(l.HistoryDir, epoch)
+	exists, err := fileExists(file)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: %s does not exist", ErrNoHistory, file)
+	}
+	infos, _, err := readStakeInfoFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	top := l.selectTop(infos)
+	if !l.verifyTopN(top) {
+		return nil, fmt.Errorf("%w: history of epoch %d", substrate.ErrInvalidTopN, epoch)
+	}
+	padded, ok := l.fillTopN(top)
+	if !ok {
+		return nil, fmt.Errorf("history of epoch %d holds only %d stakers", epoch, len(top))
+	}
+	top = padded
+	if dryRun {
+		return top, nil
+	}
+
+	block := new(big.Int).SetUint64(epoch*l.Config.EpochSize + l.Config.EpochOffset)
+	log.Info("resubmitting stake info", "epoch", epoch, "block", block, "count", len(top))
+	if err := l.submitStakeInfos(block, top, time.Time{}); err != nil {
+		return nil, fmt.Errorf("failed to resubmit stake info of epoch %d: %w", epoch, err)
+	}
+	l.stats.Submissions++
+	return top, nil
+}
@@ -0,0 +1,62 @@
+package ethereum
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+func TestListener_Resubmit(t *testing.T) {
+	dir := t.TempDir()
+	history := substrate.StakeInfos{
+		{Coinbase: Coinbase[0], WorkBase: WorkBase[0], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(1))},
+		{Coinbase: Coinbase[1], WorkBase: WorkBase[1], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(2))},
+	}
+	if err := writeStakeInfoFile(HistoryFile(dir, 1), history, nil, nil, false); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		epoch      uint64
+		historyDir string
+		dryRun     bool
+		wantErr    error
+		wantCalls  int
+	}{
+		{name: "resubmit", epoch: 1, historyDir: dir, wantCalls: 1},
+		{name: "dry run", epoch: 1, historyDir: dir, dryRun: true},
+		{name: "future epoch", epoch: 3, historyDir: dir, wantErr: ErrFutureEpoch},
+		{name: "missing history", epoch: 2, historyDir: dir, wantErr: ErrNoHistory},
+		{name: "no history dir", epoch: 1, wantErr: ErrNoHistory},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := &substrate.MockSubmitter{}
+			l := &Listener{
+				Config: &config.Config{EpochSize: 1000, UndersizedPolicy: config.UndersizedWarn, EthereumConfig: config.EthereumConfig{
+					BlockConfirmations: big.NewInt(0),
+				}},
+				Ethconn:    newTestConnection(t, map[string]rpcHandler{"eth_getBlockByNumber": blockByNumber(2500, nil)}),
+				Subconn:    sub,
+				HistoryDir: tt.historyDir,
+			}
+
+			top, err := l.Resubmit(tt.epoch, tt.dryRun)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Resubmit() error = %v, want %v", err, tt.wantErr)
+			}
+			if calls := sub.Calls(); len(calls) != tt.wantCalls {
+				t.Errorf("Resubmit() submitted %d times, want %d", len(calls), tt.wantCalls)
+			}
+			if tt.wantErr == nil && (len(top) != 2 || top[0].LockedBalance.Int.Int64() != 2) {
+				t.Errorf("Resubmit() = %+v, want the history sorted by locked balance", top)
+			}
+		})
+	}
+}
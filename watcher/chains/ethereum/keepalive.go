@@ -0,0 +1,53 @@
+package ethereum
+
+import (
+	"context"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// keepAliveTimeout bounds a keep-alive ping, a connection not answered within it is reconnected: a proxy
+// dropping it silently leaves it half-open, with calls hanging instead of failing
+var keepAliveTimeout = 10 * time.Second
+
+// Ping issues eth_blockNumber, the cheapest call the node answers, to keep an idle connection open
+func (c *Connection) Ping(ctx context.Context) error {
+	_, err := c.Client.BlockNumber(ctx)
+	return err
+}
+
+// idle waits d between polls on the Clock of the listener like sleep. With a KeepAlive shorter than d an idle websocket connection is
+// pinged every KeepAlive, so proxies and load balancers dropping idle connections don't close it unnoticed,
+// and reconnected when a ping fails. Http connections and replays just sleep.
+func (l *Listener) idle(ctx context.Context, d time.Duration) {
+	interval := l.Config.EthereumConfig.KeepAlive.Duration
+	if interval <= 0 || l.Ethconn == nil || l.Ethconn.Http || l.Ethconn.Replay != nil {
+		l.sleep(ctx, d)
+		return
+	}
+	for ; d > interval; d -= interval {
+		l.sleep(ctx, interval)
+		if ctx.Err() != nil {
+			return
+		}
+		l.keepAlive(ctx)
+	}
+	l.sleep(ctx, d)
+}
+
+// keepAlive pings the ethereum node and reconnects when it doesn't answer
+func (l *Listener) keepAlive(ctx context.Context) {
+	pctx, cancel := l.withTimeout(ctx, keepAliveTimeout)
+	defer cancel()
+	err := l.Ethconn.Ping(pctx)
+	if err == nil || ctx.Err() != nil {
+		return
+	}
+	log.Warn("Keep-alive ping to the ethereum node failed, reconnecting", "url", l.Ethconn.URL, "err", err)
+	if err := l.Ethconn.Reconnect(); err != nil {
+		log.Error("Failed to reconnect to ethereum node", "err", err)
+		return
+	}
+	l.stats.Reconnects++
+}
@@ -0,0 +1,127 @@
+package ethereum
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+type blockNumberService struct{}
+
+func (blockNumberService) BlockNumber() hexutil.Uint64 { return 1000 }
+
+// idleListener silently drops the connections it accepted once their client sent nothing for idle, like a
+// load balancer reaping idle connections without closing them: the requests sent after are never answered
+type idleListener struct {
+	net.Listener
+	idle     time.Duration
+	accepted int32
+}
+
+func (l *idleListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt32(&l.accepted, 1)
+	ic := &idleConn{Conn: c, idle: l.idle}
+	ic.reaper = time.AfterFunc(l.idle, func() { atomic.StoreInt32(&ic.dropped, 1) })
+	return ic, nil
+}
+
+type idleConn struct {
+	net.Conn
+	idle    time.Duration
+	reaper  *time.Timer
+	dropped int32
+}
+
+func (c *idleConn) Read(b []byte) (int, error) {
+	for {
+		n, err := c.Conn.Read(b)
+		if atomic.LoadInt32(&c.dropped) == 0 {
+			if n > 0 {
+				c.reaper.Reset(c.idle)
+			}
+			return n, err
+		}
+		// a dropped connection swallows what the client sends
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// newIdleWSServer serves eth_blockNumber over a websocket, dropping connections idle for longer than idle
+func newIdleWSServer(t *testing.T, idle time.Duration) (string, *idleListener) {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("eth", blockNumberService{}); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewUnstartedServer(srv.WebsocketHandler([]string{"*"}))
+	ln := &idleListener{Listener: ts.Listener, idle: idle}
+	ts.Listener = ln
+	ts.Start()
+	t.Cleanup(func() {
+		ts.Close()
+		srv.Stop()
+	})
+	return "ws" + strings.TrimPrefix(ts.URL, "http"), ln
+}
+
+func TestListener_idleKeepAlive(t *testing.T) {
+	defer func(d time.Duration) { keepAliveTimeout = d }(keepAliveTimeout)
+	keepAliveTimeout = 100 * time.Millisecond
+
+	tests := []struct {
+		name          string
+		keepAlive     time.Duration
+		wait          time.Duration
+		wantAccepted  int32
+		wantReconnect uint64
+	}{
+		// pings more often than the server reaps idle connections keep the first one open
+		{name: "kept alive", keepAlive: 40 * time.Millisecond, wait: 500 * time.Millisecond, wantAccepted: 1},
+		// a ping after the server dropped the connection goes unanswered and reconnects right away
+		{name: "dropped", keepAlive: 300 * time.Millisecond, wait: 350 * time.Millisecond, wantAccepted: 2, wantReconnect: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, ln := newIdleWSServer(t, 150*time.Millisecond)
+			conn := NewConnection(url, false, make(chan struct{}))
+			if err := conn.Connect(); err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Client.Close()
+			l := &Listener{
+				Config:  &config.Config{EthereumConfig: config.EthereumConfig{KeepAlive: config.Duration{Duration: tt.keepAlive}}},
+				Ethconn: conn,
+			}
+			if err := conn.Ping(context.Background()); err != nil {
+				t.Fatalf("Ping() error = %v", err)
+			}
+
+			l.idle(context.Background(), tt.wait)
+			if got := atomic.LoadInt32(&ln.accepted); got != tt.wantAccepted {
+				t.Errorf("server accepted %d connections, want %d", got, tt.wantAccepted)
+			}
+			if l.stats.Reconnects != tt.wantReconnect {
+				t.Errorf("idle() reconnected %d times, want %d", l.stats.Reconnects, tt.wantReconnect)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err := conn.Ping(ctx); err != nil {
+				t.Errorf("Ping() after idle() error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package ethereum
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/signature"
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+	"github.com/NuLink-network/watcher/watcher/notify"
+)
+
+// SigningKeys are the keys the submissions are signed with: Current until the NextKey activation, Next from
+// then on
+type SigningKeys struct {
+	Current *signature.KeyringPair
+	Next    *signature.KeyringPair
+}
+
+// NewSigningKeys derives the signing keys of cfg, fallback is the current key when no seed is configured
+func NewSigningKeys(cfg config.NuLinkChainConfig, fallback *signature.KeyringPair) (*SigningKeys, error) {
+	keys := &SigningKeys{Current: fallback}
+	var err error
+	if cfg.Seed != "" {
+		if keys.Current, err = substrate.KeyFromSeed(cfg.Seed); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.NextKey != nil {
+		if keys.Next, err = substrate.KeyFromSeed(cfg.NextKey.Seed); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// rotateKey sets the key the submission for block is signed with on the submitter and the Attester: the next
+// key from its activation on, the current key before it, so a catch-up of older epochs keeps the key of their
+// time. The rotation is refused while the next key isn't a registered watcher.
+func (l *Listener) rotateKey(block *big.Int) {
+	keys := l.SigningKeys
+	if keys == nil || keys.Next == nil {
+		return
+	}
+	r, ok := l.Subconn.(substrate.KeyRotator)
+	if !ok {
+		log.Warn("submitter can't rotate its signing key, keep signing with the current key", "block", block)
+		return
+	}
+	cur := r.SigningKey()
+	want := keys.Current
+	if l.Config.NextKeyActive(block.Uint64()) {
+		want = keys.Next
+		if (cur == nil || cur.Address != want.Address) && !l.checkNextKey(block) {
+			want = keys.Current
+		}
+	}
+	if l.Attester != nil {
+		l.Attester.Key = want
+	}
+	if cur == nil {
+		r.SetSigningKey(want)
+		return
+	}
+	if cur.Address == want.Address {
+		return
+	}
+	log.Info("rotating the signing key", "block", block, "epoch", l.Config.Epoch(block.Uint64()), "from", cur.Address, "to", want.Address)
+	r.SetSigningKey(want)
+	l.stats.KeyRotations++
+}
+
+// checkNextKey reports whether the next signing key is a registered watcher, at startup for a nil block and
+// otherwise before rotating to it at its activation. The pallet rejects the submissions of any other key, so
+// an unregistered key or a failed check is logged and alerted once, until the key is found registered. A
+// submitter that can't check the key is trusted.
+func (l *Listener) checkNextKey(block *big.Int) bool {
+	keys := l.SigningKeys
+	if keys == nil || keys.Next == nil {
+		return true
+	}
+	c, ok := l.Subconn.(substrate.WatcherChecker)
+	if !ok {
+		return true
+	}
+	registered, err := c.IsWatcherKey(keys.Next)
+	if err == nil && registered {
+		l.nextKeyRefused = false
+		return true
+	}
+	if !l.nextKeyRefused {
+		reason := "isn't a registered watcher"
+		if err != nil {
+			reason = fmt.Sprintf("couldn't be checked as a watcher: %v", err)
+		}
+		log.Error("Next signing key "+reason+", refusing to rotate to it", "block", block, "key", keys.Next.Address)
+		l.Alerts.Alert(notify.KindKey, fmt.Sprintf("nulink watcher: the next signing key %s %s, keep signing with the current key", keys.Next.Address, reason))
+	}
+	l.nextKeyRefused = true
+	return false
+}
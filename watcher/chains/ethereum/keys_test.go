@@ -0,0 +1,143 @@
+package ethereum
+
+import (
+	"math/big"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/signature"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+	"github.com/NuLink-network/watcher/watcher/notify"
+)
+
+func TestListener_rotateKey(t *testing.T) {
+	epoch := uint64(3)
+	tests := []struct {
+		name    string
+		nextKey *config.NextKeyConfig
+		// the signer of the submissions of the blocks 1000, 2000, 3000, 4000 and, a catch-up, 2500
+		want []string
+	}{
+		{
+			name:    "activation epoch",
+			nextKey: &config.NextKeyConfig{Seed: "//Bob", ActivationEpoch: &epoch},
+			want:    []string{"alice", "alice", "bob", "bob", "alice"},
+		},
+		{
+			name:    "activation block",
+			nextKey: &config.NextKeyConfig{Seed: "//Bob", ActivationBlock: big.NewInt(1500)},
+			want:    []string{"alice", "bob", "bob", "bob", "bob"},
+		},
+		{name: "no next key", want: []string{"alice", "alice", "alice", "alice", "alice"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{EpochSize: 1000, NuLinkChainConfig: config.NuLinkChainConfig{Seed: "//Alice", NextKey: tt.nextKey}}
+			keys, err := NewSigningKeys(cfg.NuLinkChainConfig, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if keys.Current.Address != signature.TestKeyringPairAlice.Address {
+				t.Fatalf("current key = %s, want the key of //Alice", keys.Current.Address)
+			}
+			names := map[string]string{keys.Current.Address: "alice"}
+			if keys.Next != nil {
+				names[keys.Next.Address] = "bob"
+			}
+			sub := &substrate.MockSubmitter{}
+			sub.SetSigningKey(keys.Current)
+			l := &Listener{Config: cfg, Subconn: sub, SigningKeys: keys}
+
+			for _, block := range []int64{1000, 2000, 3000, 4000, 2500} {
+				if err := l.submitStakeInfos(big.NewInt(block), substrate.StakeInfos{}, time.Time{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var got []string
+			for _, c := range sub.Calls() {
+				got = append(got, names[c.Signer])
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("submissions signed by %v, want %v", got, tt.want)
+			}
+			wantRotations := uint64(0)
+			for i := 1; i < len(tt.want); i++ {
+				if tt.want[i] != tt.want[i-1] {
+					wantRotations++
+				}
+			}
+			if l.stats.KeyRotations != wantRotations {
+				t.Errorf("rotated the key %d times, want %d", l.stats.KeyRotations, wantRotations)
+			}
+		})
+	}
+}
+
+func TestListener_checkNextKey(t *testing.T) {
+	epoch := uint64(2)
+	cfg := &config.Config{EpochSize: 1000, NuLinkChainConfig: config.NuLinkChainConfig{Seed: "//Alice",
+		NextKey: &config.NextKeyConfig{Seed: "//Bob", ActivationEpoch: &epoch}}}
+	keys, err := NewSigningKeys(cfg.NuLinkChainConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := &substrate.MockSubmitter{}
+	sub.SetSigningKey(keys.Current)
+	events := make(testNotifier, 4)
+	l := &Listener{
+		Config:      cfg,
+		Subconn:     sub,
+		SigningKeys: keys,
+		Attester:    NewAttester(keys.Current, filepath.Join(t.TempDir(), "attestations.jsonl")),
+		Alerts:      notify.NewAlerter(events, 1, time.Second),
+	}
+
+	steps := []struct {
+		name       string
+		block      *big.Int // nil for the startup check
+		registered bool
+		// the key the submission and the attestation of block are signed with
+		wantKey   *signature.KeyringPair
+		wantAlert bool
+	}{
+		{name: "startup unregistered", wantAlert: true},
+		{name: "before the activation", block: big.NewInt(1000), wantKey: keys.Current},
+		{name: "activation refused, alerted once", block: big.NewInt(2000), wantKey: keys.Current},
+		{name: "registered after the activation", block: big.NewInt(3000), registered: true, wantKey: keys.Next},
+		{name: "catch-up of an older epoch", block: big.NewInt(1500), registered: true, wantKey: keys.Current},
+	}
+	for _, s := range steps {
+		sub.SetWatcher(keys.Next.Address, s.registered)
+		if s.block == nil {
+			if l.checkNextKey(nil) != s.registered {
+				t.Errorf("%s: checkNextKey() = %v, want %v", s.name, !s.registered, s.registered)
+			}
+		} else {
+			l.attest(s.block, substrate.StakeInfos{})
+			if err := l.submitStakeInfos(s.block, substrate.StakeInfos{}, time.Time{}); err != nil {
+				t.Fatal(err)
+			}
+			calls := sub.Calls()
+			if signer := calls[len(calls)-1].Signer; signer != s.wantKey.Address {
+				t.Errorf("%s: submission signed by %s, want %s", s.name, signer, s.wantKey.Address)
+			}
+			if l.Attester.Key.Address != s.wantKey.Address {
+				t.Errorf("%s: attestation signed by %s, want %s", s.name, l.Attester.Key.Address, s.wantKey.Address)
+			}
+		}
+		select {
+		case e := <-events:
+			if !s.wantAlert || e.Kind != notify.KindKey {
+				t.Errorf("%s: alert %+v, want alert %v", s.name, e, s.wantAlert)
+			}
+		case <-time.After(100 * time.Millisecond):
+			if s.wantAlert {
+				t.Errorf("%s: no key alert sent", s.name)
+			}
+		}
+	}
+}
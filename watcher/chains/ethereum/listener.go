@@ -1,39 +1,118 @@
 package ethereum
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/big"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
 	eth "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/log"
 
 	"github.com/NuLink-network/watcher/watcher/bindings/nucypher"
 	"github.com/NuLink-network/watcher/watcher/chains/substrate"
 	"github.com/NuLink-network/watcher/watcher/config"
+	"github.com/NuLink-network/watcher/watcher/notify"
 	"github.com/NuLink-network/watcher/watcher/params"
+	"github.com/NuLink-network/watcher/watcher/sink"
 )
 
 var first = true
 var accountID types.AccountID
 var stakeInfoList = make(substrate.StakeInfos, 0)
+var stakeInfoIndex = make(map[depositKey]int)
+var contractTotals = make(map[depositKey]*big.Int)
+
+// stakerFetchBackoff is the wait before the first retry of a failed staker call, it grows with every attempt
+var stakerFetchBackoff = 200 * time.Millisecond
+
+// depositKey identifies the deposits of a staker into a contract
+type depositKey struct {
+	contract ethcommon.Address
+	staker   ethcommon.Address
+}
 
 type Listener struct {
 	Config  *config.Config
 	Ethconn *Connection
-	Subconn *substrate.Connection
+	Subconn substrate.Submitter
 	//LatestBlockPath   string
-	LastStakeInfoPath string
-	Stop              chan struct{}
+	LastStakeInfoPath     string
+	StartBlockPath        string
+	Audit                 *AuditLog
+	Attester              *Attester    // signs the set computed at every epoch boundary, nil disables it
+	SigningKeys           *SigningKeys // rotated on the submitter at the NextKey activation, nil keeps its key
+	Alerts                *notify.Alerter
+	DumpScale             bool
+	MetricsPath           string
+	DepositCheckpointPath string
+	HistoryDir            string
+	Sinks                 []sink.Sink
+	Events                *sink.EventCSV
+	Source                StakeSource   // where the stake infos are read from, the deposit contract if nil
+	Transform             Transform     // applied to the TopN set before it is submitted, IdentityTransform if nil
+	Decoder               StakerDecoder // derives the staker from its topic bytes, the configured stakerDecoder if nil
+	Clock                 Clock         // time source, the SystemClock if nil
+	Rand                  *rand.Rand    // draws the coinbases of new stakers, seeded from the Clock if nil
+	Modes                 []string      // mode flags of the run, e.g. mock or dump-scale, logged at startup
+	Stop                  chan struct{}
+
+	lastSubmitted       substrate.StakeInfos
+	lastFlush           *big.Int
+	lastInfos           substrate.StakeInfos
+	lastAbsent          map[string]uint64
+	epochsSinceFullSync uint64
+	idleEpochs          uint64
+	stats               RunStats
+	ctx                 context.Context // of the running Run, ends the waits of its retries
+	stakers             *stakerCache
+	stakersOnce         sync.Once
+	snapshot            *snapshot
+	filter              *config.StakerFilter
+	maintenance         int32
+	pending             *pendingSet
+	spill               depositSpill
+	deferredStopped     substrate.StakeInfos
+	exported            []sink.DepositEvent
+	epochQueue          []*pendingSet
+	epochQueueLoaded    bool
+	batchEpochs         []uint64
+	statusState         statusState
+	deferredLoaded      bool
+	contractLost        bool
+	unsafe              string
+	replica             replicaState
+	operatorBalance     *big.Int
+	balanceLow          bool
+	nextKeyRefused      bool
+	churnHeld           substrate.StakeInfos
+	churnStable         uint64
+	churnAccepted       int32
+	heads               []blockRef
+	depositJournal      []journaledDeposit
+	locks               *lockState
+	locksDirty          bool
+	warmupHeld          *big.Int
+	warmupDone          bool
 }
 
 func init() {
@@ -41,108 +120,523 @@ func init() {
 	accountID = types.NewAccountID(bs)
 }
 
-// PollBlocks will poll for the latest block and proceed to parse the associated events as it sees new blocks.
+// NewListener returns a listener following ethconn and submitting to subconn. Settings the listener can't
+// run without are defaulted with a warning, so a config that skipped validation degrades instead of panicking.
+func NewListener(cfg *config.Config, ethconn *Connection, subconn substrate.Submitter, stop chan struct{}) *Listener {
+	l := &Listener{Config: cfg, Ethconn: ethconn, Subconn: subconn, Stop: stop, Transform: IdentityTransform}
+	l.applyDefaults()
+	return l
+}
+
+// applyDefaults fills the settings of the config whose zero value the listener can't run with
+func (l *Listener) applyDefaults() {
+	if l.Config.EthereumConfig.BlockConfirmations == nil {
+		log.Warn("blockConfirmations is not set, using the default", "confirmations", config.BlockConfirmations)
+		l.Config.EthereumConfig.BlockConfirmations = big.NewInt(config.BlockConfirmations)
+	}
+	if l.Config.EthereumConfig.StakerTopic == nil {
+		log.Warn("stakerTopic is not set, using the default")
+		l.Config.EthereumConfig.StakerTopic = config.DefaultStakerTopic()
+	}
+	if l.Config.EthereumConfig.StakerDecoder == "" {
+		l.Config.EthereumConfig.StakerDecoder = config.StakerDecoderRaw
+	}
+	if l.Config.EpochSize == 0 {
+		log.Warn("epochSize is not set, using the default", "epochSize", config.EpochSize)
+		l.Config.EpochSize = config.EpochSize
+	}
+	if l.Config.MaxEventsPerBlock <= 0 {
+		log.Warn("maxEventsPerBlock is not set, using the default", "limit", config.MaxEventsPerBlock)
+		l.Config.MaxEventsPerBlock = config.MaxEventsPerBlock
+	}
+}
+
+// RunStats summarises what a Run of the listener has done so far
+type RunStats struct {
+	BlocksProcessed     uint64
+	EventsSeen          uint64
+	Submissions         uint64
+	SubmitErrors        uint64
+	FetchErrors         uint64
+	Regressions         uint64
+	Reorgs              uint64
+	EpochRetries        uint64
+	SnapshotFailures    uint64
+	SuppressedEpochs    uint64
+	KeyRotations        uint64
+	Reconnects          uint64
+	LateSubmissions     uint64
+	Heartbeats          uint64
+	VerifyMismatches    uint64
+	VerifyErrors        uint64
+	LastBlock           *big.Int
+	SafeHead            *big.Int
+	LastSubmissionEpoch uint64
+	LastSubmissionTime  time.Time
+}
+
+// PollBlocks runs the listener until it is stopped or fails, see Run.
+func (l *Listener) PollBlocks() error {
+	_, err := l.Run(context.Background())
+	return err
+}
+
+// Run will poll for the latest block and proceed to parse the associated events as it sees new blocks.
 // Polling begins at the block defined in `StartBlock`. Failed attempts to fetch the latest block or parse
 // a block will be retried up to BlockRetryLimit times before continuing to the next block.
-func (l *Listener) PollBlocks() error {
-	var (
-		currentBlock = big.NewInt(1)
-		retry        = params.BlockRetryLimit
-	)
+// Run returns when ctx is done, the stop channel is closed or an error occurs, together with the stats
+// of the run up to that point.
+func (l *Listener) Run(ctx context.Context) (RunStats, error) {
+	l.stats = RunStats{}
+	l.ctx = ctx
+	defer func() { l.ctx = nil }()
+	l.applyDefaults()
+	l.applyClock()
+	l.guardEpochSize()
+	currentBlock, err := l.resolveStartBlock()
+	if err != nil {
+		return l.stats, err
+	}
+	start := currentBlock
+	if currentBlock, err = l.catchUpMissedEpochs(l.restoreDeposits(currentBlock)); err != nil {
+		return l.stats, err
+	}
+	currentBlock = l.checkResumeGap(currentBlock)
+	l.startPreload(currentBlock)
+	l.logBanner(start, currentBlock)
+	l.checkOperatorBalance(nil)
+	l.checkNextKey(nil)
+	retry := params.BlockRetryLimit
+	regressions := 0
 
 	log.Info("Polling Blocks...")
 
 	for {
+		l.writeMetrics(retry)
+		l.refreshStatus()
 		select {
+		case <-ctx.Done():
+			return l.stats, ctx.Err()
 		case <-l.Stop:
-			return errors.New("polling terminated")
+			return l.stats, ErrPollingTerminated
 		default:
 			// No more retries, goto next block
 			if retry == 0 {
 				log.Error("Polling failed, retries exceeded")
-				l.Stop <- struct{}{}
-				return nil
+				l.signalStop()
+				return l.stats, ErrRetriesExceeded
 				// Goto next block and reset retry counter
 				//currentBlock.Add(currentBlock, big.NewInt(1))
 				//retry = params.BlockRetryLimit
 				//continue
 			}
 
-			latestBlock, err := l.Ethconn.LatestBlock()
+			latestBlock, err := l.Ethconn.SafeHead(l.Config.EthereumConfig.BlockConfirmations)
 			if err != nil {
 				log.Error("Unable to get latest block", "block", currentBlock, "err", err)
+				l.stats.FetchErrors++
 				retry--
-				time.Sleep(params.BlockRetryInterval)
+				l.sleep(ctx, l.Config.RetryInterval.Duration)
 				continue
 			}
 
-			// Sleep if the difference is less than BlockConfirmations; (latestBlock - currentBlock) < BlockConfirmations
+			l.stats.SafeHead = latestBlock
+
+			if currentBlock, err = l.checkReorg(currentBlock, latestBlock); err != nil {
+				l.signalStop()
+				return l.stats, err
+			}
+
+			// A safe head below currentBlock means the node lags behind or reorged, wait for it to catch up and
+			// reconnect, possibly to a healthier backend, once it regressed more than RegressionTolerance times
+			if latestBlock.Cmp(currentBlock) < 0 {
+				l.guardStartBlock(currentBlock, latestBlock)
+				regressions++
+				l.stats.Regressions++
+				log.Warn("Latest block is below the current block, node may be lagging or reorged", "latest", latestBlock, "current", currentBlock, "regressions", regressions)
+				if regressions > l.Config.RegressionTolerance {
+					if err := l.Ethconn.Reconnect(); err != nil {
+						log.Error("Failed to reconnect to ethereum node", "err", err)
+					} else {
+						l.stats.Reconnects++
+					}
+					regressions = 0
+				}
+				l.idle(ctx, l.Config.PollInterval.Duration)
+				continue
+			}
+			regressions = 0
+
+			// Sleep if the safe head (finalized, or latest - BlockConfirmations) hasn't moved past currentBlock
 			if latestBlock.Cmp(currentBlock) != 1 {
 				log.Debug("Block not ready, will retry", "target", latestBlock.Uint64()+1, "latest", latestBlock)
-				time.Sleep(params.BlockRetryInterval)
+				l.idle(ctx, l.Config.PollInterval.Duration)
 				continue
 			}
 			log.Info("get latest block", "block", latestBlock)
 
-			err = l.syncStakeInfos(latestBlock)
+			if err = l.pollDeposits(currentBlock, latestBlock); err != nil {
+				l.signalStop()
+				return l.stats, err
+			}
+			if l.Config.CatchUpEpochs {
+				head := latestBlock
+				err = syncRange(currentBlock, latestBlock, l.Config.EpochSize, l.Config.EpochOffset, func(block *big.Int) error {
+					if l.warmingUp(block, head) {
+						return nil
+					}
+					return l.syncStakeInfos(block)
+				})
+				if err == nil {
+					err = l.endWarmup(latestBlock, l.epochStakeInfos)
+				}
+			} else {
+				err = l.syncStakeInfos(latestBlock)
+			}
 			if err != nil {
-				l.Stop <- struct{}{}
-				return err
+				l.signalStop()
+				return l.stats, err
 			}
 
 			//if err := WriteLatestBlock(l.LatestBlockPath, latestBlock); err != nil {
 			//	log.Error("Failed to write latest block", "block", latestBlock, "err", err)
 			//}
 
+			l.recordHead(latestBlock)
+			l.prefetchSnapshot(latestBlock)
+
 			// Goto next block and reset retry counter
 			currentBlock = latestBlock
 			retry = params.BlockRetryLimit
+			l.stats.BlocksProcessed++
+			l.stats.LastBlock = new(big.Int).Set(latestBlock)
+		}
+	}
+}
+
+// signalStop tells the reader of the Stop channel, if any, that polling ended with an error. It never blocks:
+// the channel may be unbuffered, full or have no reader left, the error is returned by Run either way.
+func (l *Listener) signalStop() {
+	select {
+	case l.Stop <- struct{}{}:
+	default:
+	}
+}
+
+// syncRange calls sync for every epoch boundary in (from, to] in order, or once for to if the range holds none
+func syncRange(from, to *big.Int, epochSize, epochOffset uint64, sync func(*big.Int) error) error {
+	boundaries := epochBoundaries(from, to, epochSize, epochOffset)
+	if len(boundaries) == 0 {
+		return sync(to)
+	}
+	for _, b := range boundaries {
+		if err := sync(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// epochBoundaries returns the epoch boundaries in (from, to] in order
+func epochBoundaries(from, to *big.Int, epochSize, epochOffset uint64) []*big.Int {
+	size := new(big.Int).SetUint64(epochSize)
+	offset := new(big.Int).SetUint64(epochOffset)
+	// first boundary after from
+	b := new(big.Int).Set(offset)
+	if from.Cmp(offset) >= 0 {
+		b.Sub(from, offset).Div(b, size)
+		b.Add(b, big.NewInt(1)).Mul(b, size).Add(b, offset)
+	}
+	var boundaries []*big.Int
+	for ; b.Cmp(to) <= 0; b = new(big.Int).Add(b, size) {
+		boundaries = append(boundaries, b)
+	}
+	return boundaries
+}
+
+// getDepositEventsForBlock accumulates the deposit events of every deposit contract, each queried at its own
+// confirmation depth below polledBlock, the block reached by polling. The epoch boundary is that of
+// polledBlock, not of the queried blocks, so all contracts flush together and the combined top stakers are
+// submitted for the epoch polledBlock starts. At most MaxEventsPerBlock events are accumulated, the rest are
+// dropped or, with HaltOnEventLimit, ErrTooManyEvents is returned. Between boundaries the accumulated
+// deposits are checkpointed, so a restart resumes them. Deposits over the DepositSpill thresholds are spilled
+// to disk and merged back at the boundary. The boundary itself is left to flushEpoch, which submits it once
+// whichever path reaches it.
+func (l *Listener) getDepositEventsForBlock(polledBlock *big.Int) error {
+	remaining := l.Config.MaxEventsPerBlock
+	l.exported = l.exported[:0]
+	for _, c := range l.Config.EthereumConfig.DepositContracts() {
+		n, err := l.getContractDeposits(c, polledBlock, remaining)
+		if err != nil {
+			return err
 		}
+		remaining -= n
 	}
+	l.saveLocks()
+	if remaining < 0 {
+		log.Warn("too many deposit events, dropped the events over the limit", "block", polledBlock, "limit", l.Config.MaxEventsPerBlock, "dropped", -remaining)
+		if l.Config.HaltOnEventLimit {
+			return fmt.Errorf("%w: more than %d events below block %s", ErrTooManyEvents, l.Config.MaxEventsPerBlock, polledBlock)
+		}
+	}
+	l.exportEvents()
+	if !l.Config.IsEpochBoundary(polledBlock.Uint64()) {
+		if remaining != l.Config.MaxEventsPerBlock {
+			if err := l.spillDeposits(); err != nil {
+				log.Warn("Failed to spill deposits, keeping them in memory", "block", polledBlock, "error", err)
+			}
+			if err := l.checkpointDeposits(polledBlock); err != nil {
+				log.Warn("Failed to checkpoint deposits", "block", polledBlock, "error", err)
+			}
+		}
+		return nil
+	}
+	return l.flushEpoch(polledBlock)
 }
 
-// getDepositEventsForBlock looks for the deposit event in the latest block
-func (l *Listener) getDepositEventsForBlock(latestBlock *big.Int) error {
-	log.Info("Querying block for deposit events", "block", latestBlock)
-	query := buildQuery(ethcommon.HexToAddress(l.Config.EthereumConfig.DepositContractAddr), Deposited, latestBlock, latestBlock)
+// getContractDeposits accumulates up to limit deposit events of contract c in the block its confirmations
+// below polledBlock and returns the number of events found
+func (l *Listener) getContractDeposits(c config.ContractConfig, polledBlock *big.Int, limit int) (int, error) {
+	block := new(big.Int).Set(polledBlock)
+	if c.Confirmations != nil {
+		block.Sub(block, c.Confirmations)
+	}
+	if block.Sign() < 0 || (c.StartBlock != nil && block.Cmp(c.StartBlock) < 0) {
+		return 0, nil
+	}
+	sig := EventSig(c.EventSig)
+	if sig == "" {
+		sig = Deposited
+	}
+
+	log.Info("Querying block for deposit events", "contract", c.Address, "block", block)
+	query := buildQuery(ethcommon.HexToAddress(c.Address), sig, block, block)
 
 	// querying for logs
-	logs, err := l.Ethconn.Client.FilterLogs(context.Background(), query)
+	logs, err := l.filterLogs(query)
 	if err != nil {
-		return fmt.Errorf("unable to Filter Logs: %w", err)
+		return 0, fmt.Errorf("unable to Filter Logs: %w", err)
 	}
 
+	filter, err := l.stakerFilter()
+	if err != nil {
+		return 0, err
+	}
+	dec, err := l.stakerDecoder()
+	if err != nil {
+		return 0, err
+	}
+	found := len(logs)
+	l.stats.EventsSeen += uint64(found)
+	if found > limit {
+		if limit < 0 {
+			limit = 0
+		}
+		logs = logs[:limit]
+	}
 	// read through the log events and handle their deposit event if handler is recognized
 	for _, lg := range logs {
 		// 1. get data from Topics and Data
-		staker := lg.Topics[1]
+		staker, err := stakerFromTopics(lg.Topics, l.Config.EthereumConfig.StakerTopic, dec)
+		if err != nil {
+			log.Warn("skip deposit event", "tx", lg.TxHash, "index", lg.Index, "error", err)
+			continue
+		}
 		value := ethcommon.BytesToHash(lg.Data[:32]).Big()
 		periods := ethcommon.BytesToHash(lg.Data[32:]).Big()
 
+		if !filter.Allowed(staker) {
+			log.Debug("skip filtered deposit event", "contract", c.Address, "staker", staker)
+			continue
+		}
+		if l.Events != nil {
+			l.exported = append(l.exported, sink.DepositEvent{Block: block, TxHash: lg.TxHash, Staker: staker, Value: value, Periods: periods})
+		}
+		value, ok := l.fitU128(value, "tx", lg.TxHash, "index", lg.Index, "contract", c.Address, "staker", staker)
+		if !ok {
+			continue
+		}
+		addDeposit(l.Config.EthereumConfig.CrossContractAggregation, ethcommon.HexToAddress(c.Address), staker, value)
+		l.recordLock(staker, periods, polledBlock)
+		l.journalDeposit(polledBlock, depositKey{contract: ethcommon.HexToAddress(c.Address), staker: staker}, value)
+		log.Info("find deposit event", "contract", c.Address, "staker", staker, "value", value, "periods", periods)
+	}
+	return found, nil
+}
+
+// addDeposit adds value deposited by staker into contract to the accumulated stakes. Deposits of a staker
+// into the same contract are summed, policy decides how the totals of a staker across contracts are
+// aggregated: summed, the largest one or each kept as a separate record.
+func addDeposit(policy string, contract, staker ethcommon.Address, value *big.Int) {
+	key := depositKey{contract: contract, staker: staker}
+	total, ok := contractTotals[key]
+	if !ok {
+		total = new(big.Int)
+		contractTotals[key] = total
+	}
+	total.Add(total, value)
+
+	infoKey := depositKey{staker: staker}
+	if policy == config.AggregateSeparate {
+		infoKey = key
+	}
+	i, ok := stakeInfoIndex[infoKey]
+	if !ok {
+		i = len(stakeInfoList)
+		stakeInfoIndex[infoKey] = i
 		stakeInfoList = append(stakeInfoList, &substrate.StakeInfo{
-			Coinbase:      types.NewAccountID(staker[:]),
+			Coinbase:      substrate.EthAddrToAccountID(staker),
 			WorkBase:      staker[:],
 			IsWork:        true,
-			LockedBalance: types.NewU128(*value),
+			LockedBalance: types.NewU128(*big.NewInt(0)),
 			WorkCount:     0,
 		})
-		log.Info("find deposit event", "staker", staker, "value", value, "periods", periods)
 	}
-	if latestBlock.Uint64()%l.Config.EpochSize == 0 {
-		if len(stakeInfoList) == 0 {
-			return nil
+
+	info := stakeInfoList[i]
+	switch policy {
+	case config.AggregateMax:
+		if total.Cmp(info.LockedBalance.Int) > 0 {
+			info.LockedBalance = types.NewU128(*new(big.Int).Set(total))
+		}
+	case config.AggregateSeparate:
+		info.LockedBalance = types.NewU128(*new(big.Int).Set(total))
+	default:
+		info.LockedBalance = types.NewU128(*new(big.Int).Add(info.LockedBalance.Int, value))
+	}
+	if info.LockedBalance.Cmp(maxU128) > 0 {
+		log.Warn("accumulated deposits exceed the U128 range, clamping", "staker", staker, "balance", info.LockedBalance.Int)
+		info.LockedBalance = types.NewU128(*new(big.Int).Set(maxU128))
+	}
+}
+
+// maxU128 is the largest balance a stake info can be submitted with
+var maxU128 = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 128), big.NewInt(1))
+
+// fitU128 applies the OverflowPolicy to a value above maxU128, described by ctx in the log: it returns
+// maxU128 when clamping and false when the value must be skipped. Values in range are returned unchanged.
+func (l *Listener) fitU128(value *big.Int, ctx ...interface{}) (*big.Int, bool) {
+	return fitU128(l.Config.OverflowPolicy, value, ctx...)
+}
+
+// fitU128 applies the overflow policy to value, see Listener.fitU128
+func fitU128(policy string, value *big.Int, ctx ...interface{}) (*big.Int, bool) {
+	if value.Cmp(maxU128) <= 0 {
+		return value, true
+	}
+	ctx = append(ctx, "value", value)
+	if policy == config.OverflowSkip {
+		log.Error("value exceeds the U128 range, skipping it", ctx...)
+		return nil, false
+	}
+	log.Warn("value exceeds the U128 range, clamping", ctx...)
+	return new(big.Int).Set(maxU128), true
+}
+
+func resetStakeInfoList() {
+	stakeInfoList = make([]*substrate.StakeInfo, 0, 1000)
+	stakeInfoIndex = make(map[depositKey]int)
+	contractTotals = make(map[depositKey]*big.Int)
+}
+
+// stakerFromTopics derives the staker address with dec from the bytes of the topic selected by ts
+func stakerFromTopics(topics []ethcommon.Hash, ts *config.TopicSlice, dec StakerDecoder) (ethcommon.Address, error) {
+	if ts.Index >= len(topics) {
+		return ethcommon.Address{}, fmt.Errorf("staker topic %d missing, event has %d topics", ts.Index, len(topics))
+	}
+	return dec.Decode(topics[ts.Index][ts.Offset : ts.Offset+ts.Length])
+}
+
+// filterLogs runs query, split into sub-queries of at most MaxLogQuerySpan blocks. A sub-query the provider
+// rejects for returning too many results is bisected until it fits or covers a single block.
+func (l *Listener) filterLogs(query eth.FilterQuery) ([]ethtypes.Log, error) {
+	span := l.Config.EthereumConfig.MaxLogQuerySpan
+	if query.BlockHash != nil || query.FromBlock == nil || query.ToBlock == nil {
+		return l.bisectLogs(query)
+	}
+	var logs []ethtypes.Log
+	for from := new(big.Int).Set(query.FromBlock); from.Cmp(query.ToBlock) <= 0; {
+		to := query.ToBlock
+		if span > 0 {
+			if end := new(big.Int).Add(from, new(big.Int).SetUint64(span-1)); end.Cmp(to) < 0 {
+				to = end
+			}
+		}
+		q := query
+		q.FromBlock, q.ToBlock = from, to
+		part, err := l.bisectLogs(q)
+		if err != nil {
+			return nil, err
 		}
+		logs = append(logs, part...)
+		from = new(big.Int).Add(to, big.NewInt(1))
+	}
+	return logs, nil
+}
+
+// logLimitErrors are fragments of the errors providers reject a log query returning too many results with
+var logLimitErrors = []string{"query returned more than", "log response size exceeded", "too many results",
+	"limit exceeded", "block range is too large", "range too large"}
 
-		if err := l.Subconn.SubmitTx(substrate.UpdateStakeInfo, stakeInfoList.LockedBalanceTop20()); err != nil {
-			log.Error("failed to update stake info to nulink", "count", len(stakeInfoList), "error", err)
-		} else {
-			log.Error("succeeded to update stake info to nulink", "count", len(stakeInfoList))
+// isLogLimitError reports whether err rejected a log query for the size of its result or range
+func isLogLimitError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, fragment := range logLimitErrors {
+		if strings.Contains(msg, fragment) {
+			return true
 		}
+	}
+	return false
+}
 
-		stakeInfoList = make([]*substrate.StakeInfo, 0, 1000)
+// bisectLogs runs query, halving its block range while the provider rejects it as too large
+func (l *Listener) bisectLogs(query eth.FilterQuery) ([]ethtypes.Log, error) {
+	logs, err := l.queryLogs(query)
+	if err == nil || !isLogLimitError(err) || query.FromBlock == nil || query.ToBlock == nil || query.FromBlock.Cmp(query.ToBlock) >= 0 {
+		return logs, err
+	}
+	mid := new(big.Int).Add(query.FromBlock, query.ToBlock)
+	mid.Rsh(mid, 1)
+	log.Debug("log query over the provider limit, bisecting it", "from", query.FromBlock, "to", query.ToBlock, "error", err)
+	first, second := query, query
+	first.ToBlock, second.FromBlock = mid, new(big.Int).Add(mid, big.NewInt(1))
+	if logs, err = l.bisectLogs(first); err != nil {
+		return nil, err
+	}
+	rest, err := l.bisectLogs(second)
+	if err != nil {
+		return nil, err
 	}
+	return append(logs, rest...), nil
+}
 
-	return nil
+// queryLogs runs query, dumping it with the number of logs returned at trace verbosity. The query is only
+// formatted when a trace record is actually written.
+func (l *Listener) queryLogs(query eth.FilterQuery) ([]ethtypes.Log, error) {
+	logs, err := l.Ethconn.Client.FilterLogs(context.Background(), query)
+	log.Trace("FilterLogs", "query", log.Lazy{Fn: func() string { return formatQuery(query) }}, "logs", len(logs), "error", err)
+	return logs, err
+}
+
+// formatQuery describes the addresses, topics and block range of query
+func formatQuery(query eth.FilterQuery) string {
+	addresses := make([]string, len(query.Addresses))
+	for i, a := range query.Addresses {
+		addresses[i] = a.Hex()
+	}
+	topics := make([]string, len(query.Topics))
+	for i, alternatives := range query.Topics {
+		hashes := make([]string, len(alternatives))
+		for j, h := range alternatives {
+			hashes[j] = h.Hex()
+		}
+		topics[i] = "[" + strings.Join(hashes, " ") + "]"
+	}
+	s := fmt.Sprintf("addresses=[%s] topics=[%s]", strings.Join(addresses, " "), strings.Join(topics, " "))
+	if query.BlockHash != nil {
+		return s + " blockHash=" + query.BlockHash.Hex()
+	}
+	return s + fmt.Sprintf(" from=%v to=%v", query.FromBlock, query.ToBlock)
 }
 
 // buildQuery constructs a query for the bridgeContract by hashing sig to get the event topic
@@ -159,40 +653,279 @@ func buildQuery(contract ethcommon.Address, sig EventSig, startBlock *big.Int, e
 }
 
 func (l *Listener) syncStakeInfos(latestBlock *big.Int) error {
-	if first || latestBlock.Uint64()%l.Config.EpochSize == 0 {
-		first = false
-		log.Info("ready to update stake info to nulink", "block", latestBlock)
-
-		stakeInfos, err := l.GetStakeInfo()
-		if err != nil {
+	boundary := first || l.Config.IsEpochBoundary(latestBlock.Uint64())
+	l.checkPrimary(l.clock().Now())
+	if l.pending != nil && !l.submissionsPaused() && !boundary {
+		if !l.boundaryFinalized(l.pending.block) {
+			return nil
+		}
+		return l.flushPending()
+	}
+	if boundary {
+		return l.flushEpoch(latestBlock)
+	} else if l.submissionsPaused() {
+		return nil
+	} else if latestBlock.Uint64()%10 == 0 {
+		if err := l.submitStakeInfos(latestBlock, substrate.StakeInfos{}, time.Time{}); err != nil {
+			log.Error("failed to update empty stake info to nulink", "count", 0, "error", err)
 			return err
 		}
+		log.Info("succeeded to update empty stake info to nulink", "count", 0)
+		l.stats.Submissions++
+	}
+	return nil
+}
 
-		lastInfos, err := ReadStakeInfos(l.LastStakeInfoPath)
-		if err != nil {
+// syncEpoch computes and submits the stake info update of the epoch boundary latestBlock from the stake infos
+// read returns for it
+func (l *Listener) syncEpoch(latestBlock *big.Int, read func(*big.Int) (substrate.StakeInfos, error)) error {
+	first = false
+	l.pending = nil
+	deadline := l.submissionDeadline(l.clock().Now())
+	log.Info("ready to update stake info to nulink", "block", latestBlock)
+
+	l.checkOperatorBalance(latestBlock)
+	l.checkContract(latestBlock)
+	if l.contractLost {
+		log.Error("deposit contract lost, skip the stake info update", "block", latestBlock, "contract", l.Config.EthereumConfig.DepositContractAddr)
+		return nil
+	}
+	stakeInfos, err := read(latestBlock)
+	if errors.Is(err, ErrZeroStakers) {
+		switch l.Config.ZeroStakersPolicy {
+		case config.ZeroStakersAbort:
 			return err
+		case config.ZeroStakersSubmitEmpty:
+			stakeInfos, err = substrate.StakeInfos{}, nil
+		default:
+			log.Warn("skip the stake info update of the epoch", "block", latestBlock, "error", err)
+			return nil
 		}
-		top20StakeInfos := AssignCoinbase(stakeInfos.LockedBalanceTop20(), lastInfos)
-		if err := l.Subconn.SubmitTx(substrate.UpdateStakeInfo, top20StakeInfos); err != nil {
-			log.Error("failed to update stake info to nulink", "count", len(top20StakeInfos), "error", err)
+	}
+	if errors.Is(err, ErrIncompleteStakeInfos) {
+		log.Error("abort the stake info update of the epoch", "block", latestBlock, "error", err)
+		return nil
+	} else if err != nil {
+		return &snapshotError{err: err}
+	}
+	stakeInfos = l.dropExpired(stakeInfos, latestBlock)
+
+	lastInfos, absent, err := l.readLastStakeInfos()
+	if err != nil {
+		return err
+	}
+	top, err := l.confirmStopped(l.selectTop(stakeInfos), stakeInfos, lastInfos, latestBlock)
+	if err != nil {
+		return err
+	}
+	top, absent = l.applyStopGrace(top, stakeInfos, lastInfos, absent)
+	top = l.dropExpired(top, latestBlock)
+	top20StakeInfos := l.transform(assignCoinbase(top, coinbaseIndex(lastInfos), l.rand()))
+	if !l.verifyTopN(top20StakeInfos) {
+		return nil
+	}
+	submitInfos, ok := l.fillTopN(top20StakeInfos)
+	if !ok || !l.checkChurn(latestBlock, top20StakeInfos, lastInfos) {
+		return nil
+	}
+	l.guardSetSize(latestBlock, top20StakeInfos, lastInfos)
+	l.attest(latestBlock, top20StakeInfos)
+	set := &pendingSet{block: latestBlock, top: top20StakeInfos, absent: absent, submit: submitInfos}
+	if l.Config.EpochSubmissionOffset > 0 {
+		if set, err = l.shiftEpoch(set); err != nil || set == nil {
 			return err
 		}
-		log.Info("succeeded to update stake info to nulink", "count", len(top20StakeInfos))
+	}
+	if set = l.batchEpoch(set); set == nil {
+		return nil
+	}
+	return l.submitOrHold(set, deadline)
+}
 
-		if err := WriteStakeInfos(l.LastStakeInfoPath, top20StakeInfos); err != nil {
-			return err
+// submitOrHold submits set unless submissions are paused or its boundary isn't finalized yet, in which case
+// it is held as the pending update
+func (l *Listener) submitOrHold(set *pendingSet, deadline time.Time) error {
+	if l.submissionsPaused() {
+		log.Info("submissions paused, holding the stake info update", "block", set.block, "count", len(set.submit), "maintenance", l.InMaintenance(), "unsafe", l.unsafe)
+		l.pending = set
+		return nil
+	}
+	if !l.boundaryFinalized(set.block) {
+		l.pending = set
+		return nil
+	}
+	return l.submitSet(set, deadline)
+}
+
+// submissionDeadline returns when a submission for an epoch boundary seen at start is abandoned, the zero
+// time without a SubmissionDeadline
+func (l *Listener) submissionDeadline(start time.Time) time.Time {
+	if l.Config.SubmissionDeadline.Duration <= 0 {
+		return time.Time{}
+	}
+	return start.Add(l.Config.SubmissionDeadline.Duration)
+}
+
+// submitSet submits the stake infos of set, only the changes since the last submission unless a full
+// resync is due, and persists them as the last stake infos once submitted
+func (l *Listener) submitSet(set *pendingSet, deadline time.Time) error {
+	if err := l.loadDeferredStopped(); err != nil {
+		return err
+	}
+	payload, full := l.stakeInfoPayload(set.submit)
+	payload, deferred := l.capStopped(payload, set.submit, full)
+	if !full && len(payload) == 0 {
+		log.Info("stake info unchanged since last submission, skip update", "block", set.block)
+		l.epochsSinceFullSync++
+		l.endBatch(set)
+		l.heartbeat(set.block, deadline)
+		return nil
+	}
+	if err := l.submitEpochs(set.block, set.epochs, payload, deadline); err != nil {
+		if errors.Is(err, ErrSubmissionLate) {
+			log.Warn("late stake info update abandoned, deferred to the next epoch", "block", set.block, "error", err)
+			return nil
 		}
-	} else if latestBlock.Uint64()%10 == 0 {
-		if err := l.Subconn.SubmitTx(substrate.UpdateStakeInfo, substrate.StakeInfos{}); err != nil {
-			log.Error("failed to update empty stake info to nulink", "count", 0, "error", err)
-			return err
+		ctx := []interface{}{"count", len(payload), "full", full, "error", err}
+		var de *substrate.DispatchError
+		if errors.As(err, &de) {
+			ctx = append(ctx, "pallet", de.Pallet, "dispatchError", de.Name)
 		}
-		log.Info("succeeded to update empty stake info to nulink", "count", 0)
+		log.Error("failed to update stake info to nulink", ctx...)
+		return err
 	}
+	log.Info("succeeded to update stake info to nulink", "count", len(payload), "full", full)
+	l.stats.Submissions++
+	l.verifySubmission(set)
+	l.endBatch(set)
+	l.lastSubmitted = set.submit
+	l.idleEpochs = 0
+	l.deferredStopped = deferred
+	if full {
+		l.epochsSinceFullSync = 0
+	} else {
+		l.epochsSinceFullSync++
+	}
+
+	if err := l.writeLastStakeInfos(set.top, set.absent); err != nil {
+		return err
+	}
+	l.writeHistory(set.block, set.submit)
+	l.publish(set.block, set.submit)
+	l.recordSubmissionStatus(set)
 	return nil
 }
 
+// heartbeat submits an empty update once HeartbeatEpochs epochs in a row had no change to submit, proving
+// the watcher alive while the set is stable. It leaves the state of the last submission alone, a failed
+// heartbeat is logged and tried again at the next idle epoch.
+func (l *Listener) heartbeat(block *big.Int, deadline time.Time) {
+	if l.Config.HeartbeatEpochs == 0 {
+		return
+	}
+	l.idleEpochs++
+	if l.idleEpochs < l.Config.HeartbeatEpochs {
+		return
+	}
+	if err := l.submitStakeInfos(block, substrate.StakeInfos{}, deadline); err != nil {
+		log.Warn("failed to submit the heartbeat", "block", block, "idleEpochs", l.idleEpochs, "error", err)
+		return
+	}
+	log.Info("submitted heartbeat, stake info unchanged", "block", block, "idleEpochs", l.idleEpochs)
+	l.idleEpochs = 0
+	l.stats.Heartbeats++
+}
+
+// publish hands the submitted stake infos to the Sinks, their failures are only logged
+func (l *Listener) publish(block *big.Int, infos substrate.StakeInfos) {
+	if len(l.Sinks) == 0 {
+		return
+	}
+	u := sink.NewUpdate(l.Config.Epoch(block.Uint64()), block, infos, l.clock().Now())
+	for _, s := range l.Sinks {
+		if err := s.Publish(context.Background(), u); err != nil {
+			log.Warn("failed to publish stake infos", "sink", s.Name(), "epoch", u.Epoch, "error", err)
+			continue
+		}
+		log.Debug("published stake infos", "sink", s.Name(), "epoch", u.Epoch, "count", len(u.Stakers))
+	}
+}
+
+// selectTop returns the TopN stakers by locked balance, skipping those below MinLockedBalance or without a
+// balance and, with MinWorkCount, those whose work count stored by the pallet is below it. The stakers tied
+// at the cutoff are ranked by work base or, with TopNTiesExclude, all left out.
+func (l *Listener) selectTop(infos substrate.StakeInfos) substrate.StakeInfos {
+	infos = infos.FilterLockedBalance(l.Config.MinLockedBalance)
+	if l.Config.MinWorkCount > 0 {
+		infos = l.filterWorkCount(infos)
+	}
+	if l.Config.TopNTies != config.TopNTiesExclude {
+		return infos.LockedBalanceTop20()
+	}
+	top := infos.TopNWithoutTies()
+	if len(infos) > substrate.TopN && len(top) < substrate.TopN {
+		log.Warn("stakers tied at the top cutoff left out", "count", len(top), "tied", len(infos)-len(top), "balance", infos[substrate.TopN].LockedBalance)
+	}
+	return top
+}
+
+// verifyTopN reports whether the selected set may be submitted, it always does unless VerifyTopN is enabled
+func (l *Listener) verifyTopN(top substrate.StakeInfos) bool {
+	if !l.Config.VerifyTopN {
+		return true
+	}
+	if err := top.CheckTopN(); err != nil {
+		log.Error("refuse to submit stake info", "count", len(top), "error", err)
+		return false
+	}
+	return true
+}
+
+// fillTopN applies the UndersizedPolicy to a set with fewer than TopN stakers. It reports false if the
+// set must not be submitted, otherwise it returns the set, padded with stopped placeholders for pad.
+func (l *Listener) fillTopN(top substrate.StakeInfos) (substrate.StakeInfos, bool) {
+	if len(top) >= substrate.TopN {
+		return top, true
+	}
+	switch l.Config.UndersizedPolicy {
+	case config.UndersizedAbort:
+		log.Error("refuse to submit undersized stake info", "count", len(top), "want", substrate.TopN)
+		return nil, false
+	case config.UndersizedPad:
+		log.Warn("padding undersized stake info", "count", len(top), "want", substrate.TopN)
+		padded := make(substrate.StakeInfos, len(top), substrate.TopN)
+		copy(padded, top)
+		for len(padded) < substrate.TopN {
+			padded = append(padded, &substrate.StakeInfo{WorkBase: []byte{}, LockedBalance: types.NewU128(*big.NewInt(0))})
+		}
+		return padded, true
+	default:
+		log.Warn("submitting undersized stake info", "count", len(top), "want", substrate.TopN)
+		return top, true
+	}
+}
+
+// stakeInfoPayload returns the set to submit for this epoch and whether it is a full set. In diff mode only
+// the stakers that joined, left or changed balance since the last submission are sent, with a full set
+// every FullResyncEpochs epochs and whenever nothing has been submitted yet in this run. The placeholders
+// of a padded set are no stakers, a set growing to or shrinking from TopN doesn't report them joined or
+// stopped.
+func (l *Listener) stakeInfoPayload(top substrate.StakeInfos) (substrate.StakeInfos, bool) {
+	if l.Config.SubmitMode != config.SubmitModeDiff || l.lastSubmitted == nil ||
+		l.epochsSinceFullSync+1 >= l.Config.FullResyncEpochs {
+		return top, true
+	}
+	return substrate.DiffStakeInfos(l.lastSubmitted.WithoutPlaceholders(), top.WithoutPlaceholders()).StakeInfos(), false
+}
+
+// AssignCoinbase keeps the coinbase of the stakers of lastInfos and gives the new stakers the free accounts
+// of params.AccountIDs in order
 func AssignCoinbase(top20StakeInfos substrate.StakeInfos, lastInfos map[string][32]byte) substrate.StakeInfos {
+	return assignCoinbase(top20StakeInfos, lastInfos, nil)
+}
+
+// assignCoinbase is AssignCoinbase drawing the free accounts with r, in order if r is nil
+func assignCoinbase(top20StakeInfos substrate.StakeInfos, lastInfos map[string][32]byte, r *rand.Rand) substrate.StakeInfos {
 	newStakeIndex := make([]int, 0)
 	accounts := make(map[types.AccountID]struct{}, len(params.AccountIDs))
 	for k, v := range params.AccountIDs {
@@ -213,6 +946,10 @@ func AssignCoinbase(top20StakeInfos substrate.StakeInfos, lastInfos map[string][
 	for a := range accounts {
 		as = append(as, a)
 	}
+	sort.Slice(as, func(i, j int) bool { return bytes.Compare(as[i][:], as[j][:]) < 0 })
+	if r != nil {
+		r.Shuffle(len(as), func(i, j int) { as[i], as[j] = as[j], as[i] })
+	}
 	for i, s := range newStakeIndex {
 		top20StakeInfos[s].Coinbase = as[i]
 	}
@@ -229,81 +966,531 @@ func fileExists(fileName string) (bool, error) {
 	return true, nil
 }
 
-func (l *Listener) GetStakeInfo() (substrate.StakeInfos, error) {
-	stakeInfos := make(substrate.StakeInfos, 0)
-	nc, err := nucypher.NewNucypher(ethcommon.HexToAddress(l.Config.EthereumConfig.DepositContractAddr), l.Ethconn.Client)
+// GetStakeInfo reads the stake infos of all stakers from the Source, the deposit contract by default. With a
+// StakerCache configured, the StakerInfo of a staker already read in the bucket of block is reused instead of
+// calling the contract. A read failing without any stake infos returns its error, handled at an epoch
+// boundary by OnSnapshotFailure.
+func (l *Listener) GetStakeInfo(block *big.Int) (substrate.StakeInfos, error) {
+	filter, err := l.stakerFilter()
+	if err != nil {
+		return make(substrate.StakeInfos, 0), err
+	}
+	stakeInfos, skipped, err := l.readStakeSource(l.Ethconn.Client, block, filter)
+	if err != nil && len(stakeInfos) == 0 {
+		return nil, fmt.Errorf("failed to get stake infos: %w", err)
+	} else if err != nil {
+		log.Error("failed to get stake infos", "error", err)
+	}
+	if max := l.Config.MaxStakerFetchFailures; max != nil && skipped > *max {
+		return nil, fmt.Errorf("%w: %d stakers couldn't be read, at most %d may fail", ErrIncompleteStakeInfos, skipped, *max)
+	}
+	if err == nil && skipped == 0 && len(stakeInfos) == 0 {
+		if err := l.checkZeroStakers(block); err != nil {
+			return nil, err
+		}
+	}
+	return stakeInfos, nil
+}
+
+// checkZeroStakers returns ErrZeroStakers when an empty read of the stake infos comes from a deposit contract
+// without stakers, not from the staker filter. The zero is confirmed when the contract reports it at block
+// too, with the confirmations of the synced block, otherwise it was only seen at the head and may be a
+// transient misread. A failed check is logged and treated as stakers being present.
+func (l *Listener) checkZeroStakers(block *big.Int) error {
+	nc, err := nucypher.NewNucypherCaller(ethcommon.HexToAddress(l.Config.EthereumConfig.DepositContractAddr), l.Ethconn.Client)
 	if err != nil {
-		log.Error("failed to new nucypher", "error", err)
-		return stakeInfos, nil
+		return nil
 	}
 	length, err := nc.GetStakersLength(nil)
 	if err != nil {
-		log.Error("failed to get stakes length", "error", err)
-		return stakeInfos, nil
+		log.Warn("failed to check for a deposit contract without stakers", "block", block, "error", err)
+		return nil
+	} else if length.Sign() != 0 {
+		return nil
+	}
+
+	ec := l.Config.EthereumConfig
+	confirmed := false
+	if block != nil && (ec.UseFinalizedTag || ec.BlockConfirmations == nil || ec.BlockConfirmations.Sign() > 0) {
+		deep, err := nc.GetStakersLength(&bind.CallOpts{BlockNumber: block})
+		confirmed = err == nil && deep.Sign() == 0
+	}
+	if confirmed {
+		log.Warn("deposit contract reports no stakers, confirmed at the synced block", "block", block, "policy", l.Config.ZeroStakersPolicy)
+		return fmt.Errorf("%w, confirmed at block %s", ErrZeroStakers, block)
+	}
+	log.Warn("deposit contract reports no stakers at the head only, possibly a transient misread", "block", block, "policy", l.Config.ZeroStakersPolicy)
+	return fmt.Errorf("%w at the head, not confirmed at block %s", ErrZeroStakers, block)
+}
+
+// GetStakeInfoAt returns the stake infos of all allowed stakers as of block, pinning every call of the
+// deposit contract to it. Old blocks need an archive node, ErrStateUnavailable is returned when the node
+// pruned the state of block. Unlike GetStakeInfo a staker that can't be read fails the whole read.
+func (l *Listener) GetStakeInfoAt(block *big.Int) (substrate.StakeInfos, error) {
+	filter, err := l.stakerFilter()
+	if err != nil {
+		return nil, err
+	}
+	infos, skipped, err := l.fetchStakeInfos(l.Ethconn.Client, &bind.CallOpts{BlockNumber: block}, nil, filter)
+	if err != nil {
+		if stateUnavailable(err) {
+			return nil, fmt.Errorf("%w: block %s: %v", ErrStateUnavailable, block, err)
+		}
+		return nil, err
+	}
+	if skipped > 0 {
+		return nil, fmt.Errorf("failed to read %d stakers at block %s", skipped, block)
+	}
+	return infos, nil
+}
+
+// TopAt returns the stakers selected for submission from the stake infos as of block, see GetStakeInfoAt
+func (l *Listener) TopAt(block *big.Int) (substrate.StakeInfos, error) {
+	infos, err := l.GetStakeInfoAt(block)
+	if err != nil {
+		return nil, err
+	}
+	return l.selectTop(infos), nil
+}
+
+// stateUnavailable reports whether err is a node's answer for a block whose state it doesn't hold
+func stateUnavailable(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"missing trie node", "header not found", "state is not available", "historical state", "state not available"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchStakeInfos reads the stake infos of all allowed stakers through caller with opts and returns how many
+// stakers couldn't be read. Only reads of the latest state go through the staker cache.
+func (l *Listener) fetchStakeInfos(caller bind.ContractCaller, opts *bind.CallOpts, block *big.Int, filter *config.StakerFilter) (substrate.StakeInfos, uint64, error) {
+	stakeInfos := make(substrate.StakeInfos, 0)
+	nc, err := nucypher.NewNucypherCaller(ethcommon.HexToAddress(l.Config.EthereumConfig.DepositContractAddr), caller)
+	if err != nil {
+		return stakeInfos, 0, fmt.Errorf("failed to new nucypher: %w", err)
+	}
+	length, err := nc.GetStakersLength(opts)
+	if err != nil {
+		return stakeInfos, 0, fmt.Errorf("failed to get stakes length: %w", err)
 	}
 	log.Info("succeeded to get stakes length", "length", length.Uint64())
 
+	var skipped, filtered uint64
 	for i := int64(0); i < length.Int64(); i++ {
-		staker, err := nc.Stakers(nil, big.NewInt(i))
+		var staker ethcommon.Address
+		err := l.retryStakerCall(func() (err error) {
+			staker, err = nc.Stakers(opts, big.NewInt(i))
+			return err
+		})
 		if err != nil {
 			log.Error("failed to get stakes", "index", i, "error", err)
+			skipped++
+			continue
+		}
+		if !filter.Allowed(staker) {
+			log.Trace("skip filtered staker", "staker", staker)
+			filtered++
 			continue
 		}
 
-		info, err := nc.StakerInfo(nil, staker)
+		var info stakerInfo
+		err = l.retryStakerCall(func() (err error) {
+			info, err = l.stakerInfo(nc, opts, staker, block)
+			return err
+		})
 		if err != nil {
 			log.Error("failed to get stake info", "staker", staker, "error", err)
+			skipped++
+			continue
+		}
+		value, ok := l.fitU128(info.value, "staker", staker)
+		if !ok {
 			continue
 		}
+		info.value = value
 
-		stakeInfos = append(stakeInfos, &substrate.StakeInfo{
-			Coinbase:      types.NewAccountID(staker[:]),
-			WorkBase:      staker[:],
+		stakeInfos = append(stakeInfos, newStakeInfo(staker, info.worker, info.value, l.Config.EthereumConfig.SeparateOperator))
+		log.Trace("succeeded to import stake info", "staker", staker, "operator", info.worker)
+		if n := l.Config.StakerLogInterval; n > 0 && uint64(len(stakeInfos))%n == 0 {
+			log.Debug("importing stake infos", "imported", len(stakeInfos), "skipped", skipped, "total", length)
+		}
+	}
+	log.Info("succeeded to import stake infos", "imported", len(stakeInfos), "skipped", skipped, "filtered", filtered, "total", length)
+	if l.Config.StakerCache.Size > 0 {
+		cache := l.stakerCache()
+		hits, misses, evictions := cache.counts()
+		log.Debug("staker cache", "size", cache.len(), "hits", hits, "misses", misses, "evictions", evictions)
+	}
+	return stakeInfos, skipped, nil
+}
+
+// retryStakerCall calls f up to StakerFetchRetries more times while it fails, waiting a growing backoff
+// between the attempts
+func (l *Listener) retryStakerCall(f func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= l.Config.StakerFetchRetries {
+			return err
+		}
+		log.Debug("retrying staker call", "attempt", attempt+1, "error", err)
+		l.sleep(l.context(), time.Duration(attempt+1)*stakerFetchBackoff)
+	}
+}
+
+// stakerFilter loads the StakerFilter of the config on first use
+func (l *Listener) stakerFilter() (*config.StakerFilter, error) {
+	if l.filter == nil {
+		filter, err := l.Config.StakerFilter.Load()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the staker filter: %w", err)
+		}
+		l.filter = filter
+	}
+	return l.filter, nil
+}
+
+// stakerInfo calls StakerInfo of the deposit contract for staker, through the staker cache if configured
+func (l *Listener) stakerInfo(nc *nucypher.NucypherCaller, opts *bind.CallOpts, staker ethcommon.Address, block *big.Int) (stakerInfo, error) {
+	size := l.Config.StakerCache.Size
+	if size <= 0 || block == nil || opts != nil {
+		info, err := nc.StakerInfo(opts, staker)
+		if err != nil {
+			return stakerInfo{}, err
+		}
+		return stakerInfo{worker: info.Worker, value: info.Value}, nil
+	}
+	cache := l.stakerCache()
+	key := stakerCacheKey{staker: staker, bucket: l.stakerCacheBucket(block)}
+	if info, ok := cache.get(key); ok {
+		return info, nil
+	}
+	info, err := nc.StakerInfo(nil, staker)
+	if err != nil {
+		return stakerInfo{}, err
+	}
+	cached := stakerInfo{worker: info.Worker, value: info.Value}
+	cache.add(key, cached)
+	return cached, nil
+}
+
+// stakerCache returns the staker cache, creating it on first use. The background snapshot reads through it
+// too, so it is created at most once.
+func (l *Listener) stakerCache() *stakerCache {
+	l.stakersOnce.Do(func() {
+		l.stakers = newStakerCache(l.Config.StakerCache.Size)
+	})
+	return l.stakers
+}
+
+// stakerCacheBucket is the bucket of StaleEpochs epochs block belongs to
+func (l *Listener) stakerCacheBucket(block *big.Int) uint64 {
+	stale := l.Config.StakerCache.StaleEpochs
+	if stale == 0 {
+		stale = config.StakerCacheStaleEpochs
+	}
+	return l.Config.Epoch(block.Uint64()) / stale
+}
+
+// newStakeInfo maps a staker to its stake info. With separateOperator the WorkBase is the bonded operator
+// and the Coinbase the owner, a staker without an operator falls back to its own address for both.
+func newStakeInfo(staker, operator ethcommon.Address, value *big.Int, separateOperator bool) *substrate.StakeInfo {
+	workBase := staker
+	if separateOperator && operator != (ethcommon.Address{}) {
+		workBase = operator
+	}
+	return &substrate.StakeInfo{
+		Coinbase:      substrate.EthAddrToAccountID(staker),
+		WorkBase:      workBase[:],
+		IsWork:        true,
+		LockedBalance: types.NewU128(*value),
+		WorkCount:     0,
+	}
+}
+
+// stakeInfoFileVersion is the layout of the stake info file written by writeStakeInfoFile. Version 0 is a
+// json map of work base to coinbase, version 1 a bare array of stakeInfoRecord and version 2 a stakeInfoFile.
+// Bump it when the records change incompatibly and keep decoding the older versions in decodeStakeInfos.
+const stakeInfoFileVersion = 2
+
+// stakeInfoFile tags the records of the stake info file with the version of their layout
+type stakeInfoFile struct {
+	Version    int               `json:"version"`
+	StakeInfos []stakeInfoRecord `json:"stakeInfos"`
+	// DeferredStopped are the stopped stakers MaxStoppedPerEpoch deferred to the next epochs
+	DeferredStopped []stakeInfoRecord `json:"deferredStopped,omitempty"`
+}
+
+// stakeInfoRecord is the form a StakeInfo is persisted in the stake info file
+type stakeInfoRecord struct {
+	Coinbase      hexutil.Bytes `json:"coinbase"`
+	WorkBase      hexutil.Bytes `json:"workBase"`
+	IsWork        bool          `json:"isWork"`
+	LockedBalance string        `json:"lockedBalance"`
+	WorkCount     uint32        `json:"workCount"`
+	// AbsentEpochs counts the epochs a staker kept in its stopped grace period has been out of the top set
+	AbsentEpochs uint64 `json:"absentEpochs,omitempty"`
+}
+
+func newStakeInfoRecord(info *substrate.StakeInfo) stakeInfoRecord {
+	balance := "0"
+	if info.LockedBalance.Int != nil {
+		balance = info.LockedBalance.String()
+	}
+	return stakeInfoRecord{
+		Coinbase:      info.Coinbase[:],
+		WorkBase:      info.WorkBase,
+		IsWork:        info.IsWork,
+		LockedBalance: balance,
+		WorkCount:     info.WorkCount,
+	}
+}
+
+func (r stakeInfoRecord) stakeInfo() (*substrate.StakeInfo, error) {
+	if len(r.Coinbase) != len(types.AccountID{}) {
+		return nil, fmt.Errorf("invalid coinbase length %d", len(r.Coinbase))
+	}
+	balance, ok := new(big.Int).SetString(r.LockedBalance, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid locked balance %q", r.LockedBalance)
+	}
+	return &substrate.StakeInfo{
+		Coinbase:      types.NewAccountID(r.Coinbase),
+		WorkBase:      r.WorkBase,
+		IsWork:        r.IsWork,
+		LockedBalance: types.NewU128(*balance),
+		WorkCount:     r.WorkCount,
+	}, nil
+}
+
+// decodeStakeInfos decodes the stake info file streamed from r, the absent epochs of stakers in their grace
+// period, keyed by hex work base, and the deferred stopped stakers. Older file versions decode into the
+// current StakeInfo with the fields they lack zeroed: files written before balances were persisted are a map
+// of work base to coinbase and decode with a zero locked balance. Files of a newer version than
+// stakeInfoFileVersion fail with ErrUnknownStateVersion. The records are decoded one at a time, so a large
+// file isn't held in memory next to its stake infos.
+func decodeStakeInfos(r io.Reader) (substrate.StakeInfos, map[string]uint64, substrate.StakeInfos, error) {
+	dec := json.NewDecoder(r)
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	switch tok {
+	case json.Delim('['):
+		records, err := decodeRecordElements(dec)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if err := expectEOF(dec); err != nil {
+			return nil, nil, nil, err
+		}
+		infos, absent, err := stakeInfosFromRecords(records)
+		return infos, absent, nil, err
+	case json.Delim('{'):
+	default:
+		return nil, nil, nil, fmt.Errorf("unexpected %v at the start of the stake info file", tok)
+	}
+
+	// the object is a stakeInfoFile with a version or else a legacy map, which is only known at its end
+	var version *int
+	var records, deferredRecords []stakeInfoRecord
+	legacy := make(map[string]json.RawMessage)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		key, _ := tok.(string)
+		switch key {
+		case "version":
+			if err := dec.Decode(&version); err != nil {
+				return nil, nil, nil, err
+			}
+			if version != nil && *version > stakeInfoFileVersion {
+				return nil, nil, nil, fmt.Errorf("%w %d, expected at most %d", ErrUnknownStateVersion, *version, stakeInfoFileVersion)
+			}
+		case "stakeInfos":
+			if records, err = decodeRecords(dec); err != nil {
+				return nil, nil, nil, err
+			}
+		case "deferredStopped":
+			if deferredRecords, err = decodeRecords(dec); err != nil {
+				return nil, nil, nil, err
+			}
+		default:
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return nil, nil, nil, err
+			}
+			legacy[key] = raw
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := expectEOF(dec); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if version != nil {
+		infos, absent, err := stakeInfosFromRecords(records)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		deferred, _, err := stakeInfosFromRecords(deferredRecords)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("deferred stopped stakers: %w", err)
+		}
+		return infos, absent, deferred, nil
+	}
+
+	infos := make(substrate.StakeInfos, 0, len(legacy))
+	for workBase, raw := range legacy {
+		var coinbase [32]byte
+		if err := json.Unmarshal(raw, &coinbase); err != nil {
+			return nil, nil, nil, err
+		}
+		infos = append(infos, &substrate.StakeInfo{
+			Coinbase:      coinbase,
+			WorkBase:      ethcommon.Hex2Bytes(workBase),
 			IsWork:        true,
-			LockedBalance: types.NewU128(*info.Value),
-			WorkCount:     0,
+			LockedBalance: types.NewU128(*big.NewInt(0)),
 		})
-		log.Debug("succeeded to import stake info", "staker", staker)
 	}
-	return stakeInfos, err
+	return infos, map[string]uint64{}, nil, nil
 }
 
-func ReadStakeInfos(file string) (map[string][32]byte, error) {
-	//stakeInfoList := make(substrate.StakeInfos, 0)
-	stakeInfoList := make(map[string][32]byte, 0)
-	// If it exists, load and return
-	exists, err := fileExists(file)
+// decodeRecords decodes the next value of dec, an array of stakeInfoRecord or null
+func decodeRecords(dec *json.Decoder) ([]stakeInfoRecord, error) {
+	tok, err := dec.Token()
 	if err != nil {
-		return stakeInfoList, err
+		return nil, err
+	}
+	if tok == nil {
+		return nil, nil
 	}
-	if !exists {
-		log.Warn("stake info file does not exist")
-		return stakeInfoList, nil
+	if tok != json.Delim('[') {
+		return nil, fmt.Errorf("expected an array of stake infos, got %v", tok)
 	}
+	return decodeRecordElements(dec)
+}
 
-	data, err := ioutil.ReadFile(file)
-	if err != nil {
+// decodeRecordElements decodes the elements of an array of stakeInfoRecord after its opening bracket
+func decodeRecordElements(dec *json.Decoder) ([]stakeInfoRecord, error) {
+	var records []stakeInfoRecord
+	for dec.More() {
+		var r stakeInfoRecord
+		if err := dec.Decode(&r); err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// expectEOF fails if dec holds anything after the decoded value
+func expectEOF(dec *json.Decoder) error {
+	if _, err := dec.Token(); err != io.EOF {
+		return fmt.Errorf("unexpected data after the stake infos")
+	}
+	return nil
+}
+
+// stakeInfosFromRecords converts the records of a stake info file, see decodeStakeInfos
+func stakeInfosFromRecords(records []stakeInfoRecord) (substrate.StakeInfos, map[string]uint64, error) {
+	infos := make(substrate.StakeInfos, 0, len(records))
+	absent := make(map[string]uint64)
+	for _, r := range records {
+		info, err := r.stakeInfo()
+		if err != nil {
+			return nil, nil, err
+		}
+		infos = append(infos, info)
+		if r.AbsentEpochs > 0 {
+			absent[ethcommon.Bytes2Hex(r.WorkBase)] = r.AbsentEpochs
+		}
+	}
+	return infos, absent, nil
+}
+
+// coinbaseIndex maps the hex work base of each staker to its assigned coinbase
+func coinbaseIndex(infos substrate.StakeInfos) map[string][32]byte {
+	index := make(map[string][32]byte, len(infos))
+	for _, info := range infos {
+		index[ethcommon.Bytes2Hex(info.WorkBase)] = info.Coinbase
+	}
+	return index
+}
+
+// ReadStakeInfos reads the stake info file. A missing or empty file holds no stake infos and returns an
+// empty set without an error, only failures to read or decode an existing file return one. An empty file
+// name disables persistence and also returns an empty set.
+func ReadStakeInfos(file string) (substrate.StakeInfos, error) {
+	infos, _, err := readStakeInfoFile(file)
+	return infos, err
+}
+
+// readStakeInfoFile reads the stake infos and absent epochs of the stake info file, like ReadStakeInfos
+func readStakeInfoFile(file string) (substrate.StakeInfos, map[string]uint64, error) {
+	infos, absent, _, err := readStakeInfoState(file)
+	return infos, absent, err
+}
+
+// stakeInfoReadBuffer is the buffer the stake info file is streamed through
+const stakeInfoReadBuffer = 64 << 10
+
+// readStakeInfoState is readStakeInfoFile also returning the deferred stopped stakers
+func readStakeInfoState(file string) (substrate.StakeInfos, map[string]uint64, substrate.StakeInfos, error) {
+	if file == "" {
+		return make(substrate.StakeInfos, 0), make(map[string]uint64), nil, nil
+	}
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		log.Warn("stake info file does not exist", "path", file)
+		return make(substrate.StakeInfos, 0), make(map[string]uint64), nil, nil
+	} else if err != nil {
 		log.Error("read stake info list from file filed", "error", err)
-		return stakeInfoList, err
+		return make(substrate.StakeInfos, 0), make(map[string]uint64), nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReaderSize(f, stakeInfoReadBuffer)
+	if _, err := br.Peek(1); err == io.EOF {
+		log.Warn("stake info file is empty", "path", file)
+		return make(substrate.StakeInfos, 0), make(map[string]uint64), nil, nil
 	}
-	if len(data) == 0 {
-		log.Warn("stake info file is empty")
-		return stakeInfoList, nil
+	src, err := gunzipReader(br)
+	if err != nil {
+		log.Error("decompress stake info list failed", "error", err)
+		return make(substrate.StakeInfos, 0), make(map[string]uint64), nil, fmt.Errorf("failed to decompress %s: %w", file, err)
 	}
+	defer src.Close()
 
-	//var infos []*substrate.StakeInfo
-	var infos map[string][32]byte
-	if err := json.Unmarshal(data, &infos); err != nil {
+	infos, absent, deferred, err := decodeStakeInfos(src)
+	if err != nil {
 		log.Error("json unmarshal stake info list failed", "error", err)
-		return stakeInfoList, err
+		return make(substrate.StakeInfos, 0), make(map[string]uint64), nil, fmt.Errorf("failed to decode %s: %w", file, err)
 	}
+	return infos, absent, deferred, nil
+}
 
-	stakeInfoList = infos
-
-	return stakeInfoList, err
+// WriteStakeInfos atomically replaces the stake info file with infos, gzip compressed if compress is set. An
+// empty file name disables persistence and writes nothing.
+func WriteStakeInfos(file string, infos substrate.StakeInfos, compress bool) error {
+	return writeStakeInfoFile(file, infos, nil, nil, compress)
 }
 
-func WriteStakeInfos(file string, infos substrate.StakeInfos) error {
+// writeStakeInfoFile is WriteStakeInfos also persisting the absent epochs of stakers in their grace period
+// and the deferred stopped stakers
+func writeStakeInfoFile(file string, infos substrate.StakeInfos, absent map[string]uint64, deferred substrate.StakeInfos, compress bool) error {
+	if file == "" {
+		return nil
+	}
 	// Create dir if it does not exist
 	if _, err := os.Stat(file); os.IsNotExist(err) {
 		dir, _ := filepath.Split(file)
@@ -313,25 +1500,92 @@ func WriteStakeInfos(file string, infos substrate.StakeInfos) error {
 		}
 	}
 
-	stakeInfos := make(map[string][32]byte)
+	records := make([]stakeInfoRecord, 0, len(infos))
 	for _, info := range infos {
-		stakeInfos[ethcommon.Bytes2Hex(info.WorkBase)] = info.Coinbase
+		r := newStakeInfoRecord(info)
+		r.AbsentEpochs = absent[ethcommon.Bytes2Hex(info.WorkBase)]
+		records = append(records, r)
 	}
 
-	data, err := json.Marshal(stakeInfos)
+	content := stakeInfoFile{Version: stakeInfoFileVersion, StakeInfos: records}
+	for _, info := range deferred {
+		content.DeferredStopped = append(content.DeferredStopped, newStakeInfoRecord(info))
+	}
+	data, err := json.Marshal(content)
 	if err != nil {
 		log.Error("json marshal stake info list failed", "error", err)
 		return err
 	}
-	if err := ioutil.WriteFile(file, data, 0664); err != nil {
+	if compress {
+		if data, err = gzipBytes(data); err != nil {
+			log.Error("compress stake info list failed", "error", err)
+			return err
+		}
+	}
+	if err := writeFileAtomic(file, data, 0664); err != nil {
 		log.Error("write stake info list to file filed", "error", err)
 		return err
 	}
-	log.Info("write stake info list to file succeeded", "count", len(stakeInfos))
+	log.Info("write stake info list to file succeeded", "count", len(records), "compressed", compress)
 	return nil
 }
 
-func WriteLatestBlock(file string, number *big.Int) error {
+// writeFileAtomic writes data to a temporary file next to file and renames it into place, so readers
+// never observe a partially written file
+func writeFileAtomic(file string, data []byte, perm os.FileMode) error {
+	dir, name := filepath.Split(file)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := ioutil.TempFile(dir, "."+name+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// gunzipReader decompresses r when it starts with the gzip magic bytes and returns anything else as is
+func gunzipReader(r *bufio.Reader) (io.ReadCloser, error) {
+	if magic, err := r.Peek(2); err != nil || magic[0] != 0x1f || magic[1] != 0x8b {
+		return ioutil.NopCloser(r), nil
+	}
+	return gzip.NewReader(r)
+}
+
+// WriteLatestBlock persists number as a decimal string, or 0x prefixed hex for the hex format. An empty file
+// name disables persistence and writes nothing.
+func WriteLatestBlock(file string, number *big.Int, format string) error {
+	if file == "" {
+		return nil
+	}
 	// Create dir if it does not exist
 	if _, err := os.Stat(file); os.IsNotExist(err) {
 		dir, _ := filepath.Split(file)
@@ -343,14 +1597,18 @@ func WriteLatestBlock(file string, number *big.Int) error {
 
 	// Write bytes to file
 	data := []byte(number.String())
-	err := ioutil.WriteFile(file, data, 0600)
-	if err != nil {
-		return err
+	if format == config.LatestBlockHex {
+		data = []byte("0x" + number.Text(16))
 	}
-	return nil
+	return writeFileAtomic(file, data, 0600)
 }
 
+// ReadLatestBlock returns the persisted block, 0 if the file doesn't exist or the file name is empty. Both
+// formats of WriteLatestBlock are read, a 0x prefix marks a hex block.
 func ReadLatestBlock(file string) (*big.Int, error) {
+	if file == "" {
+		return big.NewInt(0), nil
+	}
 	// If it exists, load and return
 	exists, err := fileExists(file)
 	if err != nil {
@@ -361,7 +1619,14 @@ func ReadLatestBlock(file string) (*big.Int, error) {
 		if err != nil {
 			return nil, err
 		}
-		block, _ := new(big.Int).SetString(string(data), 10)
+		text, base := strings.TrimSpace(string(data)), 10
+		if strings.HasPrefix(text, "0x") || strings.HasPrefix(text, "0X") {
+			text, base = text[2:], 16
+		}
+		block, ok := new(big.Int).SetString(text, base)
+		if !ok || block.Sign() < 0 {
+			return nil, fmt.Errorf("invalid latest block %q in %s", data, file)
+		}
 		return block, nil
 	}
 	// Otherwise just return 0
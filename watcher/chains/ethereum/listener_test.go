@@ -1,18 +1,30 @@
 package ethereum
 
 import (
+	"bytes"
+	"context"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math/big"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/rlp"
 
 	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
 )
 
 var (
@@ -259,7 +271,7 @@ func TestAssignCoinbase(t *testing.T) {
 }
 
 func TestWriteAndReadStakeInfo(t *testing.T) {
-	filePath := "./stake-info.json"
+	filePath := filepath.Join(t.TempDir(), "stake-info.json")
 	stakeInfos := substrate.StakeInfos{
 		{
 			Coinbase:      Coinbase[4],
@@ -306,7 +318,7 @@ func TestWriteAndReadStakeInfo(t *testing.T) {
 		common.Bytes2Hex(WorkBase[0]): Coinbase[0],
 	}
 
-	if err := WriteStakeInfos(filePath, stakeInfos); err != nil {
+	if err := WriteStakeInfos(filePath, stakeInfos, false); err != nil {
 		t.Fatal(err)
 	}
 
@@ -314,8 +326,1222 @@ func TestWriteAndReadStakeInfo(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if !reflect.DeepEqual(got, want) {
-		t.Errorf("ReadStakeInfos() = %v, want %v", got, want)
+	if !reflect.DeepEqual(got, stakeInfos) {
+		t.Errorf("ReadStakeInfos() = %v, want %v", got, stakeInfos)
 	}
+	if index := coinbaseIndex(got); !reflect.DeepEqual(index, want) {
+		t.Errorf("coinbaseIndex() = %v, want %v", index, want)
+	}
+
+}
+
+func TestListener_stakeInfoPayload(t *testing.T) {
+	last := substrate.StakeInfos{
+		{WorkBase: WorkBase[0], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(1))},
+		{WorkBase: WorkBase[1], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(2))},
+	}
+	top := substrate.StakeInfos{
+		{WorkBase: WorkBase[0], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(1))},
+		{WorkBase: WorkBase[2], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(3))},
+	}
+
+	tests := []struct {
+		name                string
+		submitMode          string
+		lastSubmitted       substrate.StakeInfos
+		epochsSinceFullSync uint64
+		wantFull            bool
+		wantLen             int
+	}{
+		{name: "full-mode", submitMode: config.SubmitModeFull, lastSubmitted: last, wantFull: true, wantLen: 2},
+		{name: "diff-mode-first-submission", submitMode: config.SubmitModeDiff, wantFull: true, wantLen: 2},
+		{name: "diff-mode", submitMode: config.SubmitModeDiff, lastSubmitted: last, wantFull: false, wantLen: 2},
+		{name: "diff-mode-resync", submitMode: config.SubmitModeDiff, lastSubmitted: last, epochsSinceFullSync: 9, wantFull: true, wantLen: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &Listener{
+				Config:              &config.Config{SubmitMode: tt.submitMode, FullResyncEpochs: 10},
+				lastSubmitted:       tt.lastSubmitted,
+				epochsSinceFullSync: tt.epochsSinceFullSync,
+			}
+			got, full := l.stakeInfoPayload(top)
+			if full != tt.wantFull {
+				t.Errorf("stakeInfoPayload() full = %v, want %v", full, tt.wantFull)
+			}
+			if len(got) != tt.wantLen {
+				t.Errorf("stakeInfoPayload() len = %d, want %d", len(got), tt.wantLen)
+			}
+			if !full && (got[1].IsWork || !reflect.DeepEqual(got[1].WorkBase, WorkBase[1])) {
+				t.Errorf("stakeInfoPayload() want %x reported as stopped, got %+v", WorkBase[1], got[1])
+			}
+		})
+	}
+}
+
+func TestListener_heartbeat(t *testing.T) {
+	set := func(balances ...int64) substrate.StakeInfos {
+		infos := make(substrate.StakeInfos, len(balances))
+		for i, b := range balances {
+			infos[i] = &substrate.StakeInfo{WorkBase: WorkBase[i], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(b))}
+		}
+		return infos
+	}
+	sub := &substrate.MockSubmitter{}
+	l := &Listener{
+		Config:        &config.Config{EpochSize: 1000, SubmitMode: config.SubmitModeDiff, FullResyncEpochs: 100, HeartbeatEpochs: 2},
+		Subconn:       sub,
+		lastSubmitted: set(2, 1),
+	}
+
+	// the count of submitted stake infos per epoch, 0 for a heartbeat and -1 for none
+	epochs := []struct {
+		top  substrate.StakeInfos
+		want int
+	}{
+		{top: set(2, 1), want: -1},
+		{top: set(2, 1), want: 0},
+		{top: set(2, 1), want: -1},
+		{top: set(3, 1), want: 1},
+		{top: set(3, 1), want: -1},
+		{top: set(3, 1), want: 0},
+	}
+	for i, e := range epochs {
+		before := len(sub.Calls())
+		block := big.NewInt(int64(i+1) * 1000)
+		if err := l.submitSet(&pendingSet{block: block, top: e.top, submit: e.top}, time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+		calls := sub.Calls()
+		got := -1
+		if len(calls) > before {
+			got = len(calls[len(calls)-1].Args[0].(substrate.StakeInfos))
+		}
+		if len(calls) > before+1 || got != e.want {
+			t.Errorf("epoch %d: submitted %d calls, last with %d stake infos, want %d", i, len(calls)-before, got, e.want)
+		}
+	}
+	if l.stats.Heartbeats != 2 || l.stats.Submissions != 1 {
+		t.Errorf("Heartbeats = %d, Submissions = %d, want 2 and 1", l.stats.Heartbeats, l.stats.Submissions)
+	}
+	// heartbeats leave the diff base and the resync schedule alone
+	if len(substrate.DiffStakeInfos(l.lastSubmitted, set(3, 1)).StakeInfos()) != 0 || l.epochsSinceFullSync != 6 {
+		t.Errorf("lastSubmitted = %v, epochsSinceFullSync = %d, want the last set and 6", l.lastSubmitted, l.epochsSinceFullSync)
+	}
+}
+
+func TestListener_verifyTopN(t *testing.T) {
+	unsorted := substrate.StakeInfos{
+		{WorkBase: WorkBase[0], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(1))},
+		{WorkBase: WorkBase[1], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(2))},
+	}
+	tests := []struct {
+		name   string
+		verify bool
+		want   bool
+	}{
+		{name: "disabled", verify: false, want: true},
+		{name: "enabled", verify: true, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &Listener{Config: &config.Config{VerifyTopN: tt.verify}}
+			if got := l.verifyTopN(unsorted); got != tt.want {
+				t.Errorf("verifyTopN() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListener_fillTopN(t *testing.T) {
+	undersized := substrate.StakeInfos{
+		{WorkBase: WorkBase[0], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(2))},
+		{WorkBase: WorkBase[1], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(1))},
+	}
+	full := make(substrate.StakeInfos, substrate.TopN)
+	for i := range full {
+		full[i] = &substrate.StakeInfo{WorkBase: []byte{byte(i)}, IsWork: true, LockedBalance: types.NewU128(*big.NewInt(1))}
+	}
+
+	tests := []struct {
+		name    string
+		policy  string
+		infos   substrate.StakeInfos
+		wantOK  bool
+		wantLen int
+	}{
+		{name: "warn-and-submit", policy: config.UndersizedWarn, infos: undersized, wantOK: true, wantLen: 2},
+		{name: "abort", policy: config.UndersizedAbort, infos: undersized, wantOK: false},
+		{name: "pad", policy: config.UndersizedPad, infos: undersized, wantOK: true, wantLen: substrate.TopN},
+		{name: "abort-full-set", policy: config.UndersizedAbort, infos: full, wantOK: true, wantLen: substrate.TopN},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &Listener{Config: &config.Config{UndersizedPolicy: tt.policy}}
+			got, ok := l.fillTopN(tt.infos)
+			if ok != tt.wantOK {
+				t.Fatalf("fillTopN() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if len(got) != tt.wantLen {
+				t.Errorf("fillTopN() len = %d, want %d", len(got), tt.wantLen)
+			}
+			for _, info := range got[len(tt.infos):] {
+				if info.IsWork || info.LockedBalance.Sign() != 0 {
+					t.Errorf("fillTopN() placeholder = %+v, want stopped with no balance", info)
+				}
+			}
+		})
+	}
+}
+
+func TestNewStakeInfo(t *testing.T) {
+	staker := common.HexToAddress("0xa7f6c9a5052a08a14ff0e3349094b6efbc591ea4")
+	operator := common.HexToAddress("0x00192fb10df37c9fb26829eb2cc623cd1bf599e8")
+	tests := []struct {
+		name             string
+		operator         common.Address
+		separateOperator bool
+		wantWorkBase     common.Address
+	}{
+		{name: "combined", operator: operator, separateOperator: false, wantWorkBase: staker},
+		{name: "separate", operator: operator, separateOperator: true, wantWorkBase: operator},
+		{name: "separate-unbonded", operator: common.Address{}, separateOperator: true, wantWorkBase: staker},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newStakeInfo(staker, tt.operator, big.NewInt(7), tt.separateOperator)
+			if !reflect.DeepEqual(got.WorkBase, tt.wantWorkBase[:]) {
+				t.Errorf("newStakeInfo() WorkBase = %x, want %x", got.WorkBase, tt.wantWorkBase)
+			}
+			if got.Coinbase != substrate.EthAddrToAccountID(staker) {
+				t.Errorf("newStakeInfo() Coinbase = %x, want owner %x", got.Coinbase, staker)
+			}
+			if got.LockedBalance.Int64() != 7 || !got.IsWork {
+				t.Errorf("newStakeInfo() = %+v", got)
+			}
+		})
+	}
+}
+
+func TestListener_selectTop(t *testing.T) {
+	infos := substrate.StakeInfos{
+		{WorkBase: WorkBase[0], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(0))},
+		{WorkBase: WorkBase[1], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(1))},
+		{WorkBase: WorkBase[2], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(0))},
+	}
+	l := &Listener{Config: &config.Config{}}
+	got := l.selectTop(infos)
+	if len(got) != 1 || !reflect.DeepEqual(got[0].WorkBase, WorkBase[1]) {
+		t.Errorf("selectTop() = %+v, want only %x", got, WorkBase[1])
+	}
+}
+
+func TestSyncRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to int64
+		offset   uint64
+		want     []int64
+	}{
+		{name: "three-boundaries", from: 950, to: 3100, want: []int64{1000, 2000, 3000}},
+		{name: "ends-on-boundary", from: 1000, to: 2000, want: []int64{2000}},
+		{name: "no-boundary", from: 1001, to: 1999, want: []int64{1999}},
+		{name: "single-block", from: 999, to: 1000, want: []int64{1000}},
+		{name: "offset", from: 950, to: 3100, offset: 100, want: []int64{1100, 2100, 3100}},
+		{name: "offset-from-boundary", from: 1100, to: 2100, offset: 100, want: []int64{2100}},
+		{name: "before-offset", from: 10, to: 1200, offset: 100, want: []int64{100, 1100}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int64
+			err := syncRange(big.NewInt(tt.from), big.NewInt(tt.to), 1000, tt.offset, func(b *big.Int) error {
+				got = append(got, b.Int64())
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("syncRange() synced %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	failed := errors.New("submit failed")
+	var calls int
+	err := syncRange(big.NewInt(0), big.NewInt(3000), 1000, 0, func(b *big.Int) error {
+		calls++
+		return failed
+	})
+	if !errors.Is(err, failed) || calls != 1 {
+		t.Errorf("syncRange() = %v after %d calls, want to stop at the first failure", err, calls)
+	}
+}
+
+func TestListener_syncStakeInfosEmptyUpdate(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = false
+
+	sub := &substrate.MockSubmitter{}
+	l := &Listener{Config: &config.Config{EpochSize: 1000}, Subconn: sub}
+	for _, block := range []int64{9, 10, 11, 20} {
+		if err := l.syncStakeInfos(big.NewInt(block)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	calls := sub.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("syncStakeInfos() submitted %d times, want 2", len(calls))
+	}
+	for _, c := range calls {
+		if infos, ok := c.Args[0].(substrate.StakeInfos); !ok || len(infos) != 0 {
+			t.Errorf("syncStakeInfos() submitted %v, want an empty update", c.Args)
+		}
+	}
+	if l.stats.Submissions != 2 {
+		t.Errorf("Submissions = %d, want 2", l.stats.Submissions)
+	}
+}
+
+func TestListener_syncStakeInfosDispatchError(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = false
+
+	rejected := &substrate.DispatchError{Module: true, Pallet: "NulinkNuproxy", Name: "NoPermission", PalletIndex: 8, ErrorIndex: 1}
+	l := &Listener{
+		Config:  &config.Config{EpochSize: 1000, SubmitMode: config.SubmitModeFull},
+		Ethconn: newTestConnection(t, nil),
+		Source:  emptySource{},
+		Subconn: &substrate.MockSubmitter{Err: rejected},
+	}
+	err := l.syncStakeInfos(big.NewInt(1000))
+	if !errors.Is(err, substrate.ErrSubmitFailed) || !errors.Is(err, substrate.ErrDispatchFailed) {
+		t.Fatalf("syncStakeInfos() error = %v, want a failed dispatch", err)
+	}
+	var de *substrate.DispatchError
+	if !errors.As(err, &de) || de.Pallet != "NulinkNuproxy" || de.Name != "NoPermission" {
+		t.Errorf("syncStakeInfos() error = %v, want NulinkNuproxy.NoPermission", err)
+	}
+	if l.lastSubmitted != nil || l.stats.SubmitErrors != 1 {
+		t.Errorf("lastSubmitted = %v, SubmitErrors = %d, want a failed submission", l.lastSubmitted, l.stats.SubmitErrors)
+	}
+}
+
+func TestListener_RunRegressingLatestBlock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var calls int
+	conn := newTestConnection(t, map[string]rpcHandler{
+		"eth_getBlockByNumber": func(params []json.RawMessage) (interface{}, *rpcError) {
+			calls++
+			if calls == 7 {
+				cancel()
+			}
+			return testHeader(50), nil
+		},
+	})
+	l := &Listener{
+		Config: &config.Config{
+			PollInterval:        config.Duration{Duration: time.Millisecond},
+			RegressionTolerance: 2,
+			EthereumConfig: config.EthereumConfig{
+				BlockConfirmations: big.NewInt(0),
+				StartBlock:         big.NewInt(100),
+			},
+		},
+		Ethconn: conn,
+		Subconn: &substrate.MockSubmitter{},
+		Stop:    make(chan struct{}, 1),
+	}
+	stats, err := l.Run(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run() error = %v, want %v", err, context.Canceled)
+	}
+	if stats.BlocksProcessed != 0 {
+		t.Errorf("Run() processed %d blocks from a regressing node, want to wait", stats.BlocksProcessed)
+	}
+	if stats.Regressions != 7 || stats.Reconnects != 2 {
+		t.Errorf("Run() regressions = %d, reconnects = %d, want 7 and 2", stats.Regressions, stats.Reconnects)
+	}
+}
 
+func TestListener_syncStakeInfosDeadline(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = false
+
+	tests := []struct {
+		name     string
+		delay    time.Duration
+		wantLate uint64
+	}{
+		{name: "in-time", delay: 0, wantLate: 0},
+		{name: "slow-submitter", delay: 200 * time.Millisecond, wantLate: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "stake-info.json")
+			sub := &substrate.MockSubmitter{Delay: tt.delay}
+			l := &Listener{
+				Config: &config.Config{
+					EpochSize:          1000,
+					SubmitMode:         config.SubmitModeFull,
+					SubmissionDeadline: config.Duration{Duration: 20 * time.Millisecond},
+				},
+				Ethconn:           newTestConnection(t, nil),
+				Source:            emptySource{},
+				Subconn:           sub,
+				LastStakeInfoPath: path,
+			}
+			if err := l.syncStakeInfos(big.NewInt(1000)); err != nil {
+				t.Fatalf("syncStakeInfos() error = %v", err)
+			}
+			if l.stats.LateSubmissions != tt.wantLate {
+				t.Errorf("LateSubmissions = %d, want %d", l.stats.LateSubmissions, tt.wantLate)
+			}
+			deferred := tt.wantLate > 0
+			if _, err := os.Stat(path); deferred != os.IsNotExist(err) {
+				t.Errorf("stake info file written = %v, want %v", !os.IsNotExist(err), !deferred)
+			}
+			if deferred != (l.lastSubmitted == nil) {
+				t.Errorf("lastSubmitted = %v, want it unset only for a late submission", l.lastSubmitted)
+			}
+			// an abandoned submission was never sent
+			if got := len(sub.Calls()); got != 1-int(tt.wantLate) {
+				t.Errorf("sent %d submissions, want %d", got, 1-tt.wantLate)
+			}
+		})
+	}
+}
+
+func TestListener_RunStopped(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	l := &Listener{Config: &config.Config{}, Stop: make(chan struct{})}
+	stats, err := l.Run(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() error = %v, want %v", err, context.Canceled)
+	}
+	if stats.BlocksProcessed != 0 || stats.LastBlock != nil {
+		t.Errorf("Run() stats = %+v, want zero", stats)
+	}
+
+	stop := make(chan struct{})
+	close(stop)
+	l = &Listener{Config: &config.Config{}, Stop: stop}
+	if _, err := l.Run(context.Background()); !errors.Is(err, ErrPollingTerminated) {
+		t.Errorf("Run() error = %v, want %v", err, ErrPollingTerminated)
+	}
+}
+
+// PollBlocks returns on an error even though the Stop channel is unbuffered and nobody reads it
+func TestListener_PollBlocksErrorUnbufferedStop(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = false
+
+	staker := common.BytesToAddress(WorkBase[0])
+	var tags []string
+	contract := stakingContract(t, map[string]map[common.Address]int64{"latest": {staker: 10}}, &tags)
+	tests := []struct {
+		name    string
+		header  rpcHandler
+		wantErr error
+	}{
+		{name: "retries exceeded", wantErr: ErrRetriesExceeded,
+			header: func(params []json.RawMessage) (interface{}, *rpcError) {
+				return nil, &rpcError{Code: -32000, Message: "header not found"}
+			}},
+		{name: "sync failed", wantErr: substrate.ErrSubmitFailed,
+			header: func(params []json.RawMessage) (interface{}, *rpcError) { return testHeader(1000), nil }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetStakeInfoList()
+			conn := newTestConnection(t, map[string]rpcHandler{"eth_getBlockByNumber": tt.header, "eth_call": contract})
+			l := &Listener{
+				Config: &config.Config{EpochSize: 1000, SubmitMode: config.SubmitModeFull,
+					RetryInterval:  config.Duration{Duration: time.Millisecond},
+					EthereumConfig: config.EthereumConfig{BlockConfirmations: big.NewInt(0), StartBlock: big.NewInt(0)}},
+				Ethconn: conn,
+				Subconn: &substrate.MockSubmitter{Err: errors.New("pool full")},
+				Stop:    make(chan struct{}),
+			}
+			done := make(chan error, 1)
+			go func() { done <- l.PollBlocks() }()
+			select {
+			case err := <-done:
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("PollBlocks() error = %v, want %v", err, tt.wantErr)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("PollBlocks() blocked on the Stop channel")
+			}
+		})
+	}
+}
+
+func TestNewListenerNilConfirmations(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var calls int
+	conn := newTestConnection(t, map[string]rpcHandler{
+		"eth_getBlockByNumber": func(params []json.RawMessage) (interface{}, *rpcError) {
+			calls++
+			if calls == 2 {
+				cancel()
+			}
+			return testHeader(50), nil
+		},
+	})
+	cfg := &config.Config{
+		PollInterval:   config.Duration{Duration: time.Millisecond},
+		EthereumConfig: config.EthereumConfig{StartBlock: big.NewInt(100)},
+	}
+	l := NewListener(cfg, conn, &substrate.MockSubmitter{}, make(chan struct{}, 1))
+	if c := l.Config.EthereumConfig.BlockConfirmations; c == nil || c.Int64() != config.BlockConfirmations {
+		t.Fatalf("NewListener() blockConfirmations = %v, want %d", c, config.BlockConfirmations)
+	}
+
+	// a listener built without NewListener gets the default when it runs
+	cfg.EthereumConfig.BlockConfirmations = nil
+	l = &Listener{Config: cfg, Ethconn: conn, Subconn: &substrate.MockSubmitter{}, Stop: make(chan struct{}, 1)}
+	if _, err := l.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run() error = %v, want %v", err, context.Canceled)
+	}
+	if calls < 2 {
+		t.Errorf("Run() fetched the latest block %d times, want it to poll", calls)
+	}
+}
+
+func TestNewListenerBareConfig(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = true
+	defer resetStakeInfoList()
+	resetStakeInfoList()
+
+	l := NewListener(&config.Config{}, nil, nil, nil)
+	ec := l.Config.EthereumConfig
+	if ec.BlockConfirmations == nil || ec.StakerTopic == nil || ec.StakerDecoder != config.StakerDecoderRaw ||
+		l.Config.EpochSize == 0 || l.Config.MaxEventsPerBlock == 0 {
+		t.Fatalf("NewListener() left settings unset: %+v", l.Config)
+	}
+
+	// a bare config polling the deposit events reads the staker with the default topic and decoder
+	contract, staker := common.HexToAddress(""), common.HexToAddress("0x01")
+	l = NewListener(&config.Config{EpochSource: config.EpochSourceEvents}, nil, nil, nil)
+	_, err := runDeposits(t, l, 20, map[common.Address]map[string][]*ethtypes.Log{
+		contract: {"0x5": {depositLog(contract, staker, 10)}},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run() error = %v, want %v", err, context.Canceled)
+	}
+	if len(stakeInfoList) != 1 || !reflect.DeepEqual(stakeInfoList[0].WorkBase, staker[:]) {
+		t.Errorf("stakeInfoList = %+v, want the deposit of %s", stakeInfoList, staker)
+	}
+}
+
+func TestReadStakeInfosLegacyFormat(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "stake-info.json")
+	legacy := map[string][32]byte{
+		common.Bytes2Hex(WorkBase[1]): Coinbase[1],
+		common.Bytes2Hex(WorkBase[0]): Coinbase[0],
+	}
+	data, err := json.Marshal(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filePath, data, 0664); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadStakeInfos(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index := coinbaseIndex(got); !reflect.DeepEqual(index, legacy) {
+		t.Errorf("ReadStakeInfos() = %v, want %v", index, legacy)
+	}
+	for _, info := range got {
+		if !info.IsWork || info.LockedBalance.Sign() != 0 {
+			t.Errorf("ReadStakeInfos() legacy entry = %+v, want working with zero balance", info)
+		}
+	}
+}
+
+func TestReadStakeInfosVersions(t *testing.T) {
+	dir := t.TempDir()
+	coinbase := common.Bytes2Hex(Coinbase[0][:])
+	workBase := common.Bytes2Hex(WorkBase[0])
+	files := map[string]string{
+		// version 1 is a bare array of records without the fields added since
+		"v1.json":     `[{"coinbase": "0x` + coinbase + `", "workBase": "0x` + workBase + `", "isWork": true, "lockedBalance": "5"}]`,
+		"v2.json":     `{"version": 2, "stakeInfos": [{"coinbase": "0x` + coinbase + `", "workBase": "0x` + workBase + `", "isWork": true, "lockedBalance": "5", "workCount": 3, "absentEpochs": 2}]}`,
+		"future.json": `{"version": 3, "stakeInfos": [{"coinbase": "0x` + coinbase + `"}]}`,
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		file       string
+		wantCount  uint32
+		wantAbsent uint64
+		wantErr    error
+	}{
+		{file: "v1.json"},
+		{file: "v2.json", wantCount: 3, wantAbsent: 2},
+		{file: "future.json", wantErr: ErrUnknownStateVersion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			got, absent, err := readStakeInfoFile(filepath.Join(dir, tt.file))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("readStakeInfoFile() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if len(got) != 1 || got[0].Coinbase != Coinbase[0] || !bytes.Equal(got[0].WorkBase, WorkBase[0]) ||
+				got[0].LockedBalance.Int64() != 5 || got[0].WorkCount != tt.wantCount || absent[workBase] != tt.wantAbsent {
+				t.Errorf("readStakeInfoFile() = %+v, %v", got, absent)
+			}
+		})
+	}
+
+	// files are written with the current version and read back unchanged
+	path := filepath.Join(dir, "current.json")
+	infos, absent, _ := readStakeInfoFile(filepath.Join(dir, "v2.json"))
+	if err := writeStakeInfoFile(path, infos, absent, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file stakeInfoFile
+	if err := json.Unmarshal(data, &file); err != nil || file.Version != stakeInfoFileVersion {
+		t.Errorf("writeStakeInfoFile() wrote version %d, %v, want %d", file.Version, err, stakeInfoFileVersion)
+	}
+	got, gotAbsent, err := readStakeInfoFile(path)
+	if err != nil || !reflect.DeepEqual(got, infos) || !reflect.DeepEqual(gotAbsent, absent) {
+		t.Errorf("readStakeInfoFile() = %v, %v, %v, want %v, %v", got, gotAbsent, err, infos, absent)
+	}
+}
+
+func TestStakerFromTopics(t *testing.T) {
+	staker := common.HexToAddress("0xa7f6c9a5052a08a14ff0e3349094b6efbc591ea4")
+	sig := Deposited.GetTopic()
+
+	var leftAligned common.Hash
+	copy(leftAligned[:], staker[:])
+
+	tests := []struct {
+		name    string
+		topics  []common.Hash
+		ts      *config.TopicSlice
+		want    common.Address
+		wantErr bool
+	}{
+		{
+			name:   "right-aligned",
+			topics: []common.Hash{sig, common.BytesToHash(staker[:])},
+			ts:     &config.TopicSlice{Index: 1, Offset: 12, Length: 20},
+			want:   staker,
+		},
+		{
+			name:   "left-aligned",
+			topics: []common.Hash{sig, leftAligned},
+			ts:     &config.TopicSlice{Index: 1, Offset: 0, Length: 20},
+			want:   staker,
+		},
+		{
+			name:   "second-indexed-argument",
+			topics: []common.Hash{sig, {}, common.BytesToHash(staker[:])},
+			ts:     &config.TopicSlice{Index: 2, Offset: 12, Length: 20},
+			want:   staker,
+		},
+		{
+			name:    "missing-topic",
+			topics:  []common.Hash{sig},
+			ts:      &config.TopicSlice{Index: 1, Offset: 12, Length: 20},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := stakerFromTopics(tt.topics, tt.ts, RawAddressDecoder{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("stakerFromTopics() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("stakerFromTopics() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteAndReadStakeInfosCompressed(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "stake-info.json")
+	stakeInfos := substrate.StakeInfos{
+		{Coinbase: Coinbase[1], WorkBase: WorkBase[1], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(2)), WorkCount: 1},
+		{Coinbase: Coinbase[0], WorkBase: WorkBase[0], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(1)), WorkCount: 1},
+	}
+
+	for _, compress := range []bool{true, false, true} {
+		if err := WriteStakeInfos(filePath, stakeInfos, compress); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gzipped := len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b; gzipped != compress {
+			t.Errorf("WriteStakeInfos(compress=%v) wrote gzip data = %v", compress, gzipped)
+		}
+
+		got, err := ReadStakeInfos(filePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, stakeInfos) {
+			t.Errorf("ReadStakeInfos() = %v, want %v", got, stakeInfos)
+		}
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("WriteStakeInfos() left %d files in the state dir, want 1", len(entries))
+	}
+}
+
+func TestListener_getDepositEventsForBlockContracts(t *testing.T) {
+	a, b := common.HexToAddress("0xa1"), common.HexToAddress("0xb2")
+	staker1, staker2 := common.HexToAddress("0x01"), common.HexToAddress("0x02")
+	deposit := func(contract, staker common.Address, value int64) *ethtypes.Log {
+		data := append(common.BigToHash(big.NewInt(value)).Bytes(), common.BigToHash(big.NewInt(1)).Bytes()...)
+		return &ethtypes.Log{Address: contract, Topics: []common.Hash{Deposited.GetTopic(), common.BytesToHash(staker[:])}, Data: data}
+	}
+	logs := map[common.Address][]*ethtypes.Log{
+		a: {deposit(a, staker1, 10), deposit(a, staker2, 5)},
+		b: {deposit(b, staker1, 7)},
+	}
+	queried := make(map[common.Address]string)
+	conn := newTestConnection(t, map[string]rpcHandler{
+		"eth_getLogs": func(params []json.RawMessage) (interface{}, *rpcError) {
+			var q struct {
+				Address   []common.Address `json:"address"`
+				FromBlock string           `json:"fromBlock"`
+			}
+			if err := json.Unmarshal(params[0], &q); err != nil {
+				return nil, &rpcError{Code: -32602, Message: err.Error()}
+			}
+			queried[q.Address[0]] = q.FromBlock
+			return logs[q.Address[0]], nil
+		},
+	})
+
+	cfg := &config.Config{EpochSize: 1000, MaxEventsPerBlock: config.MaxEventsPerBlock, EthereumConfig: config.EthereumConfig{
+		StakerTopic: &config.TopicSlice{Index: 1, Offset: 12, Length: 20},
+		Contracts: []config.ContractConfig{
+			{Address: a.Hex()},
+			{Address: b.Hex(), Confirmations: big.NewInt(5)},
+			// not deployed yet at the queried block
+			{Address: "0xc3", StartBlock: big.NewInt(2000)},
+		},
+	}}
+	l := &Listener{Config: cfg, Ethconn: conn}
+	defer resetStakeInfoList()
+	resetStakeInfoList()
+
+	if err := l.getDepositEventsForBlock(big.NewInt(999)); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[common.Address]string{a: "0x3e7", b: "0x3e2"}; !reflect.DeepEqual(queried, want) {
+		t.Errorf("queried blocks = %v, want %v", queried, want)
+	}
+	if len(stakeInfoList) != 2 {
+		t.Fatalf("stakeInfoList holds %d stakers, want 2", len(stakeInfoList))
+	}
+	for i, want := range []struct {
+		staker common.Address
+		value  int64
+	}{{staker1, 17}, {staker2, 5}} {
+		info := stakeInfoList[i]
+		if !reflect.DeepEqual(info.WorkBase, want.staker[:]) || info.LockedBalance.Int.Int64() != want.value {
+			t.Errorf("stakeInfoList[%d] = %x %v, want %x %d", i, info.WorkBase, info.LockedBalance.Int, want.staker, want.value)
+		}
+	}
+}
+
+func TestListener_getDepositEventsForBlockContractStart(t *testing.T) {
+	a, b := common.HexToAddress("0xa1"), common.HexToAddress("0xb2")
+	queried := make(map[common.Address][]string)
+	conn := newTestConnection(t, map[string]rpcHandler{
+		"eth_getLogs": func(params []json.RawMessage) (interface{}, *rpcError) {
+			var q struct {
+				Address   []common.Address `json:"address"`
+				FromBlock string           `json:"fromBlock"`
+			}
+			if err := json.Unmarshal(params[0], &q); err != nil {
+				return nil, &rpcError{Code: -32602, Message: err.Error()}
+			}
+			queried[q.Address[0]] = append(queried[q.Address[0]], q.FromBlock)
+			return []*ethtypes.Log{}, nil
+		},
+	})
+	cfg := &config.Config{EpochSize: 1000, MaxEventsPerBlock: config.MaxEventsPerBlock, EthereumConfig: config.EthereumConfig{
+		Contracts: []config.ContractConfig{
+			{Address: a.Hex(), StartBlock: big.NewInt(100)},
+			{Address: b.Hex(), StartBlock: big.NewInt(102), Confirmations: big.NewInt(1)},
+		},
+	}}
+	l := &Listener{Config: cfg, Ethconn: conn}
+	defer resetStakeInfoList()
+	resetStakeInfoList()
+
+	start, err := l.resolveStartBlock()
+	if err != nil || start.Int64() != 100 {
+		t.Fatalf("resolveStartBlock() = %v, %v, want 100", start, err)
+	}
+	for block := start.Int64(); block <= 104; block++ {
+		if err := l.getDepositEventsForBlock(big.NewInt(block)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// b is only queried from its start block on, one confirmation behind the polled block
+	want := map[common.Address][]string{a: {"0x64", "0x65", "0x66", "0x67", "0x68"}, b: {"0x66", "0x67"}}
+	if !reflect.DeepEqual(queried, want) {
+		t.Errorf("queried blocks = %v, want %v", queried, want)
+	}
+}
+
+func TestListener_getDepositEventsForBlockOverflow(t *testing.T) {
+	contract := common.HexToAddress("0xa1")
+	staker1, staker2, staker3 := common.HexToAddress("0x01"), common.HexToAddress("0x02"), common.HexToAddress("0x03")
+	deposit := func(staker common.Address, value *big.Int) *ethtypes.Log {
+		data := append(common.BigToHash(value).Bytes(), common.BigToHash(big.NewInt(1)).Bytes()...)
+		return &ethtypes.Log{Address: contract, Topics: []common.Hash{Deposited.GetTopic(), common.BytesToHash(staker[:])}, Data: data}
+	}
+	above := new(big.Int).Lsh(big.NewInt(1), 130)
+	logs := []*ethtypes.Log{
+		deposit(staker1, above),
+		deposit(staker2, big.NewInt(10)),
+		// each deposit fits, their sum doesn't
+		deposit(staker3, maxU128),
+		deposit(staker3, big.NewInt(1)),
+	}
+	tests := []struct {
+		policy string
+		want   map[common.Address]*big.Int
+	}{
+		{policy: config.OverflowClamp, want: map[common.Address]*big.Int{staker1: maxU128, staker2: big.NewInt(10), staker3: maxU128}},
+		{policy: config.OverflowSkip, want: map[common.Address]*big.Int{staker2: big.NewInt(10), staker3: maxU128}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			conn := newTestConnection(t, map[string]rpcHandler{
+				"eth_getLogs": func(params []json.RawMessage) (interface{}, *rpcError) { return logs, nil },
+			})
+			cfg := &config.Config{EpochSize: 1000, MaxEventsPerBlock: config.MaxEventsPerBlock, OverflowPolicy: tt.policy, EthereumConfig: config.EthereumConfig{
+				DepositContractAddr: contract.Hex(),
+				StakerTopic:         &config.TopicSlice{Index: 1, Offset: 12, Length: 20},
+			}}
+			l := &Listener{Config: cfg, Ethconn: conn}
+			defer resetStakeInfoList()
+			resetStakeInfoList()
+
+			if err := l.getDepositEventsForBlock(big.NewInt(999)); err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[common.Address]*big.Int, len(stakeInfoList))
+			for _, info := range stakeInfoList {
+				got[common.BytesToAddress(info.WorkBase)] = info.LockedBalance.Int
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("locked balances = %v, want %v", got, tt.want)
+			}
+			if _, err := types.EncodeToBytes(substrate.StakeInfos(stakeInfoList)); err != nil {
+				t.Errorf("EncodeToBytes() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestListener_getDepositEventsForBlockLimit(t *testing.T) {
+	contract := common.HexToAddress("0xa1")
+	logs := make([]*ethtypes.Log, 5)
+	for i := range logs {
+		staker := common.BigToAddress(big.NewInt(int64(i + 1)))
+		data := append(common.BigToHash(big.NewInt(10)).Bytes(), common.BigToHash(big.NewInt(1)).Bytes()...)
+		logs[i] = &ethtypes.Log{Address: contract, Topics: []common.Hash{Deposited.GetTopic(), common.BytesToHash(staker[:])}, Data: data}
+	}
+	tests := []struct {
+		name      string
+		contracts int
+		limit     int
+		halt      bool
+		wantErr   error
+		wantCount int
+	}{
+		{name: "below limit", contracts: 1, limit: 5, wantCount: 5},
+		{name: "over limit", contracts: 1, limit: 3, wantCount: 3},
+		{name: "over limit across contracts", contracts: 2, limit: 7, wantCount: 5},
+		{name: "halt", contracts: 1, limit: 3, halt: true, wantErr: ErrTooManyEvents, wantCount: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := newTestConnection(t, map[string]rpcHandler{
+				"eth_getLogs": func(params []json.RawMessage) (interface{}, *rpcError) { return logs, nil },
+			})
+			cfg := &config.Config{EpochSize: 1000, MaxEventsPerBlock: tt.limit, HaltOnEventLimit: tt.halt, EthereumConfig: config.EthereumConfig{
+				StakerTopic: &config.TopicSlice{Index: 1, Offset: 12, Length: 20},
+			}}
+			for i := 0; i < tt.contracts; i++ {
+				cfg.EthereumConfig.Contracts = append(cfg.EthereumConfig.Contracts, config.ContractConfig{Address: contract.Hex()})
+			}
+			l := &Listener{Config: cfg, Ethconn: conn}
+			defer resetStakeInfoList()
+			resetStakeInfoList()
+
+			err := l.getDepositEventsForBlock(big.NewInt(999))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("getDepositEventsForBlock() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(stakeInfoList) != tt.wantCount {
+				t.Errorf("stakeInfoList holds %d stakers, want %d", len(stakeInfoList), tt.wantCount)
+			}
+			if l.stats.EventsSeen != uint64(len(logs)*tt.contracts) {
+				t.Errorf("EventsSeen = %d, want %d", l.stats.EventsSeen, len(logs)*tt.contracts)
+			}
+		})
+	}
+}
+
+func TestListener_filterLogsTrace(t *testing.T) {
+	contract := common.HexToAddress("0xa1")
+	conn := newTestConnection(t, map[string]rpcHandler{
+		"eth_getLogs": func(params []json.RawMessage) (interface{}, *rpcError) {
+			return []*ethtypes.Log{{Address: contract, Topics: []common.Hash{Deposited.GetTopic()}}}, nil
+		},
+	})
+	l := &Listener{Config: &config.Config{}, Ethconn: conn}
+	defer log.Root().SetHandler(log.Root().GetHandler())
+
+	for _, lvl := range []log.Lvl{log.LvlDebug, log.LvlTrace} {
+		var records []*log.Record
+		log.Root().SetHandler(log.LvlFilterHandler(lvl, log.LazyHandler(log.FuncHandler(func(r *log.Record) error {
+			records = append(records, r)
+			return nil
+		}))))
+		if _, err := l.filterLogs(buildQuery(contract, Deposited, big.NewInt(999), big.NewInt(999))); err != nil {
+			t.Fatal(err)
+		}
+		if lvl != log.LvlTrace {
+			if len(records) != 0 {
+				t.Errorf("%d records logged at %s, want none", len(records), lvl)
+			}
+			continue
+		}
+		if len(records) != 1 {
+			t.Fatalf("%d records logged at trace, want 1", len(records))
+		}
+		ctx := fmt.Sprintln(records[0].Ctx...)
+		for _, want := range []string{contract.Hex(), Deposited.GetTopic().Hex(), "from=999 to=999", "logs 1"} {
+			if !strings.Contains(ctx, want) {
+				t.Errorf("trace record %q doesn't hold %q", ctx, want)
+			}
+		}
+	}
+}
+
+func TestListener_filterLogsSpan(t *testing.T) {
+	contract := common.HexToAddress("0xa1")
+	var ranges [][2]uint64
+	var failure string
+	conn := newTestConnection(t, map[string]rpcHandler{
+		"eth_getLogs": func(params []json.RawMessage) (interface{}, *rpcError) {
+			if failure != "" {
+				ranges = append(ranges, [2]uint64{})
+				return nil, &rpcError{Code: -32000, Message: failure}
+			}
+			var arg struct {
+				FromBlock hexutil.Uint64 `json:"fromBlock"`
+				ToBlock   hexutil.Uint64 `json:"toBlock"`
+			}
+			if err := json.Unmarshal(params[0], &arg); err != nil {
+				return nil, &rpcError{Code: -32602, Message: err.Error()}
+			}
+			from, to := uint64(arg.FromBlock), uint64(arg.ToBlock)
+			ranges = append(ranges, [2]uint64{from, to})
+			// the blocks from 200 hold too many events for a query over more than 50 of them
+			if from >= 200 && from < 300 && to-from >= 50 {
+				return nil, &rpcError{Code: -32005, Message: "query returned more than 10000 results"}
+			}
+			return []*ethtypes.Log{{Address: contract, Topics: []common.Hash{Deposited.GetTopic()}, BlockNumber: from}}, nil
+		},
+	})
+	l := &Listener{Config: &config.Config{EthereumConfig: config.EthereumConfig{MaxLogQuerySpan: 100}}, Ethconn: conn}
+
+	logs, err := l.filterLogs(buildQuery(contract, Deposited, big.NewInt(100), big.NewInt(349)))
+	if err != nil {
+		t.Fatalf("filterLogs() error = %v", err)
+	}
+	want := [][2]uint64{{100, 199}, {200, 299}, {200, 249}, {250, 299}, {300, 349}}
+	if !reflect.DeepEqual(ranges, want) {
+		t.Errorf("filterLogs() queried %v, want %v", ranges, want)
+	}
+	var blocks []uint64
+	for _, lg := range logs {
+		blocks = append(blocks, lg.BlockNumber)
+	}
+	if !reflect.DeepEqual(blocks, []uint64{100, 200, 250, 300}) {
+		t.Errorf("filterLogs() returned the logs of blocks %v, want 100, 200, 250 and 300", blocks)
+	}
+
+	// other errors are returned as is
+	l.Config.EthereumConfig.MaxLogQuerySpan = 0
+	ranges, failure = nil, "header not found"
+	if _, err := l.filterLogs(buildQuery(contract, Deposited, big.NewInt(100), big.NewInt(349))); err == nil || len(ranges) != 1 {
+		t.Errorf("filterLogs() = %v after %d queries, want the error of a single query", err, len(ranges))
+	}
+}
+
+func TestListener_getDepositEventsForBlockFilter(t *testing.T) {
+	contract := common.HexToAddress("0xa1")
+	logs := make([]*ethtypes.Log, 3)
+	for i := range logs {
+		staker := common.BigToAddress(big.NewInt(int64(i + 1)))
+		data := append(common.BigToHash(big.NewInt(10)).Bytes(), common.BigToHash(big.NewInt(1)).Bytes()...)
+		logs[i] = &ethtypes.Log{Address: contract, Topics: []common.Hash{Deposited.GetTopic(), common.BytesToHash(staker[:])}, Data: data}
+	}
+	conn := newTestConnection(t, map[string]rpcHandler{
+		"eth_getLogs": func(params []json.RawMessage) (interface{}, *rpcError) { return logs, nil },
+	})
+	cfg := &config.Config{EpochSize: 1000, MaxEventsPerBlock: config.MaxEventsPerBlock, EthereumConfig: config.EthereumConfig{
+		DepositContractAddr: contract.Hex(),
+		StakerTopic:         &config.TopicSlice{Index: 1, Offset: 12, Length: 20},
+	}}
+	// staker 3 isn't allowed and staker 2 is blocked although allowed
+	cfg.StakerFilter = config.StakerFilterConfig{
+		Allowlist: []string{"0x0000000000000000000000000000000000000001", "0x0000000000000000000000000000000000000002"},
+		Blocklist: []string{"0x0000000000000000000000000000000000000002"},
+	}
+	l := &Listener{Config: cfg, Ethconn: conn}
+	defer resetStakeInfoList()
+	resetStakeInfoList()
+
+	if err := l.getDepositEventsForBlock(big.NewInt(999)); err != nil {
+		t.Fatal(err)
+	}
+	if len(stakeInfoList) != 1 || !bytes.Equal(stakeInfoList[0].WorkBase, common.BigToAddress(big.NewInt(1)).Bytes()) {
+		t.Errorf("stakeInfoList = %+v, want staker 1 only", stakeInfoList)
+	}
+}
+
+func TestAddDeposit(t *testing.T) {
+	a, b := common.HexToAddress("0xa1"), common.HexToAddress("0xb2")
+	staker1, staker2 := common.HexToAddress("0x01"), common.HexToAddress("0x02")
+	deposits := []struct {
+		contract, staker common.Address
+		value            int64
+	}{
+		{a, staker1, 4}, {a, staker1, 6}, {b, staker1, 7}, {b, staker2, 5},
+	}
+	type want struct {
+		staker common.Address
+		value  int64
+	}
+	tests := []struct {
+		policy string
+		want   []want
+	}{
+		{policy: config.AggregateSum, want: []want{{staker1, 17}, {staker2, 5}}},
+		{policy: config.AggregateMax, want: []want{{staker1, 10}, {staker2, 5}}},
+		{policy: config.AggregateSeparate, want: []want{{staker1, 10}, {staker1, 7}, {staker2, 5}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			defer resetStakeInfoList()
+			resetStakeInfoList()
+			for _, d := range deposits {
+				addDeposit(tt.policy, d.contract, d.staker, big.NewInt(d.value))
+			}
+			if len(stakeInfoList) != len(tt.want) {
+				t.Fatalf("stakeInfoList holds %d records, want %d", len(stakeInfoList), len(tt.want))
+			}
+			for i, w := range tt.want {
+				info := stakeInfoList[i]
+				if !reflect.DeepEqual(info.WorkBase, w.staker[:]) || info.LockedBalance.Int.Int64() != w.value {
+					t.Errorf("stakeInfoList[%d] = %x %v, want %x %d", i, info.WorkBase, info.LockedBalance.Int, w.staker, w.value)
+				}
+			}
+		})
+	}
+}
+
+func TestReadStakeInfos(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.json")
+	infos := substrate.StakeInfos{{Coinbase: Coinbase[0], WorkBase: WorkBase[0], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(1))}}
+	if err := WriteStakeInfos(valid, infos, false); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"empty.json":    {},
+		"corrupt.json":  []byte(`[{"coinbase": `),
+		"corrupt.gz":    {0x1f, 0x8b, 0x08, 0x00},
+		"trailing.json": []byte(`[]{}`),
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		file    string
+		want    int
+		wantErr bool
+	}{
+		{name: "missing", file: "missing.json"},
+		{name: "empty", file: "empty.json"},
+		{name: "corrupt", file: "corrupt.json", wantErr: true},
+		{name: "corrupt compressed", file: "corrupt.gz", wantErr: true},
+		{name: "trailing data", file: "trailing.json", wantErr: true},
+		{name: "valid", file: "valid.json", want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadStakeInfos(filepath.Join(dir, tt.file))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadStakeInfos() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got == nil || len(got) != tt.want {
+				t.Errorf("ReadStakeInfos() = %v, want %d stake infos", got, tt.want)
+			}
+		})
+	}
+}
+
+// openFiles counts the open file descriptors of the test process, false where /proc isn't available
+func openFiles(t *testing.T) (int, bool) {
+	t.Helper()
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0, false
+	}
+	return len(fds), true
+}
+
+func TestReadStakeInfosLarge(t *testing.T) {
+	dir := t.TempDir()
+	infos := make(substrate.StakeInfos, 10000)
+	for i := range infos {
+		staker := common.BigToAddress(big.NewInt(int64(i + 1)))
+		infos[i] = &substrate.StakeInfo{Coinbase: substrate.EthAddrToAccountID(staker), WorkBase: staker[:], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(int64(i)))}
+	}
+	corrupt := filepath.Join(dir, "corrupt.json")
+	for _, compress := range []bool{false, true} {
+		path := filepath.Join(dir, fmt.Sprintf("stake-info-%v.json", compress))
+		if err := WriteStakeInfos(path, infos, compress); err != nil {
+			t.Fatal(err)
+		}
+		// a file cut off halfway fails to decode, its handle is closed as well
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(corrupt, data[:len(data)/2], 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		before, ok := openFiles(t)
+		for i := 0; i < 5; i++ {
+			got, err := ReadStakeInfos(path)
+			if err != nil || len(got) != len(infos) {
+				t.Fatalf("ReadStakeInfos(compress=%v) = %d stake infos, %v, want %d", compress, len(got), err, len(infos))
+			}
+			if _, err := ReadStakeInfos(corrupt); err == nil {
+				t.Fatalf("ReadStakeInfos() of a truncated file succeeded")
+			}
+		}
+		if after, _ := openFiles(t); ok && after > before {
+			t.Errorf("reading the stake info files leaked %d file descriptors", after-before)
+		}
+		got, _ := ReadStakeInfos(path)
+		if !reflect.DeepEqual(got[len(got)-1], infos[len(infos)-1]) {
+			t.Errorf("ReadStakeInfos() last = %v, want %v", got[len(got)-1], infos[len(infos)-1])
+		}
+	}
+}
+
+func TestListener_getDepositEventsForBlockEpochTrigger(t *testing.T) {
+	contract := common.HexToAddress("0xa1")
+	staker := common.HexToAddress("0x01")
+	data := append(common.BigToHash(big.NewInt(10)).Bytes(), common.BigToHash(big.NewInt(1)).Bytes()...)
+	logs := []*ethtypes.Log{{Address: contract, Topics: []common.Hash{Deposited.GetTopic(), common.BytesToHash(staker[:])}, Data: data}}
+	var queried []string
+	conn := newTestConnection(t, map[string]rpcHandler{
+		"eth_getLogs": func(params []json.RawMessage) (interface{}, *rpcError) {
+			var q struct {
+				FromBlock string `json:"fromBlock"`
+				ToBlock   string `json:"toBlock"`
+			}
+			if err := json.Unmarshal(params[0], &q); err != nil {
+				return nil, &rpcError{Code: -32602, Message: err.Error()}
+			}
+			queried = append(queried, q.FromBlock+"-"+q.ToBlock)
+			return logs, nil
+		},
+	})
+	sub := &substrate.MockSubmitter{}
+	cfg := &config.Config{EpochSize: 1000, EpochOffset: 5, EpochSource: config.EpochSourceEvents, MaxEventsPerBlock: config.MaxEventsPerBlock, EthereumConfig: config.EthereumConfig{
+		StakerTopic: &config.TopicSlice{Index: 1, Offset: 12, Length: 20},
+		Contracts:   []config.ContractConfig{{Address: contract.Hex(), Confirmations: big.NewInt(3)}},
+	}}
+	l := &Listener{Config: cfg, Ethconn: conn, Subconn: sub}
+	defer resetStakeInfoList()
+	resetStakeInfoList()
+
+	tests := []struct {
+		polled      int64
+		wantQuery   string
+		wantFlushed bool
+	}{
+		// the query range is the single block the contract's confirmations below the polled block
+		{polled: 1004, wantQuery: "0x3e9-0x3e9"},
+		// the epoch boundary is that of the polled block, not of the queried block 1002
+		{polled: 1005, wantQuery: "0x3ea-0x3ea", wantFlushed: true},
+		{polled: 1008, wantQuery: "0x3ed-0x3ed"},
+	}
+	for _, tt := range tests {
+		queried = nil
+		calls := len(sub.Calls())
+		if err := l.getDepositEventsForBlock(big.NewInt(tt.polled)); err != nil {
+			t.Fatal(err)
+		}
+		if len(queried) != 1 || queried[0] != tt.wantQuery {
+			t.Errorf("polled %d queried %v, want %s", tt.polled, queried, tt.wantQuery)
+		}
+		if flushed := len(sub.Calls()) > calls; flushed != tt.wantFlushed {
+			t.Errorf("polled %d flushed = %v, want %v", tt.polled, flushed, tt.wantFlushed)
+		}
+	}
+	if calls := sub.Calls(); len(calls) != 1 {
+		t.Fatalf("submitted %d times, want 1", len(calls))
+	} else if infos, ok := calls[0].Args[0].(substrate.StakeInfos); !ok || len(infos) != 1 || infos[0].LockedBalance.Int.Int64() != 20 {
+		t.Errorf("submitted %v, want the deposits of the polled blocks 1004 and 1005", calls[0].Args)
+	}
+	if len(stakeInfoList) != 1 || stakeInfoList[0].LockedBalance.Int.Int64() != 10 {
+		t.Errorf("stakeInfoList after the flush = %+v, want the deposit of polled block 1008", stakeInfoList)
+	}
 }
@@ -0,0 +1,169 @@
+package ethereum
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+// lockState is the persisted form of the locks tracked from the periods of the deposit events
+type lockState struct {
+	Mode string `json:"mode"`
+	// Epoch is the epoch the countdowns were aged to
+	Epoch uint64 `json:"epoch"`
+	// Locks holds per hex staker address the epochs its lock has left with LockPeriodsCountdown, the epoch
+	// it expires in with LockPeriodsExpiry
+	Locks map[string]uint64 `json:"locks"`
+}
+
+// lockStatePath is where the locks are persisted, next to the stake info file. An empty stake info path
+// keeps them in memory only.
+func (l *Listener) lockStatePath() string {
+	if l.LastStakeInfoPath == "" {
+		return ""
+	}
+	return l.LastStakeInfoPath + ".locks"
+}
+
+// tracksLocks reports whether the periods of the deposit events are tracked as lock expiries
+func (l *Listener) tracksLocks() bool {
+	mode := l.Config.LockPeriods
+	return mode == config.LockPeriodsCountdown || mode == config.LockPeriodsExpiry
+}
+
+// loadLocks reads the locks persisted by an earlier run on first use. A file written with another
+// LockPeriods mode is ignored, its numbers mean something else.
+func (l *Listener) loadLocks() {
+	if l.locks != nil {
+		return
+	}
+	l.locks = &lockState{Mode: l.Config.LockPeriods, Locks: make(map[string]uint64)}
+	path := l.lockStatePath()
+	if path == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warn("Failed to read the staker locks", "path", path, "error", err)
+		}
+		return
+	}
+	var state lockState
+	if err := json.Unmarshal(data, &state); err != nil {
+		log.Warn("Ignore invalid staker locks", "path", path, "error", err)
+		return
+	}
+	if state.Mode != l.Config.LockPeriods {
+		log.Warn("Ignore staker locks of another lockPeriods mode", "path", path, "mode", state.Mode, "lockPeriods", l.Config.LockPeriods)
+		return
+	}
+	if state.Locks == nil {
+		state.Locks = make(map[string]uint64)
+	}
+	l.locks = &state
+	log.Info("Restored staker locks", "count", len(state.Locks), "epoch", state.Epoch)
+}
+
+// saveLocks persists the locks once they changed
+func (l *Listener) saveLocks() {
+	if !l.locksDirty {
+		return
+	}
+	l.locksDirty = false
+	path := l.lockStatePath()
+	if path == "" {
+		return
+	}
+	data, err := json.Marshal(l.locks)
+	if err != nil {
+		log.Warn("Failed to encode the staker locks", "error", err)
+		return
+	}
+	if err := writeFileAtomic(path, data, 0664); err != nil {
+		log.Warn("Failed to write the staker locks", "path", path, "error", err)
+	}
+}
+
+// ageLocks counts the countdowns down by the epochs elapsed until epoch. Expiry epochs don't age.
+func (l *Listener) ageLocks(epoch uint64) {
+	state := l.locks
+	if epoch <= state.Epoch {
+		return
+	}
+	if state.Mode == config.LockPeriodsCountdown {
+		elapsed := epoch - state.Epoch
+		for staker, left := range state.Locks {
+			if left > elapsed {
+				state.Locks[staker] = left - elapsed
+			} else {
+				state.Locks[staker] = 0
+			}
+		}
+	}
+	state.Epoch = epoch
+	l.locksDirty = true
+}
+
+// recordLock tracks the lock of a deposit of staker polled at block. A staker depositing several times is
+// locked until the latest of its expiries.
+func (l *Listener) recordLock(staker ethcommon.Address, periods *big.Int, block *big.Int) {
+	if !l.tracksLocks() {
+		return
+	}
+	l.loadLocks()
+	l.ageLocks(l.Config.Epoch(block.Uint64()))
+	if !periods.IsUint64() {
+		log.Warn("lock periods out of range, keeping the lock of the staker", "staker", staker, "periods", periods)
+		return
+	}
+	key := staker.Hex()
+	if cur, ok := l.locks.Locks[key]; !ok || periods.Uint64() > cur {
+		l.locks.Locks[key] = periods.Uint64()
+		l.locksDirty = true
+	}
+}
+
+// expired reports whether the lock of staker is over in the epoch the locks were aged to. A staker without
+// a tracked lock never expires.
+func (l *Listener) expired(staker string) bool {
+	lock, ok := l.locks.Locks[staker]
+	if !ok {
+		return false
+	}
+	if l.locks.Mode == config.LockPeriodsExpiry {
+		return l.locks.Epoch >= lock
+	}
+	return lock == 0
+}
+
+// dropExpired removes the stakers whose lock is over in the epoch of block from infos, matched by their
+// work base. The last set no longer holding them, they are reported stopped like any staker that left. The
+// aged locks are persisted by the caller once the epoch is through.
+func (l *Listener) dropExpired(infos substrate.StakeInfos, block *big.Int) substrate.StakeInfos {
+	if !l.tracksLocks() {
+		return infos
+	}
+	l.loadLocks()
+	l.ageLocks(l.Config.Epoch(block.Uint64()))
+	if len(l.locks.Locks) == 0 {
+		return infos
+	}
+	kept := make(substrate.StakeInfos, 0, len(infos))
+	for _, info := range infos {
+		staker := ethcommon.BytesToAddress(info.WorkBase).Hex()
+		if l.expired(staker) {
+			log.Info("staker lock expired, reporting it stopped", "staker", staker, "epoch", l.locks.Epoch, "mode", l.locks.Mode)
+			continue
+		}
+		kept = append(kept, info)
+	}
+	return kept
+}
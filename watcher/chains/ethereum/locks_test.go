@@ -0,0 +1,110 @@
+package ethereum
+
+import (
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+func TestListener_dropExpired(t *testing.T) {
+	info := func(i int) *substrate.StakeInfo {
+		return &substrate.StakeInfo{Coinbase: Coinbase[i], WorkBase: WorkBase[i], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(10))}
+	}
+	a, b := ethcommon.BytesToAddress(WorkBase[0]), ethcommon.BytesToAddress(WorkBase[1])
+	tests := []struct {
+		name string
+		mode string
+		// the periods of the deposit of a at block 1500, epoch 1
+		periods int64
+	}{
+		// locked for epochs 1 and 2
+		{name: "countdown", mode: config.LockPeriodsCountdown, periods: 2},
+		// expires at the start of epoch 3
+		{name: "expiry", mode: config.LockPeriodsExpiry, periods: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "stake-info.json")
+			newListener := func() *Listener {
+				return &Listener{Config: &config.Config{EpochSize: 1000, LockPeriods: tt.mode}, LastStakeInfoPath: path}
+			}
+			infos := substrate.StakeInfos{info(0), info(1)}
+
+			l := newListener()
+			l.recordLock(a, big.NewInt(tt.periods), big.NewInt(1500))
+			l.saveLocks()
+			if got := l.dropExpired(infos, big.NewInt(2000)); len(got) != 2 {
+				t.Fatalf("dropExpired() in epoch 2 kept %d stakers, want 2", len(got))
+			}
+			l.saveLocks()
+
+			// the locks aged to epoch 2 are resumed by the next run, a staker without a lock never expires
+			l = newListener()
+			got := l.dropExpired(infos, big.NewInt(3000))
+			if len(got) != 1 || ethcommon.BytesToAddress(got[0].WorkBase) != b {
+				t.Fatalf("dropExpired() in epoch 3 = %v, want only the staker without a lock", got)
+			}
+
+			// a new deposit locks a again
+			l.recordLock(a, big.NewInt(tt.periods+2), big.NewInt(3000))
+			if got := l.dropExpired(infos, big.NewInt(4000)); len(got) != 2 {
+				t.Errorf("dropExpired() after a new deposit kept %d stakers, want 2", len(got))
+			}
+
+			// the locks of the other mode mean something else and are ignored
+			other := config.LockPeriodsExpiry
+			if tt.mode == other {
+				other = config.LockPeriodsCountdown
+			}
+			l = &Listener{Config: &config.Config{EpochSize: 1000, LockPeriods: other}, LastStakeInfoPath: path}
+			if l.loadLocks(); len(l.locks.Locks) != 0 {
+				t.Errorf("loaded %d locks of the %s mode, want none", len(l.locks.Locks), tt.mode)
+			}
+		})
+	}
+}
+
+// A staker of the last set whose lock expired is left out of the top stakers and submitted stopped
+func TestListener_syncEpochExpiredLock(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = false
+
+	a, b := ethcommon.BytesToAddress(WorkBase[0]), ethcommon.BytesToAddress(WorkBase[1])
+	var tags []string
+	conn := newTestConnection(t, map[string]rpcHandler{
+		"eth_call": stakingContract(t, map[string]map[ethcommon.Address]int64{"latest": {a: 10, b: 20}}, &tags),
+	})
+	sub := &substrate.MockSubmitter{}
+	l := &Listener{
+		Config: &config.Config{EpochSize: 1000, SubmitMode: config.SubmitModeDiff, FullResyncEpochs: 10, LockPeriods: config.LockPeriodsCountdown,
+			EthereumConfig: config.EthereumConfig{BlockConfirmations: big.NewInt(0)}},
+		Ethconn:           conn,
+		Subconn:           sub,
+		LastStakeInfoPath: filepath.Join(t.TempDir(), "stake-info.json"),
+	}
+	l.recordLock(b, big.NewInt(1), big.NewInt(1000))
+
+	for _, block := range []int64{1000, 2000} {
+		if err := l.syncStakeInfos(big.NewInt(block)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	calls := sub.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("submitted %d times, want 2", len(calls))
+	}
+	payload, _ := calls[1].Args[0].(substrate.StakeInfos)
+	if len(payload) != 1 || ethcommon.BytesToAddress(payload[0].WorkBase) != b || payload[0].IsWork {
+		t.Errorf("epoch 2 submitted %v, want b stopped", payload)
+	}
+	last, err := ReadStakeInfos(l.LastStakeInfoPath)
+	if err != nil || len(last) != 1 || ethcommon.BytesToAddress(last[0].WorkBase) != a {
+		t.Errorf("persisted %v, %v, want a only", last, err)
+	}
+}
@@ -0,0 +1,84 @@
+package ethereum
+
+import (
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// pendingSet is a computed stake info update: top is persisted as the last stake infos and submit is the set
+// submitted for block
+type pendingSet struct {
+	block  *big.Int
+	top    substrate.StakeInfos
+	absent map[string]uint64
+	submit substrate.StakeInfos
+	epochs []uint64 // the epochs a batched set covers, with SubmitEveryNEpochs
+}
+
+// SetMaintenance pauses or resumes the submissions. In maintenance the listener keeps scanning and computing
+// the stake infos of every epoch but holds the latest update back, it is submitted once maintenance ends.
+// It is safe to call while the listener runs.
+func (l *Listener) SetMaintenance(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	if atomic.SwapInt32(&l.maintenance, v) == v {
+		return
+	}
+	if on {
+		log.Warn("Entering maintenance mode, submissions are paused")
+	} else {
+		log.Warn("Leaving maintenance mode, submissions are resumed")
+	}
+}
+
+// InMaintenance reports whether submissions are paused
+func (l *Listener) InMaintenance() bool {
+	return atomic.LoadInt32(&l.maintenance) == 1
+}
+
+// submissionsPaused reports whether submissions are held back, in maintenance, while the NuLink chain
+// signals a halt, once the deposit contract is lost or a safety guard tripped, or while a replica stands
+// by for its primary
+func (l *Listener) submissionsPaused() bool {
+	if l.InMaintenance() || l.contractLost || l.unsafe != "" || l.standby() {
+		return true
+	}
+	h, ok := l.Subconn.(substrate.Halter)
+	return ok && h.Halted()
+}
+
+// boundaryFinalized reports whether the update computed at block may be submitted, always unless
+// SubmitOnFinalizedEpoch holds it until the node reports block finalized. A failed check holds it too, the
+// next poll tries again.
+func (l *Listener) boundaryFinalized(block *big.Int) bool {
+	if !l.Config.SubmitOnFinalizedEpoch {
+		return true
+	}
+	ok, err := l.Ethconn.IsFinalized(block)
+	if err != nil {
+		log.Warn("Unable to get finalized block, holding the stake info update", "block", block, "err", err)
+		return false
+	}
+	if !ok {
+		log.Info("epoch boundary not finalized yet, holding the stake info update", "block", block)
+	}
+	return ok
+}
+
+// flushPending submits the update held back while submissions were paused or its boundary wasn't finalized. The
+// epoch boundary it was computed for has passed, so no submission deadline applies.
+func (l *Listener) flushPending() error {
+	set := l.pending
+	log.Info("submitting the held stake info update", "block", set.block, "count", len(set.submit))
+	if err := l.submitSet(set, time.Time{}); err != nil {
+		return err
+	}
+	l.pending = nil
+	return nil
+}
@@ -0,0 +1,159 @@
+package ethereum
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+func TestListener_maintenance(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = false
+
+	path := filepath.Join(t.TempDir(), "stake-info.json")
+	sub := &substrate.MockSubmitter{}
+	l := &Listener{
+		Config:            &config.Config{EpochSize: 1000, SubmitMode: config.SubmitModeFull},
+		Ethconn:           newTestConnection(t, nil),
+		Source:            emptySource{},
+		Subconn:           sub,
+		LastStakeInfoPath: path,
+	}
+	l.SetMaintenance(true)
+
+	tests := []struct {
+		block        int64
+		maintenance  bool
+		wantCalls    int
+		wantPending  int64
+		wantPersists bool
+	}{
+		{block: 1000, maintenance: true, wantPending: 1000},
+		// no empty heartbeat updates either
+		{block: 1010, maintenance: true, wantPending: 1000},
+		// a later epoch replaces the held update
+		{block: 2000, maintenance: true, wantPending: 2000},
+		// the held update is flushed by the first block after maintenance
+		{block: 2001, wantCalls: 1, wantPersists: true},
+		{block: 2002, wantCalls: 1, wantPersists: true},
+	}
+	for _, tt := range tests {
+		l.SetMaintenance(tt.maintenance)
+		if err := l.syncStakeInfos(big.NewInt(tt.block)); err != nil {
+			t.Fatalf("syncStakeInfos(%d) error = %v", tt.block, err)
+		}
+		if calls := len(sub.Calls()); calls != tt.wantCalls {
+			t.Errorf("block %d: submitted %d times, want %d", tt.block, calls, tt.wantCalls)
+		}
+		var pending int64
+		if l.pending != nil {
+			pending = l.pending.block.Int64()
+		}
+		if pending != tt.wantPending {
+			t.Errorf("block %d: pending update of block %d, want %d", tt.block, pending, tt.wantPending)
+		}
+		if _, err := os.Stat(path); os.IsNotExist(err) == tt.wantPersists {
+			t.Errorf("block %d: stake info file written = %v, want %v", tt.block, !os.IsNotExist(err), tt.wantPersists)
+		}
+	}
+	if l.stats.Submissions != 1 || l.lastSubmitted == nil {
+		t.Errorf("Submissions = %d, lastSubmitted = %v, want the held update submitted once", l.stats.Submissions, l.lastSubmitted)
+	}
+}
+
+func TestListener_halted(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = false
+
+	sub := &substrate.MockSubmitter{}
+	l := &Listener{
+		Config:  &config.Config{EpochSize: 1000, SubmitMode: config.SubmitModeFull},
+		Ethconn: newTestConnection(t, nil),
+		Source:  emptySource{},
+		Subconn: sub,
+	}
+	sub.SetHalted(true)
+	for _, block := range []int64{1000, 1010} {
+		if err := l.syncStakeInfos(big.NewInt(block)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls := len(sub.Calls()); calls != 0 || l.pending == nil {
+		t.Fatalf("submitted %d times while halted, want the update held", calls)
+	}
+
+	// the update is submitted automatically once the halt is cleared
+	sub.SetHalted(false)
+	if err := l.syncStakeInfos(big.NewInt(1011)); err != nil {
+		t.Fatal(err)
+	}
+	if calls := len(sub.Calls()); calls != 1 || l.pending != nil {
+		t.Errorf("submitted %d times after the halt, want the held update once", calls)
+	}
+}
+
+func TestListener_submitOnFinalizedEpoch(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = false
+
+	type step struct {
+		block       int64
+		finalized   int64
+		wantCalls   int
+		wantPending bool
+	}
+	tests := []struct {
+		name        string
+		onFinalized bool
+		unsupported bool
+		steps       []step
+	}{
+		{name: "live tip", steps: []step{
+			{block: 1000, finalized: 900, wantCalls: 1},
+			{block: 1001, finalized: 900, wantCalls: 1},
+		}},
+		{name: "finalized epoch", onFinalized: true, steps: []step{
+			{block: 1000, finalized: 900, wantPending: true},
+			{block: 1001, finalized: 999, wantPending: true},
+			// submitted by the first poll that sees the boundary finalized
+			{block: 1002, finalized: 1000, wantCalls: 1},
+			{block: 1003, finalized: 1002, wantCalls: 1},
+		}},
+		{name: "finalized tag unsupported", onFinalized: true, unsupported: true, steps: []step{
+			{block: 1000, wantCalls: 1},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			finalized := new(int64)
+			if tt.unsupported {
+				finalized = nil
+			}
+			sub := &substrate.MockSubmitter{}
+			l := &Listener{
+				Config:  &config.Config{EpochSize: 1000, SubmitMode: config.SubmitModeFull, SubmitOnFinalizedEpoch: tt.onFinalized},
+				Ethconn: newTestConnection(t, map[string]rpcHandler{"eth_getBlockByNumber": blockByNumber(1100, finalized)}),
+				Source:  emptySource{},
+				Subconn: sub,
+			}
+			for _, s := range tt.steps {
+				if finalized != nil {
+					*finalized = s.finalized
+				}
+				if err := l.syncStakeInfos(big.NewInt(s.block)); err != nil {
+					t.Fatalf("syncStakeInfos(%d) error = %v", s.block, err)
+				}
+				if calls := len(sub.Calls()); calls != s.wantCalls {
+					t.Errorf("block %d: submitted %d times, want %d", s.block, calls, s.wantCalls)
+				}
+				if pending := l.pending != nil; pending != s.wantPending {
+					t.Errorf("block %d: update held = %v, want %v", s.block, pending, s.wantPending)
+				}
+			}
+		})
+	}
+}
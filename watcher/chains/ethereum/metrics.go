@@ -0,0 +1,96 @@
+package ethereum
+
+import (
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// MetricsSnapshot is written to the metrics file for monitoring without a metrics scraper
+type MetricsSnapshot struct {
+	Time                time.Time  `json:"time"`
+	LastBlock           *big.Int   `json:"lastBlock"`
+	SafeHead            *big.Int   `json:"safeHead"`
+	BlockLag            *big.Int   `json:"blockLag"`
+	RetryBudget         int        `json:"retryBudget"`
+	BlocksProcessed     uint64     `json:"blocksProcessed"`
+	EventsSeen          uint64     `json:"eventsSeen"`
+	Submissions         uint64     `json:"submissions"`
+	SubmitErrors        uint64     `json:"submitErrors"`
+	FetchErrors         uint64     `json:"fetchErrors"`
+	Regressions         uint64     `json:"regressions"`
+	Reconnects          uint64     `json:"reconnects"`
+	LateSubmissions     uint64     `json:"lateSubmissions"`
+	Heartbeats          uint64     `json:"heartbeats"`
+	VerifyMismatches    uint64     `json:"verifyMismatches"`
+	VerifyErrors        uint64     `json:"verifyErrors"`
+	LastSubmissionEpoch uint64     `json:"lastSubmissionEpoch"`
+	LastSubmissionTime  *time.Time `json:"lastSubmissionTime,omitempty"`
+	StakerCacheHits     uint64     `json:"stakerCacheHits"`
+	StakerCacheMisses   uint64     `json:"stakerCacheMisses"`
+	ContractLost        bool       `json:"contractLost"`
+	Unsafe              string     `json:"unsafe,omitempty"`
+	OperatorBalance     *big.Int   `json:"operatorBalance,omitempty"`
+	OperatorBalanceLow  bool       `json:"operatorBalanceLow"`
+	ChurnHeld           bool       `json:"churnHeld"`
+}
+
+func (l *Listener) metricsSnapshot(retry int) MetricsSnapshot {
+	s := MetricsSnapshot{
+		Time:                l.clock().Now().UTC(),
+		LastBlock:           l.stats.LastBlock,
+		SafeHead:            l.stats.SafeHead,
+		RetryBudget:         retry,
+		BlocksProcessed:     l.stats.BlocksProcessed,
+		EventsSeen:          l.stats.EventsSeen,
+		Submissions:         l.stats.Submissions,
+		SubmitErrors:        l.stats.SubmitErrors,
+		FetchErrors:         l.stats.FetchErrors,
+		Regressions:         l.stats.Regressions,
+		Reconnects:          l.stats.Reconnects,
+		LateSubmissions:     l.stats.LateSubmissions,
+		Heartbeats:          l.stats.Heartbeats,
+		VerifyMismatches:    l.stats.VerifyMismatches,
+		VerifyErrors:        l.stats.VerifyErrors,
+		LastSubmissionEpoch: l.stats.LastSubmissionEpoch,
+		ContractLost:        l.contractLost,
+		Unsafe:              l.unsafe,
+		OperatorBalance:     l.operatorBalance,
+		OperatorBalanceLow:  l.balanceLow,
+		ChurnHeld:           l.churnHeld != nil,
+	}
+	if !l.stats.LastSubmissionTime.IsZero() {
+		t := l.stats.LastSubmissionTime
+		s.LastSubmissionTime = &t
+	}
+	if l.Config != nil && l.Config.StakerCache.Size > 0 {
+		s.StakerCacheHits, s.StakerCacheMisses, _ = l.stakerCache().counts()
+	}
+	if s.LastBlock != nil && s.SafeHead != nil {
+		s.BlockLag = new(big.Int).Sub(s.SafeHead, s.LastBlock)
+	}
+	return s
+}
+
+// writeMetrics atomically replaces the metrics file with a snapshot, it does nothing without MetricsPath
+func (l *Listener) writeMetrics(retry int) {
+	if l.MetricsPath == "" {
+		return
+	}
+	data, err := json.Marshal(l.metricsSnapshot(retry))
+	if err != nil {
+		log.Warn("failed to encode metrics", "error", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(l.MetricsPath), os.ModePerm); err != nil {
+		log.Warn("failed to write metrics", "path", l.MetricsPath, "error", err)
+		return
+	}
+	if err := writeFileAtomic(l.MetricsPath, data, 0644); err != nil {
+		log.Warn("failed to write metrics", "path", l.MetricsPath, "error", err)
+	}
+}
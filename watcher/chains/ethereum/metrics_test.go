@@ -0,0 +1,73 @@
+package ethereum
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NuLink-network/watcher/watcher/config"
+	"github.com/NuLink-network/watcher/watcher/params"
+)
+
+func TestListener_metricsSnapshot(t *testing.T) {
+	now := time.Now().UTC()
+	l := &Listener{stats: RunStats{
+		LastBlock:           big.NewInt(90),
+		SafeHead:            big.NewInt(100),
+		Submissions:         3,
+		SubmitErrors:        1,
+		LastSubmissionEpoch: 7,
+		LastSubmissionTime:  now,
+	}}
+	s := l.metricsSnapshot(4)
+	if s.BlockLag.Int64() != 10 || s.RetryBudget != 4 || s.SubmitErrors != 1 || s.LastSubmissionEpoch != 7 {
+		t.Errorf("metricsSnapshot() = %+v", s)
+	}
+	if s.LastSubmissionTime == nil || !s.LastSubmissionTime.Equal(now) {
+		t.Errorf("metricsSnapshot() LastSubmissionTime = %v, want %v", s.LastSubmissionTime, now)
+	}
+
+	if s := (&Listener{}).metricsSnapshot(0); s.BlockLag != nil || s.LastSubmissionTime != nil {
+		t.Errorf("metricsSnapshot() before the first block = %+v", s)
+	}
+}
+
+func TestListener_RunWritesMetrics(t *testing.T) {
+	conn := newTestConnection(t, map[string]rpcHandler{
+		"eth_getBlockByNumber": func(params []json.RawMessage) (interface{}, *rpcError) {
+			return nil, &rpcError{Code: -32000, Message: "header not found"}
+		},
+	})
+	path := filepath.Join(t.TempDir(), "metrics.json")
+	l := &Listener{
+		Config: &config.Config{
+			RetryInterval: config.Duration{Duration: time.Millisecond},
+			EthereumConfig: config.EthereumConfig{
+				BlockConfirmations: big.NewInt(0),
+			},
+		},
+		Ethconn:     conn,
+		MetricsPath: path,
+		Stop:        make(chan struct{}, 1),
+	}
+	if _, err := l.Run(context.Background()); !errors.Is(err, ErrRetriesExceeded) {
+		t.Fatalf("Run() error = %v, want %v", err, ErrRetriesExceeded)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var s MetricsSnapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.FetchErrors != uint64(params.BlockRetryLimit) || s.RetryBudget != 0 {
+		t.Errorf("metrics = %+v, want %d fetch errors and no retry budget", s, params.BlockRetryLimit)
+	}
+}
@@ -0,0 +1,102 @@
+package ethereum
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/NuLink-network/watcher/watcher/config"
+	"github.com/NuLink-network/watcher/watcher/notify"
+)
+
+// checkContract makes sure the deposit contract is still alive at an epoch boundary before its stakers are
+// read. A contract whose code is gone, or that emitted the MigrationEventSig within the epoch, is replaced
+// by MigrateTo when one is configured. Otherwise submissions are paused and an alert is raised, the contract
+// stays lost until the watcher is restarted with a new address. A failed check is only logged.
+func (l *Listener) checkContract(block *big.Int) {
+	if l.contractLost {
+		return
+	}
+	ec := &l.Config.EthereumConfig
+	addr := ethcommon.HexToAddress(ec.DepositContractAddr)
+	reason, err := l.contractGone(addr, block)
+	if err != nil {
+		log.Warn("Failed to check the deposit contract", "contract", addr, "block", block, "error", err)
+		return
+	}
+	if reason == "" {
+		return
+	}
+
+	if !config.IsEmpty(ec.MigrateTo) {
+		to := ethcommon.HexToAddress(ec.MigrateTo)
+		if code, err := l.Ethconn.Client.CodeAt(context.Background(), to, block); err != nil {
+			log.Warn("Failed to check the migrated deposit contract", "contract", to, "block", block, "error", err)
+			return
+		} else if len(code) > 0 {
+			l.repointContract(addr, to)
+			log.Warn("Deposit contract migrated, following the new contract", "from", addr, "to", to, "block", block, "reason", reason)
+			l.Alerts.Alert(notify.KindContract, fmt.Sprintf("nulink watcher: deposit contract %s %s, following %s", addr.Hex(), reason, to.Hex()))
+			return
+		}
+		reason += fmt.Sprintf(" and migrateTo %s has no code", to.Hex())
+	}
+	l.contractLost = true
+	log.Error("Deposit contract lost, submissions are paused until the watcher is restarted with a new contract", "contract", addr, "block", block, "reason", reason)
+	l.Alerts.Alert(notify.KindContract, fmt.Sprintf("nulink watcher: deposit contract %s %s, submissions are paused", addr.Hex(), reason))
+}
+
+// contractGone returns why the contract at addr can't be used at block anymore, empty while it can
+func (l *Listener) contractGone(addr ethcommon.Address, block *big.Int) (string, error) {
+	code, err := l.Ethconn.Client.CodeAt(context.Background(), addr, block)
+	if err != nil {
+		return "", err
+	}
+	if len(code) == 0 {
+		return fmt.Sprintf("has no code at block %s", block), nil
+	}
+	sig := EventSig(l.Config.EthereumConfig.MigrationEventSig)
+	if sig == "" {
+		return "", nil
+	}
+	from := new(big.Int).Sub(block, new(big.Int).SetUint64(l.Config.EpochSize-1))
+	if from.Sign() < 0 {
+		from.SetInt64(0)
+	}
+	logs, err := l.filterLogs(buildQuery(addr, sig, from, block))
+	if err != nil {
+		return "", fmt.Errorf("unable to Filter Logs: %w", err)
+	}
+	if len(logs) == 0 {
+		return "", nil
+	}
+	e := logs[len(logs)-1]
+	reason := fmt.Sprintf("emitted %s in block %d", sig, e.BlockNumber)
+	if len(e.Topics) > 1 {
+		reason += fmt.Sprintf(" naming %s", ethcommon.BytesToAddress(e.Topics[1].Bytes()).Hex())
+	}
+	return reason, nil
+}
+
+// repointContract replaces the deposit contract from by to, keeping the state of the run. The stakers read
+// from the old contract are dropped: the prefetched snapshot and the staker cache.
+func (l *Listener) repointContract(from, to ethcommon.Address) {
+	if s := l.snapshot; s != nil {
+		<-s.done
+		l.snapshot = nil
+	}
+	ec := &l.Config.EthereumConfig
+	ec.DepositContractAddr = to.Hex()
+	ec.MigrateTo = ""
+	for i := range ec.Contracts {
+		if ethcommon.HexToAddress(ec.Contracts[i].Address) == from {
+			ec.Contracts[i].Address = to.Hex()
+		}
+	}
+	if l.Config.StakerCache.Size > 0 {
+		l.stakerCache().reset()
+	}
+}
@@ -0,0 +1,131 @@
+package ethereum
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+	"github.com/NuLink-network/watcher/watcher/notify"
+)
+
+type testNotifier chan notify.Event
+
+func (n testNotifier) Notify(ctx context.Context, e notify.Event) error {
+	n <- e
+	return nil
+}
+
+func TestListener_checkContract(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = false
+
+	old, next := ethcommon.HexToAddress("0xa1"), ethcommon.HexToAddress("0xb2")
+	migrated := EventSig("Migrated(address)")
+	tests := []struct {
+		name      string
+		migrateTo string
+		eventSig  string
+		removed   bool // the code of the old contract is removed
+		event     bool // the old contract emits the migration event
+		wantLost  bool
+		wantAddr  ethcommon.Address
+	}{
+		{name: "code removed", removed: true, wantLost: true, wantAddr: old},
+		{name: "code removed with migrateTo", removed: true, migrateTo: next.Hex(), wantAddr: next},
+		{name: "migration event", eventSig: string(migrated), event: true, wantLost: true, wantAddr: old},
+		{name: "migration event with migrateTo", eventSig: string(migrated), event: true, migrateTo: next.Hex(), wantAddr: next},
+		{name: "migration event not watched", event: true, wantAddr: old},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			code := map[ethcommon.Address]string{old: "0x6001", next: "0x6002"}
+			var logs []*ethtypes.Log
+			conn := newTestConnection(t, map[string]rpcHandler{
+				"eth_getCode": func(params []json.RawMessage) (interface{}, *rpcError) {
+					var addr ethcommon.Address
+					if err := json.Unmarshal(params[0], &addr); err != nil {
+						return nil, &rpcError{Code: -32602, Message: err.Error()}
+					}
+					mu.Lock()
+					defer mu.Unlock()
+					if c, ok := code[addr]; ok {
+						return c, nil
+					}
+					return "0x", nil
+				},
+				"eth_getLogs": func(params []json.RawMessage) (interface{}, *rpcError) {
+					mu.Lock()
+					defer mu.Unlock()
+					if logs == nil {
+						return []*ethtypes.Log{}, nil
+					}
+					return logs, nil
+				},
+			})
+			events := make(testNotifier, 1)
+			sub := &substrate.MockSubmitter{}
+			cfg := &config.Config{EpochSize: 1000, SubmitMode: config.SubmitModeFull, EthereumConfig: config.EthereumConfig{
+				DepositContractAddr: old.Hex(),
+				MigrateTo:           tt.migrateTo,
+				MigrationEventSig:   tt.eventSig,
+			}}
+			l := &Listener{Config: cfg, Ethconn: conn, Source: emptySource{}, Subconn: sub, Alerts: notify.NewAlerter(events, 1, time.Second)}
+
+			if err := l.syncStakeInfos(big.NewInt(1000)); err != nil {
+				t.Fatal(err)
+			}
+			mu.Lock()
+			if tt.removed {
+				delete(code, old)
+			}
+			if tt.event {
+				logs = []*ethtypes.Log{{Address: old, Topics: []ethcommon.Hash{migrated.GetTopic(), ethcommon.BytesToHash(next.Bytes())}, BlockNumber: 1500}}
+			}
+			mu.Unlock()
+			for _, block := range []int64{1990, 2000, 2010} {
+				if err := l.syncStakeInfos(big.NewInt(block)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if l.contractLost != tt.wantLost {
+				t.Errorf("contractLost = %v, want %v", l.contractLost, tt.wantLost)
+			}
+			if got := ethcommon.HexToAddress(cfg.EthereumConfig.DepositContractAddr); got != tt.wantAddr {
+				t.Errorf("deposit contract = %s, want %s", got.Hex(), tt.wantAddr.Hex())
+			}
+			// the sets of both boundaries and the empty sets of 1990 and 2010, the loss is detected at 2000 and
+			// holds the later submissions back
+			want := 4
+			if tt.wantLost {
+				want = 2
+			}
+			if calls := sub.Calls(); len(calls) != want {
+				t.Errorf("submitted %d times, want %d", len(calls), want)
+			}
+			if tt.wantLost || tt.wantAddr != old {
+				select {
+				case e := <-events:
+					if e.Kind != notify.KindContract || !strings.Contains(e.Message, old.Hex()) {
+						t.Errorf("alert = %+v, want a %s alert for %s", e, notify.KindContract, old.Hex())
+					}
+				case <-time.After(time.Second):
+					t.Error("no alert was raised")
+				}
+			}
+			if got := l.submissionsPaused(); got != tt.wantLost {
+				t.Errorf("submissionsPaused() = %v, want %v", got, tt.wantLost)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package ethereum
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// queuedSetRecord is the form a set waiting for its shifted boundary is persisted in, the absent epochs are
+// kept in the records of top
+type queuedSetRecord struct {
+	Block  *big.Int          `json:"block"`
+	Top    []stakeInfoRecord `json:"top"`
+	Submit []stakeInfoRecord `json:"submit"`
+}
+
+// epochQueuePath is the file the queued sets are persisted in, next to the last stake infos so a restart
+// resumes them. Without a LastStakeInfoPath they are kept in memory only.
+func (l *Listener) epochQueuePath() string {
+	if l.LastStakeInfoPath == "" {
+		return ""
+	}
+	return l.LastStakeInfoPath + ".queue"
+}
+
+// shiftEpoch applies the EpochSubmissionOffset to the set computed at the boundary of the current epoch: it
+// is queued and the set computed EpochSubmissionOffset epochs before is returned to be submitted in its
+// place, still for the block and epoch it was computed at. It returns nil when no set was computed for that
+// epoch, while the queue fills up or after the watcher was down. Queued sets whose boundary passed are
+// dropped.
+func (l *Listener) shiftEpoch(set *pendingSet) (*pendingSet, error) {
+	offset := l.Config.EpochSubmissionOffset
+	if err := l.loadEpochQueue(); err != nil {
+		return nil, err
+	}
+	current := l.Config.Epoch(set.block.Uint64())
+	var due *pendingSet
+	queue := make([]*pendingSet, 0, len(l.epochQueue)+1)
+	for _, q := range l.epochQueue {
+		epoch := l.Config.Epoch(q.block.Uint64())
+		switch {
+		case epoch+offset == current:
+			due = q
+		case epoch+offset < current:
+			log.Warn("drop the queued stake info set, its submission epoch passed", "block", q.block, "epoch", epoch, "submitEpoch", epoch+offset, "current", current)
+		case epoch < current:
+			queue = append(queue, q)
+		}
+	}
+	l.epochQueue = append(queue, set)
+	if err := l.writeEpochQueue(); err != nil {
+		return nil, err
+	}
+	if due == nil {
+		log.Warn("no stake info set computed for the epoch to submit, skip the update", "block", set.block, "epoch", current, "offset", offset)
+		return nil, nil
+	}
+	log.Info("submitting the stake info set of an earlier epoch", "block", due.block, "epoch", current-offset, "current", current, "offset", offset)
+	return due, nil
+}
+
+// loadEpochQueue reads the persisted queue once, a missing file is an empty queue
+func (l *Listener) loadEpochQueue() error {
+	if l.epochQueueLoaded {
+		return nil
+	}
+	l.epochQueueLoaded = true
+	path := l.epochQueuePath()
+	if path == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var records []queuedSetRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	for _, r := range records {
+		if r.Block == nil {
+			return fmt.Errorf("queued stake info set without a block in %s", path)
+		}
+		top, absent, err := stakeInfosFromRecords(r.Top)
+		if err != nil {
+			return fmt.Errorf("failed to decode %s: %w", path, err)
+		}
+		submit, _, err := stakeInfosFromRecords(r.Submit)
+		if err != nil {
+			return fmt.Errorf("failed to decode %s: %w", path, err)
+		}
+		l.epochQueue = append(l.epochQueue, &pendingSet{block: r.Block, top: top, absent: absent, submit: submit})
+	}
+	log.Info("Restored the queued stake info sets", "path", path, "count", len(l.epochQueue))
+	return nil
+}
+
+// writeEpochQueue persists the queued sets read back by loadEpochQueue
+func (l *Listener) writeEpochQueue() error {
+	path := l.epochQueuePath()
+	if path == "" {
+		return nil
+	}
+	records := make([]queuedSetRecord, 0, len(l.epochQueue))
+	for _, q := range l.epochQueue {
+		r := queuedSetRecord{Block: q.block}
+		for _, info := range q.top {
+			rec := newStakeInfoRecord(info)
+			rec.AbsentEpochs = q.absent[ethcommon.Bytes2Hex(info.WorkBase)]
+			r.Top = append(r.Top, rec)
+		}
+		for _, info := range q.submit {
+			r.Submit = append(r.Submit, newStakeInfoRecord(info))
+		}
+		records = append(records, r)
+	}
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0664)
+}
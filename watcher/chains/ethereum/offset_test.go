@@ -0,0 +1,76 @@
+package ethereum
+
+import (
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+	"github.com/NuLink-network/watcher/watcher/sink"
+)
+
+func TestListener_epochSubmissionOffset(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = false
+
+	staker := ethcommon.BytesToAddress(WorkBase[0])
+	balances := map[string]map[ethcommon.Address]int64{}
+	var tags []string
+	conn := newTestConnection(t, map[string]rpcHandler{"eth_call": stakingContract(t, balances, &tags)})
+	path := filepath.Join(t.TempDir(), "stake-info.json")
+	sub := &substrate.MockSubmitter{}
+	published := &fakeSink{}
+	newListener := func() *Listener {
+		cfg := &config.Config{EpochSize: 1000, SubmitMode: config.SubmitModeFull, EpochSubmissionOffset: 1,
+			EthereumConfig: config.EthereumConfig{BlockConfirmations: big.NewInt(10)}}
+		return &Listener{Config: cfg, Ethconn: conn, Subconn: sub, LastStakeInfoPath: path, Sinks: []sink.Sink{published}}
+	}
+
+	// the locked balance read at a boundary is 10 times its epoch, the submitted balance tells its epoch
+	l := newListener()
+	steps := []struct {
+		name      string
+		restart   bool
+		epoch     int64
+		wantEpoch int64 // the epoch whose set is submitted, 0 for none
+	}{
+		{name: "queue fills up", epoch: 1},
+		{name: "previous epoch", epoch: 2, wantEpoch: 1},
+		{name: "queue resumed after a restart", restart: true, epoch: 3, wantEpoch: 2},
+		// the set of epoch 3 was due at epoch 4, the watcher was down
+		{name: "set of a passed boundary", restart: true, epoch: 5},
+		{name: "after the gap", epoch: 6, wantEpoch: 5},
+	}
+	for _, s := range steps {
+		if s.restart {
+			l = newListener()
+		}
+		resetStakeInfoList()
+		balances["latest"] = map[ethcommon.Address]int64{staker: 10 * s.epoch}
+		before := len(sub.Calls())
+		if err := l.syncStakeInfos(big.NewInt(s.epoch * 1000)); err != nil {
+			t.Fatalf("%s: syncStakeInfos() error = %v", s.name, err)
+		}
+		calls := sub.Calls()[before:]
+		if s.wantEpoch == 0 {
+			if len(calls) != 0 {
+				t.Errorf("%s: submitted %d times, want none", s.name, len(calls))
+			}
+			continue
+		}
+		if len(calls) != 1 {
+			t.Fatalf("%s: submitted %d times, want once", s.name, len(calls))
+		}
+		infos := calls[0].Args[0].(substrate.StakeInfos)
+		if len(infos) != 1 || infos[0].LockedBalance.Int64() != 10*s.wantEpoch {
+			t.Errorf("%s: submitted %v, want the set of epoch %d", s.name, infos, s.wantEpoch)
+		}
+		// the set keeps the epoch it was computed for
+		if u := published.updates[len(published.updates)-1]; int64(u.Epoch) != s.wantEpoch {
+			t.Errorf("%s: published epoch %d, want %d", s.name, u.Epoch, s.wantEpoch)
+		}
+	}
+}
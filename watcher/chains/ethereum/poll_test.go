@@ -0,0 +1,491 @@
+package ethereum
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+// depositLog is a Deposited event of contract for staker locking value
+func depositLog(contract, staker common.Address, value int64) *ethtypes.Log {
+	data := append(common.BigToHash(big.NewInt(value)).Bytes(), common.BigToHash(big.NewInt(1)).Bytes()...)
+	return &ethtypes.Log{Address: contract, Topics: []common.Hash{Deposited.GetTopic(), common.BytesToHash(staker[:])}, Data: data}
+}
+
+// pollConfig is a config polling the deposit events of contracts from start, without confirmations
+func pollConfig(start int64, contracts ...config.ContractConfig) *config.Config {
+	return &config.Config{
+		EpochSize:         1000,
+		EpochSource:       config.EpochSourceEvents,
+		MaxEventsPerBlock: config.MaxEventsPerBlock,
+		PollInterval:      config.Duration{Duration: time.Millisecond},
+		RetryInterval:     config.Duration{Duration: time.Millisecond},
+		EthereumConfig: config.EthereumConfig{
+			BlockConfirmations: big.NewInt(0),
+			StartBlock:         big.NewInt(start),
+			StakerTopic:        &config.TopicSlice{Index: 1, Offset: 12, Length: 20},
+			Contracts:          contracts,
+		},
+	}
+}
+
+// runDeposits runs l against a node at head serving logs, by contract and queried block, to eth_getLogs.
+// Run is cancelled once it polled up to head, its error and the blocks each contract was queried at are
+// returned. Every query must range over a single block.
+func runDeposits(t *testing.T, l *Listener, head int64, logs map[common.Address]map[string][]*ethtypes.Log) (map[common.Address][]string, error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var heads int
+	queried := make(map[common.Address][]string)
+	l.Ethconn = newTestConnection(t, map[string]rpcHandler{
+		"eth_getBlockByNumber": func(params []json.RawMessage) (interface{}, *rpcError) {
+			heads++
+			if heads > 1 {
+				cancel()
+			}
+			return testHeader(head), nil
+		},
+		"eth_getLogs": func(params []json.RawMessage) (interface{}, *rpcError) {
+			var q struct {
+				Address   []common.Address `json:"address"`
+				FromBlock string           `json:"fromBlock"`
+				ToBlock   string           `json:"toBlock"`
+			}
+			if err := json.Unmarshal(params[0], &q); err != nil {
+				return nil, &rpcError{Code: -32602, Message: err.Error()}
+			}
+			if q.FromBlock != q.ToBlock {
+				t.Errorf("queried blocks %s to %s, want a single block", q.FromBlock, q.ToBlock)
+			}
+			queried[q.Address[0]] = append(queried[q.Address[0]], q.FromBlock)
+			return logs[q.Address[0]][q.FromBlock], nil
+		},
+	})
+	if l.Subconn == nil {
+		l.Subconn = &substrate.MockSubmitter{}
+	}
+	if l.Stop == nil {
+		l.Stop = make(chan struct{}, 1)
+	}
+	_, err := l.Run(ctx)
+	return queried, err
+}
+
+func TestListener_RunDepositContracts(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = true
+	defer resetStakeInfoList()
+	resetStakeInfoList()
+
+	a, b := common.HexToAddress("0xa1"), common.HexToAddress("0xb2")
+	staker1, staker2 := common.HexToAddress("0x01"), common.HexToAddress("0x02")
+	sub := &substrate.MockSubmitter{}
+	l := &Listener{Subconn: sub, Config: pollConfig(997,
+		config.ContractConfig{Address: a.Hex()},
+		config.ContractConfig{Address: b.Hex(), Confirmations: big.NewInt(5)},
+		// not deployed yet at the polled blocks
+		config.ContractConfig{Address: "0xc3", StartBlock: big.NewInt(2000)},
+	)}
+	queried, err := runDeposits(t, l, 1000, map[common.Address]map[string][]*ethtypes.Log{
+		a: {"0x3e7": {depositLog(a, staker1, 10), depositLog(a, staker2, 5)}},
+		b: {"0x3e3": {depositLog(b, staker1, 7)}},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run() error = %v, want %v", err, context.Canceled)
+	}
+	want := map[common.Address][]string{a: {"0x3e6", "0x3e7", "0x3e8"}, b: {"0x3e1", "0x3e2", "0x3e3"}}
+	if !reflect.DeepEqual(queried, want) {
+		t.Errorf("queried blocks = %v, want %v", queried, want)
+	}
+	calls := sub.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("Run() submitted %d times, want once at the epoch boundary", len(calls))
+	}
+	infos := calls[0].Args[0].(substrate.StakeInfos)
+	if len(infos) != 2 {
+		t.Fatalf("Run() submitted %d stakers, want 2", len(infos))
+	}
+	for i, want := range []struct {
+		staker common.Address
+		value  int64
+	}{{staker1, 17}, {staker2, 5}} {
+		info := infos[i]
+		if !reflect.DeepEqual(info.WorkBase, want.staker[:]) || info.LockedBalance.Int.Int64() != want.value {
+			t.Errorf("submitted[%d] = %x %v, want %x %d", i, info.WorkBase, info.LockedBalance.Int, want.staker, want.value)
+		}
+	}
+	if len(stakeInfoList) != 0 {
+		t.Errorf("stakeInfoList holds %d stakers after the boundary, want them flushed", len(stakeInfoList))
+	}
+}
+
+func TestListener_RunEventLimit(t *testing.T) {
+	contract := common.HexToAddress("0xa1")
+	logs := make([]*ethtypes.Log, 5)
+	for i := range logs {
+		logs[i] = depositLog(contract, common.BigToAddress(big.NewInt(int64(i+1))), 10)
+	}
+	tests := []struct {
+		name      string
+		halt      bool
+		wantErr   error
+		wantCalls int
+	}{
+		{name: "drop", wantErr: context.Canceled, wantCalls: 1},
+		{name: "halt", halt: true, wantErr: ErrTooManyEvents},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(f bool) { first = f }(first)
+			first = true
+			defer resetStakeInfoList()
+			resetStakeInfoList()
+
+			sub := &substrate.MockSubmitter{}
+			l := &Listener{Subconn: sub, Config: pollConfig(998, config.ContractConfig{Address: contract.Hex()})}
+			l.Config.MaxEventsPerBlock = 3
+			l.Config.HaltOnEventLimit = tt.halt
+			_, err := runDeposits(t, l, 1000, map[common.Address]map[string][]*ethtypes.Log{
+				contract: {"0x3e7": logs},
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Run() error = %v, want %v", err, tt.wantErr)
+			}
+			calls := sub.Calls()
+			if len(calls) != tt.wantCalls {
+				t.Fatalf("Run() submitted %d times, want %d", len(calls), tt.wantCalls)
+			}
+			if tt.wantCalls > 0 {
+				if infos := calls[0].Args[0].(substrate.StakeInfos); len(infos) != 3 {
+					t.Errorf("Run() submitted %d stakers, want the 3 within the limit", len(infos))
+				}
+			}
+		})
+	}
+}
+
+func TestListener_RunResumesCheckpoint(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	defer resetStakeInfoList()
+	resetStakeInfoList()
+
+	contract, staker := common.HexToAddress("0xa1"), common.HexToAddress("0x01")
+	logs := map[common.Address]map[string][]*ethtypes.Log{
+		contract: {"0x3e9": {depositLog(contract, staker, 10)}, "0x3ed": {depositLog(contract, staker, 5)}},
+	}
+	path := filepath.Join(t.TempDir(), "deposits.json")
+	newListener := func(sub substrate.Submitter) *Listener {
+		cfg := pollConfig(1000, config.ContractConfig{Address: contract.Hex()})
+		cfg.EpochSize = 10
+		return &Listener{Config: cfg, Subconn: sub, DepositCheckpointPath: path}
+	}
+
+	first = true
+	if _, err := runDeposits(t, newListener(nil), 1002, logs); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run() error = %v, want %v", err, context.Canceled)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("Run() wrote no deposit checkpoint: %v", err)
+	}
+
+	// a restart resumes its deposits and polling after the last block holding one
+	resetStakeInfoList()
+	first = true
+	sub := &substrate.MockSubmitter{}
+	queried, err := runDeposits(t, newListener(sub), 1010, logs)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run() error = %v, want %v", err, context.Canceled)
+	}
+	if got := queried[contract]; len(got) != 9 || got[0] != "0x3ea" {
+		t.Errorf("resumed Run() queried %v, want the 9 blocks after the checkpointed 1001", got)
+	}
+	calls := sub.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("resumed Run() submitted %d times, want once at the epoch boundary", len(calls))
+	}
+	if infos := calls[0].Args[0].(substrate.StakeInfos); len(infos) != 1 || infos[0].LockedBalance.Int.Int64() != 15 {
+		t.Errorf("resumed Run() submitted %+v, want the staker with both deposits, 15", infos)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("deposit checkpoint still exists after the epoch flush: %v", err)
+	}
+}
+
+func TestListener_RunCrossContractAggregation(t *testing.T) {
+	a, b := common.HexToAddress("0xa1"), common.HexToAddress("0xb2")
+	staker1, staker2 := common.HexToAddress("0x01"), common.HexToAddress("0x02")
+	logs := map[common.Address]map[string][]*ethtypes.Log{
+		a: {"0x3e6": {depositLog(a, staker1, 4)}, "0x3e7": {depositLog(a, staker1, 6)}},
+		b: {"0x3e7": {depositLog(b, staker1, 7), depositLog(b, staker2, 5)}},
+	}
+	tests := []struct {
+		policy string
+		want   []int64
+	}{
+		{policy: config.AggregateSum, want: []int64{17, 5}},
+		{policy: config.AggregateMax, want: []int64{10, 5}},
+		{policy: config.AggregateSeparate, want: []int64{10, 7, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			defer func(f bool) { first = f }(first)
+			first = true
+			defer resetStakeInfoList()
+			resetStakeInfoList()
+
+			sub := &substrate.MockSubmitter{}
+			l := &Listener{Subconn: sub, Config: pollConfig(997, config.ContractConfig{Address: a.Hex()}, config.ContractConfig{Address: b.Hex()})}
+			l.Config.EthereumConfig.CrossContractAggregation = tt.policy
+			if _, err := runDeposits(t, l, 1000, logs); !errors.Is(err, context.Canceled) {
+				t.Fatalf("Run() error = %v, want %v", err, context.Canceled)
+			}
+			calls := sub.Calls()
+			if len(calls) != 1 {
+				t.Fatalf("Run() submitted %d times, want once at the epoch boundary", len(calls))
+			}
+			infos := calls[0].Args[0].(substrate.StakeInfos)
+			got := make([]int64, len(infos))
+			for i, info := range infos {
+				got[i] = info.LockedBalance.Int.Int64()
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Run() submitted balances %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListener_RunEpochTrigger(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = true
+	defer resetStakeInfoList()
+	resetStakeInfoList()
+
+	contract, staker := common.HexToAddress("0xa1"), common.HexToAddress("0x01")
+	sub := &substrate.MockSubmitter{}
+	l := &Listener{Subconn: sub, Config: pollConfig(1002, config.ContractConfig{Address: contract.Hex(), Confirmations: big.NewInt(3)})}
+	l.Config.EpochOffset = 5
+	queried, err := runDeposits(t, l, 1006, map[common.Address]map[string][]*ethtypes.Log{
+		contract: {
+			"0x3e9": {depositLog(contract, staker, 1)},
+			"0x3ea": {depositLog(contract, staker, 2)},
+			"0x3eb": {depositLog(contract, staker, 4)},
+		},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run() error = %v, want %v", err, context.Canceled)
+	}
+	// the blocks after the current block 1002 up to the safe head are polled, each contract is queried its
+	// confirmations below the polled block
+	if want := []string{"0x3e8", "0x3e9", "0x3ea", "0x3eb"}; !reflect.DeepEqual(queried[contract], want) {
+		t.Errorf("queried blocks = %v, want %v", queried[contract], want)
+	}
+	// the epoch boundary is that of polled block 1005, not of the queried block 1002
+	calls := sub.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("Run() submitted %d times, want once at the epoch boundary", len(calls))
+	}
+	if infos := calls[0].Args[0].(substrate.StakeInfos); len(infos) != 1 || infos[0].LockedBalance.Int.Int64() != 3 {
+		t.Errorf("Run() submitted %+v, want the deposits of the polled blocks up to 1005", infos)
+	}
+	if len(stakeInfoList) != 1 || stakeInfoList[0].LockedBalance.Int.Int64() != 4 {
+		t.Errorf("stakeInfoList after the boundary = %+v, want the deposit of polled block 1006", stakeInfoList)
+	}
+}
+
+func TestListener_RunDepositsMaintenance(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = true
+	defer resetStakeInfoList()
+	resetStakeInfoList()
+
+	contract, staker := common.HexToAddress("0xa1"), common.HexToAddress("0x01")
+	logs := map[common.Address]map[string][]*ethtypes.Log{contract: {"0x3e7": {depositLog(contract, staker, 10)}}}
+	sub := &substrate.MockSubmitter{}
+	l := &Listener{Subconn: sub, Config: pollConfig(998, config.ContractConfig{Address: contract.Hex()})}
+	l.SetMaintenance(true)
+	if _, err := runDeposits(t, l, 1000, logs); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run() error = %v, want %v", err, context.Canceled)
+	}
+	if calls := len(sub.Calls()); calls != 0 || l.pending == nil || l.pending.block.Int64() != 1000 {
+		t.Fatalf("Run() in maintenance submitted %d times with pending %v, want the boundary held", calls, l.pending)
+	}
+
+	// the held update is submitted by the first block polled after maintenance
+	l.SetMaintenance(false)
+	l.Config.EthereumConfig.StartBlock = big.NewInt(1000)
+	if _, err := runDeposits(t, l, 1001, logs); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run() error = %v, want %v", err, context.Canceled)
+	}
+	calls := sub.Calls()
+	if len(calls) != 1 || l.pending != nil {
+		t.Fatalf("Run() after maintenance submitted %d times with pending %v, want the held update", len(calls), l.pending)
+	}
+	if infos := calls[0].Args[0].(substrate.StakeInfos); len(infos) != 1 || infos[0].LockedBalance.Int.Int64() != 10 {
+		t.Errorf("Run() submitted %+v, want the deposit of the held epoch", infos)
+	}
+}
+
+func TestListener_RunSpillsDeposits(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = true
+	defer resetStakeInfoList()
+	resetStakeInfoList()
+
+	contract := common.HexToAddress("0xa1")
+	staker1, staker2 := common.HexToAddress("0x01"), common.HexToAddress("0x02")
+	dir := t.TempDir()
+	sub := &substrate.MockSubmitter{}
+	l := &Listener{Subconn: sub, Config: pollConfig(997, config.ContractConfig{Address: contract.Hex()})}
+	l.Config.DepositSpill = config.DepositSpillConfig{MaxEntries: 1, Dir: dir}
+	_, err := runDeposits(t, l, 1000, map[common.Address]map[string][]*ethtypes.Log{
+		contract: {
+			"0x3e6": {depositLog(contract, staker1, 10), depositLog(contract, staker2, 5)},
+			"0x3e7": {depositLog(contract, staker1, 3)},
+		},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run() error = %v, want %v", err, context.Canceled)
+	}
+	calls := sub.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("Run() submitted %d times, want once at the epoch boundary", len(calls))
+	}
+	infos := calls[0].Args[0].(substrate.StakeInfos)
+	if len(infos) != 2 || infos[0].LockedBalance.Int.Int64() != 13 || infos[1].LockedBalance.Int.Int64() != 5 {
+		t.Errorf("Run() submitted %+v, want the spilled deposits merged back, 13 and 5", infos)
+	}
+	if fis, err := ioutil.ReadDir(dir); err != nil || len(fis) != 0 {
+		t.Errorf("spill dir holds %d files after the epoch flush, want none: %v", len(fis), err)
+	}
+}
+
+func TestListener_RunTracesDeposits(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = true
+	defer resetStakeInfoList()
+	resetStakeInfoList()
+
+	var mu sync.Mutex
+	var traced []string
+	defer log.Root().SetHandler(log.Root().GetHandler())
+	log.Root().SetHandler(log.LvlFilterHandler(log.LvlTrace, log.LazyHandler(log.FuncHandler(func(r *log.Record) error {
+		if r.Msg == "FilterLogs" {
+			mu.Lock()
+			traced = append(traced, fmt.Sprintln(r.Ctx...))
+			mu.Unlock()
+		}
+		return nil
+	}))))
+
+	a, b := common.HexToAddress("0xa1"), common.HexToAddress("0xb2")
+	l := &Listener{Config: pollConfig(998, config.ContractConfig{Address: a.Hex()}, config.ContractConfig{Address: b.Hex()})}
+	_, err := runDeposits(t, l, 1000, map[common.Address]map[string][]*ethtypes.Log{
+		a: {"0x3e8": {depositLog(a, common.HexToAddress("0x01"), 10)}},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run() error = %v, want %v", err, context.Canceled)
+	}
+	// every contract is traced at every polled block with the number of logs it returned
+	want := []struct {
+		contract common.Address
+		block    string
+		logs     string
+	}{{a, "from=999 to=999", "logs 0"}, {b, "from=999 to=999", "logs 0"}, {a, "from=1000 to=1000", "logs 1"}, {b, "from=1000 to=1000", "logs 0"}}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(traced) != len(want) {
+		t.Fatalf("Run() traced %d FilterLogs queries, want %d: %q", len(traced), len(want), traced)
+	}
+	for i, w := range want {
+		for _, s := range []string{w.contract.Hex(), w.block, w.logs} {
+			if !strings.Contains(traced[i], s) {
+				t.Errorf("trace record %d %q doesn't hold %q", i, traced[i], s)
+			}
+		}
+	}
+}
+
+func TestListener_RunEarliestContractStart(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = true
+	defer resetStakeInfoList()
+	resetStakeInfoList()
+
+	a, b := common.HexToAddress("0xa1"), common.HexToAddress("0xb2")
+	l := &Listener{Config: pollConfig(0,
+		config.ContractConfig{Address: a.Hex(), StartBlock: big.NewInt(1000)},
+		config.ContractConfig{Address: b.Hex(), StartBlock: big.NewInt(997)},
+	)}
+	l.Config.EthereumConfig.StartBlock = nil
+	queried, err := runDeposits(t, l, 1000, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run() error = %v, want %v", err, context.Canceled)
+	}
+	// polling starts after the earliest start block, a is skipped until its own
+	want := map[common.Address][]string{a: {"0x3e8"}, b: {"0x3e6", "0x3e7", "0x3e8"}}
+	if !reflect.DeepEqual(queried, want) {
+		t.Errorf("queried blocks = %v, want %v", queried, want)
+	}
+}
+
+func TestListener_RunOverflowPolicy(t *testing.T) {
+	contract := common.HexToAddress("0xa1")
+	staker1, staker2 := common.HexToAddress("0x01"), common.HexToAddress("0x02")
+	huge := depositLog(contract, staker1, 0)
+	copy(huge.Data, common.BigToHash(new(big.Int).Lsh(big.NewInt(1), 130)).Bytes())
+	logs := map[common.Address]map[string][]*ethtypes.Log{contract: {"0x3e7": {huge, depositLog(contract, staker2, 5)}}}
+	tests := []struct {
+		policy string
+		want   []*big.Int
+	}{
+		{policy: config.OverflowClamp, want: []*big.Int{maxU128, big.NewInt(5)}},
+		{policy: config.OverflowSkip, want: []*big.Int{big.NewInt(5)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			defer func(f bool) { first = f }(first)
+			first = true
+			defer resetStakeInfoList()
+			resetStakeInfoList()
+
+			sub := &substrate.MockSubmitter{}
+			l := &Listener{Subconn: sub, Config: pollConfig(998, config.ContractConfig{Address: contract.Hex()})}
+			l.Config.OverflowPolicy = tt.policy
+			if _, err := runDeposits(t, l, 1000, logs); !errors.Is(err, context.Canceled) {
+				t.Fatalf("Run() error = %v, want %v", err, context.Canceled)
+			}
+			calls := sub.Calls()
+			if len(calls) != 1 {
+				t.Fatalf("Run() submitted %d times, want once at the epoch boundary", len(calls))
+			}
+			infos := calls[0].Args[0].(substrate.StakeInfos)
+			if len(infos) != len(tt.want) {
+				t.Fatalf("Run() submitted %d stakers, want %d", len(infos), len(tt.want))
+			}
+			for i, want := range tt.want {
+				if infos[i].LockedBalance.Int.Cmp(want) != 0 {
+					t.Errorf("submitted[%d] balance = %v, want %v", i, infos[i].LockedBalance.Int, want)
+				}
+			}
+		})
+	}
+}
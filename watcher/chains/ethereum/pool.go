@@ -0,0 +1,50 @@
+package ethereum
+
+import (
+	"net/http"
+	"sync"
+)
+
+// ConnectionPool shares one Connection per endpoint within a process, so tools dialing the same node
+// repeatedly reuse the first connection. CloseAll should be deferred by the owner of the pool.
+type ConnectionPool struct {
+	// HTTPClient is the Connection.HTTPClient of the connections the pool dials
+	HTTPClient *http.Client
+	// Recorder and Replay are the Connection.Recorder and Connection.Replay of the connections the pool dials
+	Recorder *Recorder
+	Replay   *Recording
+
+	mu    sync.Mutex
+	conns map[string]*Connection
+}
+
+func NewConnectionPool() *ConnectionPool {
+	return &ConnectionPool{conns: make(map[string]*Connection)}
+}
+
+// Get returns the connection to endpoint, dialing it on first use
+func (p *ConnectionPool) Get(endpoint string, http bool) (*Connection, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if conn, ok := p.conns[endpoint]; ok {
+		return conn, nil
+	}
+	conn := NewConnection(endpoint, http, make(chan struct{}))
+	conn.HTTPClient = p.HTTPClient
+	conn.Recorder, conn.Replay = p.Recorder, p.Replay
+	if err := conn.Connect(); err != nil {
+		return nil, err
+	}
+	p.conns[endpoint] = conn
+	return conn, nil
+}
+
+// CloseAll closes every connection of the pool, the pool can be used again afterwards
+func (p *ConnectionPool) CloseAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for endpoint, conn := range p.conns {
+		conn.Close()
+		delete(p.conns, endpoint)
+	}
+}
@@ -0,0 +1,45 @@
+package ethereum
+
+import (
+	"testing"
+)
+
+func TestConnectionPool(t *testing.T) {
+	srvA := newTestRPCServer(t, nil)
+	srvB := newTestRPCServer(t, nil)
+	pool := NewConnectionPool()
+
+	a1, err := pool.Get(srvA.URL, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a2, err := pool.Get(srvA.URL, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := pool.Get(srvB.URL, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a1 != a2 {
+		t.Errorf("Get() dialed %s twice", srvA.URL)
+	}
+	if a1 == b {
+		t.Errorf("Get() shared a connection between endpoints")
+	}
+
+	pool.CloseAll()
+	select {
+	case <-a1.Stop:
+	default:
+		t.Errorf("CloseAll() did not close %s", srvA.URL)
+	}
+	a3, err := pool.Get(srvA.URL, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a3 == a1 {
+		t.Errorf("Get() returned a closed connection")
+	}
+	pool.CloseAll()
+}
@@ -0,0 +1,74 @@
+package ethereum
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// pacedCaller is a bind.ContractCaller waiting interval on clock between its calls, or until the context of
+// the call is done
+type pacedCaller struct {
+	caller   bind.ContractCaller
+	interval time.Duration
+	clock    Clock
+	mu       sync.Mutex
+	called   bool
+}
+
+func (c *pacedCaller) wait(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.called {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.clock.After(c.interval):
+		}
+	}
+	c.called = true
+	return nil
+}
+
+func (c *pacedCaller) CodeAt(ctx context.Context, contract ethcommon.Address, blockNumber *big.Int) ([]byte, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.caller.CodeAt(ctx, contract, blockNumber)
+}
+
+func (c *pacedCaller) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, err
+	}
+	return c.caller.CallContract(ctx, call, blockNumber)
+}
+
+// startPreload reads the stake infos in the background once at startup with WarmPreload, for the first epoch
+// flush after block to use instead of a cold read. The read goes through the staker cache of the next epoch
+// boundary, warming it. It doesn't wait for the read.
+func (l *Listener) startPreload(block *big.Int) {
+	if !l.Config.WarmPreload.Enabled {
+		return
+	}
+	filter, err := l.stakerFilter()
+	if err != nil {
+		log.Warn("Failed to preload the stake infos", "error", err)
+		return
+	}
+	var caller bind.ContractCaller = l.Ethconn.Client
+	if d := l.Config.WarmPreload.CallInterval.Duration; d > 0 {
+		caller = &pacedCaller{caller: caller, interval: d, clock: l.clock()}
+	}
+	next := l.Config.NextEpochBoundary(block.Uint64())
+	s := &snapshot{boundary: next, block: new(big.Int).Set(block), preload: true, done: make(chan struct{})}
+	l.snapshot = s
+	log.Info("Preloading the stake infos", "block", block, "boundary", next, "callInterval", l.Config.WarmPreload.CallInterval)
+	go l.readSnapshot(s, caller, filter)
+}
@@ -0,0 +1,134 @@
+package ethereum
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+// The first boundary after startup submits the preloaded stake infos without reading the stakers
+func TestListener_warmPreload(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+
+	a := common.BytesToAddress(WorkBase[0])
+	tests := []struct {
+		name    string
+		preload bool
+		// the contract calls made once the boundary is polled: the stakers length, the staker and its info
+		wantBoundaryCalls int32
+	}{
+		{name: "preloaded", preload: true},
+		{name: "cold", wantBoundaryCalls: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first = false
+			var tags []string
+			contract := stakingContract(t, map[string]map[common.Address]int64{"latest": {a: 10}}, &tags)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			// the contract calls wait for polling to start, a preload holding up Run never returns
+			polling := make(chan struct{})
+			var startPolling sync.Once
+			var calls, boundaryCalls, atBoundary int32
+			polls := 0
+			conn := newTestConnection(t, map[string]rpcHandler{
+				"eth_getBlockByNumber": func(params []json.RawMessage) (interface{}, *rpcError) {
+					var tag string
+					_ = json.Unmarshal(params[0], &tag)
+					if tag != "latest" {
+						n, _ := new(big.Int).SetString(tag[2:], 16)
+						return testHeader(n.Int64()), nil
+					}
+					startPolling.Do(func() { close(polling) })
+					polls++
+					// with the preload the boundary waits for its calls, a while at most
+					switch {
+					case tt.preload && atomic.LoadInt32(&calls) < 3 && polls < 1000:
+						return testHeader(1501), nil
+					case atomic.CompareAndSwapInt32(&atBoundary, 0, 1):
+					default:
+						cancel()
+					}
+					return testHeader(2000), nil
+				},
+				"eth_call": func(params []json.RawMessage) (interface{}, *rpcError) {
+					<-polling
+					if atomic.LoadInt32(&atBoundary) == 1 {
+						atomic.AddInt32(&boundaryCalls, 1)
+					}
+					atomic.AddInt32(&calls, 1)
+					return contract(params)
+				},
+				"eth_getCode": func(params []json.RawMessage) (interface{}, *rpcError) { return "0x01", nil },
+			})
+			sub := &substrate.MockSubmitter{}
+			l := &Listener{
+				Config: &config.Config{EpochSize: 1000, SubmitMode: config.SubmitModeFull, PollInterval: config.Duration{Duration: time.Millisecond},
+					WarmPreload: config.WarmPreloadConfig{Enabled: tt.preload},
+					EthereumConfig: config.EthereumConfig{BlockConfirmations: big.NewInt(0), StartBlock: big.NewInt(1500),
+						DepositContractAddr: a.Hex()}},
+				Ethconn: conn,
+				Subconn: sub,
+				Stop:    make(chan struct{}, 1),
+			}
+			if _, err := l.Run(ctx); !errors.Is(err, context.Canceled) {
+				t.Fatalf("Run() error = %v, want %v", err, context.Canceled)
+			}
+			if got := atomic.LoadInt32(&boundaryCalls); got != tt.wantBoundaryCalls {
+				t.Errorf("made %d contract calls at the boundary, want %d", got, tt.wantBoundaryCalls)
+			}
+			submitted := sub.Calls()
+			if len(submitted) != 1 {
+				t.Fatalf("made %d submissions, want 1", len(submitted))
+			}
+			if infos := submitted[0].Args[0].(substrate.StakeInfos); len(infos) != 1 || infos[0].LockedBalance.Int64() != 10 {
+				t.Errorf("submitted %v, want the staker with 10", infos)
+			}
+		})
+	}
+}
+
+func TestListener_preloadPaced(t *testing.T) {
+	a := common.BytesToAddress(WorkBase[0])
+	var tags []string
+	conn := newTestConnection(t, map[string]rpcHandler{
+		"eth_call": stakingContract(t, map[string]map[common.Address]int64{"latest": {a: 10}}, &tags),
+	})
+	clock := &fakeClock{}
+	l := &Listener{
+		Config: &config.Config{EpochSize: 1000, StakerCache: config.StakerCacheConfig{Size: 10},
+			WarmPreload:    config.WarmPreloadConfig{Enabled: true, CallInterval: config.Duration{Duration: time.Second}},
+			EthereumConfig: config.EthereumConfig{BlockConfirmations: big.NewInt(0), DepositContractAddr: a.Hex()}},
+		Ethconn: conn,
+		Clock:   clock,
+	}
+	l.startPreload(big.NewInt(1500))
+	s := l.snapshot
+	<-s.done
+	if s.err != nil || len(s.infos) != 1 {
+		t.Fatalf("preloaded %v, %v, want the staker", s.infos, s.err)
+	}
+	// three calls, spaced twice
+	if got := clock.Now().Sub(time.Time{}); got != 2*time.Second {
+		t.Errorf("preload waited %v between its calls, want %v", got, 2*time.Second)
+	}
+	if _, misses, _ := l.stakerCache().counts(); misses != 1 || l.stakerCache().len() != 1 {
+		t.Errorf("staker cache holds %d stakers after %d misses, want the preloaded staker", l.stakerCache().len(), misses)
+	}
+	tags = nil
+	infos, err := l.epochStakeInfos(big.NewInt(2000))
+	if err != nil || len(infos) != 1 || len(tags) != 0 {
+		t.Errorf("epochStakeInfos() = %v, %v after %d calls, want the preload without calls", infos, err, len(tags))
+	}
+}
@@ -0,0 +1,103 @@
+package ethereum
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+var historyFilePattern = regexp.MustCompile(`^epoch-(\d+)\.json$`)
+
+// Pruner enforces the retention of the per epoch history files in Dir. It keeps the MaxFiles latest epochs
+// and removes files older than MaxAge, a zero value disables either limit. Files not named like a
+// HistoryFile and the Protected live state files are never removed.
+type Pruner struct {
+	Dir       string
+	MaxFiles  int
+	MaxAge    time.Duration
+	Protected []string
+	Clock     Clock // the SystemClock if nil
+}
+
+type historyEntry struct {
+	path    string
+	epoch   uint64
+	modTime time.Time
+}
+
+// Prune removes the history files exceeding the retention at now and returns their paths
+func (p *Pruner) Prune(now time.Time) ([]string, error) {
+	fis, err := ioutil.ReadDir(p.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	protected := make(map[string]struct{}, len(p.Protected))
+	for _, path := range p.Protected {
+		if abs, err := filepath.Abs(path); err == nil {
+			protected[abs] = struct{}{}
+		}
+	}
+
+	var entries []historyEntry
+	for _, fi := range fis {
+		m := historyFilePattern.FindStringSubmatch(fi.Name())
+		if fi.IsDir() || m == nil {
+			continue
+		}
+		path := filepath.Join(p.Dir, fi.Name())
+		if abs, err := filepath.Abs(path); err == nil {
+			if _, ok := protected[abs]; ok {
+				continue
+			}
+		}
+		epoch, err := strconv.ParseUint(m[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, historyEntry{path: path, epoch: epoch, modTime: fi.ModTime()})
+	}
+	// latest epochs first
+	sort.Slice(entries, func(i, j int) bool { return entries[i].epoch > entries[j].epoch })
+
+	var removed []string
+	for i, e := range entries {
+		expired := p.MaxAge > 0 && now.Sub(e.modTime) > p.MaxAge
+		if !expired && (p.MaxFiles <= 0 || i < p.MaxFiles) {
+			continue
+		}
+		if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		log.Info("Pruned history file", "path", e.path, "epoch", e.epoch, "modified", e.modTime.Format(time.RFC3339))
+		removed = append(removed, e.path)
+	}
+	return removed, nil
+}
+
+// Run prunes every interval on the Clock until ctx is done
+func (p *Pruner) Run(ctx context.Context, interval time.Duration) {
+	clock := p.Clock
+	if clock == nil {
+		clock = SystemClock{}
+	}
+	for {
+		if _, err := p.Prune(clock.Now()); err != nil {
+			log.Warn("Failed to prune history files", "dir", p.Dir, "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-clock.After(interval):
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package ethereum
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestPruner_Prune(t *testing.T) {
+	now := time.Date(2022, 1, 10, 0, 0, 0, 0, time.UTC)
+	// epoch n was written 6-n days before now
+	epochs := []uint64{1, 2, 3, 4, 5}
+	others := []string{"stake_info.json", "deposits.json", "epoch-x.json"}
+
+	tests := []struct {
+		name     string
+		maxFiles int
+		maxAge   time.Duration
+		protect  []string
+		want     []string
+	}{
+		{name: "no limits", want: nil},
+		{name: "by count", maxFiles: 2, want: []string{"epoch-1.json", "epoch-2.json", "epoch-3.json"}},
+		{name: "by age", maxAge: 3*24*time.Hour + time.Minute, want: []string{"epoch-1.json", "epoch-2.json"}},
+		{name: "by count and age", maxFiles: 4, maxAge: 3*24*time.Hour + time.Minute, want: []string{"epoch-1.json", "epoch-2.json"}},
+		{name: "protected", maxFiles: 1, protect: []string{"epoch-2.json"}, want: []string{"epoch-1.json", "epoch-3.json", "epoch-4.json"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, epoch := range epochs {
+				file := HistoryFile(dir, epoch)
+				if err := ioutil.WriteFile(file, []byte("[]"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				modTime := now.Add(-time.Duration(6-epoch) * 24 * time.Hour)
+				if err := os.Chtimes(file, modTime, modTime); err != nil {
+					t.Fatal(err)
+				}
+			}
+			old := now.Add(-100 * 24 * time.Hour)
+			for _, name := range others {
+				file := filepath.Join(dir, name)
+				if err := ioutil.WriteFile(file, []byte("[]"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chtimes(file, old, old); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var protected []string
+			for _, name := range tt.protect {
+				protected = append(protected, filepath.Join(dir, name))
+			}
+
+			p := &Pruner{Dir: dir, MaxFiles: tt.maxFiles, MaxAge: tt.maxAge, Protected: protected}
+			removed, err := p.Prune(now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, path := range removed {
+				got = append(got, filepath.Base(path))
+				if _, err := os.Stat(path); !os.IsNotExist(err) {
+					t.Errorf("%s still exists", path)
+				}
+			}
+			sort.Strings(got)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Prune() removed %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("Prune() removed %v, want %v", got, tt.want)
+					break
+				}
+			}
+			for _, name := range append(others, tt.protect...) {
+				if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+					t.Errorf("Prune() removed %s: %v", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestPruner_PruneMissingDir(t *testing.T) {
+	p := &Pruner{Dir: filepath.Join(t.TempDir(), "history"), MaxFiles: 1}
+	if removed, err := p.Prune(time.Now()); err != nil || len(removed) != 0 {
+		t.Errorf("Prune() of a missing dir = %v, %v", removed, err)
+	}
+}
@@ -0,0 +1,302 @@
+package ethereum
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// recordingVersion is the layout of the recording file: a header line {"version": n} followed by one
+// recordEntry per line. Bump it when the entries change incompatibly.
+const recordingVersion = 1
+
+// recordingFile is the file of a recording directory holding the entries
+const recordingFile = "rpc.jsonl"
+
+// recordBuffer is how many entries a Recorder queues before it drops new ones rather than block polling
+const recordBuffer = 4096
+
+// ErrReplayExhausted is returned by a replayed call the recording holds no more answers for
+var ErrReplayExhausted = errors.New("call not in the recording")
+
+// recordEntry is a call answered by the ethereum node: its method and params as sent, the block it concerns
+// and the raw result or the rpc error
+type recordEntry struct {
+	Block  uint64            `json:"block"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+	Result json.RawMessage   `json:"result,omitempty"`
+	Error  *recordedError    `json:"error,omitempty"`
+}
+
+// key identifies the call of e, replies to the same call are replayed in the order they were recorded
+func (e recordEntry) key() string {
+	return callKey(e.Method, e.Params)
+}
+
+func callKey(method string, params []json.RawMessage) string {
+	parts := make([]string, 0, len(params)+1)
+	parts = append(parts, method)
+	for _, p := range params {
+		parts = append(parts, string(p))
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// recordedError is an rpc error answered by the node, replayed with the same code and message
+type recordedError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *recordedError) Error() string  { return e.Message }
+func (e *recordedError) ErrorCode() int { return e.Code }
+
+// Recorder serializes every block header, log query result and contract call the ethereum connection
+// fetches to a recording directory for a later replay. Entries are written by a background goroutine, a
+// full buffer drops entries rather than delaying the poll loop. Close flushes the entries queued.
+type Recorder struct {
+	ch      chan recordEntry
+	done    chan error
+	mu      sync.Mutex
+	closed  bool
+	dropped uint64
+}
+
+// NewRecorder creates dir and starts recording to it, an existing recording is replaced
+func NewRecorder(dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(filepath.Join(dir, recordingFile))
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	if err := json.NewEncoder(w).Encode(map[string]int{"version": recordingVersion}); err != nil {
+		f.Close()
+		return nil, err
+	}
+	r := &Recorder{ch: make(chan recordEntry, recordBuffer), done: make(chan error, 1)}
+	go r.write(f, w)
+	return r, nil
+}
+
+// write appends the queued entries, flushing whenever the queue runs empty
+func (r *Recorder) write(f *os.File, w *bufio.Writer) {
+	enc := json.NewEncoder(w)
+	var err error
+	for e := range r.ch {
+		if err == nil {
+			err = enc.Encode(e)
+		}
+		if err == nil && len(r.ch) == 0 {
+			err = w.Flush()
+		}
+	}
+	if ferr := w.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	r.done <- err
+}
+
+// record queues e, dropping it when the buffer is full or the recorder closed
+func (r *Recorder) record(e recordEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	select {
+	case r.ch <- e:
+	default:
+		if r.dropped == 0 {
+			log.Warn("Recording buffer full, dropping entries", "method", e.Method, "block", e.Block)
+		}
+		r.dropped++
+	}
+}
+
+// Close writes the queued entries out and closes the recording
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	close(r.ch)
+	dropped := r.dropped
+	r.mu.Unlock()
+	err := <-r.done
+	if err == nil && dropped > 0 {
+		err = fmt.Errorf("recording incomplete, dropped %d entries", dropped)
+	}
+	return err
+}
+
+// Recording is a recording loaded for replay, it answers every call with the replies recorded for it in
+// order and fails once they are used up
+type Recording struct {
+	mu      sync.Mutex
+	replies map[string][]recordEntry
+}
+
+// LoadRecording reads the recording written to dir by a Recorder
+func LoadRecording(dir string) (*Recording, error) {
+	f, err := os.Open(filepath.Join(dir, recordingFile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(bufio.NewReader(f))
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := dec.Decode(&header); err != nil {
+		return nil, fmt.Errorf("failed to read the recording header: %w", err)
+	}
+	if header.Version != recordingVersion {
+		return nil, fmt.Errorf("unsupported recording version %d, expected %d", header.Version, recordingVersion)
+	}
+	rec := &Recording{replies: make(map[string][]recordEntry)}
+	for dec.More() {
+		var e recordEntry
+		if err := dec.Decode(&e); err != nil {
+			return nil, fmt.Errorf("failed to read the recording: %w", err)
+		}
+		rec.replies[e.key()] = append(rec.replies[e.key()], e)
+	}
+	return rec, nil
+}
+
+// next returns the next recorded reply to method with params
+func (r *Recording) next(method string, params []json.RawMessage) (json.RawMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := callKey(method, params)
+	replies := r.replies[key]
+	if len(replies) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrReplayExhausted, method)
+	}
+	e := replies[0]
+	r.replies[key] = replies[1:]
+	if e.Error != nil {
+		return nil, e.Error
+	}
+	return e.Result, nil
+}
+
+// rpcProxy serves the eth methods the listener calls through call, it sits between the ethclient of a
+// Connection and the node to record its answers or in place of the node to replay them
+type rpcProxy struct {
+	call func(ctx context.Context, method string, params []json.RawMessage) (json.RawMessage, error)
+}
+
+func (p *rpcProxy) forward(ctx context.Context, method string, params ...json.RawMessage) (json.RawMessage, error) {
+	// optional params the client left out arrive empty
+	for len(params) > 0 && len(params[len(params)-1]) == 0 {
+		params = params[:len(params)-1]
+	}
+	return p.call(ctx, method, params)
+}
+
+func (p *rpcProxy) BlockNumber(ctx context.Context) (json.RawMessage, error) {
+	return p.forward(ctx, "eth_blockNumber")
+}
+
+func (p *rpcProxy) ChainId(ctx context.Context) (json.RawMessage, error) {
+	return p.forward(ctx, "eth_chainId")
+}
+
+func (p *rpcProxy) GetBlockByNumber(ctx context.Context, number, full json.RawMessage) (json.RawMessage, error) {
+	return p.forward(ctx, "eth_getBlockByNumber", number, full)
+}
+
+func (p *rpcProxy) GetLogs(ctx context.Context, query json.RawMessage) (json.RawMessage, error) {
+	return p.forward(ctx, "eth_getLogs", query)
+}
+
+func (p *rpcProxy) Call(ctx context.Context, msg, block json.RawMessage) (json.RawMessage, error) {
+	return p.forward(ctx, "eth_call", msg, block)
+}
+
+func (p *rpcProxy) GetCode(ctx context.Context, addr, block json.RawMessage) (json.RawMessage, error) {
+	return p.forward(ctx, "eth_getCode", addr, block)
+}
+
+// dialProxy returns a client of an in-process server answering through call
+func dialProxy(call func(ctx context.Context, method string, params []json.RawMessage) (json.RawMessage, error)) (*rpc.Client, error) {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("eth", &rpcProxy{call: call}); err != nil {
+		return nil, err
+	}
+	return rpc.DialInProc(srv), nil
+}
+
+// recordingCall forwards a call to upstream and records its answer
+func recordingCall(upstream *rpc.Client, r *Recorder) func(context.Context, string, []json.RawMessage) (json.RawMessage, error) {
+	return func(ctx context.Context, method string, params []json.RawMessage) (json.RawMessage, error) {
+		args := make([]interface{}, len(params))
+		for i, p := range params {
+			args[i] = p
+		}
+		var result json.RawMessage
+		err := upstream.CallContext(ctx, &result, method, args...)
+		e := recordEntry{Block: entryBlock(method, params, result), Method: method, Params: params, Result: result}
+		if err != nil {
+			var rpcErr rpc.Error
+			if !errors.As(err, &rpcErr) {
+				// a failed transport isn't an answer of the node, a replay fails the call anew
+				return nil, err
+			}
+			e.Result, e.Error = nil, &recordedError{Code: rpcErr.ErrorCode(), Message: err.Error()}
+		}
+		r.record(e)
+		return result, err
+	}
+}
+
+// entryBlock returns the block a call concerns: the number of a returned header, the first block of a log
+// query or the block a call or code read is made at, 0 for the latest block or a call without one
+func entryBlock(method string, params []json.RawMessage, result json.RawMessage) uint64 {
+	var tag json.RawMessage
+	switch method {
+	case "eth_getBlockByNumber":
+		var header struct {
+			Number hexutil.Uint64 `json:"number"`
+		}
+		if json.Unmarshal(result, &header) == nil {
+			return uint64(header.Number)
+		}
+	case "eth_getLogs":
+		var query struct {
+			FromBlock json.RawMessage `json:"fromBlock"`
+		}
+		if len(params) > 0 && json.Unmarshal(params[0], &query) == nil {
+			tag = query.FromBlock
+		}
+	case "eth_call", "eth_getCode":
+		if len(params) > 1 {
+			tag = params[1]
+		}
+	}
+	var number hexutil.Uint64
+	if len(tag) > 0 && json.Unmarshal(tag, &number) == nil {
+		return uint64(number)
+	}
+	return 0
+}
@@ -0,0 +1,130 @@
+package ethereum
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+// A run replayed from its recording submits the same stake infos as the live run it was recorded from
+func TestRecorder_replay(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = false
+
+	a, b := ethcommon.BytesToAddress(WorkBase[0]), ethcommon.BytesToAddress(WorkBase[1])
+	balances := map[string]map[ethcommon.Address]int64{"latest": {a: 20}}
+	var tags []string
+	contract := stakingContract(t, balances, &tags)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	head := int64(0)
+	srv := newTestRPCServer(t, map[string]rpcHandler{
+		// the head moves an epoch per poll, b stakes from the second epoch on
+		"eth_getBlockByNumber": func(params []json.RawMessage) (interface{}, *rpcError) {
+			if head < 3000 {
+				head += 1000
+			} else {
+				cancel()
+			}
+			if head == 2000 {
+				balances["latest"][b] = 30
+			}
+			return testHeader(head), nil
+		},
+		"eth_call":    contract,
+		"eth_getCode": func(params []json.RawMessage) (interface{}, *rpcError) { return "0x01", nil },
+		"eth_getLogs": func(params []json.RawMessage) (interface{}, *rpcError) { return []*ethtypes.Log{}, nil },
+	})
+
+	run := func(conn *Connection) ([]substrate.MockCall, error) {
+		resetStakeInfoList()
+		sub := &substrate.MockSubmitter{}
+		l := &Listener{
+			Config: &config.Config{EpochSize: 1000, SubmitMode: config.SubmitModeFull, CatchUpEpochs: true,
+				PollInterval: config.Duration{Duration: time.Millisecond}, RetryInterval: config.Duration{Duration: time.Millisecond},
+				EthereumConfig: config.EthereumConfig{BlockConfirmations: big.NewInt(0), StartBlock: big.NewInt(0),
+					DepositContractAddr: a.Hex(), MigrationEventSig: "Migrated(address)"}},
+			Ethconn: conn,
+			Subconn: sub,
+			Stop:    make(chan struct{}, 1),
+			Clock:   &fakeClock{now: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+		}
+		_, err := l.Run(ctx)
+		return sub.Calls(), err
+	}
+
+	dir := t.TempDir()
+	rec, err := NewRecorder(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := NewConnection(srv.URL, true, make(chan struct{}))
+	live.Recorder = rec
+	if err := live.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	want, err := run(live)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("live Run() error = %v, want %v", err, context.Canceled)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("Recorder.Close() error = %v", err)
+	}
+	if len(want) != 3 {
+		t.Fatalf("live run submitted %d times, want once per epoch", len(want))
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, recordingFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, method := range []string{`"version":1`, "eth_getBlockByNumber", "eth_getLogs", "eth_call", `"block":2000`} {
+		if !strings.Contains(string(data), method) {
+			t.Errorf("recording doesn't hold %s", method)
+		}
+	}
+
+	recording, err := LoadRecording(dir)
+	if err != nil {
+		t.Fatalf("LoadRecording() error = %v", err)
+	}
+	replay := &Connection{Replay: recording, Stop: make(chan struct{})}
+	if err := replay.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	served := len(tags)
+	ctx = context.Background()
+	got, err := run(replay)
+	// the replay ends once the recorded heads are used up
+	if !errors.Is(err, ErrRetriesExceeded) {
+		t.Errorf("replayed Run() error = %v, want %v", err, ErrRetriesExceeded)
+	}
+	if len(tags) != served {
+		t.Errorf("replay called the node %d times", len(tags)-served)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("replay submitted %+v, want the live submissions %+v", got, want)
+	}
+}
+
+func TestLoadRecording_version(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, recordingFile), []byte(`{"version":99}`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRecording(dir); err == nil || !strings.Contains(err.Error(), "unsupported recording version 99") {
+		t.Errorf("LoadRecording() error = %v, want an unsupported version", err)
+	}
+}
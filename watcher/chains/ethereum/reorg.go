@@ -0,0 +1,202 @@
+package ethereum
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	eth "github.com/ethereum/go-ethereum"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// blockRef is a processed safe head and its hash, to tell when the chain reorged below it
+type blockRef struct {
+	number uint64
+	hash   ethcommon.Hash
+}
+
+// journaledDeposit is a deposit accumulated while polling a block, kept to revert it when that block is
+// reorged away
+type journaledDeposit struct {
+	polled uint64
+	key    depositKey
+	value  *big.Int
+}
+
+// reorgTolerance is the depth up to which a reorg is handled by re-scanning, BlockConfirmations by default
+func (l *Listener) reorgTolerance() uint64 {
+	if t := l.Config.EthereumConfig.ReorgTolerance; t != nil {
+		return *t
+	}
+	return l.Config.EthereumConfig.BlockConfirmations.Uint64()
+}
+
+// recordHead remembers the hash of the processed safe head number. Heads and journaled deposits more than
+// the tolerance below it are dropped, a reorg reaching below them is handled as a deep one.
+func (l *Listener) recordHead(number *big.Int) {
+	if !l.Config.EthereumConfig.DetectReorgs {
+		return
+	}
+	hash, err := l.Ethconn.BlockHash(number)
+	if err != nil {
+		log.Warn("Unable to get the hash of the processed block, reorgs below it are not detected", "block", number, "err", err)
+		return
+	}
+	l.heads = append(l.heads, blockRef{number: number.Uint64(), hash: hash})
+	var oldest uint64
+	if tolerance := l.reorgTolerance(); number.Uint64() > tolerance {
+		oldest = number.Uint64() - tolerance
+	}
+	for len(l.heads) > 1 && l.heads[0].number < oldest {
+		l.heads = l.heads[1:]
+	}
+	journal := l.depositJournal[:0]
+	for _, d := range l.depositJournal {
+		if d.polled >= oldest {
+			journal = append(journal, d)
+		}
+	}
+	l.depositJournal = journal
+}
+
+// journalDeposit records a deposit accumulated while polling block, for revertDeposits
+func (l *Listener) journalDeposit(polled *big.Int, key depositKey, value *big.Int) {
+	if !l.Config.EthereumConfig.DetectReorgs {
+		return
+	}
+	l.depositJournal = append(l.depositJournal, journaledDeposit{polled: polled.Uint64(), key: key, value: new(big.Int).Set(value)})
+}
+
+// forkPoint returns the newest recorded head still on the chain, false when none of them is
+func (l *Listener) forkPoint() (uint64, bool, error) {
+	for i := len(l.heads) - 1; i >= 0; i-- {
+		head := l.heads[i]
+		hash, err := l.Ethconn.BlockHash(new(big.Int).SetUint64(head.number))
+		if errors.Is(err, eth.NotFound) {
+			continue
+		} else if err != nil {
+			return 0, false, err
+		}
+		if hash == head.hash {
+			return head.number, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// checkReorg compares the recorded heads with the chain and returns the block polling continues after. A reorg
+// up to reorgTolerance blocks below current rewinds polling to the fork point, so the affected range is
+// scanned again, after reverting the deposits accumulated from it. A deeper one, or one whose deposits can't
+// be reverted, resyncs the stake infos from a full snapshot read at latest.
+func (l *Listener) checkReorg(current, latest *big.Int) (*big.Int, error) {
+	if !l.Config.EthereumConfig.DetectReorgs || len(l.heads) == 0 {
+		return current, nil
+	}
+	fork, ok, err := l.forkPoint()
+	if err != nil {
+		log.Warn("Unable to check the processed blocks for a reorg", "block", current, "err", err)
+		return current, nil
+	}
+	if ok && fork == l.heads[len(l.heads)-1].number {
+		return current, nil
+	}
+	l.stats.Reorgs++
+	tolerance := l.reorgTolerance()
+	if ok && current.Uint64()-fork <= tolerance && l.revertDeposits(fork) {
+		log.Warn("Chain reorged, scanning the affected blocks again", "fork", fork, "depth", current.Uint64()-fork, "current", current, "tolerance", tolerance)
+		for len(l.heads) > 0 && l.heads[len(l.heads)-1].number > fork {
+			l.heads = l.heads[:len(l.heads)-1]
+		}
+		// a boundary after the fork is on the new branch now and flushed again
+		if l.lastFlush != nil && l.lastFlush.Uint64() > fork {
+			l.lastFlush = nil
+		}
+		return new(big.Int).SetUint64(fork), nil
+	}
+	if ok {
+		log.Warn("Chain reorged deeper than the tolerance, resyncing the stake infos from a snapshot", "fork", fork, "depth", current.Uint64()-fork, "current", current, "tolerance", tolerance)
+	} else {
+		log.Warn("Chain reorged below every recorded block, resyncing the stake infos from a snapshot", "oldest", l.heads[0].number, "current", current, "tolerance", tolerance)
+	}
+	return l.resyncSnapshot(current, latest)
+}
+
+// revertDeposits subtracts the deposits accumulated while polling the blocks after fork from the accumulated
+// stakes. It returns false, leaving them alone, when a deposit isn't held in memory anymore.
+func (l *Listener) revertDeposits(fork uint64) bool {
+	reverted := make(map[depositKey]*big.Int)
+	kept := l.depositJournal[:0:0]
+	for _, d := range l.depositJournal {
+		if d.polled <= fork {
+			kept = append(kept, d)
+			continue
+		}
+		sum, ok := reverted[d.key]
+		if !ok {
+			sum = new(big.Int)
+			reverted[d.key] = sum
+		}
+		sum.Add(sum, d.value)
+	}
+	if len(reverted) == 0 {
+		return true
+	}
+	for key, sum := range reverted {
+		if total := contractTotals[key]; total == nil || total.Cmp(sum) < 0 {
+			log.Warn("Reorged deposit not held in memory, it can't be reverted", "contract", key.contract, "staker", key.staker)
+			return false
+		}
+	}
+
+	totals := contractTotals
+	keys := make([]depositKey, 0, len(totals))
+	for key, total := range totals {
+		if sum := reverted[key]; sum != nil {
+			total.Sub(total, sum)
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].contract != keys[j].contract {
+			return keys[i].contract.Hex() < keys[j].contract.Hex()
+		}
+		return keys[i].staker.Hex() < keys[j].staker.Hex()
+	})
+	resetStakeInfoList()
+	for _, key := range keys {
+		if totals[key].Sign() > 0 {
+			addDeposit(l.Config.EthereumConfig.CrossContractAggregation, key.contract, key.staker, totals[key])
+		}
+	}
+	l.depositJournal = kept
+	if len(contractTotals) > 0 {
+		if err := l.checkpointDeposits(new(big.Int).SetUint64(fork)); err != nil {
+			log.Warn("Failed to checkpoint deposits", "block", fork, "error", err)
+		}
+	} else {
+		l.clearDepositCheckpoint()
+	}
+	log.Info("Reverted the deposits of the reorged blocks", "fork", fork, "stakers", len(reverted))
+	return true
+}
+
+// resyncSnapshot drops the accumulated deposits and the prefetched snapshot and submits the full set read
+// from the deposit contract at latest, whatever the epoch, as on the first sync of a run. A failed resync
+// returns ErrReorgTooDeep.
+func (l *Listener) resyncSnapshot(current, latest *big.Int) (*big.Int, error) {
+	resetStakeInfoList()
+	l.clearDepositCheckpoint()
+	l.clearSpill()
+	l.depositJournal = nil
+	l.heads = nil
+	l.snapshot = nil
+	l.pending = nil
+	l.lastSubmitted = nil
+	if err := l.runEpoch(latest, l.GetStakeInfo); err != nil {
+		return current, fmt.Errorf("%w: unable to resync the stake infos at block %s: %v", ErrReorgTooDeep, latest, err)
+	}
+	l.recordHead(latest)
+	return latest, nil
+}
@@ -0,0 +1,157 @@
+package ethereum
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+	"github.com/NuLink-network/watcher/watcher/sink"
+)
+
+func TestListener_checkReorg(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = false
+
+	tests := []struct {
+		name      string
+		tolerance uint64
+		// the deposit contract holds no stakers once the chain forked, aborting the resync
+		emptyFork bool
+		wantErr   error
+		// the block and balance of every published submission
+		wantBlocks   []int64
+		wantBalances []string
+	}{
+		// the reorg of the 150 blocks after 905 is scanned again, the boundary 1000 is synced anew
+		{name: "shallow", tolerance: 200, wantBlocks: []int64{1000, 1000}, wantBalances: []string{"10", "20"}},
+		// the reorg reaches below the recorded heads, the full set is read at the safe head
+		{name: "deep", tolerance: 10, wantBlocks: []int64{1000, 1065}, wantBalances: []string{"10", "20"}},
+		// the snapshot of a deep reorg holds no stakers, the resync aborts the run
+		{name: "deep-resync-fails", tolerance: 10, emptyFork: true, wantErr: ErrReorgTooDeep, wantBlocks: []int64{1000}, wantBalances: []string{"10"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetStakeInfoList()
+			a := common.BytesToAddress(WorkBase[0])
+			balances := map[string]map[common.Address]int64{"latest": {a: 10}}
+			var tags []string
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			heads := []int64{905, 1055, 1065}
+			polls, forked := 0, false
+			conn := newTestConnection(t, map[string]rpcHandler{
+				// the third poll sees the blocks after 905 reorged, moving the balance of a
+				"eth_getBlockByNumber": func(params []json.RawMessage) (interface{}, *rpcError) {
+					var tag string
+					_ = json.Unmarshal(params[0], &tag)
+					var number int64
+					if tag == "latest" {
+						if polls == len(heads) {
+							cancel()
+							return testHeader(heads[len(heads)-1]), nil
+						}
+						if number = heads[polls]; polls == 2 {
+							forked = true
+							balances["latest"][a] = 20
+							if tt.emptyFork {
+								delete(balances["latest"], a)
+							}
+						}
+						polls++
+					} else {
+						n, _ := new(big.Int).SetString(tag[2:], 16)
+						number = n.Int64()
+					}
+					h := testHeader(number)
+					if forked && number > 905 {
+						h.Extra = []byte("fork")
+					}
+					return h, nil
+				},
+				"eth_call":    stakingContract(t, balances, &tags),
+				"eth_getCode": func(params []json.RawMessage) (interface{}, *rpcError) { return "0x01", nil },
+				"eth_getLogs": func(params []json.RawMessage) (interface{}, *rpcError) { return []*ethtypes.Log{}, nil },
+			})
+			published := &fakeSink{}
+			l := &Listener{
+				Config: &config.Config{EpochSize: 1000, SubmitMode: config.SubmitModeFull, CatchUpEpochs: true, ZeroStakersPolicy: config.ZeroStakersAbort,
+					PollInterval: config.Duration{Duration: time.Millisecond},
+					EthereumConfig: config.EthereumConfig{BlockConfirmations: big.NewInt(0), StartBlock: big.NewInt(0),
+						DepositContractAddr: a.Hex(), MigrationEventSig: "Migrated(address)",
+						DetectReorgs: true, ReorgTolerance: &tt.tolerance}},
+				Ethconn: conn,
+				Subconn: &substrate.MockSubmitter{},
+				Sinks:   []sink.Sink{published},
+				Stop:    make(chan struct{}, 1),
+			}
+			wantErr := tt.wantErr
+			if wantErr == nil {
+				wantErr = context.Canceled
+			}
+			stats, err := l.Run(ctx)
+			if !errors.Is(err, wantErr) {
+				t.Fatalf("Run() error = %v, want %v", err, wantErr)
+			}
+			if stats.Reorgs != 1 {
+				t.Errorf("Run() detected %d reorgs, want 1", stats.Reorgs)
+			}
+			if len(published.updates) != len(tt.wantBlocks) {
+				t.Fatalf("published %d updates, want %d", len(published.updates), len(tt.wantBlocks))
+			}
+			for i, u := range published.updates {
+				if u.Block.Int64() != tt.wantBlocks[i] || len(u.Stakers) != 1 || u.Stakers[0].LockedBalance != tt.wantBalances[i] {
+					t.Errorf("update %d at block %s = %+v, want block %d with balance %s", i, u.Block, u.Stakers, tt.wantBlocks[i], tt.wantBalances[i])
+				}
+			}
+		})
+	}
+}
+
+func TestListener_revertDeposits(t *testing.T) {
+	contract := common.HexToAddress("0xa1")
+	staker := common.HexToAddress("0x01")
+	data := append(common.BigToHash(big.NewInt(10)).Bytes(), common.BigToHash(big.NewInt(1)).Bytes()...)
+	logs := []*ethtypes.Log{{Address: contract, Topics: []common.Hash{Deposited.GetTopic(), common.BytesToHash(staker[:])}, Data: data}}
+	conn := newTestConnection(t, map[string]rpcHandler{
+		"eth_getLogs": func(params []json.RawMessage) (interface{}, *rpcError) { return logs, nil },
+	})
+	l := &Listener{
+		Config: &config.Config{EpochSize: 1000, MaxEventsPerBlock: config.MaxEventsPerBlock, EthereumConfig: config.EthereumConfig{
+			DepositContractAddr: contract.Hex(),
+			StakerTopic:         &config.TopicSlice{Index: 1, Offset: 12, Length: 20},
+			DetectReorgs:        true,
+		}},
+		Ethconn: conn,
+	}
+	defer resetStakeInfoList()
+	resetStakeInfoList()
+
+	for _, block := range []int64{1001, 1002, 1003} {
+		if err := l.getDepositEventsForBlock(big.NewInt(block)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !l.revertDeposits(1001) {
+		t.Fatal("revertDeposits() = false, want the deposits after 1001 reverted")
+	}
+	if len(stakeInfoList) != 1 || stakeInfoList[0].LockedBalance.Int64() != 10 || len(l.depositJournal) != 1 {
+		t.Errorf("after revertDeposits() stakes = %v, journal = %d, want the deposit of 1001 only", stakeInfoList, len(l.depositJournal))
+	}
+
+	// a deposit spilled out of memory can't be reverted
+	if err := l.getDepositEventsForBlock(big.NewInt(1002)); err != nil {
+		t.Fatal(err)
+	}
+	contractTotals = make(map[depositKey]*big.Int)
+	if l.revertDeposits(1001) {
+		t.Errorf("revertDeposits() of a deposit not held in memory = true, want false")
+	}
+}
@@ -0,0 +1,167 @@
+package ethereum
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/notify"
+)
+
+// auditTailBytes is how much of the end of the primary's audit log is read for its latest submission
+const auditTailBytes = 64 << 10
+
+// ownSubmissions is how many encodings of its own submissions a replica that took over remembers, to tell
+// them apart from the primary's in the pallet storage
+const ownSubmissions = 16
+
+// replicaState tracks a hot standby watcher. It follows the chain like the primary but holds its
+// submissions back until the primary was silent for the Replica timeout, then takes over until the primary
+// submits again.
+type replicaState struct {
+	active   bool      // took over submitting from the primary
+	since    time.Time // when the replica went into standby or took over
+	lastSeen time.Time // latest submission of the primary seen
+	read     bool      // stored holds the pallet storage as read before
+	stored   []byte
+	own      [][]byte // encodings of the latest submissions while active
+}
+
+// standby reports whether the listener is a replica leaving the submissions to the primary
+func (l *Listener) standby() bool {
+	return l.Config.Replica.Enabled && !l.replica.active
+}
+
+// checkPrimary takes over submitting once the primary was silent for the Replica timeout and steps down
+// again when the primary submits after the takeover. A failed look at the primary changes nothing.
+func (l *Listener) checkPrimary(now time.Time) {
+	if !l.Config.Replica.Enabled {
+		return
+	}
+	r := &l.replica
+	if r.since.IsZero() {
+		r.since = now
+		log.Info("Replica in standby, waiting for the primary to go silent", "timeout", l.Config.Replica.Timeout)
+	}
+	seen, err := l.primaryActivity(now)
+	if err != nil {
+		log.Warn("Failed to look for the primary's submissions", "error", err)
+		return
+	}
+	if seen.After(r.lastSeen) {
+		r.lastSeen = seen
+	}
+
+	if !r.active {
+		silent := r.since
+		if r.lastSeen.After(silent) {
+			silent = r.lastSeen
+		}
+		if now.Sub(silent) <= l.Config.Replica.Timeout.Duration {
+			return
+		}
+		r.active, r.since, r.own = true, now, nil
+		log.Warn("Primary silent, replica takes over submitting", "silentFor", now.Sub(silent))
+		l.Alerts.Alert(notify.KindReplica, fmt.Sprintf("nulink watcher: primary silent for %s, replica takes over submitting", now.Sub(silent).Round(time.Second)))
+		return
+	}
+	if r.lastSeen.After(r.since) {
+		r.active, r.since, r.own = false, now, nil
+		log.Warn("Primary is submitting again, replica steps down", "primarySubmitted", r.lastSeen)
+		l.Alerts.Alert(notify.KindReplica, "nulink watcher: primary is submitting again, replica steps down")
+	}
+}
+
+// primaryActivity returns when the primary submitted last, the zero time if that isn't known yet. The
+// primary's audit log is used when configured, otherwise a change of the pallet storage that isn't one of
+// the replica's own submissions counts as a submission of the primary at now.
+func (l *Listener) primaryActivity(now time.Time) (time.Time, error) {
+	if path := l.Config.Replica.PrimaryAuditLog; path != "" {
+		return lastAuditSuccess(path)
+	}
+	reader, ok := l.Subconn.(substrate.StakeInfoReader)
+	if !ok {
+		return time.Time{}, fmt.Errorf("submitter can't read the pallet storage, configure the primary's audit log")
+	}
+	data, err := reader.StoredStakeInfos()
+	if err != nil {
+		return time.Time{}, err
+	}
+	r := &l.replica
+	changed := r.read && !bytes.Equal(data, r.stored)
+	r.read, r.stored = true, data
+	if !changed {
+		return time.Time{}, nil
+	}
+	for _, own := range r.own {
+		if bytes.Equal(data, own) {
+			return time.Time{}, nil
+		}
+	}
+	return now, nil
+}
+
+// recordOwnSubmission remembers the stored form of a payload submitted by a replica that took over
+func (l *Listener) recordOwnSubmission(payload interface{}) {
+	if !l.Config.Replica.Enabled || !l.replica.active {
+		return
+	}
+	data, err := types.EncodeToBytes(payload)
+	if err != nil {
+		return
+	}
+	r := &l.replica
+	r.own = append(r.own, data)
+	if len(r.own) > ownSubmissions {
+		r.own = r.own[len(r.own)-ownSubmissions:]
+	}
+}
+
+// lastAuditSuccess returns the time of the latest successful submission in the last auditTailBytes of
+// the audit log at path, the zero time if there is none or the file doesn't exist yet
+func lastAuditSuccess(path string) (time.Time, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return time.Time{}, err
+	}
+	offset := fi.Size() - auditTailBytes
+	if offset < 0 {
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return time.Time{}, err
+	}
+
+	var last time.Time
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 4096), auditTailBytes)
+	// the first line of the tail may be cut, it doesn't decode and is skipped like any other bad line
+	for scanner.Scan() {
+		var r AuditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil || r.Result != AuditResultSuccess {
+			continue
+		}
+		if r.Time.After(last) {
+			last = r.Time
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return time.Time{}, fmt.Errorf("failed to read the audit log %s: %w", path, err)
+	}
+	return last, nil
+}
@@ -0,0 +1,136 @@
+package ethereum
+
+import (
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+	"github.com/NuLink-network/watcher/watcher/notify"
+)
+
+func replicaListener(t *testing.T, sub *substrate.MockSubmitter, auditLog string) (*Listener, testNotifier) {
+	n := make(testNotifier, 4)
+	l := &Listener{
+		Config: &config.Config{EpochSize: 1000, SubmitMode: config.SubmitModeFull, Replica: config.ReplicaConfig{
+			Enabled: true, Timeout: config.Duration{Duration: time.Hour}, PrimaryAuditLog: auditLog,
+		}},
+		Ethconn: newTestConnection(t, nil),
+		Source:  emptySource{},
+		Subconn: sub,
+		Alerts:  notify.NewAlerter(n, 3, time.Second),
+	}
+	return l, n
+}
+
+func wantReplicaAlert(t *testing.T, n testNotifier) {
+	t.Helper()
+	select {
+	case e := <-n:
+		if e.Kind != notify.KindReplica {
+			t.Errorf("alert kind = %s, want %s", e.Kind, notify.KindReplica)
+		}
+	case <-time.After(time.Second):
+		t.Error("no replica alert sent")
+	}
+}
+
+func TestListener_checkPrimaryAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "primary.jsonl")
+	l, n := replicaListener(t, &substrate.MockSubmitter{}, path)
+	primary := NewAuditLog(path)
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		name       string
+		record     *AuditRecord // appended to the primary's audit log before the check
+		at         time.Duration
+		wantActive bool
+	}{
+		{name: "no audit log yet", at: 0},
+		{name: "primary submits", record: &AuditRecord{Result: AuditResultSuccess, Time: t0.Add(10 * time.Minute)}, at: 30 * time.Minute},
+		{name: "silent below the timeout", at: 69 * time.Minute},
+		{name: "silent beyond the timeout", at: 71 * time.Minute, wantActive: true},
+		{name: "failed submission of the primary", record: &AuditRecord{Result: AuditResultFailure, Time: t0.Add(80 * time.Minute)}, at: 81 * time.Minute, wantActive: true},
+		{name: "primary submits again", record: &AuditRecord{Result: AuditResultSuccess, Time: t0.Add(90 * time.Minute)}, at: 91 * time.Minute},
+		{name: "standby after stepping down", at: 150 * time.Minute},
+	}
+	for _, s := range steps {
+		if s.record != nil {
+			s.record.Block = big.NewInt(1000)
+			if err := primary.Append(*s.record); err != nil {
+				t.Fatal(err)
+			}
+		}
+		l.checkPrimary(t0.Add(s.at))
+		if l.replica.active != s.wantActive || l.standby() == s.wantActive {
+			t.Errorf("%s: active = %v, want %v", s.name, l.replica.active, s.wantActive)
+		}
+	}
+	wantReplicaAlert(t, n)
+	wantReplicaAlert(t, n)
+}
+
+func TestListener_checkPrimaryStorage(t *testing.T) {
+	sub := &substrate.MockSubmitter{Store: true}
+	l, n := replicaListener(t, sub, "")
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// the storage read first is only the baseline, a change afterwards is a submission of the primary
+	sub.SetStored([]byte{1})
+	l.checkPrimary(t0)
+	sub.SetStored([]byte{2})
+	l.checkPrimary(t0.Add(30 * time.Minute))
+	l.checkPrimary(t0.Add(89 * time.Minute))
+	if l.replica.active {
+		t.Fatal("took over 59 minutes after the primary submitted")
+	}
+	l.checkPrimary(t0.Add(91 * time.Minute))
+	if !l.replica.active {
+		t.Fatal("didn't take over after the primary was silent for the timeout")
+	}
+	wantReplicaAlert(t, n)
+
+	// the replica's own submission doesn't count as the primary's
+	if err := l.submitStakeInfos(big.NewInt(1000), substrate.StakeInfos{}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	l.checkPrimary(t0.Add(92 * time.Minute))
+	if !l.replica.active {
+		t.Fatal("stepped down after its own submission")
+	}
+
+	sub.SetStored([]byte{3})
+	l.checkPrimary(t0.Add(93 * time.Minute))
+	if l.replica.active {
+		t.Fatal("didn't step down after the primary submitted again")
+	}
+	wantReplicaAlert(t, n)
+}
+
+func TestListener_replicaStandby(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = false
+
+	sub := &substrate.MockSubmitter{}
+	l, _ := replicaListener(t, sub, filepath.Join(t.TempDir(), "primary.jsonl"))
+	for _, block := range []int64{1000, 1010} {
+		if err := l.syncStakeInfos(big.NewInt(block)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls := len(sub.Calls()); calls != 0 || l.pending == nil {
+		t.Fatalf("submitted %d times in standby, want the update held", calls)
+	}
+
+	// once the replica took over the held update is submitted
+	l.checkPrimary(time.Now().Add(2 * time.Hour))
+	if err := l.syncStakeInfos(big.NewInt(1011)); err != nil {
+		t.Fatal(err)
+	}
+	if calls := len(sub.Calls()); calls != 1 || l.pending != nil {
+		t.Errorf("submitted %d times after taking over, want the held update once", calls)
+	}
+}
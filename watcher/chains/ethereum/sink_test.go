@@ -0,0 +1,134 @@
+package ethereum
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+	"github.com/NuLink-network/watcher/watcher/sink"
+)
+
+// fakeSink records the published updates, a non nil err fails every publication
+type fakeSink struct {
+	err     error
+	updates []sink.Update
+}
+
+func (s *fakeSink) Name() string {
+	return "fake"
+}
+
+func (s *fakeSink) Publish(ctx context.Context, u sink.Update) error {
+	s.updates = append(s.updates, u)
+	return s.err
+}
+
+func TestListener_publishSinks(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = false
+
+	path := filepath.Join(t.TempDir(), "stake-info.json")
+	failing := &fakeSink{err: errors.New("broker down")}
+	recording := &fakeSink{}
+	sub := &substrate.MockSubmitter{}
+	l := &Listener{
+		Config:            &config.Config{EpochSize: 1000, SubmitMode: config.SubmitModeFull},
+		Ethconn:           newTestConnection(t, nil),
+		Source:            emptySource{},
+		Subconn:           sub,
+		LastStakeInfoPath: path,
+		Sinks:             []sink.Sink{failing, recording},
+	}
+	if err := l.syncStakeInfos(big.NewInt(2000)); err != nil {
+		t.Fatalf("syncStakeInfos() error = %v", err)
+	}
+	if len(sub.Calls()) != 1 {
+		t.Errorf("submitted %d times, want 1", len(sub.Calls()))
+	}
+	for _, s := range []*fakeSink{failing, recording} {
+		if len(s.updates) != 1 || s.updates[0].Epoch != 2 || s.updates[0].Block.Int64() != 2000 {
+			t.Errorf("sink received %+v, want the update of epoch 2", s.updates)
+		}
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("stake info file not written after a sink failure: %v", err)
+	}
+
+	// in maintenance nothing is submitted, so nothing is published either
+	l.SetMaintenance(true)
+	if err := l.syncStakeInfos(big.NewInt(3000)); err != nil {
+		t.Fatalf("syncStakeInfos() error = %v", err)
+	}
+	if len(recording.updates) != 1 {
+		t.Errorf("sink received %d updates, want 1", len(recording.updates))
+	}
+}
+
+func TestListener_exportEvents(t *testing.T) {
+	contract := common.HexToAddress("0xa1")
+	data := append(common.BigToHash(big.NewInt(10)).Bytes(), common.BigToHash(big.NewInt(2)).Bytes()...)
+	logs := []*ethtypes.Log{{Address: contract, TxHash: common.HexToHash("0xab"), Topics: []common.Hash{Deposited.GetTopic(), common.HexToHash("0x01")}, Data: data}}
+	cfg := &config.Config{EpochSize: 1000, MaxEventsPerBlock: config.MaxEventsPerBlock, EthereumConfig: config.EthereumConfig{
+		DepositContractAddr: contract.Hex(),
+		StakerTopic:         &config.TopicSlice{Index: 1, Offset: 12, Length: 20},
+	}}
+	tests := []struct {
+		name     string
+		handlers map[string]rpcHandler
+		want     string
+	}{
+		{name: "block time", handlers: map[string]rpcHandler{
+			"eth_getBlockByNumber": func(params []json.RawMessage) (interface{}, *rpcError) {
+				header := testHeader(990)
+				header.Time = 1646370367
+				return header, nil
+			},
+		}, want: "2022-03-04T05:06:07Z"},
+		// the events are still exported, without their timestamp
+		{name: "block time unknown", handlers: map[string]rpcHandler{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.handlers["eth_getLogs"] = func(params []json.RawMessage) (interface{}, *rpcError) { return logs, nil }
+			path := filepath.Join(t.TempDir(), "deposits.csv")
+			l := &Listener{Config: cfg, Ethconn: newTestConnection(t, tt.handlers), Events: &sink.EventCSV{Path: path}}
+			defer resetStakeInfoList()
+			resetStakeInfoList()
+
+			if err := l.getDepositEventsForBlock(big.NewInt(999)); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := "block,txHash,staker,value,periods,timestamp\n" +
+				"999,0x00000000000000000000000000000000000000000000000000000000000000ab,0x0000000000000000000000000000000000000001,10,2," + tt.want + "\n"
+			if string(got) != want {
+				t.Errorf("csv =\n%s\nwant\n%s", got, want)
+			}
+			if len(stakeInfoList) != 1 {
+				t.Errorf("accumulated %d stake infos, want the deposit", len(stakeInfoList))
+			}
+		})
+	}
+
+	// without an export the events aren't collected
+	l := &Listener{Config: cfg, Ethconn: newTestConnection(t, map[string]rpcHandler{
+		"eth_getLogs": func(params []json.RawMessage) (interface{}, *rpcError) { return logs, nil },
+	})}
+	defer resetStakeInfoList()
+	if err := l.getDepositEventsForBlock(big.NewInt(999)); err != nil || len(l.exported) != 0 {
+		t.Errorf("getDepositEventsForBlock() error = %v, collected %d events", err, len(l.exported))
+	}
+}
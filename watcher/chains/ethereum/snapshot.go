@@ -0,0 +1,77 @@
+package ethereum
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+// snapshot is a read of the stake infos running in the background for the epoch boundary, started at block.
+// infos and err are set before done is closed. A preload is taken by the first epoch flush, whichever
+// boundary it is.
+type snapshot struct {
+	boundary uint64
+	block    *big.Int
+	preload  bool
+	done     chan struct{}
+	infos    substrate.StakeInfos
+	err      error
+}
+
+// prefetchSnapshot starts reading the stake infos for the next epoch boundary in the background once block
+// is within SnapshotLead blocks of it, so the read doesn't hold up polling at the boundary. It does nothing
+// unless ParallelSnapshot is enabled or when the snapshot of that boundary was started already.
+func (l *Listener) prefetchSnapshot(block *big.Int) {
+	if !l.Config.ParallelSnapshot {
+		return
+	}
+	next := l.Config.NextEpochBoundary(block.Uint64())
+	if next-block.Uint64() > l.Config.SnapshotLead || (l.snapshot != nil && l.snapshot.boundary == next && !l.snapshot.preload) {
+		return
+	}
+	// the filter is loaded and the client picked here, the background read must not race Reconnect
+	filter, err := l.stakerFilter()
+	if err != nil {
+		log.Warn("Failed to prefetch the stake info snapshot", "boundary", next, "error", err)
+		return
+	}
+	client := l.Ethconn.Client
+	s := &snapshot{boundary: next, block: new(big.Int).Set(block), done: make(chan struct{})}
+	l.snapshot = s
+	log.Info("Prefetching the stake info snapshot", "boundary", next, "block", block)
+	go l.readSnapshot(s, client, filter)
+}
+
+// readSnapshot reads the stake infos of s through caller and closes its done channel
+func (l *Listener) readSnapshot(s *snapshot, caller bind.ContractCaller, filter *config.StakerFilter) {
+	defer close(s.done)
+	infos, skipped, err := l.readStakeSource(caller, new(big.Int).SetUint64(s.boundary), filter)
+	if err == nil && skipped > 0 {
+		err = fmt.Errorf("%d stakers couldn't be read", skipped)
+	}
+	s.infos, s.err = infos, err
+}
+
+// epochStakeInfos returns the stake infos for the epoch boundary at block: the prefetched snapshot of that
+// boundary or the startup preload, waiting for it to complete, or the stake infos read now when there is
+// none or it failed
+func (l *Listener) epochStakeInfos(block *big.Int) (substrate.StakeInfos, error) {
+	s := l.snapshot
+	l.snapshot = nil
+	if s != nil && (s.boundary == block.Uint64() || s.preload) {
+		<-s.done
+		if s.err == nil && len(s.infos) == 0 {
+			log.Warn("Prefetched stake info snapshot is empty, reading the stake infos now", "boundary", block)
+		} else if s.err == nil {
+			log.Info("Using the prefetched stake info snapshot", "boundary", block, "block", s.block, "count", len(s.infos), "preload", s.preload)
+			return s.infos, nil
+		}
+		log.Warn("Prefetched stake info snapshot failed, reading the stake infos now", "boundary", block, "error", s.err)
+	}
+	return l.GetStakeInfo(block)
+}
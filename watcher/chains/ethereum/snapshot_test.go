@@ -0,0 +1,108 @@
+package ethereum
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+// Run with -race: the snapshot reads through the staker cache while the main path polls, reads the
+// metrics and reads the stake infos itself
+func TestListener_prefetchSnapshot(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = false
+
+	a, b := ethcommon.BytesToAddress(WorkBase[0]), ethcommon.BytesToAddress(WorkBase[1])
+	var mu sync.Mutex
+	var tags []string
+	contract := stakingContract(t, map[string]map[ethcommon.Address]int64{"latest": {a: 20, b: 30}}, &tags)
+	calls := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(tags)
+	}
+	conn := newTestConnection(t, map[string]rpcHandler{
+		"eth_call": func(params []json.RawMessage) (interface{}, *rpcError) {
+			mu.Lock()
+			defer mu.Unlock()
+			return contract(params)
+		},
+	})
+	sub := &substrate.MockSubmitter{}
+	cfg := &config.Config{
+		EpochSize:        100,
+		ParallelSnapshot: true,
+		SnapshotLead:     10,
+		StakerCache:      config.StakerCacheConfig{Size: 10, StaleEpochs: 1},
+		EthereumConfig:   config.EthereumConfig{BlockConfirmations: big.NewInt(0)},
+	}
+	l := &Listener{Config: cfg, Ethconn: conn, Subconn: sub}
+
+	// too far from the boundary at 100
+	l.prefetchSnapshot(big.NewInt(80))
+	if l.snapshot != nil {
+		t.Fatalf("prefetchSnapshot(80) started a snapshot for boundary %d", l.snapshot.boundary)
+	}
+	l.prefetchSnapshot(big.NewInt(91))
+	s := l.snapshot
+	if s == nil || s.boundary != 100 {
+		t.Fatalf("prefetchSnapshot(91) snapshot = %+v, want one for boundary 100", s)
+	}
+	l.prefetchSnapshot(big.NewInt(92))
+	if l.snapshot != s {
+		t.Errorf("prefetchSnapshot(92) started a second snapshot for boundary 100")
+	}
+
+	// polling goes on while the snapshot runs
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, err := l.GetStakeInfo(big.NewInt(100)); err != nil {
+			t.Errorf("GetStakeInfo() error = %v", err)
+		}
+	}()
+	for block := int64(93); block < 100; block++ {
+		if err := l.syncStakeInfos(big.NewInt(block)); err != nil {
+			t.Fatal(err)
+		}
+		l.metricsSnapshot(0)
+	}
+	wg.Wait()
+
+	<-s.done
+	before := calls()
+	if err := l.syncStakeInfos(big.NewInt(100)); err != nil {
+		t.Fatal(err)
+	}
+	if got := calls(); got != before {
+		t.Errorf("the boundary called the contract %d times, want the snapshot swapped in", got-before)
+	}
+	if l.snapshot != nil {
+		t.Errorf("the snapshot wasn't consumed at the boundary")
+	}
+	submitted := sub.Calls()
+	infos, _ := submitted[len(submitted)-1].Args[0].(substrate.StakeInfos)
+	if len(infos) != 2 || infos[0].LockedBalance.Int.Int64() != 30 {
+		t.Errorf("submitted %v at the boundary, want the snapshot's stakers", infos)
+	}
+
+	// a snapshot of another boundary isn't used, nor one that failed
+	l.snapshot = &snapshot{boundary: 300, done: make(chan struct{})}
+	if infos, err := l.epochStakeInfos(big.NewInt(200)); err != nil || len(infos) != 2 {
+		t.Errorf("epochStakeInfos(200) = %v, %v, want the stake infos read now", infos, err)
+	}
+	failed := &snapshot{boundary: 200, done: make(chan struct{}), err: errors.New("node unavailable")}
+	close(failed.done)
+	l.snapshot = failed
+	if infos, err := l.epochStakeInfos(big.NewInt(200)); err != nil || len(infos) != 2 {
+		t.Errorf("epochStakeInfos(200) after a failed snapshot = %v, %v, want the stake infos read now", infos, err)
+	}
+}
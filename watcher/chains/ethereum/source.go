@@ -0,0 +1,194 @@
+package ethereum
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+// StakeSource reads the stake infos of all allowed stakers as of block and returns how many stakers couldn't
+// be read. The deposit contract read over rpc is the default source, a Listener falls back to it whenever
+// its Source fails.
+type StakeSource interface {
+	Name() string
+	StakeInfos(block *big.Int, filter *config.StakerFilter) (substrate.StakeInfos, uint64, error)
+}
+
+// chainSource reads the stake infos from the deposit contract through caller
+type chainSource struct {
+	l      *Listener
+	caller bind.ContractCaller
+}
+
+func (s chainSource) Name() string {
+	return "rpc"
+}
+
+func (s chainSource) StakeInfos(block *big.Int, filter *config.StakerFilter) (substrate.StakeInfos, uint64, error) {
+	return s.l.fetchStakeInfos(s.caller, nil, block, filter)
+}
+
+// readStakeSource reads the stake infos from the Source, falling back to the deposit contract through caller
+// when there is none or it fails
+func (l *Listener) readStakeSource(caller bind.ContractCaller, block *big.Int, filter *config.StakerFilter) (substrate.StakeInfos, uint64, error) {
+	chain := chainSource{l: l, caller: caller}
+	if l.Source == nil {
+		return chain.StakeInfos(block, filter)
+	}
+	infos, skipped, err := l.Source.StakeInfos(block, filter)
+	if err == nil {
+		return infos, skipped, nil
+	}
+	log.Warn("failed to read the stake infos, falling back to the deposit contract", "source", l.Source.Name(), "block", block, "error", err)
+	return chain.StakeInfos(block, filter)
+}
+
+// DefaultSubgraphQuery pages through the stakers of a subgraph indexing the deposit contract as of a block
+const DefaultSubgraphQuery = `query($first: Int!, $lastId: String!, $block: Int!) {
+  stakers(first: $first, where: {id_gt: $lastId}, orderBy: id, block: {number: $block}) { id worker value }
+}`
+
+// SubgraphSource reads the stake infos from a GraphQL endpoint indexing the deposit contract. Query takes the
+// variables first, lastId and block and returns up to first stakers ordered by an id greater than lastId,
+// each with its id, the staker address, its worker and its locked value as a decimal string.
+type SubgraphSource struct {
+	URL              string
+	Query            string
+	PageSize         int
+	SeparateOperator bool
+	OverflowPolicy   string
+	Client           *http.Client
+}
+
+// NewSubgraphSource creates the configured subgraph source
+func NewSubgraphSource(cfg *config.Config) *SubgraphSource {
+	query := cfg.StakeSource.Query
+	if query == "" {
+		query = DefaultSubgraphQuery
+	}
+	return &SubgraphSource{
+		URL:              cfg.StakeSource.URL,
+		Query:            query,
+		PageSize:         cfg.StakeSource.PageSize,
+		SeparateOperator: cfg.EthereumConfig.SeparateOperator,
+		OverflowPolicy:   cfg.OverflowPolicy,
+		Client:           &http.Client{Timeout: cfg.StakeSource.Timeout.Duration},
+	}
+}
+
+func (s *SubgraphSource) Name() string {
+	return "subgraph " + s.URL
+}
+
+// subgraphStaker is a staker as returned by the subgraph query
+type subgraphStaker struct {
+	ID     string `json:"id"`
+	Worker string `json:"worker"`
+	Value  string `json:"value"`
+}
+
+type subgraphResponse struct {
+	Data struct {
+		Stakers []subgraphStaker `json:"stakers"`
+	} `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func (s *SubgraphSource) StakeInfos(block *big.Int, filter *config.StakerFilter) (substrate.StakeInfos, uint64, error) {
+	if block == nil {
+		return nil, 0, fmt.Errorf("the subgraph needs a block to read the stakers at")
+	}
+	pageSize := s.PageSize
+	if pageSize <= 0 {
+		pageSize = config.SubgraphPageSize
+	}
+	stakeInfos := make(substrate.StakeInfos, 0)
+	var filtered uint64
+	lastID := ""
+	for {
+		page, err := s.page(pageSize, lastID, block)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, st := range page {
+			if !ethcommon.IsHexAddress(st.ID) {
+				return nil, 0, fmt.Errorf("invalid staker id %q", st.ID)
+			}
+			staker := ethcommon.HexToAddress(st.ID)
+			if !filter.Allowed(staker) {
+				log.Trace("skip filtered staker", "staker", staker)
+				filtered++
+				continue
+			}
+			var worker ethcommon.Address
+			if st.Worker != "" {
+				if !ethcommon.IsHexAddress(st.Worker) {
+					return nil, 0, fmt.Errorf("invalid worker %q of staker %s", st.Worker, staker.Hex())
+				}
+				worker = ethcommon.HexToAddress(st.Worker)
+			}
+			value, ok := new(big.Int).SetString(st.Value, 10)
+			if !ok || value.Sign() < 0 {
+				return nil, 0, fmt.Errorf("invalid value %q of staker %s", st.Value, staker.Hex())
+			}
+			if value, ok = fitU128(s.OverflowPolicy, value, "staker", staker); !ok {
+				continue
+			}
+			stakeInfos = append(stakeInfos, newStakeInfo(staker, worker, value, s.SeparateOperator))
+		}
+		if len(page) < pageSize {
+			break
+		}
+		lastID = page[len(page)-1].ID
+	}
+	log.Info("succeeded to import stake infos", "source", s.Name(), "imported", len(stakeInfos), "filtered", filtered, "block", block)
+	return stakeInfos, 0, nil
+}
+
+// page queries the stakers after lastID
+func (s *SubgraphSource) page(first int, lastID string, block *big.Int) ([]subgraphStaker, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"query": s.Query,
+		"variables": map[string]interface{}{
+			"first":  first,
+			"lastId": lastID,
+			"block":  block.Uint64(),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Post(s.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the subgraph: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("subgraph answered %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	var r subgraphResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, fmt.Errorf("failed to decode the subgraph answer: %w", err)
+	}
+	if len(r.Errors) > 0 {
+		return nil, fmt.Errorf("subgraph query failed: %s", r.Errors[0].Message)
+	}
+	return r.Data.Stakers, nil
+}
@@ -0,0 +1,151 @@
+package ethereum
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+// emptySource is a StakeSource without any stakers, the contract calls confirming the zero fail and the
+// empty set is synced
+type emptySource struct{}
+
+func (emptySource) Name() string { return "empty" }
+
+func (emptySource) StakeInfos(*big.Int, *config.StakerFilter) (substrate.StakeInfos, uint64, error) {
+	return substrate.StakeInfos{}, 0, nil
+}
+
+// subgraphServer answers the stakers query from stakers, keyed by lower case hex id, or with failure as a
+// graphql error when set
+func subgraphServer(t *testing.T, stakers map[string]int64, failure string, queries *[]map[string]interface{}) *httptest.Server {
+	ids := make([]string, 0, len(stakers))
+	for id := range stakers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Query     string                 `json:"query"`
+			Variables map[string]interface{} `json:"variables"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		*queries = append(*queries, req.Variables)
+		if failure != "" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": []map[string]string{{"message": failure}}})
+			return
+		}
+		first, lastID := int(req.Variables["first"].(float64)), req.Variables["lastId"].(string)
+		page := make([]subgraphStaker, 0)
+		for _, id := range ids {
+			if id > lastID && len(page) < first {
+				page = append(page, subgraphStaker{ID: id, Value: big.NewInt(stakers[id]).String()})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"stakers": page}})
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestSubgraphSource_StakeInfos(t *testing.T) {
+	stakers := make(map[string]int64)
+	for i := int64(1); i <= 5; i++ {
+		stakers[strings.ToLower(ethcommon.BigToAddress(big.NewInt(i)).Hex())] = i * 10
+	}
+	var queries []map[string]interface{}
+	srv := subgraphServer(t, stakers, "", &queries)
+
+	blocked := ethcommon.BigToAddress(big.NewInt(3))
+	filter, err := config.StakerFilterConfig{Blocklist: []string{blocked.Hex()}}.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &SubgraphSource{URL: srv.URL, Query: DefaultSubgraphQuery, PageSize: 2}
+	infos, skipped, err := s.StakeInfos(big.NewInt(1000), filter)
+	if err != nil || skipped != 0 {
+		t.Fatalf("StakeInfos() = %d skipped, %v", skipped, err)
+	}
+	if len(infos) != 4 {
+		t.Fatalf("StakeInfos() = %d stake infos, want 4", len(infos))
+	}
+	for _, info := range infos {
+		if ethcommon.BytesToAddress(info.WorkBase) == blocked {
+			t.Errorf("StakeInfos() kept the blocked staker %s", blocked.Hex())
+		}
+	}
+	// 5 stakers in pages of 2
+	if len(queries) != 3 {
+		t.Fatalf("StakeInfos() sent %d queries, want 3", len(queries))
+	}
+	for _, q := range queries {
+		if q["block"].(float64) != 1000 {
+			t.Errorf("query at block %v, want 1000", q["block"])
+		}
+	}
+	if queries[0]["lastId"] != "" || queries[2]["lastId"] != strings.ToLower(ethcommon.BigToAddress(big.NewInt(4)).Hex()) {
+		t.Errorf("queries paged by lastId %v, %v", queries[0]["lastId"], queries[2]["lastId"])
+	}
+}
+
+func TestListener_readStakeSource(t *testing.T) {
+	a, b := ethcommon.BytesToAddress(WorkBase[0]), ethcommon.BytesToAddress(WorkBase[1])
+	var tags []string
+	conn := newTestConnection(t, map[string]rpcHandler{
+		"eth_call": stakingContract(t, map[string]map[ethcommon.Address]int64{"latest": {a: 20, b: 30}}, &tags),
+	})
+	subgraph := map[string]int64{strings.ToLower(a.Hex()): 20, strings.ToLower(b.Hex()): 30}
+	total := func(infos substrate.StakeInfos) int64 {
+		var sum int64
+		for _, info := range infos {
+			sum += info.LockedBalance.Int64()
+		}
+		return sum
+	}
+
+	tests := []struct {
+		name        string
+		failure     string
+		wantQueries bool
+		wantCalls   bool
+	}{
+		{name: "on-chain", wantCalls: true},
+		{name: "subgraph", wantQueries: true},
+		{name: "fallback", failure: "indexing error", wantQueries: true, wantCalls: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tags = nil
+			var queries []map[string]interface{}
+			l := &Listener{Config: &config.Config{EpochSize: 100}, Ethconn: conn, Subconn: &substrate.MockSubmitter{}}
+			if tt.name != "on-chain" {
+				l.Source = &SubgraphSource{URL: subgraphServer(t, subgraph, tt.failure, &queries).URL, Query: DefaultSubgraphQuery}
+			}
+			infos, err := l.GetStakeInfo(big.NewInt(100))
+			if err != nil {
+				t.Fatalf("GetStakeInfo() error = %v", err)
+			}
+			if len(infos) != 2 || total(infos) != 50 {
+				t.Errorf("GetStakeInfo() = %d stake infos locking %d, want 2 locking 50", len(infos), total(infos))
+			}
+			if got := len(queries) > 0; got != tt.wantQueries {
+				t.Errorf("subgraph queried = %v, want %v", got, tt.wantQueries)
+			}
+			if got := len(tags) > 0; got != tt.wantCalls {
+				t.Errorf("deposit contract called = %v, want %v", got, tt.wantCalls)
+			}
+		})
+	}
+}
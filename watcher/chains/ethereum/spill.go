@@ -0,0 +1,134 @@
+package ethereum
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// depositEntryBytes estimates the memory held by an accumulated deposit: its contract total, the stake info
+// of its staker and their index entries
+const depositEntryBytes = 256
+
+// depositSpill is the file the accumulated deposits are moved to once they exceed the DepositSpill
+// thresholds. The first size bytes hold a json depositRecord per line, each a partial contract total that is
+// merged back at the epoch flush.
+type depositSpill struct {
+	path string
+	size int64
+}
+
+// spillPath returns the spill file: next to the deposit checkpoint, so a restart resumes it with the
+// checkpoint, or else a temporary file in the DepositSpill dir
+func (l *Listener) spillPath() (string, error) {
+	if l.spill.path != "" {
+		return l.spill.path, nil
+	}
+	if l.DepositCheckpointPath != "" {
+		l.spill.path = l.DepositCheckpointPath + ".spill"
+		return l.spill.path, nil
+	}
+	f, err := ioutil.TempFile(l.Config.DepositSpill.Dir, "deposits-*.spill")
+	if err != nil {
+		return "", err
+	}
+	l.spill.path = f.Name()
+	return l.spill.path, f.Close()
+}
+
+// spillDeposits moves the accumulated deposits to the spill file when they exceed the entries or estimated
+// bytes of DepositSpill, a threshold that isn't positive is not checked
+func (l *Listener) spillDeposits() error {
+	n := len(contractTotals)
+	c := l.Config.DepositSpill
+	if (c.MaxEntries <= 0 || n <= c.MaxEntries) && (c.MaxBytes <= 0 || int64(n)*depositEntryBytes <= c.MaxBytes) {
+		return nil
+	}
+	path, err := l.spillPath()
+	if err != nil {
+		return err
+	}
+	flag := os.O_WRONLY | os.O_CREATE
+	if l.spill.size == 0 {
+		flag |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flag, 0664)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// bytes after size are left by a spill that failed, they are overwritten
+	if _, err := f.Seek(l.spill.size, io.SeekStart); err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for key, total := range contractTotals {
+		if err := enc.Encode(depositRecord{Contract: key.contract.Hex(), Staker: key.staker.Hex(), Value: total}); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	l.spill.size = size
+	log.Info("Spilled accumulated deposits to disk", "path", path, "count", n, "size", size)
+	resetStakeInfoList()
+	return nil
+}
+
+// mergeSpilled adds the spilled deposits back to the accumulated ones before the epoch flush. The spill is
+// read completely before any deposit is added, so a failed read leaves the accumulated deposits unchanged.
+func (l *Listener) mergeSpilled() error {
+	if l.spill.size == 0 {
+		return nil
+	}
+	f, err := os.Open(l.spill.path)
+	if err != nil {
+		return fmt.Errorf("spilled deposits: %w", err)
+	}
+	defer f.Close()
+	var deposits []depositRecord
+	dec := json.NewDecoder(io.LimitReader(f, l.spill.size))
+	for {
+		var d depositRecord
+		if err := dec.Decode(&d); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("spilled deposits in %s: %w", l.spill.path, err)
+		}
+		if d.Value == nil || !ethcommon.IsHexAddress(d.Contract) || !ethcommon.IsHexAddress(d.Staker) {
+			return fmt.Errorf("invalid spilled deposit of staker %q in %s", d.Staker, l.spill.path)
+		}
+		deposits = append(deposits, d)
+	}
+	for _, d := range deposits {
+		addDeposit(l.Config.EthereumConfig.CrossContractAggregation, ethcommon.HexToAddress(d.Contract), ethcommon.HexToAddress(d.Staker), d.Value)
+	}
+	log.Info("Merged spilled deposits", "path", l.spill.path, "records", len(deposits), "count", len(stakeInfoList))
+	return nil
+}
+
+// clearSpill removes the spill file once its deposits were flushed at an epoch boundary
+func (l *Listener) clearSpill() {
+	if l.spill.path == "" {
+		return
+	}
+	if err := os.Remove(l.spill.path); err != nil && !os.IsNotExist(err) {
+		log.Warn("Failed to remove spilled deposits", "path", l.spill.path, "error", err)
+	}
+	l.spill = depositSpill{}
+}
@@ -0,0 +1,118 @@
+package ethereum
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+func TestListener_spillDeposits(t *testing.T) {
+	contract := common.HexToAddress("0xa1")
+	logs := make([]*ethtypes.Log, 3)
+	for i := range logs {
+		staker := common.BigToAddress(big.NewInt(int64(i + 1)))
+		data := append(common.BigToHash(big.NewInt(10)).Bytes(), common.BigToHash(big.NewInt(1)).Bytes()...)
+		logs[i] = &ethtypes.Log{Address: contract, Topics: []common.Hash{Deposited.GetTopic(), common.BytesToHash(staker[:])}, Data: data}
+	}
+	conn := newTestConnection(t, map[string]rpcHandler{
+		"eth_getLogs": func(params []json.RawMessage) (interface{}, *rpcError) { return logs, nil },
+	})
+	cfg := &config.Config{EpochSize: 1000, EpochSource: config.EpochSourceEvents, MaxEventsPerBlock: config.MaxEventsPerBlock, EthereumConfig: config.EthereumConfig{
+		DepositContractAddr: contract.Hex(),
+		StakerTopic:         &config.TopicSlice{Index: 1, Offset: 12, Length: 20},
+	}}
+	cfg.DepositSpill.MaxEntries = 2
+	path := filepath.Join(t.TempDir(), "deposits.json")
+	sub := &substrate.MockSubmitter{}
+	defer resetStakeInfoList()
+	resetStakeInfoList()
+
+	// the three stakers of a block exceed the threshold and are spilled
+	l := &Listener{Config: cfg, Ethconn: conn, Subconn: sub, DepositCheckpointPath: path}
+	if err := l.getDepositEventsForBlock(big.NewInt(1001)); err != nil {
+		t.Fatal(err)
+	}
+	if len(stakeInfoList) != 0 || l.spill.size == 0 {
+		t.Fatalf("after the spill stakeInfoList holds %d deposits and the spill %d bytes", len(stakeInfoList), l.spill.size)
+	}
+
+	// a restart resumes the spilled deposits with the checkpoint, the next spill appends to them
+	resetStakeInfoList()
+	l = &Listener{Config: cfg, Ethconn: conn, Subconn: sub, DepositCheckpointPath: path}
+	if got := l.restoreDeposits(big.NewInt(1)); got.Int64() != 1001 {
+		t.Errorf("restoreDeposits() = %s, want 1001", got)
+	}
+	size := l.spill.size
+	if err := l.getDepositEventsForBlock(big.NewInt(1002)); err != nil {
+		t.Fatal(err)
+	}
+	if l.spill.size <= size {
+		t.Errorf("spill size = %d after the second spill, want more than %d", l.spill.size, size)
+	}
+
+	// the flush merges both spills with the deposits of the boundary held in memory
+	if err := l.getDepositEventsForBlock(big.NewInt(2000)); err != nil {
+		t.Fatal(err)
+	}
+	calls := sub.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("submitted %d times, want 1", len(calls))
+	}
+	infos, _ := calls[0].Args[0].(substrate.StakeInfos)
+	if len(infos) != 3 {
+		t.Fatalf("submitted %d stake infos, want 3", len(infos))
+	}
+	for _, info := range infos {
+		if info.LockedBalance.Int.Int64() != 30 {
+			t.Errorf("submitted %x with %v, want 30", info.WorkBase, info.LockedBalance.Int)
+		}
+	}
+	if _, err := os.Stat(path + ".spill"); !os.IsNotExist(err) {
+		t.Errorf("spill file still exists after the epoch flush: %v", err)
+	}
+	if len(stakeInfoList) != 0 || l.spill.size != 0 {
+		t.Errorf("after the flush stakeInfoList holds %d deposits and the spill %d bytes", len(stakeInfoList), l.spill.size)
+	}
+}
+
+func TestListener_spillDepositsBelowThreshold(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.Config{DepositSpill: config.DepositSpillConfig{MaxEntries: 2, MaxBytes: 2 * depositEntryBytes, Dir: dir}}
+	l := &Listener{Config: cfg}
+	defer resetStakeInfoList()
+	resetStakeInfoList()
+
+	addDeposit(config.AggregateSum, common.HexToAddress("0xa1"), common.HexToAddress("0x01"), big.NewInt(1))
+	addDeposit(config.AggregateSum, common.HexToAddress("0xb2"), common.HexToAddress("0x01"), big.NewInt(2))
+	if err := l.spillDeposits(); err != nil || l.spill.path != "" {
+		t.Fatalf("spillDeposits() below the thresholds = %v, spilled to %q", err, l.spill.path)
+	}
+
+	// without a checkpoint the deposits spill to a temporary file in Dir
+	addDeposit(config.AggregateSum, common.HexToAddress("0xb2"), common.HexToAddress("0x02"), big.NewInt(4))
+	if err := l.spillDeposits(); err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(l.spill.path) != dir || len(stakeInfoList) != 0 {
+		t.Fatalf("spilled to %q with %d deposits left, want a file in %s", l.spill.path, len(stakeInfoList), dir)
+	}
+	if err := l.mergeSpilled(); err != nil {
+		t.Fatal(err)
+	}
+	if len(stakeInfoList) != 2 || stakeInfoList[0].LockedBalance.Int.Int64()+stakeInfoList[1].LockedBalance.Int.Int64() != 7 {
+		t.Errorf("merged stakeInfoList = %+v, want 2 stakers holding 7", stakeInfoList)
+	}
+	l.clearSpill()
+	if fis, _ := ioutil.ReadDir(dir); len(fis) != 0 {
+		t.Errorf("%d files left in %s after clearSpill()", len(fis), dir)
+	}
+}
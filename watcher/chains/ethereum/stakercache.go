@@ -0,0 +1,97 @@
+package ethereum
+
+import (
+	"container/list"
+	"math/big"
+	"sync"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+)
+
+// stakerCacheKey identifies the StakerInfo of a staker within a bucket of blocks
+type stakerCacheKey struct {
+	staker ethcommon.Address
+	bucket uint64
+}
+
+// stakerInfo is the part of the StakerInfo of the deposit contract used for the stake infos
+type stakerInfo struct {
+	worker ethcommon.Address
+	value  *big.Int
+}
+
+type stakerCacheEntry struct {
+	key  stakerCacheKey
+	info stakerInfo
+}
+
+// stakerCache is an LRU of StakerInfo results holding up to size stakers. Values are keyed by their block
+// bucket, so a value cached in an earlier bucket is stale and fetched again. It is safe for concurrent use.
+type stakerCache struct {
+	mu    sync.Mutex
+	size  int
+	ll    *list.List
+	items map[ethcommon.Address]*list.Element
+
+	hits, misses, evictions uint64
+}
+
+func newStakerCache(size int) *stakerCache {
+	return &stakerCache{size: size, ll: list.New(), items: make(map[ethcommon.Address]*list.Element)}
+}
+
+// get returns the cached StakerInfo of key and marks it as recently used
+func (c *stakerCache) get(key stakerCacheKey) (stakerInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key.staker]; ok {
+		entry := e.Value.(*stakerCacheEntry)
+		if entry.key.bucket == key.bucket {
+			c.ll.MoveToFront(e)
+			c.hits++
+			return entry.info, true
+		}
+	}
+	c.misses++
+	return stakerInfo{}, false
+}
+
+// add caches info for key, replacing an older bucket of the same staker and evicting the least recently
+// used staker when the cache is full
+func (c *stakerCache) add(key stakerCacheKey, info stakerInfo) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key.staker]; ok {
+		e.Value = &stakerCacheEntry{key: key, info: info}
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.items[key.staker] = c.ll.PushFront(&stakerCacheEntry{key: key, info: info})
+	for c.ll.Len() > c.size {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*stakerCacheEntry).key.staker)
+		c.evictions++
+	}
+}
+
+func (c *stakerCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// reset drops all cached stakers, keeping the counts
+func (c *stakerCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = make(map[ethcommon.Address]*list.Element)
+}
+
+// counts returns the hits, misses and evictions of the cache so far
+func (c *stakerCache) counts() (hits, misses, evictions uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses, c.evictions
+}
@@ -0,0 +1,46 @@
+package ethereum
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestStakerCache(t *testing.T) {
+	a, b, c := common.HexToAddress("0x0a"), common.HexToAddress("0x0b"), common.HexToAddress("0x0c")
+	cache := newStakerCache(2)
+	cache.add(stakerCacheKey{staker: a}, stakerInfo{value: big.NewInt(1)})
+	cache.add(stakerCacheKey{staker: b}, stakerInfo{value: big.NewInt(2)})
+
+	// a becomes the most recently used, so adding c evicts b
+	if info, ok := cache.get(stakerCacheKey{staker: a}); !ok || info.value.Int64() != 1 {
+		t.Errorf("get(a) = %v, %v, want 1", info.value, ok)
+	}
+	cache.add(stakerCacheKey{staker: c}, stakerInfo{value: big.NewInt(3)})
+	if _, ok := cache.get(stakerCacheKey{staker: b}); ok {
+		t.Errorf("get(b) hit after b was evicted")
+	}
+	if _, ok := cache.get(stakerCacheKey{staker: c}); !ok {
+		t.Errorf("get(c) missed")
+	}
+	if cache.len() != 2 || cache.evictions != 1 {
+		t.Errorf("cache holds %d stakers after %d evictions, want 2 after 1", cache.len(), cache.evictions)
+	}
+
+	// a value of an earlier bucket is stale and replaced without an eviction
+	if _, ok := cache.get(stakerCacheKey{staker: a, bucket: 1}); ok {
+		t.Errorf("get(a) hit a stale bucket")
+	}
+	cache.add(stakerCacheKey{staker: a, bucket: 1}, stakerInfo{value: big.NewInt(4)})
+	if info, ok := cache.get(stakerCacheKey{staker: a, bucket: 1}); !ok || info.value.Int64() != 4 {
+		t.Errorf("get(a) = %v, %v, want 4", info.value, ok)
+	}
+	if cache.len() != 2 || cache.evictions != 1 {
+		t.Errorf("cache holds %d stakers after %d evictions, want 2 after 1", cache.len(), cache.evictions)
+	}
+
+	if cache.hits != 3 || cache.misses != 2 {
+		t.Errorf("cache hits = %d, misses = %d, want 3 and 2", cache.hits, cache.misses)
+	}
+}
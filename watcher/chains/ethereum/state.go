@@ -0,0 +1,461 @@
+package ethereum
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+	"github.com/NuLink-network/watcher/watcher/sink"
+)
+
+// checkTimestamp validates a persisted timestamp against now. Timestamps up to maxSkew ahead of now are
+// accepted as written by a host with a slightly different clock, later ones return ErrFutureTimestamp.
+// If maxAge is set, timestamps older than maxAge, again allowing for maxSkew, return ErrStateStale.
+func checkTimestamp(ts, now time.Time, maxAge, maxSkew time.Duration) error {
+	if ts.After(now.Add(maxSkew)) {
+		return fmt.Errorf("%w: %s is %s ahead of now", ErrFutureTimestamp, ts.Format(time.RFC3339), ts.Sub(now))
+	}
+	if maxAge > 0 && now.Sub(ts) > maxAge+maxSkew {
+		return fmt.Errorf("%w: %s is %s old", ErrStateStale, ts.Format(time.RFC3339), now.Sub(ts))
+	}
+	return nil
+}
+
+// DisablePersistence keeps all state of the listener in memory: none of its files, logs, attestations or
+// exports is written and the file sinks are dropped. It is applied once every writer is set up.
+func (l *Listener) DisablePersistence() {
+	l.LastStakeInfoPath = ""
+	l.StartBlockPath = ""
+	l.MetricsPath = ""
+	l.DepositCheckpointPath = ""
+	l.HistoryDir = ""
+	l.Audit = nil
+	l.Attester = nil
+	l.Events = nil
+	sinks := l.Sinks[:0]
+	for _, s := range l.Sinks {
+		if f, ok := s.(*sink.File); ok {
+			log.Warn("persistence disabled, dropping the file sink", "path", f.Path)
+			continue
+		}
+		sinks = append(sinks, s)
+	}
+	l.Sinks = sinks
+}
+
+// readLastStakeInfos reads the last submitted stake infos and the absent epochs of stakers in their stopped
+// grace period. When MaxStateAge is set, a file whose modification time fails checkTimestamp is not reused
+// and an empty set is returned instead. Without a LastStakeInfoPath they are kept in memory only.
+func (l *Listener) readLastStakeInfos() (substrate.StakeInfos, map[string]uint64, error) {
+	if l.LastStakeInfoPath == "" {
+		infos := make(substrate.StakeInfos, len(l.lastInfos))
+		copy(infos, l.lastInfos)
+		absent := make(map[string]uint64, len(l.lastAbsent))
+		for k, v := range l.lastAbsent {
+			absent[k] = v
+		}
+		return infos, absent, nil
+	}
+	if l.Config.MaxStateAge.Duration > 0 {
+		fi, err := os.Stat(l.LastStakeInfoPath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, nil, err
+		}
+		if err == nil {
+			if err := checkTimestamp(fi.ModTime(), l.clock().Now(), l.Config.MaxStateAge.Duration, l.Config.MaxClockSkew.Duration); err != nil {
+				log.Warn("ignore last stake info file", "path", l.LastStakeInfoPath, "error", err)
+				return make(substrate.StakeInfos, 0), make(map[string]uint64), nil
+			}
+		}
+	}
+	return readStakeInfoFile(l.LastStakeInfoPath)
+}
+
+// writeLastStakeInfos persists the submitted stake infos and absent epochs read back by readLastStakeInfos,
+// along with the deferred stopped stakers
+func (l *Listener) writeLastStakeInfos(infos substrate.StakeInfos, absent map[string]uint64) error {
+	if l.LastStakeInfoPath == "" {
+		l.lastInfos, l.lastAbsent = infos, absent
+		return nil
+	}
+	return writeStakeInfoFile(l.LastStakeInfoPath, infos, absent, l.deferredStopped, l.Config.CompressState)
+}
+
+// startBlockRecord caches the detected deployment block of a contract
+type startBlockRecord struct {
+	Contract string   `json:"contract"`
+	Block    *big.Int `json:"block"`
+}
+
+// resolveStartBlock returns the configured StartBlock. If it is unset and DetectStartBlock is enabled the
+// deployment block of the deposit contract is used, read from StartBlockPath when it was detected before.
+func (l *Listener) resolveStartBlock() (*big.Int, error) {
+	ec := l.Config.EthereumConfig
+	if ec.StartBlock != nil {
+		return new(big.Int).Set(ec.StartBlock), nil
+	}
+	if start := earliestContractStart(ec.Contracts); start != nil {
+		log.Info("Using the earliest start block of the contracts", "block", start)
+		return start, nil
+	}
+	if !ec.DetectStartBlock {
+		return big.NewInt(1), nil
+	}
+
+	contract := ethcommon.HexToAddress(ec.DepositContractAddr)
+	if block, ok := readStartBlock(l.StartBlockPath, contract); ok {
+		log.Info("Using cached deployment block as start block", "contract", contract, "block", block)
+		return block, nil
+	}
+
+	latest, err := l.Ethconn.LatestBlock()
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Searching for the deployment block of the deposit contract", "contract", contract, "latest", latest)
+	block, err := l.Ethconn.DeploymentBlock(contract, latest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect start block: %w", err)
+	}
+	log.Info("Detected deployment block as start block", "contract", contract, "block", block)
+
+	if l.StartBlockPath == "" {
+		return block, nil
+	}
+	data, err := json.Marshal(startBlockRecord{Contract: contract.Hex(), Block: block})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(l.StartBlockPath), os.ModePerm); err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(l.StartBlockPath, data, 0664); err != nil {
+		log.Warn("Failed to cache the detected start block", "path", l.StartBlockPath, "error", err)
+	}
+	return block, nil
+}
+
+// checkResumeGap returns the block to resume polling at instead of current. If current is more than
+// MaxResumeGap blocks behind the latest block the gap is scanned anyway or, with the jump policy, skipped
+// by resuming at the safe head. A failed read of the head keeps current, polling retries it.
+func (l *Listener) checkResumeGap(current *big.Int) *big.Int {
+	if l.Config.MaxResumeGap == 0 {
+		return current
+	}
+	latest, err := l.Ethconn.LatestBlock()
+	if err != nil {
+		log.Warn("Unable to get latest block, skip the resume gap check", "block", current, "error", err)
+		return current
+	}
+	gap := new(big.Int).Sub(latest, current)
+	if gap.Cmp(new(big.Int).SetUint64(l.Config.MaxResumeGap)) <= 0 {
+		return current
+	}
+	if l.Config.ResumeGapPolicy != config.ResumeGapJump {
+		log.Warn("Resuming far behind the latest block, scanning the whole gap", "block", current, "latest", latest, "gap", gap, "maxResumeGap", l.Config.MaxResumeGap)
+		return current
+	}
+	head, err := l.Ethconn.SafeHead(l.Config.EthereumConfig.BlockConfirmations)
+	if err != nil {
+		log.Warn("Unable to get the safe head, scanning the whole gap", "block", current, "latest", latest, "error", err)
+		return current
+	}
+	if head.Cmp(current) <= 0 {
+		return current
+	}
+	log.Warn("Resuming far behind the latest block, jumping to the safe head", "block", current, "latest", latest, "gap", gap,
+		"maxResumeGap", l.Config.MaxResumeGap, "resume", head, "skipped", new(big.Int).Sub(head, current))
+	return head
+}
+
+// catchUpMissedEpochs submits, in order, the update of every epoch boundary the watcher was down for, in
+// (resume, safe head], each computed from the stake infos pinned to its boundary block. With MaxResumeGap
+// only the boundaries within MaxResumeGap blocks of the safe head are caught up. It returns the block to
+// resume polling at, the last boundary caught up or resume if there was none. A boundary whose state the node
+// no longer holds is skipped, a failed read of the head leaves the gap to polling. With
+// SuppressSubmitUntilCaughtUp only the set of the safe head is submitted.
+func (l *Listener) catchUpMissedEpochs(resume *big.Int) (*big.Int, error) {
+	if !l.Config.CatchUpMissedEpochs {
+		return resume, nil
+	}
+	head, err := l.Ethconn.SafeHead(l.Config.EthereumConfig.BlockConfirmations)
+	if err != nil {
+		log.Warn("Unable to get the safe head, skip catching up the missed epochs", "block", resume, "error", err)
+		return resume, nil
+	}
+	from := resume
+	if gap := l.Config.MaxResumeGap; gap > 0 && new(big.Int).Sub(head, from).Cmp(new(big.Int).SetUint64(gap)) > 0 {
+		from = new(big.Int).Sub(head, new(big.Int).SetUint64(gap))
+		log.Warn("Missed epochs beyond maxResumeGap are not caught up", "block", resume, "head", head, "maxResumeGap", gap, "from", from)
+	}
+	boundaries := epochBoundaries(from, head, l.Config.EpochSize, l.Config.EpochOffset)
+	if len(boundaries) == 0 {
+		return resume, nil
+	}
+	log.Info("Catching up the missed epochs", "block", resume, "head", head, "epochs", len(boundaries))
+	for _, b := range boundaries {
+		if l.warmingUp(b, head) {
+			continue
+		}
+		err := l.runEpoch(b, l.GetStakeInfoAt)
+		if errors.Is(err, ErrStateUnavailable) {
+			log.Warn("skip the missed epoch, its state is not available", "block", b, "error", err)
+			continue
+		} else if err != nil {
+			return resume, fmt.Errorf("failed to catch up the epoch at block %s: %w", b, err)
+		}
+	}
+	if l.warmupHeld != nil {
+		err := l.endWarmup(head, l.GetStakeInfoAt)
+		if errors.Is(err, ErrStateUnavailable) {
+			log.Warn("skip the current set, its state is not available", "block", head, "error", err)
+		} else if err != nil {
+			return resume, fmt.Errorf("failed to submit the current set at block %s: %w", head, err)
+		}
+		return head, nil
+	}
+	return boundaries[len(boundaries)-1], nil
+}
+
+// earliestContractStart returns the lowest start block of contracts, nil unless every contract has one. The
+// blocks before it hold no events of any contract, later contracts are skipped until their own start block.
+func earliestContractStart(contracts []config.ContractConfig) *big.Int {
+	var start *big.Int
+	for _, c := range contracts {
+		if c.StartBlock == nil {
+			return nil
+		}
+		if start == nil || c.StartBlock.Cmp(start) < 0 {
+			start = c.StartBlock
+		}
+	}
+	if start == nil {
+		return nil
+	}
+	return new(big.Int).Set(start)
+}
+
+// readStartBlock returns the cached deployment block if it was detected for the same contract
+func readStartBlock(file string, contract ethcommon.Address) (*big.Int, bool) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, false
+	}
+	var r startBlockRecord
+	if err := json.Unmarshal(data, &r); err != nil || r.Block == nil {
+		log.Warn("Ignore invalid start block file", "path", file, "error", err)
+		return nil, false
+	}
+	if ethcommon.HexToAddress(r.Contract) != contract {
+		return nil, false
+	}
+	return r.Block, true
+}
+
+// applyStopGrace keeps stakers of the last set that dropped out of top for up to StoppedGraceEpochs
+// consecutive epochs, so a transient dip doesn't report them stopped. Kept stakers hold on to their slot,
+// displacing the lowest joiners of top. It returns the set to submit and the updated absent epochs.
+func (l *Listener) applyStopGrace(top, all, last substrate.StakeInfos, absent map[string]uint64) (substrate.StakeInfos, map[string]uint64) {
+	grace := l.Config.StoppedGraceEpochs
+	next := make(map[string]uint64)
+	if grace == 0 {
+		return top, next
+	}
+
+	inTop := make(map[string]struct{}, len(top))
+	for _, info := range top {
+		inTop[ethcommon.Bytes2Hex(info.WorkBase)] = struct{}{}
+	}
+	current := make(map[string]*substrate.StakeInfo, len(all))
+	for _, info := range all {
+		current[ethcommon.Bytes2Hex(info.WorkBase)] = info
+	}
+
+	var kept substrate.StakeInfos
+	for _, info := range last {
+		key := ethcommon.Bytes2Hex(info.WorkBase)
+		if _, ok := inTop[key]; ok {
+			continue
+		}
+		n := absent[key] + 1
+		if n > grace {
+			log.Info("staker stopped after grace period", "staker", key, "absentEpochs", absent[key])
+			continue
+		}
+		keep := *info
+		if cur, ok := current[key]; ok {
+			keep = *cur
+		}
+		keep.IsWork = true
+		kept = append(kept, &keep)
+		next[key] = n
+		log.Debug("keep absent staker in grace period", "staker", key, "absentEpochs", n, "grace", grace)
+	}
+	return mergeKept(top, kept, last), next
+}
+
+// confirmStopped re-reads the stake infos StoppedConfirmations blocks below block before any staker of the
+// last set missing from top is counted absent or reported stopped. The stakers still in the top set at that
+// depth keep their slot, so a read near the tip that is reorged away doesn't stop them.
+func (l *Listener) confirmStopped(top, all, last substrate.StakeInfos, block *big.Int) (substrate.StakeInfos, error) {
+	depth := new(big.Int).SetUint64(l.Config.StoppedConfirmations)
+	if depth.Sign() == 0 {
+		return top, nil
+	}
+	inTop := make(map[string]struct{}, len(top))
+	for _, info := range top {
+		inTop[ethcommon.Bytes2Hex(info.WorkBase)] = struct{}{}
+	}
+	var missing []string
+	for _, info := range last {
+		key := ethcommon.Bytes2Hex(info.WorkBase)
+		if _, ok := inTop[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) == 0 {
+		return top, nil
+	}
+
+	at := new(big.Int).Sub(block, depth)
+	if at.Sign() < 0 {
+		at.SetInt64(0)
+	}
+	filter, err := l.stakerFilter()
+	if err != nil {
+		return nil, err
+	}
+	deep, skipped, err := l.fetchStakeInfos(l.Ethconn.Client, &bind.CallOpts{BlockNumber: at}, nil, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to confirm stopped stakers at block %s: %w", at, err)
+	}
+	if skipped > 0 {
+		return nil, fmt.Errorf("failed to confirm stopped stakers at block %s: %d stakers couldn't be read", at, skipped)
+	}
+
+	deepTop := make(map[string]*substrate.StakeInfo)
+	for _, info := range l.selectTop(deep) {
+		deepTop[ethcommon.Bytes2Hex(info.WorkBase)] = info
+	}
+	current := make(map[string]*substrate.StakeInfo, len(all))
+	for _, info := range all {
+		current[ethcommon.Bytes2Hex(info.WorkBase)] = info
+	}
+	var kept substrate.StakeInfos
+	for _, key := range missing {
+		info, ok := deepTop[key]
+		if !ok {
+			continue
+		}
+		keep := *info
+		if cur, ok := current[key]; ok {
+			keep = *cur
+		}
+		keep.IsWork = true
+		kept = append(kept, &keep)
+		log.Info("keep staker missing at the tip but in the top set at depth", "staker", key, "block", block, "confirmedAt", at)
+	}
+	return mergeKept(top, kept, last), nil
+}
+
+// mergeKept adds the kept stakers of the last set to top. They hold on to their slot, displacing the lowest
+// joiners of top that weren't part of last.
+func mergeKept(top, kept, last substrate.StakeInfos) substrate.StakeInfos {
+	if len(kept) == 0 {
+		return top
+	}
+	inLast := make(map[string]struct{}, len(last))
+	for _, info := range last {
+		inLast[ethcommon.Bytes2Hex(info.WorkBase)] = struct{}{}
+	}
+
+	result := make(substrate.StakeInfos, 0, substrate.TopN)
+	var joiners substrate.StakeInfos
+	for _, info := range top {
+		if _, ok := inLast[ethcommon.Bytes2Hex(info.WorkBase)]; ok {
+			result = append(result, info)
+		} else {
+			joiners = append(joiners, info)
+		}
+	}
+	result = append(result, kept...)
+	for _, info := range joiners {
+		if len(result) >= substrate.TopN {
+			break
+		}
+		result = append(result, info)
+	}
+	sort.Stable(result)
+	return result
+}
+
+// capStopped submits at most MaxStoppedPerEpoch stopped stakers with a diff payload, the ones deferred by
+// earlier epochs first and then the new ones in the order of the payload, by WorkBase. It returns the payload and the
+// stopped stakers deferred to the next epochs. A full set carries no stopped stakers, it keeps all of them
+// deferred. Deferred stakers back in current are dropped, the payload holds their update.
+func (l *Listener) capStopped(payload, current substrate.StakeInfos, full bool) (substrate.StakeInfos, substrate.StakeInfos) {
+	inSet := make(map[string]struct{}, len(current))
+	for _, info := range current {
+		inSet[ethcommon.Bytes2Hex(info.WorkBase)] = struct{}{}
+	}
+	queued := make(map[string]struct{}, len(l.deferredStopped))
+	var stopped substrate.StakeInfos
+	queue := func(info *substrate.StakeInfo) {
+		key := ethcommon.Bytes2Hex(info.WorkBase)
+		if _, ok := queued[key]; !ok {
+			queued[key] = struct{}{}
+			stopped = append(stopped, info)
+		}
+	}
+	for _, info := range l.deferredStopped {
+		if _, ok := inSet[ethcommon.Bytes2Hex(info.WorkBase)]; !ok {
+			queue(info)
+		}
+	}
+	if full {
+		return payload, stopped
+	}
+	capped := make(substrate.StakeInfos, 0, len(payload))
+	for _, info := range payload {
+		if _, ok := inSet[ethcommon.Bytes2Hex(info.WorkBase)]; ok {
+			capped = append(capped, info)
+		} else {
+			queue(info)
+		}
+	}
+
+	max := l.Config.MaxStoppedPerEpoch
+	if max <= 0 || len(stopped) <= max {
+		return append(capped, stopped...), nil
+	}
+	log.Warn("too many stopped stakers, deferring the rest to the next epochs", "stopped", len(stopped), "submitted", max, "deferred", len(stopped)-max)
+	return append(capped, stopped[:max]...), stopped[max:]
+}
+
+// loadDeferredStopped reads the stopped stakers deferred by an earlier run back from the stake info file
+func (l *Listener) loadDeferredStopped() error {
+	if l.deferredLoaded || l.LastStakeInfoPath == "" {
+		return nil
+	}
+	_, _, deferred, err := readStakeInfoState(l.LastStakeInfoPath)
+	if err != nil {
+		return err
+	}
+	if len(deferred) > 0 {
+		log.Info("resuming deferred stopped stakers", "count", len(deferred))
+	}
+	l.deferredStopped, l.deferredLoaded = deferred, true
+	return nil
+}
@@ -0,0 +1,796 @@
+package ethereum
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/NuLink-network/watcher/watcher/bindings/nucypher"
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+	"github.com/NuLink-network/watcher/watcher/params"
+	"github.com/NuLink-network/watcher/watcher/sink"
+)
+
+func TestCheckTimestamp(t *testing.T) {
+	now := time.Date(2021, 11, 1, 12, 0, 0, 0, time.UTC)
+	skew := time.Minute
+	tests := []struct {
+		name    string
+		ts      time.Time
+		maxAge  time.Duration
+		wantErr error
+	}{
+		{name: "now", ts: now},
+		{name: "future-within-skew", ts: now.Add(skew)},
+		{name: "future-beyond-skew", ts: now.Add(skew + time.Second), wantErr: ErrFutureTimestamp},
+		{name: "old-no-max-age", ts: now.Add(-365 * 24 * time.Hour)},
+		{name: "old-within-max-age-and-skew", ts: now.Add(-time.Hour - skew), maxAge: time.Hour},
+		{name: "old-beyond-max-age-and-skew", ts: now.Add(-time.Hour - skew - time.Second), maxAge: time.Hour, wantErr: ErrStateStale},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkTimestamp(tt.ts, now, tt.maxAge, skew)
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("checkTimestamp() error = %v, want nil", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("checkTimestamp() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestListener_readLastStakeInfos(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "stake-info.json")
+	infos := substrate.StakeInfos{
+		{Coinbase: Coinbase[0], WorkBase: WorkBase[0], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(1))},
+	}
+	if err := WriteStakeInfos(filePath, infos, false); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		modTime time.Time
+		want    int
+	}{
+		{name: "fresh", modTime: time.Now(), want: 1},
+		{name: "slightly-future", modTime: time.Now().Add(30 * time.Second), want: 1},
+		{name: "far-future", modTime: time.Now().Add(time.Hour), want: 0},
+		{name: "stale", modTime: time.Now().Add(-48 * time.Hour), want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.Chtimes(filePath, tt.modTime, tt.modTime); err != nil {
+				t.Fatal(err)
+			}
+			l := &Listener{
+				Config: &config.Config{
+					MaxStateAge:  config.Duration{Duration: 24 * time.Hour},
+					MaxClockSkew: config.Duration{Duration: time.Minute},
+				},
+				LastStakeInfoPath: filePath,
+			}
+			got, _, err := l.readLastStakeInfos()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != tt.want {
+				t.Errorf("readLastStakeInfos() len = %d, want %d", len(got), tt.want)
+			}
+		})
+	}
+}
+
+func TestListener_checkResumeGap(t *testing.T) {
+	conn := newTestConnection(t, map[string]rpcHandler{"eth_getBlockByNumber": blockByNumber(100000, nil)})
+	tests := []struct {
+		name    string
+		gap     uint64
+		policy  string
+		current int64
+		want    int64
+	}{
+		{name: "disabled", policy: config.ResumeGapJump, current: 1000, want: 1000},
+		{name: "within the gap", gap: 99000, policy: config.ResumeGapJump, current: 1000, want: 1000},
+		{name: "scan", gap: 1000, policy: config.ResumeGapScan, current: 1000, want: 1000},
+		// the safe head is latest - blockConfirmations
+		{name: "jump", gap: 1000, policy: config.ResumeGapJump, current: 1000, want: 99990},
+		{name: "jump within the confirmations", gap: 5, policy: config.ResumeGapJump, current: 99993, want: 99993},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &Listener{Config: &config.Config{MaxResumeGap: tt.gap, ResumeGapPolicy: tt.policy, EthereumConfig: config.EthereumConfig{
+				BlockConfirmations: big.NewInt(10),
+			}}, Ethconn: conn}
+			if got := l.checkResumeGap(big.NewInt(tt.current)); got.Int64() != tt.want {
+				t.Errorf("checkResumeGap() = %v, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListener_catchUpMissedEpochs(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+
+	staker := ethcommon.BytesToAddress(WorkBase[0])
+	// the locked balance at a boundary is 10 times its epoch, the submitted balance tells its epoch
+	boundaries := map[string]map[ethcommon.Address]int64{
+		"0x7d0": {staker: 20},
+		"0xbb8": {staker: 30},
+		"0xfa0": {staker: 40},
+	}
+	tests := []struct {
+		name       string
+		disabled   bool
+		gap        uint64
+		pruned     string
+		want       int64
+		wantEpochs []int64
+	}{
+		{name: "disabled", disabled: true, want: 1500},
+		{name: "every missed epoch", want: 4000, wantEpochs: []int64{2, 3, 4}},
+		{name: "bounded by maxResumeGap", gap: 2000, want: 4000, wantEpochs: []int64{3, 4}},
+		{name: "pruned boundary", pruned: "0xbb8", want: 4000, wantEpochs: []int64{2, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first = false
+			balances := make(map[string]map[ethcommon.Address]int64, len(boundaries))
+			for tag, b := range boundaries {
+				if tag != tt.pruned {
+					balances[tag] = b
+				}
+			}
+			var tags []string
+			conn := newTestConnection(t, map[string]rpcHandler{
+				"eth_getBlockByNumber": blockByNumber(4500, nil),
+				"eth_call":             stakingContract(t, balances, &tags),
+			})
+			sub := &substrate.MockSubmitter{}
+			l := &Listener{Config: &config.Config{EpochSize: 1000, SubmitMode: config.SubmitModeFull, CatchUpMissedEpochs: !tt.disabled,
+				MaxResumeGap: tt.gap, EthereumConfig: config.EthereumConfig{BlockConfirmations: big.NewInt(0)}}, Ethconn: conn, Subconn: sub}
+
+			got, err := l.catchUpMissedEpochs(big.NewInt(1500))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Int64() != tt.want {
+				t.Errorf("catchUpMissedEpochs() = %v, want %d", got, tt.want)
+			}
+			var epochs []int64
+			for _, c := range sub.Calls() {
+				infos := c.Args[0].(substrate.StakeInfos)
+				if len(infos) != 1 {
+					t.Fatalf("submitted %d stake infos, want 1", len(infos))
+				}
+				epochs = append(epochs, infos[0].LockedBalance.Int64()/10)
+			}
+			if !reflect.DeepEqual(epochs, tt.wantEpochs) {
+				t.Errorf("submitted the sets of epochs %v, want %v", epochs, tt.wantEpochs)
+			}
+			for _, tag := range tags {
+				if tag == "latest" {
+					t.Errorf("a missed epoch was read at the latest block")
+				}
+			}
+		})
+	}
+}
+
+func TestListener_resolveStartBlock(t *testing.T) {
+	const contract = "0xa7f6c9a5052a08a14ff0e3349094b6efbc591ea4"
+	var calls int
+	conn := newTestConnection(t, map[string]rpcHandler{
+		"eth_getBlockByNumber": blockByNumber(1000, nil),
+		"eth_getCode":          codeFrom(617, &calls),
+	})
+	newListener := func(ec config.EthereumConfig, path string) *Listener {
+		ec.DepositContractAddr = contract
+		return &Listener{Config: &config.Config{EthereumConfig: ec}, Ethconn: conn, StartBlockPath: path}
+	}
+
+	t.Run("configured", func(t *testing.T) {
+		l := newListener(config.EthereumConfig{StartBlock: big.NewInt(42), DetectStartBlock: true}, "")
+		got, err := l.resolveStartBlock()
+		if err != nil || got.Int64() != 42 {
+			t.Errorf("resolveStartBlock() = %v, %v, want 42", got, err)
+		}
+	})
+
+	t.Run("contracts", func(t *testing.T) {
+		l := newListener(config.EthereumConfig{DetectStartBlock: true, Contracts: []config.ContractConfig{
+			{Address: contract, StartBlock: big.NewInt(300)},
+			{Address: "0xb2", StartBlock: big.NewInt(200)},
+		}}, "")
+		got, err := l.resolveStartBlock()
+		if err != nil || got.Int64() != 200 {
+			t.Errorf("resolveStartBlock() = %v, %v, want 200", got, err)
+		}
+		// a contract without a start block needs the detected or default start
+		l.Config.EthereumConfig.Contracts = append(l.Config.EthereumConfig.Contracts, config.ContractConfig{Address: "0xc3"})
+		got, err = l.resolveStartBlock()
+		if err != nil || got.Int64() != 617 {
+			t.Errorf("resolveStartBlock() = %v, %v, want 617", got, err)
+		}
+	})
+
+	t.Run("detection-disabled", func(t *testing.T) {
+		l := newListener(config.EthereumConfig{}, "")
+		got, err := l.resolveStartBlock()
+		if err != nil || got.Int64() != 1 {
+			t.Errorf("resolveStartBlock() = %v, %v, want 1", got, err)
+		}
+	})
+
+	t.Run("detect-and-cache", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "start_block.json")
+		l := newListener(config.EthereumConfig{DetectStartBlock: true}, path)
+		for i := 0; i < 2; i++ {
+			calls = 0
+			got, err := l.resolveStartBlock()
+			if err != nil || got.Int64() != 617 {
+				t.Fatalf("resolveStartBlock() = %v, %v, want 617", got, err)
+			}
+			if i == 1 && calls != 0 {
+				t.Errorf("resolveStartBlock() queried the node %d times, want the cached block", calls)
+			}
+		}
+	})
+
+	t.Run("cache-other-contract", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "start_block.json")
+		if err := ioutil.WriteFile(path, []byte(`{"contract":"0x00192fb10df37c9fb26829eb2cc623cd1bf599e8","block":5}`), 0664); err != nil {
+			t.Fatal(err)
+		}
+		l := newListener(config.EthereumConfig{DetectStartBlock: true}, path)
+		got, err := l.resolveStartBlock()
+		if err != nil || got.Int64() != 617 {
+			t.Errorf("resolveStartBlock() = %v, %v, want 617", got, err)
+		}
+	})
+}
+
+func TestListener_applyStopGrace(t *testing.T) {
+	info := func(i int, balance int64) *substrate.StakeInfo {
+		return &substrate.StakeInfo{Coinbase: Coinbase[i], WorkBase: WorkBase[i], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(balance))}
+	}
+	key := func(i int) string { return ethcommon.Bytes2Hex(WorkBase[i]) }
+	contains := func(infos substrate.StakeInfos, i int) bool {
+		for _, info := range infos {
+			if reflect.DeepEqual(info.WorkBase, WorkBase[i]) {
+				return info.IsWork
+			}
+		}
+		return false
+	}
+
+	l := &Listener{Config: &config.Config{StoppedGraceEpochs: 1}}
+	last := substrate.StakeInfos{info(0, 30), info(1, 20)}
+
+	// staker 1 dips out of the top set for one epoch
+	all := substrate.StakeInfos{info(0, 30), info(2, 25), info(1, 1)}
+	top := substrate.StakeInfos{info(0, 30), info(2, 25)}
+	got, absent := l.applyStopGrace(top, all, last, map[string]uint64{})
+	if !contains(got, 1) || absent[key(1)] != 1 {
+		t.Fatalf("applyStopGrace() = %d stakers, absent %v, want staker 1 kept for the blip", len(got), absent)
+	}
+	if err := got.CheckTopN(); err != nil {
+		t.Errorf("applyStopGrace() result is not a valid top set: %v", err)
+	}
+
+	// it returns the next epoch and its absence is forgotten
+	got, absent = l.applyStopGrace(substrate.StakeInfos{info(0, 30), info(2, 25), info(1, 20)}, nil, got, absent)
+	if !contains(got, 1) || len(absent) != 0 {
+		t.Errorf("applyStopGrace() absent = %v after staker 1 returned, want none", absent)
+	}
+
+	// absent for longer than the grace period it is stopped
+	_, absent = l.applyStopGrace(top, all, last, map[string]uint64{})
+	got, absent = l.applyStopGrace(top, all, last, absent)
+	if contains(got, 1) || len(absent) != 0 {
+		t.Errorf("applyStopGrace() kept staker 1 after %d absent epochs", 2)
+	}
+
+	// without a grace period nothing is kept
+	l.Config.StoppedGraceEpochs = 0
+	if got, _ := l.applyStopGrace(top, all, last, map[string]uint64{}); contains(got, 1) {
+		t.Errorf("applyStopGrace() kept staker 1 without a grace period")
+	}
+}
+
+// stakingContract serves the eth_calls of the deposit contract from the locked balance of each staker by
+// block tag, a tag missing from balances fails the call
+func stakingContract(t *testing.T, balances map[string]map[ethcommon.Address]int64, tags *[]string) rpcHandler {
+	parsed, err := abi.JSON(strings.NewReader(nucypher.NucypherABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func(params []json.RawMessage) (interface{}, *rpcError) {
+		var call struct {
+			Data hexutil.Bytes `json:"data"`
+		}
+		var tag string
+		if err := json.Unmarshal(params[0], &call); err != nil || json.Unmarshal(params[1], &tag) != nil || len(call.Data) < 4 {
+			return nil, &rpcError{Code: -32602, Message: "invalid call"}
+		}
+		*tags = append(*tags, tag)
+		set, ok := balances[tag]
+		if !ok {
+			return nil, &rpcError{Code: -32000, Message: "missing trie node"}
+		}
+		stakers := make([]ethcommon.Address, 0, len(set))
+		for staker := range set {
+			stakers = append(stakers, staker)
+		}
+		sort.Slice(stakers, func(i, j int) bool { return bytes.Compare(stakers[i][:], stakers[j][:]) < 0 })
+
+		method, err := parsed.MethodById(call.Data[:4])
+		if err != nil {
+			return nil, &rpcError{Code: -32000, Message: err.Error()}
+		}
+		args, err := method.Inputs.Unpack(call.Data[4:])
+		if err != nil {
+			return nil, &rpcError{Code: -32000, Message: err.Error()}
+		}
+		var out []byte
+		zero := new(big.Int)
+		switch method.Name {
+		case "getStakersLength":
+			out, err = method.Outputs.Pack(big.NewInt(int64(len(stakers))))
+		case "stakers":
+			out, err = method.Outputs.Pack(stakers[args[0].(*big.Int).Int64()])
+		case "stakerInfo":
+			staker := args[0].(ethcommon.Address)
+			out, err = method.Outputs.Pack(big.NewInt(set[staker]), uint16(0), uint16(0), uint16(0), uint16(0), zero,
+				uint16(0), ethcommon.Address{}, zero, zero, zero, zero, zero, zero)
+		default:
+			return nil, &rpcError{Code: -32000, Message: "unexpected call of " + method.Name}
+		}
+		if err != nil {
+			return nil, &rpcError{Code: -32000, Message: err.Error()}
+		}
+		return hexutil.Bytes(out), nil
+	}
+}
+
+func TestListener_confirmStopped(t *testing.T) {
+	a, b, c := ethcommon.BytesToAddress(WorkBase[0]), ethcommon.BytesToAddress(WorkBase[1]), ethcommon.BytesToAddress(WorkBase[2])
+	info := func(staker ethcommon.Address, balance int64) *substrate.StakeInfo {
+		return newStakeInfo(staker, ethcommon.Address{}, big.NewInt(balance), false)
+	}
+	last := substrate.StakeInfos{info(a, 30), info(b, 20)}
+	// b dropped out of the stakers read at the tip
+	all := substrate.StakeInfos{info(a, 30), info(c, 25)}
+	top := substrate.StakeInfos{info(a, 30), info(c, 25)}
+
+	tests := []struct {
+		name     string
+		depth    uint64
+		deep     map[ethcommon.Address]int64
+		wantB    bool
+		wantTags []string
+		wantErr  bool
+	}{
+		{name: "disabled", depth: 0},
+		{name: "still staking at depth", depth: 5, deep: map[ethcommon.Address]int64{a: 30, b: 20}, wantB: true, wantTags: []string{"0x3e3"}},
+		{name: "stopped at depth", depth: 5, deep: map[ethcommon.Address]int64{a: 30}, wantTags: []string{"0x3e3"}},
+		{name: "depth unreadable", depth: 5, wantErr: true, wantTags: []string{"0x3e3"}},
+		{name: "depth below genesis", depth: 2000, deep: map[ethcommon.Address]int64{a: 30, b: 20}, wantB: true, wantTags: []string{"0x0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			balances := map[string]map[ethcommon.Address]int64{}
+			if tt.deep != nil {
+				balances[tt.wantTags[0]] = tt.deep
+			}
+			var tags []string
+			conn := newTestConnection(t, map[string]rpcHandler{"eth_call": stakingContract(t, balances, &tags)})
+			l := &Listener{
+				Config:  &config.Config{StoppedConfirmations: tt.depth, StoppedGraceEpochs: 1},
+				Ethconn: conn,
+			}
+			got, err := l.confirmStopped(top, all, last, big.NewInt(1000))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("confirmStopped() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(tags) > 0 {
+				tags = tags[:1]
+			}
+			if !reflect.DeepEqual(tags, tt.wantTags) {
+				t.Errorf("confirmStopped() read at %v, want %v", tags, tt.wantTags)
+			}
+			if tt.wantErr {
+				return
+			}
+			var hasB bool
+			for _, info := range got {
+				hasB = hasB || bytes.Equal(info.WorkBase, b[:])
+			}
+			wantLen := len(top)
+			if tt.wantB {
+				wantLen++
+			}
+			if hasB != tt.wantB || len(got) != wantLen {
+				t.Errorf("confirmStopped() = %d stakers with b = %v, want %d with b = %v", len(got), hasB, wantLen, tt.wantB)
+			}
+
+			// a staker confirmed at depth isn't counted absent in its grace period
+			_, absent := l.applyStopGrace(got, all, last, map[string]uint64{})
+			if _, counted := absent[ethcommon.Bytes2Hex(b[:])]; counted == tt.wantB {
+				t.Errorf("applyStopGrace() absent = %v after confirmStopped", absent)
+			}
+		})
+	}
+}
+
+func TestStakeInfoFileAbsentEpochs(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "stake-info.json")
+	infos := substrate.StakeInfos{
+		{Coinbase: Coinbase[0], WorkBase: WorkBase[0], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(1))},
+		{Coinbase: Coinbase[1], WorkBase: WorkBase[1], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(2))},
+	}
+	absent := map[string]uint64{ethcommon.Bytes2Hex(WorkBase[1]): 2}
+	if err := writeStakeInfoFile(filePath, infos, absent, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	got, gotAbsent, err := readStakeInfoFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, infos) || !reflect.DeepEqual(gotAbsent, absent) {
+		t.Errorf("readStakeInfoFile() = %v, %v, want %v, %v", got, gotAbsent, infos, absent)
+	}
+}
+
+func TestListener_noPersist(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = false
+
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	home := os.Getenv("HOME")
+	os.Setenv("HOME", dir)
+	defer os.Setenv("HOME", home)
+
+	defer resetStakeInfoList()
+	resetStakeInfoList()
+	staker := ethcommon.HexToAddress("0x01")
+	data := append(ethcommon.BigToHash(big.NewInt(10)).Bytes(), ethcommon.BigToHash(big.NewInt(1)).Bytes()...)
+	sub := &substrate.MockSubmitter{}
+	l := &Listener{
+		Config: &config.Config{EpochSize: 1000, SubmitMode: config.SubmitModeFull, MaxEventsPerBlock: config.MaxEventsPerBlock,
+			EthereumConfig: config.EthereumConfig{StakerTopic: &config.TopicSlice{Index: 1, Offset: 12, Length: 20}}},
+		Ethconn: newTestConnection(t, map[string]rpcHandler{
+			"eth_getLogs": func(params []json.RawMessage) (interface{}, *rpcError) {
+				return []*ethtypes.Log{{Topics: []ethcommon.Hash{Deposited.GetTopic(), ethcommon.BytesToHash(staker[:])}, Data: data}}, nil
+			},
+		}),
+		Source:                emptySource{},
+		Subconn:               sub,
+		LastStakeInfoPath:     "stake-info.json",
+		StartBlockPath:        "start-block",
+		MetricsPath:           "metrics.json",
+		DepositCheckpointPath: "checkpoint.json",
+		HistoryDir:            "history",
+		Audit:                 NewAuditLog("audit.jsonl"),
+		Sinks:                 []sink.Sink{&sink.File{Path: "sink.jsonl"}, &fakeSink{}},
+		Attester:              NewAttester(params.Watcher, "attestations.jsonl"),
+		Events:                sink.NewEventCSV(config.EventExportConfig{Path: "events.csv"}),
+	}
+	l.DisablePersistence()
+	if err := l.getDepositEventsForBlock(big.NewInt(1500)); err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range []int64{1000, 2000} {
+		if err := l.syncStakeInfos(big.NewInt(block)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls := sub.Calls(); len(calls) != 2 {
+		t.Errorf("syncStakeInfos() submitted %d times, want 2", len(calls))
+	}
+	if len(l.Sinks) != 1 || len(l.Sinks[0].(*fakeSink).updates) != 2 {
+		t.Errorf("Sinks = %v, want only the in-memory sink publishing both updates", l.Sinks)
+	}
+	if infos, absent, err := l.readLastStakeInfos(); err != nil || infos == nil || absent == nil {
+		t.Errorf("readLastStakeInfos() = %v, %v, %v", infos, absent, err)
+	}
+
+	if err := WriteStakeInfos("", substrate.StakeInfos{}, false); err != nil {
+		t.Errorf("WriteStakeInfos() error = %v", err)
+	}
+	if infos, err := ReadStakeInfos(""); err != nil || len(infos) != 0 {
+		t.Errorf("ReadStakeInfos() = %v, %v", infos, err)
+	}
+	if err := WriteLatestBlock("", big.NewInt(1), config.LatestBlockDec); err != nil {
+		t.Errorf("WriteLatestBlock() error = %v", err)
+	}
+	if block, err := ReadLatestBlock(""); err != nil || block.Sign() != 0 {
+		t.Errorf("ReadLatestBlock() = %v, %v", block, err)
+	}
+
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range fis {
+		t.Errorf("file %s was created without persistence", fi.Name())
+	}
+}
+
+func TestLatestBlockFormats(t *testing.T) {
+	dir := t.TempDir()
+	block, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	for _, tt := range []struct {
+		format, want string
+	}{
+		{config.LatestBlockDec, "123456789012345678901234567890"},
+		{config.LatestBlockHex, "0x18ee90ff6c373e0ee4e3f0ad2"},
+	} {
+		file := filepath.Join(dir, tt.format, "latest")
+		if err := WriteLatestBlock(file, block, tt.format); err != nil {
+			t.Fatalf("WriteLatestBlock(%s) error = %v", tt.format, err)
+		}
+		if data, err := ioutil.ReadFile(file); err != nil || string(data) != tt.want {
+			t.Errorf("WriteLatestBlock(%s) wrote %q, %v, want %q", tt.format, data, err, tt.want)
+		}
+		if got, err := ReadLatestBlock(file); err != nil || got.Cmp(block) != 0 {
+			t.Errorf("ReadLatestBlock(%s) = %v, %v, want %v", tt.format, got, err, block)
+		}
+	}
+
+	file := filepath.Join(dir, "latest")
+	for content, want := range map[string]int64{"0XFF\n": 255, " 42\n": 42} {
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := ReadLatestBlock(file); err != nil || got.Int64() != want {
+			t.Errorf("ReadLatestBlock(%q) = %v, %v, want %d", content, got, err, want)
+		}
+	}
+	for _, content := range []string{"", "0x", "0xzz", "-1", "ten"} {
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := ReadLatestBlock(file); err == nil {
+			t.Errorf("ReadLatestBlock(%q) = %v, want an error", content, got)
+		}
+	}
+}
+
+func TestListener_GetStakeInfoAt(t *testing.T) {
+	a, b := ethcommon.BytesToAddress(WorkBase[0]), ethcommon.BytesToAddress(WorkBase[1])
+	balances := map[string]map[ethcommon.Address]int64{"0x64": {a: 20, b: 30}}
+	var tags []string
+	conn := newTestConnection(t, map[string]rpcHandler{"eth_call": stakingContract(t, balances, &tags)})
+	l := &Listener{Config: &config.Config{}, Ethconn: conn}
+
+	top, err := l.TopAt(big.NewInt(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(top) != 2 || !bytes.Equal(top[0].WorkBase, b[:]) || top[0].LockedBalance.Int.Int64() != 30 {
+		t.Errorf("TopAt(100) = %v, want b before a", top)
+	}
+	for _, tag := range tags {
+		if tag != "0x64" {
+			t.Errorf("called the contract at %s, want every call pinned to 0x64", tag)
+		}
+	}
+
+	// stakingContract answers blocks it holds no balances for like a pruned node
+	if _, err := l.GetStakeInfoAt(big.NewInt(50)); !errors.Is(err, ErrStateUnavailable) {
+		t.Errorf("GetStakeInfoAt(50) error = %v, want ErrStateUnavailable", err)
+	}
+}
+
+func TestListener_GetStakeInfoFetchFailures(t *testing.T) {
+	defer func(d time.Duration) { stakerFetchBackoff = d }(stakerFetchBackoff)
+	stakerFetchBackoff = 0
+
+	parsed, err := abi.JSON(strings.NewReader(nucypher.NucypherABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	selector := parsed.Methods["stakerInfo"].ID
+	a, b, c := ethcommon.BytesToAddress(WorkBase[0]), ethcommon.BytesToAddress(WorkBase[1]), ethcommon.BytesToAddress(WorkBase[2])
+	var tags []string
+	contract := stakingContract(t, map[string]map[ethcommon.Address]int64{"latest": {a: 30, b: 20, c: 10}}, &tags)
+	limit := func(n uint64) *uint64 { return &n }
+
+	tests := []struct {
+		name     string
+		failures map[ethcommon.Address]int // failed stakerInfo calls before a staker is read
+		retries  int
+		max      *uint64
+		want     int
+		wantErr  bool
+	}{
+		{name: "no threshold", failures: map[ethcommon.Address]int{b: 100, c: 100}, want: 1},
+		{name: "at the threshold", failures: map[ethcommon.Address]int{b: 100, c: 100}, max: limit(2), want: 1},
+		{name: "over the threshold", failures: map[ethcommon.Address]int{b: 100, c: 100}, max: limit(1), wantErr: true},
+		{name: "retried", failures: map[ethcommon.Address]int{b: 2, c: 1}, retries: 2, max: limit(0), want: 3},
+		{name: "retries exhausted", failures: map[ethcommon.Address]int{b: 3}, retries: 2, max: limit(0), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failures := make(map[ethcommon.Address]int, len(tt.failures))
+			for k, v := range tt.failures {
+				failures[k] = v
+			}
+			conn := newTestConnection(t, map[string]rpcHandler{
+				"eth_call": func(params []json.RawMessage) (interface{}, *rpcError) {
+					var call struct {
+						Data hexutil.Bytes `json:"data"`
+					}
+					if err := json.Unmarshal(params[0], &call); err == nil && len(call.Data) >= 36 && bytes.Equal(call.Data[:4], selector) {
+						staker := ethcommon.BytesToAddress(call.Data[4:36])
+						if failures[staker] > 0 {
+							failures[staker]--
+							return nil, &rpcError{Code: -32000, Message: "upstream timeout"}
+						}
+					}
+					return contract(params)
+				},
+			})
+			cfg := &config.Config{StakerFetchRetries: tt.retries, MaxStakerFetchFailures: tt.max}
+			l := &Listener{Config: cfg, Ethconn: conn}
+			infos, err := l.GetStakeInfo(big.NewInt(1000))
+			if tt.wantErr {
+				if !errors.Is(err, ErrIncompleteStakeInfos) {
+					t.Errorf("GetStakeInfo() error = %v, want ErrIncompleteStakeInfos", err)
+				}
+				return
+			}
+			if err != nil || len(infos) != tt.want {
+				t.Errorf("GetStakeInfo() = %d stake infos, %v, want %d", len(infos), err, tt.want)
+			}
+		})
+	}
+}
+
+func TestListener_zeroStakersPolicy(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = false
+
+	a := ethcommon.BytesToAddress(WorkBase[0])
+	tests := []struct {
+		name        string
+		policy      string
+		confirmed   bool // the contract reports no stakers at the synced block too
+		wantErr     bool
+		wantSubmits int
+	}{
+		{name: "skip by default", confirmed: true},
+		{name: "skip", policy: config.ZeroStakersSkip},
+		{name: "submit-empty", policy: config.ZeroStakersSubmitEmpty, confirmed: true, wantSubmits: 1},
+		{name: "submit-empty transient", policy: config.ZeroStakersSubmitEmpty, wantSubmits: 1},
+		{name: "abort", policy: config.ZeroStakersAbort, confirmed: true, wantErr: true},
+		{name: "abort transient", policy: config.ZeroStakersAbort, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetStakeInfoList()
+			balances := map[string]map[ethcommon.Address]int64{"latest": {}, "0x3e8": {}}
+			if !tt.confirmed {
+				balances["0x3e8"] = map[ethcommon.Address]int64{a: 10}
+			}
+			var tags []string
+			conn := newTestConnection(t, map[string]rpcHandler{"eth_call": stakingContract(t, balances, &tags)})
+			cfg := &config.Config{EpochSize: 1000, SubmitMode: config.SubmitModeFull, ZeroStakersPolicy: tt.policy,
+				EthereumConfig: config.EthereumConfig{BlockConfirmations: big.NewInt(10)}}
+			sub := &substrate.MockSubmitter{}
+			l := &Listener{Config: cfg, Ethconn: conn, Subconn: sub}
+
+			err := l.syncStakeInfos(big.NewInt(1000))
+			if tt.wantErr != errors.Is(err, ErrZeroStakers) || (!tt.wantErr && err != nil) {
+				t.Fatalf("syncStakeInfos() error = %v, want ErrZeroStakers %v", err, tt.wantErr)
+			}
+			if tt.wantErr && tt.confirmed != !strings.Contains(err.Error(), "not confirmed") {
+				t.Errorf("syncStakeInfos() error = %v, want confirmed %v", err, tt.confirmed)
+			}
+			if calls := len(sub.Calls()); calls != tt.wantSubmits {
+				t.Errorf("submitted %d times, want %d", calls, tt.wantSubmits)
+			}
+		})
+	}
+}
+
+func TestListener_capStoppedMassExit(t *testing.T) {
+	info := func(i int) *substrate.StakeInfo {
+		return &substrate.StakeInfo{Coinbase: Coinbase[i], WorkBase: WorkBase[i], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(int64(10 - i)))}
+	}
+	last := substrate.StakeInfos{info(0), info(1), info(2), info(3), info(4), info(5)}
+	cfg := &config.Config{EpochSize: 1000, SubmitMode: config.SubmitModeDiff, FullResyncEpochs: 10, MaxStoppedPerEpoch: 2}
+	path := filepath.Join(t.TempDir(), "stake-info.json")
+	sub := &substrate.MockSubmitter{}
+	l := &Listener{Config: cfg, Subconn: sub, LastStakeInfoPath: path, lastSubmitted: last}
+
+	// stopped reports the work bases of the stopped stakers of the last submission
+	stopped := func() []string {
+		calls := sub.Calls()
+		payload, _ := calls[len(calls)-1].Args[0].(substrate.StakeInfos)
+		var got []string
+		for _, info := range payload {
+			if !info.IsWork {
+				got = append(got, ethcommon.Bytes2Hex(info.WorkBase))
+			}
+		}
+		return got
+	}
+	want := func(is ...int) []string {
+		var keys []string
+		for _, i := range is {
+			keys = append(keys, ethcommon.Bytes2Hex(WorkBase[i]))
+		}
+		return keys
+	}
+	submit := func(l *Listener, block int64, top substrate.StakeInfos) {
+		t.Helper()
+		if err := l.submitSet(&pendingSet{block: big.NewInt(block), top: top, submit: top}, time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// five stakers exit at once, two are submitted stopped and three deferred by work base
+	top := substrate.StakeInfos{info(0)}
+	submit(l, 1000, top)
+	if got := stopped(); !reflect.DeepEqual(got, want(1, 2)) {
+		t.Errorf("epoch 1 stopped %v, want %v", got, want(1, 2))
+	}
+	if _, _, deferred, err := readStakeInfoState(path); err != nil || len(deferred) != 3 {
+		t.Fatalf("persisted %d deferred stopped stakers, %v, want 3", len(deferred), err)
+	}
+
+	// staker 3 rejoins, its deferred stop is dropped and the next deferred ones are submitted
+	top = substrate.StakeInfos{info(0), info(3)}
+	submit(l, 2000, top)
+	if got := stopped(); !reflect.DeepEqual(got, want(4, 5)) {
+		t.Errorf("epoch 2 stopped %v, want %v", got, want(4, 5))
+	}
+
+	// with a cap of one, the two remaining stakers exit: staker 0 is submitted stopped and 3 deferred
+	l.Config.MaxStoppedPerEpoch = 1
+	submit(l, 3000, substrate.StakeInfos{})
+	if got := stopped(); !reflect.DeepEqual(got, want(0)) {
+		t.Errorf("epoch 3 stopped %v, want %v", got, want(0))
+	}
+
+	// a restart resumes the deferred stopped stakers, its first full set keeps them deferred
+	l = &Listener{Config: cfg, Subconn: sub, LastStakeInfoPath: path}
+	submit(l, 4000, substrate.StakeInfos{})
+	if got := stopped(); len(got) != 0 || len(l.deferredStopped) != 1 {
+		t.Errorf("full set stopped %v and deferred %d, want none and 1", got, len(l.deferredStopped))
+	}
+	submit(l, 5000, substrate.StakeInfos{info(1)})
+	if got := stopped(); !reflect.DeepEqual(got, want(3)) {
+		t.Errorf("epoch 5 stopped %v, want %v", got, want(3))
+	}
+	if len(l.deferredStopped) != 0 {
+		t.Errorf("%d stopped stakers still deferred", len(l.deferredStopped))
+	}
+}
@@ -0,0 +1,106 @@
+package ethereum
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/NuLink-network/watcher/watcher/sink"
+)
+
+// Status is what the status endpoint reports about a running listener
+type Status struct {
+	Block          *big.Int      `json:"block"`
+	SafeHead       *big.Int      `json:"safeHead"`
+	Epoch          uint64        `json:"epoch"`
+	Maintenance    bool          `json:"maintenance"`
+	ChurnHeld      bool          `json:"churnHeld"`
+	LastSubmission *sink.Update  `json:"lastSubmission,omitempty"`
+	Accumulated    []sink.Staker `json:"accumulated"`
+	Time           time.Time     `json:"time"`
+}
+
+// statusState is the Status shared between the poll loop, which writes it, and the status readers. The
+// loop only ever replaces its values with fresh copies, so a reader holding a Status never sees it change.
+type statusState struct {
+	mu     sync.RWMutex
+	status Status
+}
+
+// Status returns a copy of the latest status of the listener. It is safe to call while the listener runs.
+func (l *Listener) Status() Status {
+	l.statusState.mu.RLock()
+	defer l.statusState.mu.RUnlock()
+	return l.statusState.status
+}
+
+// StatusHandler serves the Status of the listener as json
+func (l *Listener) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(l.Status()); err != nil {
+			log.Debug("failed to write the status", "error", err)
+		}
+	})
+}
+
+// refreshStatus publishes the progress of the poll loop and a copy of the accumulated deposits to the
+// status readers, it must only be called from the poll loop
+func (l *Listener) refreshStatus() {
+	var block, head *big.Int
+	var epoch uint64
+	if l.stats.LastBlock != nil {
+		block = new(big.Int).Set(l.stats.LastBlock)
+		epoch = l.statusEpoch(block)
+	}
+	if l.stats.SafeHead != nil {
+		head = new(big.Int).Set(l.stats.SafeHead)
+	}
+	accumulated := copyStakers(sink.NewUpdate(0, nil, stakeInfoList, l.clock().Now()).Stakers)
+
+	l.statusState.mu.Lock()
+	defer l.statusState.mu.Unlock()
+	s := &l.statusState.status
+	s.Block, s.SafeHead, s.Epoch = block, head, epoch
+	s.Maintenance, s.ChurnHeld = l.InMaintenance(), l.churnHeld != nil
+	s.Accumulated = accumulated
+	s.Time = l.clock().Now().UTC()
+}
+
+// recordSubmissionStatus publishes the submitted set to the status readers
+func (l *Listener) recordSubmissionStatus(set *pendingSet) {
+	u := sink.NewUpdate(l.statusEpoch(set.block), new(big.Int).Set(set.block), set.submit, l.clock().Now())
+	u.Stakers = copyStakers(u.Stakers)
+	l.statusState.mu.Lock()
+	defer l.statusState.mu.Unlock()
+	l.statusState.status.LastSubmission = &u
+}
+
+// statusEpoch returns the epoch of block, 0 for a config without an epoch size as built outside GetConfig
+func (l *Listener) statusEpoch(block *big.Int) uint64 {
+	if l.Config.EpochSize == 0 {
+		return 0
+	}
+	return l.Config.Epoch(block.Uint64())
+}
+
+// copyStakers detaches the bytes of stakers from the stake infos they were converted from, which the poll
+// loop keeps updating
+func copyStakers(stakers []sink.Staker) []sink.Staker {
+	for i := range stakers {
+		s := &stakers[i]
+		s.Coinbase = append(hexutil.Bytes(nil), s.Coinbase...)
+		s.WorkBase = append(hexutil.Bytes(nil), s.WorkBase...)
+	}
+	return stakers
+}
@@ -0,0 +1,89 @@
+package ethereum
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+// TestListener_StatusConcurrent reads the status endpoint while the poll loop runs and submits, run it with
+// -race (make test-race) to check the status readers never touch the state of the loop
+func TestListener_StatusConcurrent(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = false
+	defer resetStakeInfoList()
+	resetStakeInfoList()
+	addDeposit(config.AggregateSum, ethcommon.HexToAddress("0xa1"), ethcommon.HexToAddress("0x01"), big.NewInt(5))
+
+	// every poll sees a new block, an epoch boundary every 10 blocks
+	latest := int64(1000)
+	conn := newTestConnection(t, map[string]rpcHandler{
+		"eth_getBlockByNumber": func(params []json.RawMessage) (interface{}, *rpcError) {
+			return testHeader(atomic.AddInt64(&latest, 1)), nil
+		},
+	})
+	l := &Listener{
+		Config: &config.Config{EpochSize: 10, SubmitMode: config.SubmitModeFull,
+			PollInterval: config.Duration{Duration: time.Millisecond}, RetryInterval: config.Duration{Duration: time.Millisecond},
+			EthereumConfig: config.EthereumConfig{BlockConfirmations: big.NewInt(0)}},
+		Ethconn: conn,
+		Source:  emptySource{},
+		Subconn: &substrate.MockSubmitter{},
+		Stop:    make(chan struct{}, 1),
+	}
+	srv := httptest.NewServer(l.StatusHandler())
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	var wg sync.WaitGroup
+	var reads int64
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				resp, err := http.Get(srv.URL)
+				if err != nil {
+					continue
+				}
+				var s Status
+				if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+					t.Errorf("decode status: %v", err)
+				}
+				resp.Body.Close()
+				atomic.AddInt64(&reads, 1)
+			}
+		}()
+	}
+	l.Run(ctx)
+	wg.Wait()
+
+	s := l.Status()
+	if s.Block == nil || s.LastSubmission == nil || len(s.Accumulated) != 1 || s.Accumulated[0].LockedBalance != "5" {
+		t.Errorf("Status() = %+v, want the last block, submission and the accumulated deposit", s)
+	}
+	if atomic.LoadInt64(&reads) == 0 {
+		t.Error("status endpoint never read")
+	}
+
+	resp, err := http.Post(srv.URL, "application/json", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
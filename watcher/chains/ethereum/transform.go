@@ -0,0 +1,56 @@
+package ethereum
+
+import (
+	"math/big"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+)
+
+// Transform applies business rules such as caps, weights or exclusions to the TopN set of an epoch before it
+// is submitted. It returns a new set and leaves the stake infos it is given unchanged, the result should be
+// ordered by locked balance like its input for VerifyTopN to accept it.
+type Transform func(substrate.StakeInfos) substrate.StakeInfos
+
+// IdentityTransform is the default Transform, it submits the TopN set as selected
+func IdentityTransform(infos substrate.StakeInfos) substrate.StakeInfos {
+	return infos
+}
+
+// ChainTransforms returns a Transform applying transforms in order
+func ChainTransforms(transforms ...Transform) Transform {
+	return func(infos substrate.StakeInfos) substrate.StakeInfos {
+		for _, t := range transforms {
+			infos = t(infos)
+		}
+		return infos
+	}
+}
+
+// CapBalance returns a Transform limiting the locked balance of every staker to max
+func CapBalance(max *big.Int) Transform {
+	return func(infos substrate.StakeInfos) substrate.StakeInfos {
+		capped := make(substrate.StakeInfos, 0, len(infos))
+		for _, info := range infos {
+			if info.LockedBalance.Int != nil && info.LockedBalance.Cmp(max) > 0 {
+				c := *info
+				c.LockedBalance = types.NewU128(*max)
+				log.Debug("capped the locked balance", "workBase", ethcommon.Bytes2Hex(info.WorkBase), "balance", info.LockedBalance, "cap", max)
+				info = &c
+			}
+			capped = append(capped, info)
+		}
+		return capped
+	}
+}
+
+// transform applies the Transform of the listener to the TopN set, the identity if none is registered
+func (l *Listener) transform(top substrate.StakeInfos) substrate.StakeInfos {
+	if l.Transform == nil {
+		return top
+	}
+	return l.Transform(top)
+}
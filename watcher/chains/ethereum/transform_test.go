@@ -0,0 +1,91 @@
+package ethereum
+
+import (
+	"math/big"
+	"testing"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+func TestCapBalance(t *testing.T) {
+	infos := churnSet(0, 3, 10)
+	infos[0] = churnSet(0, 1, 50)[0]
+	capped := CapBalance(big.NewInt(20))(infos)
+
+	for i, want := range []int64{20, 10, 10} {
+		if got := capped[i].LockedBalance.Int64(); got != want {
+			t.Errorf("CapBalance() balance %d = %d, want %d", i, got, want)
+		}
+	}
+	if got := infos[0].LockedBalance.Int64(); got != 50 {
+		t.Errorf("CapBalance() modified its input, balance = %d, want 50", got)
+	}
+	if capped[1] != infos[1] {
+		t.Errorf("CapBalance() replaced a stake info below the cap")
+	}
+}
+
+func TestListener_transform(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = false
+	resetStakeInfoList()
+
+	// more stakers than the top n, ranked by their balance
+	balances := map[ethcommon.Address]int64{}
+	for i := 1; i <= substrate.TopN+5; i++ {
+		balances[ethcommon.BigToAddress(big.NewInt(int64(i)))] = int64(i) * 100
+	}
+	var tags []string
+	conn := newTestConnection(t, map[string]rpcHandler{
+		"eth_call": stakingContract(t, map[string]map[ethcommon.Address]int64{"latest": balances}, &tags),
+	})
+	sub := &substrate.MockSubmitter{}
+	var seen []substrate.StakeInfos
+	record := func(infos substrate.StakeInfos) substrate.StakeInfos {
+		seen = append(seen, infos)
+		return infos
+	}
+	l := &Listener{
+		Config: &config.Config{EpochSize: 1000, SubmitMode: config.SubmitModeFull,
+			EthereumConfig: config.EthereumConfig{BlockConfirmations: big.NewInt(0)}},
+		Ethconn: conn,
+		Subconn: sub,
+		// the cap sees the selected top n, record sees the capped set
+		Transform: ChainTransforms(IdentityTransform, CapBalance(big.NewInt(1000)), record),
+	}
+	if err := l.syncStakeInfos(big.NewInt(1000)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(seen) != 1 {
+		t.Fatalf("Transform called %d times, want once", len(seen))
+	}
+	if len(seen[0]) != substrate.TopN {
+		t.Fatalf("Transform got %d stake infos, want the top %d", len(seen[0]), substrate.TopN)
+	}
+	// the top n is selected by the uncapped balances, the smallest staker in it locks 600
+	for _, info := range seen[0] {
+		if info.LockedBalance.Int64() > 1000 {
+			t.Errorf("Transform ran before the cap, balance %s", info.LockedBalance)
+		}
+		if new(big.Int).SetBytes(info.WorkBase).Int64() <= 5 {
+			t.Errorf("Transform got staker %x outside the top %d", info.WorkBase, substrate.TopN)
+		}
+	}
+	calls := sub.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("submitted %d times, want once", len(calls))
+	}
+	submitted := calls[0].Args[0].(substrate.StakeInfos)
+	var total int64
+	for _, info := range submitted {
+		total += info.LockedBalance.Int64()
+	}
+	// 16 stakers capped at 1000 and 600 to 900
+	if want := int64(16*1000 + 600 + 700 + 800 + 900); total != want {
+		t.Errorf("submitted a total balance of %d, want the capped %d", total, want)
+	}
+}
@@ -0,0 +1,54 @@
+package ethereum
+
+import (
+	"bytes"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+)
+
+// verifySubmission reads the stake infos stored by the pallet back after set was submitted and compares
+// them with the SCALE encoding of the whole set, with VerifySubmission. A submission of only the changes
+// is compared against the set it completes. Mismatches and failed reads are logged and counted, they don't
+// fail the submission.
+func (l *Listener) verifySubmission(set *pendingSet) {
+	if !l.Config.VerifySubmission || l.DumpScale {
+		return
+	}
+	r, ok := l.Subconn.(substrate.StakeInfoReader)
+	if !ok {
+		log.Warn("submitter can't read back the stake infos, skip verification", "block", set.block)
+		return
+	}
+	payload, err := substrate.EncodePayload(l.Config.PayloadVersion, set.submit)
+	if err != nil {
+		log.Warn("failed to encode the submitted stake infos for verification", "block", set.block, "error", err)
+		l.stats.VerifyErrors++
+		return
+	}
+	want, err := types.EncodeToBytes(payload)
+	if err != nil {
+		log.Warn("failed to encode the submitted stake infos for verification", "block", set.block, "error", err)
+		l.stats.VerifyErrors++
+		return
+	}
+
+	// the submission returns once the extrinsic is in the pool, give it time to be included
+	l.sleep(l.context(), l.Config.VerifyDelay.Duration)
+	got, err := r.StoredStakeInfos()
+	if err != nil {
+		log.Warn("failed to read back the submitted stake infos", "block", set.block, "error", err)
+		l.stats.VerifyErrors++
+		return
+	}
+	if !bytes.Equal(got, want) {
+		log.Error("stored stake infos don't match the submission", "block", set.block, "count", len(set.submit),
+			"submitted", crypto.Keccak256Hash(want).Hex(), "stored", crypto.Keccak256Hash(got).Hex(), "storedBytes", len(got))
+		l.stats.VerifyMismatches++
+		return
+	}
+	log.Info("verified the submitted stake infos against the pallet storage", "block", set.block, "count", len(set.submit))
+}
@@ -0,0 +1,58 @@
+package ethereum
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+func TestListener_verifySubmission(t *testing.T) {
+	infos := substrate.StakeInfos{
+		newStakeInfo(ethcommon.BytesToAddress(WorkBase[0]), ethcommon.Address{}, big.NewInt(30), false),
+		newStakeInfo(ethcommon.BytesToAddress(WorkBase[1]), ethcommon.Address{}, big.NewInt(20), false),
+	}
+	tests := []struct {
+		name         string
+		version      int
+		stored       []byte // replaces what the mock stored on submission
+		wantMismatch uint64
+	}{
+		{name: "matching", version: substrate.PayloadV1},
+		{name: "matching v2", version: substrate.PayloadV2},
+		{name: "partially applied", version: substrate.PayloadV1, stored: []byte{0x04}, wantMismatch: 1},
+		{name: "not applied", version: substrate.PayloadV1, stored: []byte{}, wantMismatch: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := &substrate.MockSubmitter{Store: true}
+			cfg := &config.Config{PayloadVersion: tt.version, VerifySubmission: true, VerifyDelay: config.Duration{Duration: time.Millisecond}}
+			l := &Listener{Config: cfg, Subconn: sub}
+			set := &pendingSet{block: big.NewInt(1000), top: infos, submit: infos}
+			if err := l.submitSet(set, time.Time{}); err != nil {
+				t.Fatal(err)
+			}
+			l.stats.VerifyMismatches = 0
+			if tt.stored != nil {
+				sub.SetStored(tt.stored)
+			}
+			l.verifySubmission(set)
+			if l.stats.VerifyMismatches != tt.wantMismatch || l.stats.VerifyErrors != 0 {
+				t.Errorf("verifySubmission() mismatches = %d, errors = %d, want %d and 0", l.stats.VerifyMismatches, l.stats.VerifyErrors, tt.wantMismatch)
+			}
+		})
+	}
+
+	// without VerifySubmission nothing is read back
+	sub := &substrate.MockSubmitter{}
+	sub.SetStored([]byte{0x04})
+	l := &Listener{Config: &config.Config{}, Subconn: sub}
+	l.verifySubmission(&pendingSet{block: big.NewInt(1000), submit: infos})
+	if l.stats.VerifyMismatches != 0 {
+		t.Errorf("verifySubmission() without VerifySubmission counted %d mismatches", l.stats.VerifyMismatches)
+	}
+}
@@ -0,0 +1,63 @@
+package ethereum
+
+import (
+	"math/big"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+)
+
+// FileVerification compares a stake info file with the top stakers on chain
+type FileVerification struct {
+	// Block is the safe head the top stakers were read for, as an epoch sync reads them
+	Block *big.Int
+	// Top holds the top stakers on chain
+	Top substrate.StakeInfos
+	// Agreed holds the stakers of the file on chain with the same locked balance, Diff the ones that diverge:
+	// Joined are on chain only, Left in the file only, Updated on chain with another balance
+	Agreed substrate.StakeInfos
+	Diff   substrate.StakeInfoDiff
+	// Members and Balance are the divergence in percent, as compared by maxChurnPercent
+	Members float64
+	Balance float64
+}
+
+// Diverges reports whether the membership or the balance divergence exceeds max percent
+func (v *FileVerification) Diverges(max float64) bool {
+	return v.Members > max || v.Balance > max
+}
+
+// VerifyStakeInfos compares infos, e.g. read from a stake info file, with the top stakers the listener would
+// select at the current safe head. Nothing is submitted or persisted.
+func (l *Listener) VerifyStakeInfos(infos substrate.StakeInfos) (*FileVerification, error) {
+	head, err := l.Ethconn.SafeHead(l.Config.EthereumConfig.BlockConfirmations)
+	if err != nil {
+		return nil, err
+	}
+	all, err := l.GetStakeInfo(head)
+	if err != nil {
+		return nil, err
+	}
+	top := l.transform(l.selectTop(all))
+
+	v := &FileVerification{Block: head, Top: top, Diff: substrate.DiffStakeInfos(infos, top)}
+	diverged := make(map[string]struct{}, len(v.Diff.Joined)+len(v.Diff.Updated))
+	for _, info := range v.Diff.Joined {
+		diverged[string(info.WorkBase)] = struct{}{}
+	}
+	for _, info := range v.Diff.Updated {
+		diverged[string(info.WorkBase)] = struct{}{}
+	}
+	for _, info := range top {
+		if _, ok := diverged[string(info.WorkBase)]; !ok {
+			v.Agreed = append(v.Agreed, info)
+		}
+	}
+	if len(infos) == 0 {
+		if len(top) > 0 {
+			v.Members, v.Balance = 100, 100
+		}
+		return v, nil
+	}
+	v.Members, v.Balance = churn(infos, top)
+	return v, nil
+}
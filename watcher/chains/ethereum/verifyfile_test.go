@@ -0,0 +1,62 @@
+package ethereum
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+func TestListener_VerifyStakeInfos(t *testing.T) {
+	a, b := ethcommon.BytesToAddress(WorkBase[0]), ethcommon.BytesToAddress(WorkBase[1])
+	info := func(i int, balance int64) *substrate.StakeInfo {
+		return &substrate.StakeInfo{Coinbase: Coinbase[i], WorkBase: WorkBase[i], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(balance))}
+	}
+	tests := []struct {
+		name                     string
+		file                     substrate.StakeInfos
+		wantAgreed               int
+		wantJoined, wantLeft     int
+		wantUpdated              int
+		wantMembers, wantBalance float64
+	}{
+		{name: "match", file: substrate.StakeInfos{info(0, 10), info(1, 30)}, wantAgreed: 2},
+		{name: "balance", file: substrate.StakeInfos{info(0, 10), info(1, 10)}, wantAgreed: 1, wantUpdated: 1, wantBalance: 100},
+		{name: "membership", file: substrate.StakeInfos{info(1, 30), info(2, 10)}, wantAgreed: 1, wantJoined: 1, wantLeft: 1, wantMembers: 50},
+		{name: "empty file", wantJoined: 2, wantMembers: 100, wantBalance: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tags []string
+			conn := newTestConnection(t, map[string]rpcHandler{
+				"eth_getBlockByNumber": blockByNumber(1000, nil),
+				"eth_call":             stakingContract(t, map[string]map[ethcommon.Address]int64{"latest": {a: 10, b: 30}}, &tags),
+			})
+			l := &Listener{
+				Config:  &config.Config{EpochSize: 1000, EthereumConfig: config.EthereumConfig{BlockConfirmations: big.NewInt(10)}},
+				Ethconn: conn,
+			}
+			v, err := l.VerifyStakeInfos(tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.Block.Int64() != 990 || len(v.Top) != 2 {
+				t.Fatalf("VerifyStakeInfos() read %d stakers at block %s, want 2 at 990", len(v.Top), v.Block)
+			}
+			if len(v.Agreed) != tt.wantAgreed || len(v.Diff.Joined) != tt.wantJoined || len(v.Diff.Left) != tt.wantLeft || len(v.Diff.Updated) != tt.wantUpdated {
+				t.Errorf("VerifyStakeInfos() agreed %d, joined %d, left %d, updated %d, want %d, %d, %d, %d", len(v.Agreed), len(v.Diff.Joined),
+					len(v.Diff.Left), len(v.Diff.Updated), tt.wantAgreed, tt.wantJoined, tt.wantLeft, tt.wantUpdated)
+			}
+			if v.Members != tt.wantMembers || v.Balance != tt.wantBalance {
+				t.Errorf("VerifyStakeInfos() divergence = %g%%, %g%%, want %g%%, %g%%", v.Members, v.Balance, tt.wantMembers, tt.wantBalance)
+			}
+			if diverges := tt.wantMembers > 0 || tt.wantBalance > 0; v.Diverges(0) != diverges {
+				t.Errorf("Diverges(0) = %v, want %v", !diverges, diverges)
+			}
+		})
+	}
+}
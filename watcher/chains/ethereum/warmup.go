@@ -0,0 +1,40 @@
+package ethereum
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+)
+
+// warmingUp reports whether the update of the epoch boundary block is suppressed because the watcher is
+// still catching up with head, the safe head it syncs to, with SuppressSubmitUntilCaughtUp. The warm-up
+// ends once the watcher reaches the safe head, i.e. comes within BlockConfirmations of the tip, the updates
+// of later boundaries are never suppressed.
+func (l *Listener) warmingUp(block, head *big.Int) bool {
+	if !l.Config.SuppressSubmitUntilCaughtUp || l.warmupDone {
+		return false
+	}
+	if block.Cmp(head) >= 0 {
+		l.warmupDone = true
+		l.warmupHeld = nil
+		return false
+	}
+	log.Info("catching up with the chain, suppress the stake info update of the epoch", "block", block, "head", head)
+	l.warmupHeld = block
+	l.stats.SuppressedEpochs++
+	return true
+}
+
+// endWarmup submits the set read at head once the boundaries suppressed while catching up are behind, a
+// single current update in place of the historical ones. It does nothing when no update was suppressed.
+func (l *Listener) endWarmup(head *big.Int, read func(*big.Int) (substrate.StakeInfos, error)) error {
+	if l.warmupHeld == nil {
+		return nil
+	}
+	log.Info("caught up with the chain, submitting the current stake infos", "block", head, "lastSuppressed", l.warmupHeld, "suppressed", l.stats.SuppressedEpochs)
+	l.warmupHeld = nil
+	l.warmupDone = true
+	return l.recoverSnapshot(head, l.runEpoch(head, read))
+}
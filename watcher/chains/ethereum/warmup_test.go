@@ -0,0 +1,138 @@
+package ethereum
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+func TestListener_suppressSubmitUntilCaughtUp(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+
+	tests := []struct {
+		name     string
+		suppress bool
+		// the blocks polled as the safe head, the last one ends the run
+		heads           []int64
+		wantSubmissions int
+		wantSuppressed  uint64
+	}{
+		// the boundaries 1000 to 4000 are crossed while backfilling, only the set at the safe head is submitted
+		{name: "suppressed", suppress: true, heads: []int64{4500}, wantSubmissions: 1, wantSuppressed: 4},
+		{name: "every boundary", heads: []int64{4500}, wantSubmissions: 4},
+		// once caught up the boundaries sync as usual
+		{name: "after the warm-up", suppress: true, heads: []int64{4500, 5000}, wantSubmissions: 2, wantSuppressed: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first = false
+			resetStakeInfoList()
+			a := common.BytesToAddress(WorkBase[0])
+			var tags []string
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			polls := 0
+			conn := newTestConnection(t, map[string]rpcHandler{
+				"eth_getBlockByNumber": func(params []json.RawMessage) (interface{}, *rpcError) {
+					var tag string
+					_ = json.Unmarshal(params[0], &tag)
+					if tag != "latest" {
+						n, _ := new(big.Int).SetString(tag[2:], 16)
+						return testHeader(n.Int64()), nil
+					}
+					if polls == len(tt.heads) {
+						cancel()
+						return testHeader(tt.heads[len(tt.heads)-1]), nil
+					}
+					polls++
+					return testHeader(tt.heads[polls-1]), nil
+				},
+				"eth_call":    stakingContract(t, map[string]map[common.Address]int64{"latest": {a: 10}}, &tags),
+				"eth_getCode": func(params []json.RawMessage) (interface{}, *rpcError) { return "0x01", nil },
+			})
+			sub := &substrate.MockSubmitter{}
+			l := &Listener{
+				Config: &config.Config{EpochSize: 1000, SubmitMode: config.SubmitModeFull, CatchUpEpochs: true,
+					SuppressSubmitUntilCaughtUp: tt.suppress, PollInterval: config.Duration{Duration: time.Millisecond},
+					EthereumConfig: config.EthereumConfig{BlockConfirmations: big.NewInt(0), StartBlock: big.NewInt(0),
+						DepositContractAddr: a.Hex()}},
+				Ethconn: conn,
+				Subconn: sub,
+				Stop:    make(chan struct{}, 1),
+			}
+			stats, err := l.Run(ctx)
+			if !errors.Is(err, context.Canceled) {
+				t.Fatalf("Run() error = %v, want %v", err, context.Canceled)
+			}
+			if got := len(sub.Calls()); got != tt.wantSubmissions {
+				t.Errorf("Run() made %d submissions, want %d", got, tt.wantSubmissions)
+			}
+			if stats.SuppressedEpochs != tt.wantSuppressed {
+				t.Errorf("Run() suppressed %d epochs, want %d", stats.SuppressedEpochs, tt.wantSuppressed)
+			}
+		})
+	}
+}
+
+func TestListener_suppressMissedEpochs(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+	first = false
+
+	staker := common.BytesToAddress(WorkBase[0])
+	balances := map[string]map[common.Address]int64{
+		"0x7d0":  {staker: 20},
+		"0xbb8":  {staker: 30},
+		"0xfa0":  {staker: 40},
+		"0x1194": {staker: 45},
+	}
+	var tags []string
+	conn := newTestConnection(t, map[string]rpcHandler{
+		"eth_getBlockByNumber": blockByNumber(4500, nil),
+		"eth_call":             stakingContract(t, balances, &tags),
+	})
+	sub := &substrate.MockSubmitter{}
+	l := &Listener{Config: &config.Config{EpochSize: 1000, SubmitMode: config.SubmitModeFull, CatchUpMissedEpochs: true,
+		SuppressSubmitUntilCaughtUp: true, EthereumConfig: config.EthereumConfig{BlockConfirmations: big.NewInt(0)}},
+		Ethconn: conn, Subconn: sub}
+
+	got, err := l.catchUpMissedEpochs(big.NewInt(1500))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Int64() != 4500 {
+		t.Errorf("catchUpMissedEpochs() = %v, want the safe head 4500", got)
+	}
+	calls := sub.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("made %d submissions, want 1", len(calls))
+	}
+	if infos := calls[0].Args[0].(substrate.StakeInfos); len(infos) != 1 || infos[0].LockedBalance.Int64() != 45 {
+		t.Errorf("submitted %v, want the set at the safe head", infos)
+	}
+}
+
+// A failed read of the set ending the warm-up is handled by OnSnapshotFailure like any boundary
+func TestListener_endWarmupSnapshotFailure(t *testing.T) {
+	defer func(f bool) { first = f }(first)
+
+	sub := &substrate.MockSubmitter{}
+	l := &Listener{Config: &config.Config{EpochSize: 1000, SubmitMode: config.SubmitModeFull, SuppressSubmitUntilCaughtUp: true,
+		OnSnapshotFailure: config.SnapshotFailureSkip}, Ethconn: newTestConnection(t, nil), Subconn: sub}
+	l.warmupHeld = big.NewInt(4000)
+
+	read := func(*big.Int) (substrate.StakeInfos, error) { return nil, errors.New("upstream unavailable") }
+	if err := l.endWarmup(big.NewInt(4500), read); err != nil {
+		t.Fatalf("endWarmup() error = %v, want the epoch skipped", err)
+	}
+	if !l.warmupDone || l.stats.SnapshotFailures != 1 || len(sub.Calls()) != 0 {
+		t.Errorf("endWarmup() counted %d snapshot failures and made %d submissions, want the failure skipped", l.stats.SnapshotFailures, len(sub.Calls()))
+	}
+}
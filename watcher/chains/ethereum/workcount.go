@@ -0,0 +1,54 @@
+package ethereum
+
+import (
+	"fmt"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+)
+
+// storedWorkCounts reads the WorkCount the NuProxy pallet stores for each work base, keyed by its hex
+func (l *Listener) storedWorkCounts() (map[string]uint32, error) {
+	r, ok := l.Subconn.(substrate.StakeInfoReader)
+	if !ok {
+		return nil, fmt.Errorf("submitter can't read the stored stake infos")
+	}
+	data, err := r.StoredStakeInfos()
+	if err != nil {
+		return nil, err
+	}
+	stored, err := substrate.DecodeStakeInfos(data)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]uint32, len(stored))
+	for _, info := range stored {
+		counts[ethcommon.Bytes2Hex(info.WorkBase)] = info.WorkCount
+	}
+	return counts, nil
+}
+
+// filterWorkCount sets the WorkCount of infos from the pallet storage, a staker it doesn't hold counts 0,
+// and drops the stakers below MinWorkCount. The filter is skipped for an epoch the counts can't be read in,
+// rather than dropping every staker. infos is left unchanged.
+func (l *Listener) filterWorkCount(infos substrate.StakeInfos) substrate.StakeInfos {
+	min := l.Config.MinWorkCount
+	counts, err := l.storedWorkCounts()
+	if err != nil {
+		log.Warn("failed to read the work counts, skip the minWorkCount filter", "min", min, "error", err)
+		return infos
+	}
+	counted := make(substrate.StakeInfos, 0, len(infos))
+	for _, info := range infos {
+		c := *info
+		c.WorkCount = counts[ethcommon.Bytes2Hex(info.WorkBase)]
+		counted = append(counted, &c)
+	}
+	filtered := counted.FilterWorkCount(min)
+	if dropped := len(counted) - len(filtered); dropped > 0 {
+		log.Info("skip stakers below minWorkCount", "dropped", dropped, "min", min)
+	}
+	return filtered
+}
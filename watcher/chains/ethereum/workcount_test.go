@@ -0,0 +1,71 @@
+package ethereum
+
+import (
+	"testing"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+)
+
+func TestListener_selectTopMinWorkCount(t *testing.T) {
+	// stakers 0 to 3 locking 40 down to 10, the pallet stores work counts 4, 5 and 6 for the first three
+	infos := churnSet(0, 4, 0)
+	for i, info := range infos {
+		info.LockedBalance = churnSet(0, 1, int64(40-10*i))[0].LockedBalance
+	}
+	stored := make(substrate.StakeInfos, 3)
+	for i := range stored {
+		c := *infos[i]
+		c.WorkCount = uint32(4 + i)
+		stored[i] = &c
+	}
+	data, err := types.EncodeToBytes(stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := &substrate.MockSubmitter{}
+	sub.SetStored(data)
+
+	tests := []struct {
+		name      string
+		min       uint32
+		subconn   substrate.Submitter
+		want      []byte
+		wantCount []uint32
+	}{
+		{name: "disabled", min: 0, subconn: sub, want: []byte{0, 1, 2, 3}, wantCount: []uint32{0, 0, 0, 0}},
+		{name: "below-boundary", min: 4, subconn: sub, want: []byte{0, 1, 2}, wantCount: []uint32{4, 5, 6}},
+		{name: "at-boundary", min: 5, subconn: sub, want: []byte{1, 2}, wantCount: []uint32{5, 6}},
+		{name: "above-all", min: 7, subconn: sub},
+		// without the stored counts the filter is skipped rather than dropping every staker
+		{name: "unreadable", min: 5, subconn: struct{ substrate.Submitter }{sub}, want: []byte{0, 1, 2, 3}, wantCount: []uint32{0, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &Listener{Config: &config.Config{MinWorkCount: tt.min}, Subconn: tt.subconn}
+			top := l.selectTop(infos)
+			var workBases []byte
+			var counts []uint32
+			for _, info := range top {
+				workBases = append(workBases, info.WorkBase[0])
+				counts = append(counts, info.WorkCount)
+			}
+			if string(workBases) != string(tt.want) {
+				t.Errorf("selectTop() = stakers %v, want %v", workBases, tt.want)
+			}
+			for i := range counts {
+				if i >= len(tt.wantCount) || counts[i] != tt.wantCount[i] {
+					t.Errorf("selectTop() work counts = %v, want %v", counts, tt.wantCount)
+					break
+				}
+			}
+			for _, info := range infos {
+				if info.WorkCount != 0 {
+					t.Fatalf("selectTop() set the work count of its input")
+				}
+			}
+		})
+	}
+}
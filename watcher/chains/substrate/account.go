@@ -0,0 +1,25 @@
+package substrate
+
+import (
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// EthAddrToAccountID maps an ethereum address to an AccountID. The 20 address bytes fill the start of the
+// 32 byte AccountID and the remaining 12 bytes are zero.
+func EthAddrToAccountID(addr common.Address) types.AccountID {
+	var id types.AccountID
+	copy(id[:], addr[:])
+	return id
+}
+
+// AccountIDToEthAddr is the inverse of EthAddrToAccountID, it reports false when id has non zero bytes past
+// the first 20 and so isn't the mapping of an ethereum address.
+func AccountIDToEthAddr(id types.AccountID) (common.Address, bool) {
+	for _, b := range id[common.AddressLength:] {
+		if b != 0 {
+			return common.Address{}, false
+		}
+	}
+	return common.BytesToAddress(id[:common.AddressLength]), true
+}
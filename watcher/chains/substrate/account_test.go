@@ -0,0 +1,33 @@
+package substrate
+
+import (
+	"testing"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestEthAddrToAccountID(t *testing.T) {
+	addr := common.HexToAddress("0xa7f6c9a5052a08a14ff0e3349094b6efbc591ea4")
+	id := EthAddrToAccountID(addr)
+
+	want := types.AccountID{0xa7, 0xf6, 0xc9, 0xa5, 0x05, 0x2a, 0x08, 0xa1, 0x4f, 0xf0, 0xe3, 0x34, 0x90, 0x94, 0xb6, 0xef, 0xbc, 0x59, 0x1e, 0xa4}
+	if id != want {
+		t.Errorf("EthAddrToAccountID() = %x, want %x", id, want)
+	}
+	// the historic conversion must keep producing the same ids
+	if id != types.NewAccountID(addr[:]) {
+		t.Errorf("EthAddrToAccountID() = %x, differs from NewAccountID %x", id, types.NewAccountID(addr[:]))
+	}
+
+	got, ok := AccountIDToEthAddr(id)
+	if !ok || got != addr {
+		t.Errorf("AccountIDToEthAddr() = %s, %v, want %s", got.Hex(), ok, addr.Hex())
+	}
+
+	notAnAddress := id
+	notAnAddress[31] = 1
+	if _, ok := AccountIDToEthAddr(notAnAddress); ok {
+		t.Errorf("AccountIDToEthAddr() accepted %x with trailing bytes set", notAnAddress)
+	}
+}
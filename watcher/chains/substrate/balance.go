@@ -0,0 +1,48 @@
+package substrate
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+)
+
+// BalanceReader is implemented by Submitters that can read the free balance of the signing account, the
+// funds the fees of the submissions are paid from
+type BalanceReader interface {
+	FreeBalance() (*big.Int, error)
+}
+
+// accountState is the part of the state rpc used to read the System.Account of the signing account
+type accountState interface {
+	runtimeState
+	GetStorageLatest(key types.StorageKey, target interface{}) (bool, error)
+}
+
+// FreeBalance reads the free balance of the signing account at the latest block
+func (c *Connection) FreeBalance() (*big.Int, error) {
+	return c.freeBalance(c.API.RPC.State)
+}
+
+func (c *Connection) freeBalance(state accountState) (*big.Int, error) {
+	meta, _, _, err := c.runtime.get(state)
+	if err != nil {
+		return nil, err
+	}
+	key, err := types.CreateStorageKey(meta, "System", "Account", c.SigningKey().PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("create storage key failed: %w", err)
+	}
+	var info types.AccountInfo
+	ok, err := state.GetStorageLatest(key, &info)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the signing account: %w", err)
+	}
+	if !ok {
+		return nil, ErrAccountNotFound
+	}
+	if info.Data.Free.Int == nil {
+		return new(big.Int), nil
+	}
+	return new(big.Int).Set(info.Data.Free.Int), nil
+}
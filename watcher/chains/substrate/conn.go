@@ -1,7 +1,10 @@
 package substrate
 
 import (
+	"context"
 	"fmt"
+	"math/big"
+	"sync"
 
 	gsrpc "github.com/centrifuge/go-substrate-rpc-client/v4"
 	"github.com/centrifuge/go-substrate-rpc-client/v4/signature"
@@ -9,18 +12,36 @@ import (
 	"github.com/ethereum/go-ethereum/log"
 )
 
+// Submitter submits extrinsics to the NuLink chain, it is implemented by Connection and MockSubmitter. A
+// submission whose ctx is done before its extrinsic is sent fails with the error of ctx, once sent it can't
+// be called back and its result is returned.
+type Submitter interface {
+	SubmitTxHash(ctx context.Context, method Method, args ...interface{}) (types.Hash, error)
+}
+
 type Connection struct {
-	API  *gsrpc.SubstrateAPI
-	URL  string                 // API endpoint
-	Key  *signature.KeyringPair // Keyring used for signing
-	Stop chan struct{}          // Signals system shutdown, should be observed in all selects and loops
+	API            *gsrpc.SubstrateAPI
+	URL            string                 // API endpoint
+	Key            *signature.KeyringPair // Keyring used for signing
+	Stop           chan struct{}          // Signals system shutdown, should be observed in all selects and loops
+	UpgradeRetries int                    // Retries of a submission that failed while the runtime was upgraded
+	StakeInfoItem  string                 // Storage item of the NuProxy pallet read back by StoredStakeInfos
+	DryRun         bool                   // Dry runs every extrinsic before submitting it to decode its dispatch error
+	Tip            *big.Int               // Tip paid on the UpdateStakeInfo extrinsics for a faster inclusion, nil pays none
+
+	keyMu             sync.Mutex
+	runtime           runtimeCache
+	halted            int32
+	dryRunUnsupported bool
 }
 
 func NewConnection(url string, key *signature.KeyringPair, stop chan struct{}) *Connection {
 	return &Connection{
-		URL:  url,
-		Key:  key,
-		Stop: stop,
+		URL:            url,
+		Key:            key,
+		Stop:           stop,
+		UpgradeRetries: UpgradeRetries,
+		StakeInfoItem:  StakerInfos,
 	}
 }
 
@@ -40,69 +61,113 @@ func (c *Connection) Close() {
 
 }
 
+// SubmitTx signs and submits the given call, any failure is returned as a *SubmitError
 func (c *Connection) SubmitTx(method Method, args ...interface{}) error {
-	//c.Key = &signature.TestKeyringPairAlice
-	log.Info("Submitting substrate call...", "method", method, "sender", c.Key.Address)
+	_, err := c.SubmitTxHash(context.Background(), method, args...)
+	return err
+}
 
-	meta, err := c.API.RPC.State.GetMetadataLatest()
+// SubmitTxHash is like SubmitTx but also returns the hash of the submitted extrinsic. It gives up when ctx is
+// done before the extrinsic is signed or sent.
+func (c *Connection) SubmitTxHash(ctx context.Context, method Method, args ...interface{}) (types.Hash, error) {
+	hash, err := c.submitTx(ctx, method, args...)
 	if err != nil {
-		return fmt.Errorf("failed get the latest metadata, err: %v", err)
+		return types.Hash{}, &SubmitError{Method: method, Err: err}
 	}
+	return hash, nil
+}
 
-	// Create call and extrinsic
-	call, err := types.NewCall(meta, string(method), args...)
-	if err != nil {
-		return fmt.Errorf("failed to construct call, err: %v", err)
-	}
+func (c *Connection) submitTx(ctx context.Context, method Method, args ...interface{}) (types.Hash, error) {
+	//c.Key = &signature.TestKeyringPairAlice
+	key := c.SigningKey()
+	log.Info("Submitting substrate call...", "method", method, "sender", key.Address, "tip", c.tip(method))
 
-	// Create the extrinsic
-	ext := types.NewExtrinsic(call)
+	return c.runtime.submit(c.API.RPC.State, c.UpgradeRetries, func(meta *types.Metadata, rv types.RuntimeVersion) (types.Hash, error) {
+		return c.sendCall(ctx, meta, rv, key, method, args...)
+	})
+}
 
-	genesisHash, err := c.API.RPC.Chain.GetBlockHash(0)
-	if err != nil {
-		return fmt.Errorf("failed to get the genesis hash, err: %v", genesisHash)
+// tip returns the tip paid on an extrinsic of method, only UpdateStakeInfo is time sensitive
+func (c *Connection) tip(method Method) *big.Int {
+	if method != UpdateStakeInfo || c.Tip == nil {
+		return new(big.Int)
 	}
-	// Get latest runtime version
-	rv, err := c.API.RPC.State.GetRuntimeVersionLatest()
+	return c.Tip
+}
+
+// sendCall builds, signs with signer and sends the call for the runtime described by meta and rv, unless ctx is
+// done first
+func (c *Connection) sendCall(ctx context.Context, meta *types.Metadata, rv types.RuntimeVersion, signer *signature.KeyringPair, method Method, args ...interface{}) (types.Hash, error) {
+	genesisHash, err := c.API.RPC.Chain.GetBlockHash(0)
 	if err != nil {
-		return err
+		return types.Hash{}, fmt.Errorf("failed to get the genesis hash: %w", err)
 	}
 
-	key, err := types.CreateStorageKey(meta, "System", "Account", c.Key.PublicKey, nil)
+	key, err := types.CreateStorageKey(meta, "System", "Account", signer.PublicKey, nil)
 	if err != nil {
-		return fmt.Errorf("create storage key failed, err: %v", err)
+		return types.Hash{}, fmt.Errorf("create storage key failed: %w", err)
 	}
 
 	var accountInfo types.AccountInfo
 	ok, err := c.API.RPC.State.GetStorageLatest(key, &accountInfo)
-	if err != nil || !ok {
-		return fmt.Errorf("failed to get the latest storage, err: %v", err)
+	if err != nil {
+		return types.Hash{}, fmt.Errorf("failed to get the latest storage: %w", err)
+	}
+	if !ok {
+		return types.Hash{}, ErrAccountNotFound
 	}
 
 	nonce := uint32(accountInfo.Nonce)
 
-	// Sign the extrinsic
-	opts := types.SignatureOptions{
-		BlockHash:          genesisHash,
-		Era:                types.ExtrinsicEra{IsMortalEra: false},
-		GenesisHash:        genesisHash,
-		Nonce:              types.NewUCompactFromUInt(uint64(nonce)),
-		SpecVersion:        rv.SpecVersion,
-		Tip:                types.NewUCompactFromUInt(0),
-		TransactionVersion: rv.TransactionVersion,
+	if err := ctx.Err(); err != nil {
+		return types.Hash{}, fmt.Errorf("abandoned before signing: %w", err)
 	}
-
-	err = ext.Sign(*c.Key, opts)
+	ext, err := c.signCall(meta, rv, signer, genesisHash, nonce, method, args...)
 	if err != nil {
-		return err
+		return types.Hash{}, err
 	}
 
+	if c.DryRun {
+		if err := c.dryRun(c.API.Client, meta, ext); err != nil {
+			return types.Hash{}, err
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return types.Hash{}, fmt.Errorf("abandoned before sending: %w", err)
+	}
 	// Send the extrinsic
 	hash, err := c.API.RPC.Author.SubmitExtrinsic(ext)
 	if err != nil {
-		return fmt.Errorf("submit of extrinsic failed: %v", err)
+		return types.Hash{}, fmt.Errorf("submit of extrinsic failed: %w", err)
 	}
 	log.Info("submit extrinsic succeeded", "hash", hash.Hex())
 
-	return nil
+	return hash, nil
+}
+
+// signCall builds the extrinsic of the call and signs it with signer as its nonce-th extrinsic, tipping it
+// with the tip of method
+func (c *Connection) signCall(meta *types.Metadata, rv types.RuntimeVersion, signer *signature.KeyringPair, genesisHash types.Hash, nonce uint32, method Method, args ...interface{}) (types.Extrinsic, error) {
+	// Create call and extrinsic
+	call, err := types.NewCall(meta, string(method), args...)
+	if err != nil {
+		return types.Extrinsic{}, fmt.Errorf("failed to construct call: %w", err)
+	}
+	ext := types.NewExtrinsic(call)
+
+	// Sign the extrinsic
+	opts := types.SignatureOptions{
+		BlockHash:          genesisHash,
+		Era:                types.ExtrinsicEra{IsMortalEra: false},
+		GenesisHash:        genesisHash,
+		Nonce:              types.NewUCompactFromUInt(uint64(nonce)),
+		SpecVersion:        rv.SpecVersion,
+		Tip:                types.NewUCompact(c.tip(method)),
+		TransactionVersion: rv.TransactionVersion,
+	}
+	if err := ext.Sign(*signer, opts); err != nil {
+		return types.Extrinsic{}, fmt.Errorf("failed to sign extrinsic: %w", err)
+	}
+	return ext, nil
 }
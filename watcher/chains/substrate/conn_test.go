@@ -190,3 +190,62 @@ func TestNewAccountFromHexAddress(t *testing.T) {
 	t.Log("account id 1: ", accountID1)
 	t.Log("account id 2: ", accountID2)
 }
+
+// testCallMetadata describes a NuProxy pallet at index 9 with its Call enum as lookup type 3
+func testCallMetadata() *types.Metadata {
+	meta := &types.Metadata{Version: 14}
+	meta.AsMetadataV14.Lookup.Types = []types.PortableTypeV14{{
+		ID: types.NewSi1LookupTypeIDFromUInt(3),
+		Type: types.Si1Type{Def: types.Si1TypeDef{IsVariant: true, Variant: types.Si1TypeDefVariant{Variants: []types.Si1Variant{
+			{Name: "register_watcher", Index: 0},
+			{Name: "update_staker_infos_and_mint", Index: 1},
+		}}}},
+	}}
+	meta.AsMetadataV14.Pallets = []types.PalletMetadataV14{
+		{Name: NuProxy, Index: 9, HasCalls: true, Calls: types.FunctionMetadataV14{Type: types.NewSi1LookupTypeIDFromUInt(3)}},
+	}
+	meta.AsMetadataV14.EfficientLookup = map[int64]*types.Si1Type{3: &meta.AsMetadataV14.Lookup.Types[0].Type}
+	return meta
+}
+
+func TestConnection_signCallTip(t *testing.T) {
+	tests := []struct {
+		name    string
+		tip     *big.Int
+		method  Method
+		args    []interface{}
+		wantTip int64
+	}{
+		{name: "default", method: UpdateStakeInfo, args: []interface{}{StakeInfos{}}},
+		{name: "tipped update", tip: big.NewInt(1000), method: UpdateStakeInfo, args: []interface{}{StakeInfos{}}, wantTip: 1000},
+		{name: "register is not tipped", tip: big.NewInt(1000), method: RegisterWatcher},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Connection{Key: params.Watcher, Tip: tt.tip}
+			ext, err := c.signCall(testCallMetadata(), types.RuntimeVersion{SpecVersion: 1, TransactionVersion: 1}, params.Watcher,
+				types.Hash{1}, 7, tt.method, tt.args...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// decode what is submitted, the tip is part of the signed extrinsic
+			encoded, err := types.EncodeToBytes(ext)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var submitted types.Extrinsic
+			if err := types.DecodeFromBytes(encoded, &submitted); err != nil {
+				t.Fatal(err)
+			}
+			if !submitted.IsSigned() {
+				t.Fatal("submitted extrinsic is not signed")
+			}
+			if got := big.Int(submitted.Signature.Tip); got.Int64() != tt.wantTip {
+				t.Errorf("submitted extrinsic tips %s, want %d", &got, tt.wantTip)
+			}
+			if got := big.Int(submitted.Signature.Nonce); got.Int64() != 7 {
+				t.Errorf("submitted extrinsic nonce = %s, want 7", &got)
+			}
+		})
+	}
+}
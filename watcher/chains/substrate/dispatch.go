@@ -0,0 +1,178 @@
+package substrate
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// DispatchError is the error the runtime rejects a call with. For a module error Pallet and Name are the
+// pallet and the variant of its Error enum, e.g. Balances and InsufficientBalance, otherwise Pallet is empty
+// and Name is the variant of the runtime's DispatchError, e.g. BadOrigin.
+type DispatchError struct {
+	Pallet      string
+	Name        string
+	Docs        string
+	PalletIndex uint8
+	ErrorIndex  uint8
+	Module      bool
+}
+
+func (e *DispatchError) Error() string {
+	if !e.Module {
+		return "dispatch error " + e.Name
+	}
+	msg := fmt.Sprintf("dispatch error %s.%s (pallet %d, error %d)", e.Pallet, e.Name, e.PalletIndex, e.ErrorIndex)
+	if e.Docs != "" {
+		msg += ": " + e.Docs
+	}
+	return msg
+}
+
+func (e *DispatchError) Is(target error) bool {
+	return target == ErrDispatchFailed
+}
+
+// dispatchErrors are the variants of the runtime's DispatchError by index, Module is decoded separately
+var dispatchErrors = []string{"Other", "CannotLookup", "BadOrigin", "Module", "ConsumerRemaining", "NoProviders",
+	"TooManyConsumers", "Token", "Arithmetic", "Transactional"}
+
+// invalidTransactions are the variants of InvalidTransaction by index
+var invalidTransactions = []string{"Call", "Payment", "Future", "Stale", "BadProof", "AncientBirthBlock",
+	"ExhaustsResources", "Custom", "BadMandatory", "MandatoryDispatch", "BadSigner"}
+
+// decodeApplyResult decodes the ApplyExtrinsicResult of a dry run, nil when the call would succeed. A call the
+// runtime dispatches with an error returns a *DispatchError named from meta, a transaction the pool would
+// reject an error wrapping ErrInvalidTransaction.
+func decodeApplyResult(meta *types.Metadata, result []byte) error {
+	if len(result) < 2 {
+		return fmt.Errorf("short dry run result %x", result)
+	}
+	if result[0] != 0 {
+		// Err(TransactionValidityError): 0 Invalid(InvalidTransaction), 1 Unknown(UnknownTransaction)
+		if result[1] == 0 && len(result) > 2 && int(result[2]) < len(invalidTransactions) {
+			return fmt.Errorf("%w: %s", ErrInvalidTransaction, invalidTransactions[result[2]])
+		}
+		return fmt.Errorf("%w: %x", ErrInvalidTransaction, result[1:])
+	}
+	// Ok(DispatchOutcome): 0 Ok(()), 1 Err(DispatchError)
+	if result[1] == 0 {
+		return nil
+	}
+	d := result[2:]
+	if len(d) == 0 {
+		return fmt.Errorf("short dry run result %x", result)
+	}
+	if d[0] != 3 {
+		name := fmt.Sprintf("%d", d[0])
+		if int(d[0]) < len(dispatchErrors) {
+			name = dispatchErrors[d[0]]
+		}
+		return &DispatchError{Name: name}
+	}
+	// Module { index: u8, error: u8 }, [u8; 4] in later runtimes with the variant in the first byte
+	if len(d) < 3 {
+		return fmt.Errorf("short module error in dry run result %x", result)
+	}
+	e := &DispatchError{Module: true, PalletIndex: d[1], ErrorIndex: d[2]}
+	e.Pallet, e.Name, e.Docs = moduleError(meta, e.PalletIndex, e.ErrorIndex)
+	return e
+}
+
+// moduleError names error index of the pallet at index from meta, falling back to the indices when meta
+// doesn't describe it
+func moduleError(meta *types.Metadata, pallet, index uint8) (string, string, string) {
+	palletName, errorName := fmt.Sprintf("pallet%d", pallet), fmt.Sprintf("error%d", index)
+	if meta == nil {
+		return palletName, errorName, ""
+	}
+	switch meta.Version {
+	case 14:
+		m := meta.AsMetadataV14
+		for _, p := range m.Pallets {
+			if uint8(p.Index) != pallet {
+				continue
+			}
+			palletName = string(p.Name)
+			if !p.HasErrors {
+				break
+			}
+			id := p.Errors.Type.Int64()
+			for _, t := range m.Lookup.Types {
+				if t.ID.Int64() != id || !t.Type.Def.IsVariant {
+					continue
+				}
+				for _, v := range t.Type.Def.Variant.Variants {
+					if uint8(v.Index) == index {
+						return palletName, string(v.Name), joinDocs(v.Docs)
+					}
+				}
+			}
+		}
+	case 13:
+		for _, m := range meta.AsMetadataV13.Modules {
+			if m.Index == pallet {
+				palletName = string(m.Name)
+				if int(index) < len(m.Errors) {
+					return palletName, string(m.Errors[index].Name), joinDocs(m.Errors[index].Documentation)
+				}
+			}
+		}
+	case 12:
+		for _, m := range meta.AsMetadataV12.Modules {
+			if m.Index == pallet {
+				palletName = string(m.Name)
+				if int(index) < len(m.Errors) {
+					return palletName, string(m.Errors[index].Name), joinDocs(m.Errors[index].Documentation)
+				}
+			}
+		}
+	}
+	return palletName, errorName, ""
+}
+
+func joinDocs(docs []types.Text) string {
+	lines := make([]string, 0, len(docs))
+	for _, d := range docs {
+		if s := strings.TrimSpace(string(d)); s != "" {
+			lines = append(lines, s)
+		}
+	}
+	return strings.Join(lines, " ")
+}
+
+// rpcCaller is the part of the rpc client used for the dry run
+type rpcCaller interface {
+	Call(result interface{}, method string, args ...interface{}) error
+}
+
+// dryRun applies ext to the latest state without submitting it and returns the error the runtime would
+// reject it with. A node without system_dryRun, an unsafe rpc method, disables the dry run.
+func (c *Connection) dryRun(client rpcCaller, meta *types.Metadata, ext types.Extrinsic) error {
+	if c.dryRunUnsupported {
+		return nil
+	}
+	encoded, err := types.EncodeToHexString(ext)
+	if err != nil {
+		return fmt.Errorf("failed to encode the extrinsic: %w", err)
+	}
+	var result string
+	if err := client.Call(&result, "system_dryRun", encoded); err != nil {
+		log.Warn("Node can't dry run extrinsics, submitting without a dry run", "url", c.URL, "error", err)
+		c.dryRunUnsupported = true
+		return nil
+	}
+	raw, err := types.HexDecodeString(result)
+	if err != nil {
+		return fmt.Errorf("failed to decode the dry run result %q: %w", result, err)
+	}
+	err = decodeApplyResult(meta, raw)
+	var de *DispatchError
+	if errors.As(err, &de) {
+		log.Error("extrinsic rejected by the runtime", "pallet", de.Pallet, "error", de.Name, "palletIndex", de.PalletIndex, "errorIndex", de.ErrorIndex, "docs", de.Docs)
+	}
+	return err
+}
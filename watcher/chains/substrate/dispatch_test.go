@@ -0,0 +1,112 @@
+package substrate
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+)
+
+// testDispatchMetadata describes a Balances pallet at index 5 with its Error enum as lookup type 7
+func testDispatchMetadata() *types.Metadata {
+	meta := &types.Metadata{Version: 14}
+	meta.AsMetadataV14.Lookup.Types = []types.PortableTypeV14{{
+		ID: types.NewSi1LookupTypeIDFromUInt(7),
+		Type: types.Si1Type{Def: types.Si1TypeDef{IsVariant: true, Variant: types.Si1TypeDefVariant{Variants: []types.Si1Variant{
+			{Name: "VestingBalance", Index: 0},
+			{Name: "InsufficientBalance", Index: 2, Docs: []types.Text{" Balance too low to send value"}},
+		}}}},
+	}}
+	meta.AsMetadataV14.Pallets = []types.PalletMetadataV14{
+		{Name: "System", Index: 0},
+		{Name: "Balances", Index: 5, HasErrors: true, Errors: types.ErrorMetadataV14{Type: types.NewSi1LookupTypeIDFromUInt(7)}},
+	}
+	return meta
+}
+
+func TestDecodeApplyResult(t *testing.T) {
+	tests := []struct {
+		name    string
+		result  []byte
+		want    *DispatchError
+		wantErr error
+	}{
+		{name: "ok", result: []byte{0, 0}},
+		{name: "module", result: []byte{0, 1, 3, 5, 2},
+			want: &DispatchError{Module: true, Pallet: "Balances", Name: "InsufficientBalance", PalletIndex: 5, ErrorIndex: 2, Docs: "Balance too low to send value"}},
+		{name: "module-error-array", result: []byte{0, 1, 3, 5, 2, 0, 0, 0},
+			want: &DispatchError{Module: true, Pallet: "Balances", Name: "InsufficientBalance", PalletIndex: 5, ErrorIndex: 2, Docs: "Balance too low to send value"}},
+		{name: "unknown-module", result: []byte{0, 1, 3, 9, 1},
+			want: &DispatchError{Module: true, Pallet: "pallet9", Name: "error1", PalletIndex: 9, ErrorIndex: 1}},
+		{name: "bad-origin", result: []byte{0, 1, 2}, want: &DispatchError{Name: "BadOrigin"}},
+		{name: "invalid", result: []byte{1, 0, 4}, wantErr: ErrInvalidTransaction},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := decodeApplyResult(testDispatchMetadata(), tt.result)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("decodeApplyResult() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if tt.want == nil {
+				if err != nil {
+					t.Fatalf("decodeApplyResult() error = %v, want nil", err)
+				}
+				return
+			}
+			var de *DispatchError
+			if !errors.As(err, &de) {
+				t.Fatalf("decodeApplyResult() error = %v, want a *DispatchError", err)
+			}
+			if *de != *tt.want {
+				t.Errorf("decodeApplyResult() = %+v, want %+v", *de, *tt.want)
+			}
+			if !errors.Is(err, ErrDispatchFailed) {
+				t.Errorf("errors.Is(err, ErrDispatchFailed) = false, want true")
+			}
+		})
+	}
+}
+
+type testDryRunClient struct {
+	result string
+	err    error
+	calls  int
+}
+
+func (c *testDryRunClient) Call(result interface{}, method string, args ...interface{}) error {
+	c.calls++
+	if c.err != nil {
+		return c.err
+	}
+	*result.(*string) = c.result
+	return nil
+}
+
+func TestConnection_dryRun(t *testing.T) {
+	ext := types.NewExtrinsic(types.Call{})
+
+	c := &Connection{URL: "ws://test"}
+	client := &testDryRunClient{result: "0x0001030502"}
+	err := c.dryRun(client, testDispatchMetadata(), ext)
+	var de *DispatchError
+	if !errors.As(err, &de) || de.Pallet != "Balances" || de.Name != "InsufficientBalance" {
+		t.Fatalf("dryRun() error = %v, want Balances.InsufficientBalance", err)
+	}
+	if want := "dispatch error Balances.InsufficientBalance (pallet 5, error 2): Balance too low to send value"; err.Error() != want {
+		t.Errorf("dryRun() error = %q, want %q", err, want)
+	}
+
+	// a node without system_dryRun submits without a dry run from then on
+	unsupported := &testDryRunClient{err: errors.New("Method not found")}
+	for i := 0; i < 2; i++ {
+		if err := c.dryRun(unsupported, testDispatchMetadata(), ext); err != nil {
+			t.Fatalf("dryRun() on an unsupported node error = %v, want nil", err)
+		}
+	}
+	if unsupported.calls != 1 {
+		t.Errorf("dryRun() called an unsupported node %d times, want once", unsupported.calls)
+	}
+}
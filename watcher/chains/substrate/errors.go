@@ -0,0 +1,41 @@
+package substrate
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrSubmitFailed matches any error returned by SubmitTx, use errors.As with *SubmitError for details
+	ErrSubmitFailed = errors.New("substrate submission failed")
+	// ErrWatcherExists is returned when another watcher is already registered in the NuProxy pallet
+	ErrWatcherExists = errors.New("watcher already exists")
+	// ErrAccountNotFound is returned when the signing account has no entry in System.Account
+	ErrAccountNotFound = errors.New("signing account not found")
+	// ErrInvalidTopN is returned by CheckTopN when a selected set is unsorted or holds a staker twice
+	ErrInvalidTopN = errors.New("invalid top stake info set")
+	// ErrUnknownPayloadVersion is returned by EncodePayload for a version without an encoder
+	ErrUnknownPayloadVersion = errors.New("unknown payload version")
+	// ErrDispatchFailed matches a call the runtime rejected, use errors.As with *DispatchError for the pallet
+	ErrDispatchFailed = errors.New("dispatch failed")
+	// ErrInvalidTransaction is returned when the transaction pool would reject an extrinsic as invalid
+	ErrInvalidTransaction = errors.New("invalid transaction")
+)
+
+// SubmitError describes a failed extrinsic submission and wraps the underlying cause
+type SubmitError struct {
+	Method Method
+	Err    error
+}
+
+func (e *SubmitError) Error() string {
+	return fmt.Sprintf("submit %s failed: %v", e.Method, e.Err)
+}
+
+func (e *SubmitError) Unwrap() error {
+	return e.Err
+}
+
+func (e *SubmitError) Is(target error) bool {
+	return target == ErrSubmitFailed
+}
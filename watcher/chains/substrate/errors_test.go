@@ -0,0 +1,29 @@
+package substrate
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSubmitError(t *testing.T) {
+	cause := errors.New("connection refused")
+	err := fmt.Errorf("sync stake infos: %w", &SubmitError{Method: UpdateStakeInfo, Err: cause})
+
+	if !errors.Is(err, ErrSubmitFailed) {
+		t.Errorf("errors.Is(err, ErrSubmitFailed) = false, want true")
+	}
+	if !errors.Is(err, cause) {
+		t.Errorf("errors.Is(err, cause) = false, want true")
+	}
+	var se *SubmitError
+	if !errors.As(err, &se) {
+		t.Fatalf("errors.As(err, *SubmitError) = false, want true")
+	}
+	if se.Method != UpdateStakeInfo {
+		t.Errorf("SubmitError.Method = %v, want %v", se.Method, UpdateStakeInfo)
+	}
+	if errors.Is(err, ErrWatcherExists) {
+		t.Errorf("errors.Is(err, ErrWatcherExists) = true, want false")
+	}
+}
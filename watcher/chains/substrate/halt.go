@@ -0,0 +1,90 @@
+package substrate
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// Halter is implemented by Submitters that know whether the NuLink chain signalled a halt, submissions are
+// held back while Halted returns true
+type Halter interface {
+	Halted() bool
+}
+
+// rawState is the part of the state rpc used to read raw storage, the halt flag and the stored stake infos
+type rawState interface {
+	GetStorageRawLatest(key types.StorageKey) (*types.StorageDataRaw, error)
+}
+
+// HaltKey returns the storage key of the halt flag, the raw hex key if given or else the key of the plain
+// storage item of pallet, which needs the metadata of the connected chain
+func (c *Connection) HaltKey(key, pallet, item string) (types.StorageKey, error) {
+	if key != "" {
+		k, err := types.HexDecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid halt storage key: %w", err)
+		}
+		return types.NewStorageKey(k), nil
+	}
+	meta, _, _, err := c.runtime.get(c.API.RPC.State)
+	if err != nil {
+		return nil, err
+	}
+	return types.CreateStorageKey(meta, pallet, item)
+}
+
+// Halted reports whether the halt flag was set when it was last polled
+func (c *Connection) Halted() bool {
+	return atomic.LoadInt32(&c.halted) == 1
+}
+
+// WatchHalt polls the halt flag at key every interval until ctx is done
+func (c *Connection) WatchHalt(ctx context.Context, key types.StorageKey, interval time.Duration) {
+	c.watchHalt(ctx, c.API.RPC.State, key, interval)
+}
+
+func (c *Connection) watchHalt(ctx context.Context, state rawState, key types.StorageKey, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if err := c.checkHalt(state, key); err != nil {
+			log.Warn("Failed to read the halt flag", "key", key.Hex(), "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// checkHalt reads the halt flag, any non zero value halts the submissions. A failed read keeps the state.
+func (c *Connection) checkHalt(state rawState, key types.StorageKey) error {
+	data, err := state.GetStorageRawLatest(key)
+	if err != nil {
+		return err
+	}
+	var v int32
+	if data != nil {
+		for _, b := range *data {
+			if b != 0 {
+				v = 1
+				break
+			}
+		}
+	}
+	if atomic.SwapInt32(&c.halted, v) == v {
+		return nil
+	}
+	if v == 1 {
+		log.Warn("NuLink chain signalled a halt, submissions are paused", "key", key.Hex())
+	} else {
+		log.Warn("NuLink chain halt cleared, submissions are resumed", "key", key.Hex())
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package substrate
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+)
+
+// testHaltState serves the halt flag, a nil flag is an unset storage item
+type testHaltState struct {
+	flag *types.StorageDataRaw
+	err  error
+}
+
+func (s *testHaltState) GetStorageRawLatest(key types.StorageKey) (*types.StorageDataRaw, error) {
+	return s.flag, s.err
+}
+
+func TestConnection_checkHalt(t *testing.T) {
+	set, cleared := types.NewStorageDataRaw([]byte{1}), types.NewStorageDataRaw([]byte{0})
+	unreadable := errors.New("connection reset")
+	state := &testHaltState{}
+	c := &Connection{}
+	key := types.NewStorageKey([]byte{0xab})
+
+	steps := []struct {
+		flag       *types.StorageDataRaw
+		err        error
+		wantHalted bool
+	}{
+		{flag: nil},
+		{flag: &set, wantHalted: true},
+		// a failed read keeps the submissions halted
+		{err: unreadable, wantHalted: true},
+		{flag: &cleared},
+		{flag: &set, wantHalted: true},
+		{flag: nil},
+	}
+	for i, step := range steps {
+		state.flag, state.err = step.flag, step.err
+		if err := c.checkHalt(state, key); !errors.Is(err, step.err) {
+			t.Errorf("step %d: checkHalt() error = %v, want %v", i, err, step.err)
+		}
+		if c.Halted() != step.wantHalted {
+			t.Errorf("step %d: Halted() = %v, want %v", i, c.Halted(), step.wantHalted)
+		}
+	}
+}
+
+func TestConnection_HaltKey(t *testing.T) {
+	c := &Connection{}
+	key, err := c.HaltKey("0xabcd", "", "")
+	if err != nil || key.Hex() != "0xabcd" {
+		t.Errorf("HaltKey() = %s, %v, want 0xabcd", key.Hex(), err)
+	}
+	if _, err := c.HaltKey("0xzz", "", ""); err == nil {
+		t.Errorf("HaltKey() accepted an invalid key")
+	}
+}
@@ -0,0 +1,42 @@
+package substrate
+
+import (
+	"fmt"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/signature"
+)
+
+// SS58Prefix is the address format of the keys derived from a seed, the generic substrate format of the
+// built-in watcher address
+const SS58Prefix uint8 = 42
+
+// KeyFromSeed derives the sr25519 signing key of a secret seed or mnemonic
+func KeyFromSeed(seed string) (*signature.KeyringPair, error) {
+	key, err := signature.KeyringPairFromSecret(seed, SS58Prefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key: %w", err)
+	}
+	return &key, nil
+}
+
+// KeyRotator is implemented by Submitters whose signing key can be replaced while they run, e.g. to rotate
+// the operator key at an activation block
+type KeyRotator interface {
+	SigningKey() *signature.KeyringPair
+	SetSigningKey(key *signature.KeyringPair)
+}
+
+// SigningKey returns the key the extrinsics are signed with
+func (c *Connection) SigningKey() *signature.KeyringPair {
+	c.keyMu.Lock()
+	defer c.keyMu.Unlock()
+	return c.Key
+}
+
+// SetSigningKey replaces the key the extrinsics are signed with, a submission in flight keeps the key it
+// started with
+func (c *Connection) SetSigningKey(key *signature.KeyringPair) {
+	c.keyMu.Lock()
+	defer c.keyMu.Unlock()
+	c.Key = key
+}
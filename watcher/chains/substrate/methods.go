@@ -6,6 +6,9 @@ const NuProxy = "NulinkNuproxy"
 
 const Watchers = "Watchers"
 
+// StakerInfos is the storage item of the NuProxy pallet holding the submitted stake infos
+const StakerInfos = "StakerInfos"
+
 var (
 	RegisterWatcher Method = NuProxy + ".register_watcher"
 	UpdateStakeInfo Method = NuProxy + ".update_staker_infos_and_mint"
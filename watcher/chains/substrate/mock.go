@@ -0,0 +1,150 @@
+package substrate
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/signature"
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+)
+
+// MockCall is a call recorded by MockSubmitter, Signer is the address of the signing key set when it was
+// made, empty without one
+type MockCall struct {
+	Method Method
+	Args   []interface{}
+	Signer string
+}
+
+// MockSubmitter is a Submitter recording every call instead of submitting it. A non nil Err fails every
+// call with a *SubmitError wrapping it, otherwise Hash is returned. Every call takes Delay before it is
+// recorded, a call whose ctx is done first isn't recorded and fails with the error of ctx.
+// With Store a successful UpdateStakeInfo call stores the SCALE encoding of its payload, as the pallet does.
+type MockSubmitter struct {
+	Err   error
+	Hash  types.Hash
+	Delay time.Duration
+	Store bool
+
+	mu     sync.Mutex
+	calls  []MockCall
+	halted bool
+	stored []byte
+	free   *big.Int
+	key    *signature.KeyringPair
+	// the addresses of the keys that aren't registered watchers, any other key is
+	unregistered map[string]bool
+}
+
+func (m *MockSubmitter) SubmitTxHash(ctx context.Context, method Method, args ...interface{}) (types.Hash, error) {
+	t := time.NewTimer(m.Delay)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return types.Hash{}, &SubmitError{Method: method, Err: ctx.Err()}
+	case <-t.C:
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	call := MockCall{Method: method, Args: args}
+	if m.key != nil {
+		call.Signer = m.key.Address
+	}
+	m.calls = append(m.calls, call)
+	if m.Err != nil {
+		return types.Hash{}, &SubmitError{Method: method, Err: m.Err}
+	}
+	if m.Store && method == UpdateStakeInfo && len(args) > 0 {
+		stored, err := types.EncodeToBytes(args[0])
+		if err != nil {
+			return types.Hash{}, &SubmitError{Method: method, Err: err}
+		}
+		m.stored = stored
+	}
+	return m.Hash, nil
+}
+
+// SetStored replaces the stake infos the mock reports stored by the pallet
+func (m *MockSubmitter) SetStored(stored []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stored = stored
+}
+
+// StoredStakeInfos implements StakeInfoReader
+func (m *MockSubmitter) StoredStakeInfos() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]byte(nil), m.stored...), nil
+}
+
+// SetHalted sets whether the mock reports the NuLink chain halted
+func (m *MockSubmitter) SetHalted(halted bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.halted = halted
+}
+
+// Halted implements Halter
+func (m *MockSubmitter) Halted() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.halted
+}
+
+// Calls returns the calls recorded so far
+func (m *MockSubmitter) Calls() []MockCall {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]MockCall(nil), m.calls...)
+}
+
+// SetFreeBalance sets the free balance the mock reports for the signing account, nil reports it missing
+func (m *MockSubmitter) SetFreeBalance(free *big.Int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.free = free
+}
+
+// FreeBalance implements BalanceReader
+func (m *MockSubmitter) FreeBalance() (*big.Int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.free == nil {
+		return nil, ErrAccountNotFound
+	}
+	return new(big.Int).Set(m.free), nil
+}
+
+// SigningKey implements KeyRotator
+func (m *MockSubmitter) SigningKey() *signature.KeyringPair {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.key
+}
+
+// SetSigningKey implements KeyRotator
+func (m *MockSubmitter) SetSigningKey(key *signature.KeyringPair) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.key = key
+}
+
+// SetWatcher registers the key of address as a watcher or removes it
+func (m *MockSubmitter) SetWatcher(address string, registered bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.unregistered == nil {
+		m.unregistered = make(map[string]bool)
+	}
+	m.unregistered[address] = !registered
+}
+
+// IsWatcherKey implements WatcherChecker
+func (m *MockSubmitter) IsWatcherKey(key *signature.KeyringPair) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return !m.unregistered[key.Address], nil
+}
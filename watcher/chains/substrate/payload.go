@@ -0,0 +1,78 @@
+package substrate
+
+import (
+	"fmt"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Versions of the UpdateStakeInfo payload layout, matching the NuProxy pallet releases
+const (
+	// PayloadV1 is a Vec of StakeInfo, with a variable length WorkBase and the WorkCount
+	PayloadV1 = 1
+	// PayloadV2 is a Vec of StakeInfoV2, with the WorkBase as a fixed 20 byte H160 and without the WorkCount
+	PayloadV2 = 2
+)
+
+// StakeInfoV2 is a staker in the PayloadV2 layout
+type StakeInfoV2 struct {
+	Coinbase      [32]byte
+	WorkBase      types.H160
+	IsWork        bool
+	LockedBalance types.U128
+}
+
+// PayloadEncoder converts stake infos into the UpdateStakeInfo argument of a payload version
+type PayloadEncoder func(infos StakeInfos) interface{}
+
+var payloadEncoders = map[int]PayloadEncoder{
+	PayloadV1: encodePayloadV1,
+	PayloadV2: encodePayloadV2,
+}
+
+// EncodePayload returns the UpdateStakeInfo argument of infos in the layout of version, 0 selects PayloadV1
+func EncodePayload(version int, infos StakeInfos) (interface{}, error) {
+	if version == 0 {
+		version = PayloadV1
+	}
+	enc, ok := payloadEncoders[version]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownPayloadVersion, version)
+	}
+	return enc(infos), nil
+}
+
+// DecodeStakeInfos decodes stake infos stored by the NuProxy pallet in the PayloadV1 layout, the only one
+// holding the WorkCount. An empty storage item decodes to no stake infos.
+func DecodeStakeInfos(data []byte) (StakeInfos, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var stored []StakeInfo
+	if err := types.DecodeFromBytes(data, &stored); err != nil {
+		return nil, fmt.Errorf("failed to decode the stored stake infos: %w", err)
+	}
+	infos := make(StakeInfos, len(stored))
+	for i := range stored {
+		infos[i] = &stored[i]
+	}
+	return infos, nil
+}
+
+func encodePayloadV1(infos StakeInfos) interface{} {
+	return infos
+}
+
+func encodePayloadV2(infos StakeInfos) interface{} {
+	payload := make([]StakeInfoV2, 0, len(infos))
+	for _, info := range infos {
+		payload = append(payload, StakeInfoV2{
+			Coinbase:      info.Coinbase,
+			WorkBase:      types.NewH160(common.BytesToAddress(info.WorkBase).Bytes()),
+			IsWork:        info.IsWork,
+			LockedBalance: info.LockedBalance,
+		})
+	}
+	return payload
+}
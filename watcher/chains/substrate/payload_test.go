@@ -0,0 +1,94 @@
+package substrate
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestEncodePayload(t *testing.T) {
+	staker := common.HexToAddress("0xa7f6c9a5052a08a14ff0e3349094b6efbc591ea4")
+	coinbase := EthAddrToAccountID(staker)
+	infos := StakeInfos{{Coinbase: coinbase, WorkBase: staker[:], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(0x0102)), WorkCount: 3}}
+
+	balance := make([]byte, 16)
+	balance[0], balance[1] = 0x02, 0x01
+	layout := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+	tests := []struct {
+		name    string
+		version int
+		want    []byte
+	}{
+		{
+			name:    "v1",
+			version: PayloadV1,
+			// vec length, coinbase, compact length and bytes of the workBase, isWork, lockedBalance, workCount
+			want: layout([]byte{0x04}, coinbase[:], []byte{0x50}, staker[:], []byte{0x01}, balance, []byte{0x03, 0, 0, 0}),
+		},
+		{
+			name:    "default",
+			version: 0,
+			want:    layout([]byte{0x04}, coinbase[:], []byte{0x50}, staker[:], []byte{0x01}, balance, []byte{0x03, 0, 0, 0}),
+		},
+		{
+			name:    "v2",
+			version: PayloadV2,
+			// vec length, coinbase, fixed workBase, isWork, lockedBalance
+			want: layout([]byte{0x04}, coinbase[:], staker[:], []byte{0x01}, balance),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, err := EncodePayload(tt.version, infos)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := types.EncodeToBytes(payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("EncodePayload() encodes to %x, want %x", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := EncodePayload(3, infos); !errors.Is(err, ErrUnknownPayloadVersion) {
+		t.Errorf("EncodePayload() of an unknown version error = %v, want %v", err, ErrUnknownPayloadVersion)
+	}
+}
+
+func TestDecodeStakeInfos(t *testing.T) {
+	staker := common.HexToAddress("0xa7f6c9a5052a08a14ff0e3349094b6efbc591ea4")
+	infos := StakeInfos{
+		{Coinbase: EthAddrToAccountID(staker), WorkBase: staker[:], IsWork: true, LockedBalance: types.NewU128(*big.NewInt(0x0102)), WorkCount: 3},
+		{Coinbase: EthAddrToAccountID(common.Address{}), WorkBase: []byte{1}, LockedBalance: types.NewU128(*big.NewInt(7)), WorkCount: 9},
+	}
+	data, err := types.EncodeToBytes(infos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeStakeInfos(data)
+	if err != nil {
+		t.Fatalf("DecodeStakeInfos() error = %v", err)
+	}
+	if len(got) != len(infos) {
+		t.Fatalf("DecodeStakeInfos() = %d stake infos, want %d", len(got), len(infos))
+	}
+	for i, info := range got {
+		want := infos[i]
+		if info.Coinbase != want.Coinbase || !bytes.Equal(info.WorkBase, want.WorkBase) || info.IsWork != want.IsWork ||
+			info.LockedBalance.Cmp(want.LockedBalance.Int) != 0 || info.WorkCount != want.WorkCount {
+			t.Errorf("DecodeStakeInfos()[%d] = %+v, want %+v", i, info, want)
+		}
+	}
+	if got, err := DecodeStakeInfos(nil); err != nil || got != nil {
+		t.Errorf("DecodeStakeInfos(nil) = %v, %v, want nothing", got, err)
+	}
+}
@@ -0,0 +1,73 @@
+package substrate
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// UpgradeRetries is how often a submission failing during a runtime upgrade is retried by default
+const UpgradeRetries = 1
+
+// runtimeState is the part of the state rpc used to follow runtime upgrades
+type runtimeState interface {
+	GetRuntimeVersionLatest() (*types.RuntimeVersion, error)
+	GetMetadataLatest() (*types.Metadata, error)
+}
+
+// runtimeCache holds the metadata of the current runtime. It is refreshed whenever the spec version of the
+// chain changes, so calls are built with the call indices of the upgraded runtime.
+type runtimeCache struct {
+	mu      sync.Mutex
+	meta    *types.Metadata
+	version types.RuntimeVersion
+}
+
+// get returns the metadata and version of the current runtime and whether the runtime was upgraded since
+// the last call
+func (r *runtimeCache) get(state runtimeState) (*types.Metadata, types.RuntimeVersion, bool, error) {
+	rv, err := state.GetRuntimeVersionLatest()
+	if err != nil {
+		return nil, types.RuntimeVersion{}, false, fmt.Errorf("failed to get the latest runtime version: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.meta != nil && r.version.SpecVersion == rv.SpecVersion {
+		return r.meta, r.version, false, nil
+	}
+	meta, err := state.GetMetadataLatest()
+	if err != nil {
+		return nil, types.RuntimeVersion{}, false, fmt.Errorf("failed get the latest metadata: %w", err)
+	}
+	upgraded := r.meta != nil
+	if upgraded {
+		log.Warn("Runtime upgraded, refreshed metadata", "oldSpecVersion", r.version.SpecVersion, "specVersion", rv.SpecVersion, "transactionVersion", rv.TransactionVersion)
+	}
+	r.meta, r.version = meta, *rv
+	return meta, *rv, upgraded, nil
+}
+
+// submit calls send with the current runtime. When send fails and the runtime was upgraded meanwhile, the
+// call was built for the old runtime and is sent again with the new metadata, up to retries times.
+func (r *runtimeCache) submit(state runtimeState, retries int, send func(*types.Metadata, types.RuntimeVersion) (types.Hash, error)) (types.Hash, error) {
+	meta, rv, _, err := r.get(state)
+	if err != nil {
+		return types.Hash{}, err
+	}
+	for attempt := 0; ; attempt++ {
+		hash, err := send(meta, rv)
+		if err == nil || attempt >= retries {
+			return hash, err
+		}
+		var upgraded bool
+		var uerr error
+		meta, rv, upgraded, uerr = r.get(state)
+		if uerr != nil || !upgraded {
+			return hash, err
+		}
+		log.Warn("Retrying submission after runtime upgrade", "specVersion", rv.SpecVersion, "attempt", attempt+1, "error", err)
+	}
+}
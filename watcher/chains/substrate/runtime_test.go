@@ -0,0 +1,97 @@
+package substrate
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+)
+
+// testRuntimeState serves a runtime at spec, with metadata whose Version is the spec for telling them apart
+type testRuntimeState struct {
+	spec          types.U32
+	metadataCalls int
+}
+
+func (s *testRuntimeState) GetRuntimeVersionLatest() (*types.RuntimeVersion, error) {
+	return &types.RuntimeVersion{SpecVersion: s.spec, TransactionVersion: 1}, nil
+}
+
+func (s *testRuntimeState) GetMetadataLatest() (*types.Metadata, error) {
+	s.metadataCalls++
+	return &types.Metadata{Version: uint8(s.spec)}, nil
+}
+
+func TestRuntimeCache_get(t *testing.T) {
+	state := &testRuntimeState{spec: 100}
+	var r runtimeCache
+
+	for i := 0; i < 2; i++ {
+		meta, rv, upgraded, err := r.get(state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if upgraded || rv.SpecVersion != 100 || meta.Version != 100 {
+			t.Errorf("get() = %d, %d, %v, want the metadata of spec 100 without an upgrade", meta.Version, rv.SpecVersion, upgraded)
+		}
+	}
+	if state.metadataCalls != 1 {
+		t.Errorf("metadata fetched %d times for an unchanged runtime, want 1", state.metadataCalls)
+	}
+
+	state.spec = 101
+	meta, rv, upgraded, err := r.get(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !upgraded || rv.SpecVersion != 101 || meta.Version != 101 {
+		t.Errorf("get() after a spec bump = %d, %d, %v, want the metadata of spec 101 and an upgrade", meta.Version, rv.SpecVersion, upgraded)
+	}
+}
+
+func TestRuntimeCache_submit(t *testing.T) {
+	rejected := errors.New("invalid transaction")
+	tests := []struct {
+		name      string
+		retries   int
+		bump      bool
+		wantErr   error
+		wantSpecs []types.U32
+	}{
+		{name: "no upgrade", retries: 1, wantErr: rejected, wantSpecs: []types.U32{100}},
+		{name: "upgrade in flight", retries: 1, bump: true, wantSpecs: []types.U32{100, 101}},
+		{name: "retries disabled", retries: 0, bump: true, wantErr: rejected, wantSpecs: []types.U32{100}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := &testRuntimeState{spec: 100}
+			var r runtimeCache
+			var specs []types.U32
+			_, err := r.submit(state, tt.retries, func(meta *types.Metadata, rv types.RuntimeVersion) (types.Hash, error) {
+				specs = append(specs, rv.SpecVersion)
+				if types.U32(meta.Version) != rv.SpecVersion {
+					t.Errorf("call built with the metadata of spec %d for spec %d", meta.Version, rv.SpecVersion)
+				}
+				if rv.SpecVersion == 100 {
+					// the runtime is upgraded while the call is in flight
+					if tt.bump {
+						state.spec = 101
+					}
+					return types.Hash{}, rejected
+				}
+				return types.NewHash([]byte{1}), nil
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("submit() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(specs) != len(tt.wantSpecs) {
+				t.Fatalf("submit() sent for specs %v, want %v", specs, tt.wantSpecs)
+			}
+			for i := range specs {
+				if specs[i] != tt.wantSpecs[i] {
+					t.Errorf("submit() sent for specs %v, want %v", specs, tt.wantSpecs)
+				}
+			}
+		})
+	}
+}
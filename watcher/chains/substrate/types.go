@@ -1,17 +1,73 @@
 package substrate
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
 	"sort"
 
 	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 )
 
+// TopN is the number of stakers with the highest locked balance submitted to the NuLink chain
+const TopN = 20
+
+// StakeInfo is a staker as submitted to the NuProxy pallet. Its json form is stable for tools reading it:
+// the byte fields are 0x prefixed hex and the locked balance a decimal string, so parsers reading numbers
+// as floats don't lose precision.
 type StakeInfo struct {
-	Coinbase      [32]byte
-	WorkBase      []byte
-	IsWork        bool
-	LockedBalance types.U128
-	WorkCount     uint32
+	Coinbase      [32]byte   `json:"coinbase"`
+	WorkBase      []byte     `json:"work_base"`
+	IsWork        bool       `json:"is_work"`
+	LockedBalance types.U128 `json:"locked_balance"`
+	WorkCount     uint32     `json:"work_count"`
+}
+
+// stakeInfoJSON is the json form of a StakeInfo, with the field names and order of its tags
+type stakeInfoJSON struct {
+	Coinbase      hexutil.Bytes `json:"coinbase"`
+	WorkBase      hexutil.Bytes `json:"work_base"`
+	IsWork        bool          `json:"is_work"`
+	LockedBalance string        `json:"locked_balance"`
+	WorkCount     uint32        `json:"work_count"`
+}
+
+// MarshalJSON implements json.Marshaler
+func (s StakeInfo) MarshalJSON() ([]byte, error) {
+	balance := "0"
+	if s.LockedBalance.Int != nil {
+		balance = s.LockedBalance.String()
+	}
+	return json.Marshal(stakeInfoJSON{
+		Coinbase:      s.Coinbase[:],
+		WorkBase:      s.WorkBase,
+		IsWork:        s.IsWork,
+		LockedBalance: balance,
+		WorkCount:     s.WorkCount,
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (s *StakeInfo) UnmarshalJSON(data []byte) error {
+	var v stakeInfoJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if len(v.Coinbase) != len(s.Coinbase) {
+		return fmt.Errorf("invalid coinbase length %d", len(v.Coinbase))
+	}
+	balance, ok := new(big.Int).SetString(v.LockedBalance, 10)
+	if !ok {
+		return fmt.Errorf("invalid locked balance %q", v.LockedBalance)
+	}
+	copy(s.Coinbase[:], v.Coinbase)
+	s.WorkBase = v.WorkBase
+	s.IsWork = v.IsWork
+	s.LockedBalance = types.NewU128(*balance)
+	s.WorkCount = v.WorkCount
+	return nil
 }
 
 type StakeInfos []*StakeInfo
@@ -22,10 +78,143 @@ func (s StakeInfos) Less(i, j int) bool {
 	return s[i].LockedBalance.Int.Cmp(s[j].LockedBalance.Int) > 0
 }
 
+// LockedBalanceTop20 sorts s by locked balance and returns its TopN stakers. Stakers of equal balance are
+// ranked by work base, so of the stakers tied at the cutoff the same ones are selected whatever order they
+// were read in.
 func (s StakeInfos) LockedBalanceTop20() []*StakeInfo {
-	sort.Sort(s)
-	if s.Len() > 20 {
-		return s[:20]
+	sort.Slice(s, func(i, j int) bool {
+		if c := s[i].LockedBalance.Int.Cmp(s[j].LockedBalance.Int); c != 0 {
+			return c > 0
+		}
+		return bytes.Compare(s[i].WorkBase, s[j].WorkBase) < 0
+	})
+	if s.Len() > TopN {
+		return s[:TopN]
 	}
 	return s
 }
+
+// TopNWithoutTies is LockedBalanceTop20 leaving out all the stakers tied at the cutoff when some of them
+// would be cut, so it may return fewer than TopN stakers for more than TopN.
+func (s StakeInfos) TopNWithoutTies() []*StakeInfo {
+	top := s.LockedBalanceTop20()
+	if s.Len() <= TopN || s[TopN].LockedBalance.Cmp(s[TopN-1].LockedBalance.Int) != 0 {
+		return top
+	}
+	cut := TopN - 1
+	for cut > 0 && s[cut-1].LockedBalance.Cmp(s[TopN].LockedBalance.Int) == 0 {
+		cut--
+	}
+	return s[:cut]
+}
+
+// WithoutPlaceholders returns the stakers of s, leaving out the empty placeholders a set is padded with
+func (s StakeInfos) WithoutPlaceholders() StakeInfos {
+	stakers := make(StakeInfos, 0, len(s))
+	for _, info := range s {
+		if len(info.WorkBase) > 0 {
+			stakers = append(stakers, info)
+		}
+	}
+	return stakers
+}
+
+// FilterLockedBalance returns the stakers with a positive locked balance of at least min, a nil min only
+// drops stakers without a balance
+func (s StakeInfos) FilterLockedBalance(min *big.Int) StakeInfos {
+	filtered := make(StakeInfos, 0, len(s))
+	for _, info := range s {
+		if info.LockedBalance.Int == nil || info.LockedBalance.Sign() <= 0 {
+			continue
+		}
+		if min != nil && info.LockedBalance.Cmp(min) < 0 {
+			continue
+		}
+		filtered = append(filtered, info)
+	}
+	return filtered
+}
+
+// FilterWorkCount returns the stakers with a WorkCount of at least min
+func (s StakeInfos) FilterWorkCount(min uint32) StakeInfos {
+	filtered := make(StakeInfos, 0, len(s))
+	for _, info := range s {
+		if info.WorkCount >= min {
+			filtered = append(filtered, info)
+		}
+	}
+	return filtered
+}
+
+// CheckTopN verifies that s is sorted by locked balance descending and holds every staker only once
+func (s StakeInfos) CheckTopN() error {
+	seen := make(map[string]struct{}, len(s))
+	for i, info := range s {
+		if i > 0 && s.Less(i, i-1) {
+			return fmt.Errorf("%w: balance %s at %d exceeds %s at %d", ErrInvalidTopN, info.LockedBalance, i, s[i-1].LockedBalance, i-1)
+		}
+		if _, ok := seen[string(info.WorkBase)]; ok {
+			return fmt.Errorf("%w: staker %x at %d is duplicated", ErrInvalidTopN, info.WorkBase, i)
+		}
+		seen[string(info.WorkBase)] = struct{}{}
+	}
+	return nil
+}
+
+// StakeInfoDiff holds the membership and balance changes between two submitted sets
+type StakeInfoDiff struct {
+	Joined  StakeInfos
+	Left    StakeInfos
+	Updated StakeInfos
+}
+
+// DiffStakeInfos compares the current set against the last submitted one, keyed by WorkBase
+func DiffStakeInfos(last, current StakeInfos) StakeInfoDiff {
+	var diff StakeInfoDiff
+	lastIndex := make(map[string]*StakeInfo, len(last))
+	for _, info := range last {
+		lastIndex[string(info.WorkBase)] = info
+	}
+
+	seen := make(map[string]struct{}, len(current))
+	for _, info := range current {
+		seen[string(info.WorkBase)] = struct{}{}
+		prev, ok := lastIndex[string(info.WorkBase)]
+		if !ok {
+			diff.Joined = append(diff.Joined, info)
+			continue
+		}
+		if prev.LockedBalance.Int.Cmp(info.LockedBalance.Int) != 0 {
+			diff.Updated = append(diff.Updated, info)
+		}
+	}
+
+	for _, info := range last {
+		if _, ok := seen[string(info.WorkBase)]; !ok {
+			diff.Left = append(diff.Left, info)
+		}
+	}
+	return diff
+}
+
+func (d StakeInfoDiff) Empty() bool {
+	return len(d.Joined) == 0 && len(d.Left) == 0 && len(d.Updated) == 0
+}
+
+// StakeInfos flattens the diff into a submission payload, stakers that left are reported with IsWork unset.
+// They are sorted by WorkBase, so the payload doesn't depend on the order the last set was read in.
+func (d StakeInfoDiff) StakeInfos() StakeInfos {
+	infos := make(StakeInfos, 0, len(d.Joined)+len(d.Updated)+len(d.Left))
+	infos = append(infos, d.Joined...)
+	infos = append(infos, d.Updated...)
+	stopped := make(StakeInfos, 0, len(d.Left))
+	for _, info := range d.Left {
+		s := *info
+		s.IsWork = false
+		stopped = append(stopped, &s)
+	}
+	sort.SliceStable(stopped, func(i, j int) bool {
+		return bytes.Compare(stopped[i].WorkBase, stopped[j].WorkBase) < 0
+	})
+	return append(infos, stopped...)
+}
@@ -1,6 +1,8 @@
 package substrate
 
 import (
+	"encoding/json"
+	"errors"
 	"math/big"
 	"reflect"
 	"testing"
@@ -494,3 +496,294 @@ func TestStakeInfos_LockedBalanceTop20(t *testing.T) {
 		})
 	}
 }
+
+func TestDiffStakeInfos(t *testing.T) {
+	newInfo := func(workBase byte, balance int64) *StakeInfo {
+		return &StakeInfo{
+			WorkBase:      common.BytesToAddress([]byte{workBase}).Bytes(),
+			IsWork:        true,
+			LockedBalance: types.NewU128(*big.NewInt(balance)),
+		}
+	}
+	last := StakeInfos{newInfo(1, 10), newInfo(2, 20), newInfo(3, 30)}
+
+	tests := []struct {
+		name        string
+		current     StakeInfos
+		wantJoined  StakeInfos
+		wantLeft    StakeInfos
+		wantUpdated StakeInfos
+	}{
+		{
+			name:    "unchanged",
+			current: StakeInfos{newInfo(1, 10), newInfo(2, 20), newInfo(3, 30)},
+		},
+		{
+			name:       "joined",
+			current:    StakeInfos{newInfo(1, 10), newInfo(2, 20), newInfo(3, 30), newInfo(4, 40)},
+			wantJoined: StakeInfos{newInfo(4, 40)},
+		},
+		{
+			name:     "left",
+			current:  StakeInfos{newInfo(1, 10), newInfo(3, 30)},
+			wantLeft: StakeInfos{newInfo(2, 20)},
+		},
+		{
+			name:        "balance-updated",
+			current:     StakeInfos{newInfo(1, 10), newInfo(2, 25), newInfo(3, 30)},
+			wantUpdated: StakeInfos{newInfo(2, 25)},
+		},
+		{
+			name:        "joined-left-updated",
+			current:     StakeInfos{newInfo(4, 40), newInfo(3, 35), newInfo(1, 10)},
+			wantJoined:  StakeInfos{newInfo(4, 40)},
+			wantLeft:    StakeInfos{newInfo(2, 20)},
+			wantUpdated: StakeInfos{newInfo(3, 35)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DiffStakeInfos(last, tt.current)
+			if !reflect.DeepEqual(got.Joined, tt.wantJoined) {
+				t.Errorf("DiffStakeInfos() Joined = %v, want %v", got.Joined, tt.wantJoined)
+			}
+			if !reflect.DeepEqual(got.Left, tt.wantLeft) {
+				t.Errorf("DiffStakeInfos() Left = %v, want %v", got.Left, tt.wantLeft)
+			}
+			if !reflect.DeepEqual(got.Updated, tt.wantUpdated) {
+				t.Errorf("DiffStakeInfos() Updated = %v, want %v", got.Updated, tt.wantUpdated)
+			}
+			if got.Empty() != (tt.wantJoined == nil && tt.wantLeft == nil && tt.wantUpdated == nil) {
+				t.Errorf("DiffStakeInfos() Empty = %v", got.Empty())
+			}
+		})
+	}
+}
+
+func TestStakeInfoDiff_StakeInfos(t *testing.T) {
+	left := &StakeInfo{WorkBase: []byte{2}, IsWork: true, LockedBalance: types.NewU128(*big.NewInt(20))}
+	diff := StakeInfoDiff{
+		Joined:  StakeInfos{{WorkBase: []byte{1}, IsWork: true, LockedBalance: types.NewU128(*big.NewInt(10))}},
+		Left:    StakeInfos{left},
+		Updated: StakeInfos{{WorkBase: []byte{3}, IsWork: true, LockedBalance: types.NewU128(*big.NewInt(30))}},
+	}
+
+	got := diff.StakeInfos()
+	if len(got) != 3 {
+		t.Fatalf("StakeInfos() len = %d, want 3", len(got))
+	}
+	if !got[0].IsWork || !got[1].IsWork {
+		t.Errorf("StakeInfos() joined/updated entries must keep IsWork set")
+	}
+	if got[2].IsWork || string(got[2].WorkBase) != string(left.WorkBase) {
+		t.Errorf("StakeInfos() left entry = %+v, want stopped %x", got[2], left.WorkBase)
+	}
+	if !left.IsWork {
+		t.Errorf("StakeInfos() must not modify the last submitted set")
+	}
+}
+
+func TestStakeInfoDiff_StakeInfosStoppedOrder(t *testing.T) {
+	info := func(workBase byte, balance int64) *StakeInfo {
+		return &StakeInfo{WorkBase: []byte{workBase}, IsWork: true, LockedBalance: types.NewU128(*big.NewInt(balance))}
+	}
+	current := StakeInfos{info(9, 50)}
+	// the stakers that left hold equal balances and the last set was read back in different orders
+	orders := []StakeInfos{
+		{info(9, 50), info(3, 10), info(1, 10), info(7, 10), info(2, 10)},
+		{info(2, 10), info(7, 10), info(9, 50), info(1, 10), info(3, 10)},
+		{info(7, 10), info(1, 10), info(2, 10), info(3, 10), info(9, 50)},
+	}
+	want := []byte{1, 2, 3, 7}
+	var first []byte
+	for i, last := range orders {
+		got := DiffStakeInfos(last, current).StakeInfos()
+		var stopped []byte
+		for _, s := range got {
+			if !s.IsWork {
+				stopped = append(stopped, s.WorkBase...)
+			}
+		}
+		if !reflect.DeepEqual(stopped, want) {
+			t.Errorf("order %d: stopped stakers %v, want %v", i, stopped, want)
+		}
+		encoded, err := types.EncodeToBytes(got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first == nil {
+			first = encoded
+		} else if !reflect.DeepEqual(encoded, first) {
+			t.Errorf("order %d: encoded payload %x differs from %x", i, encoded, first)
+		}
+	}
+}
+
+func TestStakeInfos_CheckTopN(t *testing.T) {
+	info := func(workBase byte, balance int64) *StakeInfo {
+		return &StakeInfo{WorkBase: []byte{workBase}, IsWork: true, LockedBalance: types.NewU128(*big.NewInt(balance))}
+	}
+	tests := []struct {
+		name    string
+		infos   StakeInfos
+		wantErr bool
+	}{
+		{name: "empty", infos: StakeInfos{}},
+		{name: "sorted", infos: StakeInfos{info(1, 30), info(2, 20), info(3, 10)}},
+		{name: "equal-balances", infos: StakeInfos{info(1, 20), info(2, 20)}},
+		{name: "unsorted", infos: StakeInfos{info(1, 20), info(2, 30)}, wantErr: true},
+		{name: "duplicate", infos: StakeInfos{info(1, 30), info(1, 20)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.infos.CheckTopN()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckTopN() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidTopN) {
+				t.Errorf("CheckTopN() error = %v, want ErrInvalidTopN", err)
+			}
+		})
+	}
+}
+
+func TestStakeInfos_TopNWithoutTies(t *testing.T) {
+	// stakers 1 to TopN-2 above the cutoff, then the given balances for the stakers after them
+	infos := func(tail ...int64) StakeInfos {
+		var s StakeInfos
+		for i := 0; i < TopN-2; i++ {
+			s = append(s, &StakeInfo{WorkBase: []byte{byte(i + 1)}, LockedBalance: types.NewU128(*big.NewInt(int64(100 - i)))})
+		}
+		for i, balance := range tail {
+			s = append(s, &StakeInfo{WorkBase: []byte{byte(TopN - 1 + i)}, LockedBalance: types.NewU128(*big.NewInt(balance))})
+		}
+		return s
+	}
+	tests := []struct {
+		name  string
+		infos StakeInfos
+		want  int
+	}{
+		{name: "TopN-1", infos: infos(10), want: TopN - 1},
+		{name: "TopN tied", infos: infos(10, 10), want: TopN},
+		{name: "TopN+1", infos: infos(10, 9, 8), want: TopN},
+		{name: "TopN+1 tied at the cutoff", infos: infos(10, 9, 9), want: TopN - 1},
+		{name: "TopN+2 tied across the cutoff", infos: infos(9, 9, 9, 8), want: TopN - 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.infos.TopNWithoutTies(); len(got) != tt.want {
+				t.Errorf("TopNWithoutTies() selected %d stakers, want %d", len(got), tt.want)
+			}
+		})
+	}
+
+	// the ties are ranked by work base whatever their order
+	s := infos(10, 9, 9)
+	s[TopN-1], s[TopN] = s[TopN], s[TopN-1]
+	if top := s.LockedBalanceTop20(); top[TopN-1].WorkBase[0] != byte(TopN) {
+		t.Errorf("LockedBalanceTop20() selected staker %d of the tied ones, want %d", top[TopN-1].WorkBase[0], TopN)
+	}
+}
+
+func TestStakeInfos_FilterLockedBalance(t *testing.T) {
+	infos := StakeInfos{
+		{WorkBase: []byte{1}, LockedBalance: types.NewU128(*big.NewInt(0))},
+		{WorkBase: []byte{2}, LockedBalance: types.NewU128(*big.NewInt(5))},
+		{WorkBase: []byte{3}, LockedBalance: types.NewU128(*big.NewInt(10))},
+		{WorkBase: []byte{4}},
+	}
+	tests := []struct {
+		name string
+		min  *big.Int
+		want []byte
+	}{
+		{name: "default-floor", min: nil, want: []byte{2, 3}},
+		{name: "zero-floor", min: big.NewInt(0), want: []byte{2, 3}},
+		{name: "min", min: big.NewInt(10), want: []byte{3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := infos.FilterLockedBalance(tt.min)
+			var workBases []byte
+			for _, info := range got {
+				workBases = append(workBases, info.WorkBase[0])
+			}
+			if !reflect.DeepEqual(workBases, tt.want) {
+				t.Errorf("FilterLockedBalance() = %v, want %v", workBases, tt.want)
+			}
+		})
+	}
+}
+
+func TestStakeInfos_FilterWorkCount(t *testing.T) {
+	infos := StakeInfos{
+		{WorkBase: []byte{1}, WorkCount: 0},
+		{WorkBase: []byte{2}, WorkCount: 4},
+		{WorkBase: []byte{3}, WorkCount: 5},
+		{WorkBase: []byte{4}, WorkCount: 6},
+	}
+	tests := []struct {
+		name string
+		min  uint32
+		want []byte
+	}{
+		{name: "no-minimum", min: 0, want: []byte{1, 2, 3, 4}},
+		{name: "below-boundary", min: 4, want: []byte{2, 3, 4}},
+		{name: "at-boundary", min: 5, want: []byte{3, 4}},
+		{name: "above-all", min: 7, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var workBases []byte
+			for _, info := range infos.FilterWorkCount(tt.min) {
+				workBases = append(workBases, info.WorkBase[0])
+			}
+			if !reflect.DeepEqual(workBases, tt.want) {
+				t.Errorf("FilterWorkCount() = %v, want %v", workBases, tt.want)
+			}
+		})
+	}
+}
+
+func TestStakeInfo_JSON(t *testing.T) {
+	balance, _ := new(big.Int).SetString("340282366920938463463374607431768211455", 10)
+	info := &StakeInfo{
+		Coinbase:      EthAddrToAccountID(address1),
+		WorkBase:      address1.Bytes(),
+		IsWork:        true,
+		LockedBalance: types.NewU128(*balance),
+		WorkCount:     3,
+	}
+	data, err := json.Marshal(StakeInfos{info})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"coinbase":"0x` + common.Bytes2Hex(info.Coinbase[:]) + `","work_base":"0xa7f6c9a5052a08a14ff0e3349094b6efbc591ea4",` +
+		`"is_work":true,"locked_balance":"340282366920938463463374607431768211455","work_count":3}]`
+	if string(data) != want {
+		t.Errorf("json.Marshal() = %s, want %s", data, want)
+	}
+
+	var got StakeInfos
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || !reflect.DeepEqual(got[0], info) {
+		t.Errorf("json round trip = %+v, want %+v", got, info)
+	}
+	// the json tags don't change the SCALE encoding of the payload
+	scale, err := types.EncodeToBytes(*got[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := types.EncodeToBytes(*info); !reflect.DeepEqual(scale, want) {
+		t.Errorf("SCALE encoding after the round trip = %x, want %x", scale, want)
+	}
+
+	for _, bad := range []string{`{"coinbase":"0x01","locked_balance":"1"}`, `{"coinbase":"0x` + common.Bytes2Hex(info.Coinbase[:]) + `","locked_balance":"1.5"}`} {
+		if err := json.Unmarshal([]byte(bad), new(StakeInfo)); err == nil {
+			t.Errorf("json.Unmarshal(%s) accepted an invalid stake info", bad)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package substrate
+
+import (
+	"fmt"
+)
+
+// StakeInfoReader is implemented by Submitters that can read back the stake infos stored by the NuProxy
+// pallet, as the raw SCALE encoding of its storage item
+type StakeInfoReader interface {
+	StoredStakeInfos() ([]byte, error)
+}
+
+// StoredStakeInfos reads the StakeInfoItem of the NuProxy pallet at the latest block, nil if it is empty
+func (c *Connection) StoredStakeInfos() ([]byte, error) {
+	return c.storedStakeInfos(c.API.RPC.State)
+}
+
+func (c *Connection) storedStakeInfos(state rawState) ([]byte, error) {
+	key, err := CreateStoreKey(NuProxy, c.StakeInfoItem, nil)
+	if err != nil {
+		return nil, err
+	}
+	data, err := state.GetStorageRawLatest(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s.%s: %w", NuProxy, c.StakeInfoItem, err)
+	}
+	if data == nil {
+		return nil, nil
+	}
+	return *data, nil
+}
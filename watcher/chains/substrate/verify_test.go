@@ -0,0 +1,21 @@
+package substrate
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+)
+
+func TestConnection_storedStakeInfos(t *testing.T) {
+	c := NewConnection("", nil, nil)
+	state := &testHaltState{}
+	if got, err := c.storedStakeInfos(state); err != nil || got != nil {
+		t.Errorf("storedStakeInfos() of an empty item = %x, %v, want nil", got, err)
+	}
+	stored := types.NewStorageDataRaw([]byte{0x04, 0x01})
+	state.flag = &stored
+	if got, err := c.storedStakeInfos(state); err != nil || !bytes.Equal(got, stored) {
+		t.Errorf("storedStakeInfos() = %x, %v, want %x", got, err, []byte(stored))
+	}
+}
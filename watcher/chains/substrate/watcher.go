@@ -1,12 +1,12 @@
 package substrate
 
 import (
-	"errors"
 	"fmt"
 	"github.com/ethereum/go-ethereum/log"
 	"strings"
 
 	"github.com/centrifuge/go-substrate-rpc-client/v4/hash"
+	"github.com/centrifuge/go-substrate-rpc-client/v4/signature"
 	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
 	"github.com/centrifuge/go-substrate-rpc-client/v4/xxhash"
 )
@@ -58,16 +58,27 @@ func (c *Connection) isExistWatcher() (bool, error) {
 	return len(keys) > 0, nil
 }
 
+// WatcherChecker is implemented by Submitters that can tell whether a key is registered as a watcher of the
+// NuProxy pallet, which only accepts the submissions signed by one
+type WatcherChecker interface {
+	IsWatcherKey(key *signature.KeyringPair) (bool, error)
+}
+
 func (c *Connection) isWatcher() (bool, error) {
-	key, err := CreateStoreKey(NuProxy, Watchers, c.Key.PublicKey)
+	return c.IsWatcherKey(c.SigningKey())
+}
+
+// IsWatcherKey reports whether key is registered as a watcher of the NuProxy pallet
+func (c *Connection) IsWatcherKey(key *signature.KeyringPair) (bool, error) {
+	storeKey, err := CreateStoreKey(NuProxy, Watchers, key.PublicKey)
 	if err != nil {
 		return false, err
 	}
 
 	var n uint32
-	ok, err := c.API.RPC.State.GetStorageLatest(key, &n)
+	ok, err := c.API.RPC.State.GetStorageLatest(storeKey, &n)
 	if err != nil {
-		return false, fmt.Errorf("failed to get the latest storage, err: %v", err)
+		return false, fmt.Errorf("failed to get the latest storage: %w", err)
 	}
 	if !ok {
 		return false, nil
@@ -81,7 +92,7 @@ func (c *Connection) RegisterWatcher() error {
 		return err
 	}
 	if w {
-		log.Info("repeat register watcher", "watcher", c.Key.Address)
+		log.Info("repeat register watcher", "watcher", c.SigningKey().Address)
 		return nil
 	}
 
@@ -90,7 +101,7 @@ func (c *Connection) RegisterWatcher() error {
 		return err
 	}
 	if exist {
-		return errors.New("watcher already exists")
+		return ErrWatcherExists
 	}
 
 	return c.SubmitTx(RegisterWatcher)
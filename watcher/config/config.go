@@ -6,10 +6,13 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"math/big"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/urfave/cli/v2"
 )
@@ -25,38 +28,662 @@ func IsEmpty(s string) bool {
 }
 
 type Config struct {
-	EpochSize         uint64            `json:"epochSize"`
-	EthereumConfig    EthereumConfig    `json:"ethereumConfig"`
-	NuLinkChainConfig NuLinkChainConfig `json:"nuLinkChainConfig"`
+	EpochSize                   uint64             `json:"epochSize"`
+	EpochOffset                 uint64             `json:"epochOffset"`
+	EpochSubmissionOffset       uint64             `json:"epochSubmissionOffset"`
+	SubmitEveryNEpochs          uint64             `json:"submitEveryNEpochs"`
+	SubmitOnFinalizedEpoch      bool               `json:"submitOnFinalizedEpoch"`
+	SubmitMode                  string             `json:"submitMode"`
+	EpochSource                 string             `json:"epochSource"`
+	FullResyncEpochs            uint64             `json:"fullResyncEpochs"`
+	HeartbeatEpochs             uint64             `json:"heartbeatEpochs"`
+	CatchUpEpochs               bool               `json:"catchUpEpochs"`
+	CatchUpMissedEpochs         bool               `json:"catchUpMissedEpochs"`
+	SuppressSubmitUntilCaughtUp bool               `json:"suppressSubmitUntilCaughtUp"`
+	MaxResumeGap                uint64             `json:"maxResumeGap"`
+	ResumeGapPolicy             string             `json:"resumeGapPolicy"`
+	PollInterval                Duration           `json:"pollInterval"`
+	RetryInterval               Duration           `json:"retryInterval"`
+	EpochRetries                int                `json:"epochRetries"`
+	EpochRetryBackoff           Duration           `json:"epochRetryBackoff"`
+	MaxEpochRetryBackoff        Duration           `json:"maxEpochRetryBackoff"`
+	RegressionTolerance         int                `json:"regressionTolerance"`
+	StakerLogInterval           uint64             `json:"stakerLogInterval"`
+	StakerFetchRetries          int                `json:"stakerFetchRetries"`
+	MaxStakerFetchFailures      *uint64            `json:"maxStakerFetchFailures"`
+	CompressState               bool               `json:"compressState"`
+	MaxStateAge                 Duration           `json:"maxStateAge"`
+	MaxClockSkew                Duration           `json:"maxClockSkew"`
+	SubmissionDeadline          Duration           `json:"submissionDeadline"`
+	MinLockedBalance            *big.Int           `json:"minLockedBalance"`
+	MinWorkCount                uint32             `json:"minWorkCount"`
+	VerifyTopN                  bool               `json:"verifyTopN"`
+	VerifySubmission            bool               `json:"verifySubmission"`
+	VerifyDelay                 Duration           `json:"verifyDelay"`
+	UndersizedPolicy            string             `json:"undersizedPolicy"`
+	TopNTies                    string             `json:"topNTies"`
+	ZeroStakersPolicy           string             `json:"zeroStakersPolicy"`
+	OnSnapshotFailure           string             `json:"onSnapshotFailure"`
+	OverflowPolicy              string             `json:"overflowPolicy"`
+	StoppedGraceEpochs          uint64             `json:"stoppedGraceEpochs"`
+	StoppedConfirmations        uint64             `json:"stoppedConfirmations"`
+	MaxStoppedPerEpoch          int                `json:"maxStoppedPerEpoch"`
+	LockPeriods                 string             `json:"lockPeriods"`
+	MaxChurnPercent             float64            `json:"maxChurnPercent"`
+	ChurnStableEpochs           uint64             `json:"churnStableEpochs"`
+	ParallelSnapshot            bool               `json:"parallelSnapshot"`
+	SnapshotLead                uint64             `json:"snapshotLead"`
+	MaxEventsPerBlock           int                `json:"maxEventsPerBlock"`
+	HaltOnEventLimit            bool               `json:"haltOnEventLimit"`
+	PayloadVersion              int                `json:"payloadVersion"`
+	LatestBlockFormat           string             `json:"latestBlockFormat"`
+	Retention                   RetentionConfig    `json:"retention"`
+	StakerCache                 StakerCacheConfig  `json:"stakerCache"`
+	WarmPreload                 WarmPreloadConfig  `json:"warmPreload"`
+	SafetyGuards                SafetyGuardConfig  `json:"safetyGuards"`
+	StakeSource                 StakeSourceConfig  `json:"stakeSource"`
+	StakerFilter                StakerFilterConfig `json:"stakerFilter"`
+	DepositSpill                DepositSpillConfig `json:"depositSpill"`
+	Sinks                       []SinkConfig       `json:"sinks"`
+	EventExport                 EventExportConfig  `json:"eventExport"`
+	Notify                      NotifyConfig       `json:"notify"`
+	Replica                     ReplicaConfig      `json:"replica"`
+	EthereumConfig              EthereumConfig     `json:"ethereumConfig"`
+	NuLinkChainConfig           NuLinkChainConfig  `json:"nuLinkChainConfig"`
+
+	// Network is the --network preset applied by GetConfig, empty without one
+	Network string `json:"-"`
 }
 
 type EthereumConfig struct {
-	URL                 string `json:"url"`
-	Http                bool   `json:"http"`
-	DepositContractAddr string `json:"depositContractAddr"`
-	//StartBlock          *big.Int `json:"startBlock"`
-	//BlockConfirmations  *big.Int `json:"blockConfirmations"`
+	URL                      string           `json:"url"`
+	Http                     bool             `json:"http"`
+	DepositContractAddr      string           `json:"depositContractAddr"`
+	BlockConfirmations       *big.Int         `json:"blockConfirmations"`
+	UseFinalizedTag          bool             `json:"useFinalizedTag"`
+	StakerTopic              *TopicSlice      `json:"stakerTopic"`
+	StakerDecoder            string           `json:"stakerDecoder"`
+	StartBlock               *big.Int         `json:"startBlock"`
+	ChainID                  *big.Int         `json:"chainId"`
+	DetectStartBlock         bool             `json:"detectStartBlock"`
+	SeparateOperator         bool             `json:"separateOperator"`
+	Contracts                []ContractConfig `json:"contracts"`
+	CrossContractAggregation string           `json:"crossContractAggregation"`
+	MigrateTo                string           `json:"migrateTo"`
+	MigrationEventSig        string           `json:"migrationEventSig"`
+	// MaxLogQuerySpan caps the blocks a single eth_getLogs call covers, a longer range is split into
+	// sub-queries. 0 leaves the range to the provider.
+	MaxLogQuerySpan uint64 `json:"maxLogQuerySpan"`
+	// DetectReorgs checks the hashes of the processed safe heads against the chain every poll. A reorg up to
+	// ReorgTolerance blocks deep, BlockConfirmations when nil, is scanned again from the fork point, a deeper
+	// one resyncs the stake infos from a full snapshot.
+	DetectReorgs   bool    `json:"detectReorgs"`
+	ReorgTolerance *uint64 `json:"reorgTolerance"`
+	// KeepAlive pings an idle websocket connection this often while waiting for the next poll and reconnects
+	// when a ping fails. 0 disables it.
+	KeepAlive Duration `json:"keepAlive"`
+}
+
+// ContractConfig is a deposit contract whose events are read by the listener. Confirmations are waited for
+// on top of the safe head, a nil StartBlock falls back to the one of the EthereumConfig and an empty
+// EventSig to the Deposited event.
+type ContractConfig struct {
+	Address       string   `json:"address"`
+	StartBlock    *big.Int `json:"startBlock"`
+	Confirmations *big.Int `json:"confirmations"`
+	EventSig      string   `json:"eventSig"`
+}
+
+// DepositContracts returns the contracts to read deposit events from, the DepositContractAddr unless
+// Contracts are configured
+func (c *EthereumConfig) DepositContracts() []ContractConfig {
+	if len(c.Contracts) == 0 {
+		return []ContractConfig{{Address: c.DepositContractAddr, StartBlock: c.StartBlock}}
+	}
+	return c.Contracts
+}
+
+// TopicSlice selects the bytes of an event topic holding the staker, at most an address for the raw decoder
+type TopicSlice struct {
+	Index  int `json:"index"`
+	Offset int `json:"offset"`
+	Length int `json:"length"`
+}
+
+// DefaultStakerTopic selects the staker of a Deposited event, the address in the last bytes of its first
+// indexed topic
+func DefaultStakerTopic() *TopicSlice {
+	return &TopicSlice{Index: 1, Offset: common.HashLength - common.AddressLength, Length: common.AddressLength}
+}
+
+func (t *TopicSlice) validate() error {
+	if t.Index < 1 {
+		return fmt.Errorf("topic index must be at least 1, topic 0 is the event signature")
+	}
+	if t.Length < 1 || t.Length > common.HashLength {
+		return fmt.Errorf("topic length must be between 1 and %d", common.HashLength)
+	}
+	if t.Offset < 0 || t.Offset+t.Length > common.HashLength {
+		return fmt.Errorf("topic offset %d and length %d exceed the %d byte topic", t.Offset, t.Length, common.HashLength)
+	}
+	return nil
+}
+
+// NotifyConfig configures the webhook called on repeated submission failures, an empty URL disables it
+type NotifyConfig struct {
+	URL              string   `json:"url"`
+	Template         string   `json:"template"`
+	FailureThreshold int      `json:"failureThreshold"`
+	Timeout          Duration `json:"timeout"`
+}
+
+// ReplicaConfig runs the watcher as a hot standby of a primary watcher. The replica follows the chain but
+// only submits once the primary was silent for Timeout, and steps down when the primary submits again. The
+// primary's submissions are read from its PrimaryAuditLog, or from the pallet storage without one.
+type ReplicaConfig struct {
+	Enabled         bool     `json:"enabled"`
+	Timeout         Duration `json:"timeout"`
+	PrimaryAuditLog string   `json:"primaryAuditLog"`
+}
+
+// RetentionConfig limits the per epoch history kept, MaxFiles latest epochs and files younger than MaxAge.
+// Zero values disable the limits, the history is pruned every Interval.
+type RetentionConfig struct {
+	MaxFiles int      `json:"maxFiles"`
+	MaxAge   Duration `json:"maxAge"`
+	Interval Duration `json:"interval"`
+}
+
+// Enabled reports whether any retention limit is configured
+func (r RetentionConfig) Enabled() bool {
+	return r.MaxFiles > 0 || r.MaxAge.Duration > 0
+}
+
+// SinkConfig is an output receiving the stake info set submitted for every epoch. An http sink posts it as
+// json to URL with Headers and Timeout, a file sink appends it as a json line to Path.
+type SinkConfig struct {
+	Type    string            `json:"type"`
+	URL     string            `json:"url"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers"`
+	Timeout Duration          `json:"timeout"`
+}
+
+// EventExportConfig writes the deposit events found to a csv file at Path for reporting, an empty Path
+// disables it. The file is rotated once it would grow beyond MaxBytes and, with Daily, at the first event of
+// a new UTC day; zero MaxBytes doesn't rotate by size.
+type EventExportConfig struct {
+	Path     string `json:"path"`
+	MaxBytes int64  `json:"maxBytes"`
+	Daily    bool   `json:"daily"`
+}
+
+// DepositSpillConfig bounds the memory of the deposits accumulated between epoch flushes. Once they exceed
+// MaxEntries contract and staker totals or their estimated MaxBytes, they are spilled to a file next to the
+// deposit checkpoint, or in Dir without a checkpoint, and merged back at the flush.
+type DepositSpillConfig struct {
+	MaxEntries int    `json:"maxEntries"`
+	MaxBytes   int64  `json:"maxBytes"`
+	Dir        string `json:"dir"`
+}
+
+// StakerCacheConfig caches the StakerInfo of up to Size stakers. A cached value is reused by the syncs of the
+// same bucket of StaleEpochs epochs, a zero Size disables the cache.
+type StakerCacheConfig struct {
+	Size        int    `json:"size"`
+	StaleEpochs uint64 `json:"staleEpochs"`
+}
+
+// WarmPreloadConfig reads the stake infos in the background once at startup, through the staker cache, and
+// keeps them for the first epoch flush, so it doesn't wait for a cold read. CallInterval paces the contract
+// calls of the preload, leaving the node capacity to polling.
+type WarmPreloadConfig struct {
+	Enabled      bool     `json:"enabled"`
+	CallInterval Duration `json:"callInterval"`
+}
+
+// SafetyGuardConfig are sanity checks beyond validate on a config that is valid but looks unsafe. A tripped
+// guard puts the listener in a safe state holding every submission back with an alert until it is restarted.
+// An epoch shorter than MinEpochSize trips at startup, a start block more than MaxStartBlockAhead blocks
+// above the safe head once polling starts and a set of fewer than MinSetSize stakers replacing a larger one
+// at the epoch boundary. A zero threshold uses the default, Disabled turns every guard off.
+type SafetyGuardConfig struct {
+	Disabled           bool   `json:"disabled"`
+	MinEpochSize       uint64 `json:"minEpochSize"`
+	MaxStartBlockAhead uint64 `json:"maxStartBlockAhead"`
+	MinSetSize         int    `json:"minSetSize"`
+}
+
+// StakeSourceConfig selects where the stake infos of an epoch are read from: the deposit contract over rpc,
+// or a subgraph at URL queried with Query in pages of PageSize stakers. A failed subgraph read falls back
+// to the deposit contract. An empty Query uses the default one, a custom Query takes the same variables
+// and returns the same fields.
+type StakeSourceConfig struct {
+	Type     string   `json:"type"`
+	URL      string   `json:"url"`
+	Query    string   `json:"query"`
+	PageSize int      `json:"pageSize"`
+	Timeout  Duration `json:"timeout"`
+}
+
+// HaltConfig is the storage flag the NuLink chain signals a halt with, either the raw hex Key or the plain
+// storage Item of Pallet. Submissions are held back while it is set, it is polled every Interval.
+type HaltConfig struct {
+	Key      string   `json:"key"`
+	Pallet   string   `json:"pallet"`
+	Item     string   `json:"item"`
+	Interval Duration `json:"interval"`
+}
+
+// Enabled reports whether a halt flag is configured
+func (h HaltConfig) Enabled() bool {
+	return h.Key != "" || h.Pallet != "" || h.Item != ""
 }
 
 type NuLinkChainConfig struct {
-	URL string `json:"url"`
-	//Seed    string `json:"seed"`
+	URL            string     `json:"url"`
+	UpgradeRetries int        `json:"upgradeRetries"`
+	Halt           HaltConfig `json:"halt"`
+	StakeInfoItem  string     `json:"stakeInfoItem"`
+	// DryRun applies every extrinsic with system_dryRun before submitting it, so a call the runtime rejects
+	// fails with the pallet and error it is rejected with
+	DryRun bool `json:"dryRun"`
+	// Tip is paid on every UpdateStakeInfo extrinsic, in the smallest unit of the NuLink token, to have it
+	// included ahead of the other extrinsics during congestion. Nil or 0 tips nothing.
+	Tip *big.Int `json:"tip"`
+	// MinOperatorBalance is the free balance of the signing account below which an alert is raised, checked
+	// at startup and every BalanceCheckEpochs epochs. Nil disables the check.
+	MinOperatorBalance *big.Int `json:"minOperatorBalance"`
+	BalanceCheckEpochs uint64   `json:"balanceCheckEpochs"`
+	// Seed is the secret seed or mnemonic of the signing key, empty signs with the built-in watcher key
+	Seed string `json:"seed"`
+	// NextKey is the signing key rotated to at its activation, so the operator key is replaced without
+	// downtime. Nil keeps signing with Seed.
+	NextKey *NextKeyConfig `json:"nextKey"`
 	//Network uint8  `json:"network"`
 }
 
+// NextKeyConfig is the signing key the submissions of ActivationBlock and later, or of the blocks of
+// ActivationEpoch and later, are signed with
+type NextKeyConfig struct {
+	Seed            string   `json:"seed"`
+	ActivationBlock *big.Int `json:"activationBlock"`
+	ActivationEpoch *uint64  `json:"activationEpoch"`
+}
+
+// NextKeyActive reports whether the submission made for block is signed with NextKey
+func (c *Config) NextKeyActive(block uint64) bool {
+	k := c.NuLinkChainConfig.NextKey
+	if k == nil {
+		return false
+	}
+	if k.ActivationBlock != nil {
+		return new(big.Int).SetUint64(block).Cmp(k.ActivationBlock) >= 0
+	}
+	return k.ActivationEpoch != nil && c.Epoch(block) >= *k.ActivationEpoch
+}
+
+// IsEpochBoundary reports whether block starts an epoch, epochs start every EpochSize blocks from EpochOffset
+func (c *Config) IsEpochBoundary(block uint64) bool {
+	return block >= c.EpochOffset && (block-c.EpochOffset)%c.EpochSize == 0
+}
+
+// NextEpochBoundary returns the first block after block that starts an epoch
+func (c *Config) NextEpochBoundary(block uint64) uint64 {
+	if block < c.EpochOffset {
+		return c.EpochOffset
+	}
+	return c.EpochOffset + (c.Epoch(block)+1)*c.EpochSize
+}
+
+// Epoch returns the number of the epoch block belongs to, blocks before EpochOffset are in epoch 0
+func (c *Config) Epoch(block uint64) uint64 {
+	if block < c.EpochOffset {
+		return 0
+	}
+	return (block - c.EpochOffset) / c.EpochSize
+}
+
 func (c *Config) validate() error {
 	if c.EpochSize == 0 {
 		c.EpochSize = EpochSize
 	}
+	if c.EpochOffset >= c.EpochSize {
+		return fmt.Errorf("epochOffset %d must be less than epochSize %d", c.EpochOffset, c.EpochSize)
+	}
+	switch c.SubmitMode {
+	case "":
+		c.SubmitMode = SubmitModeFull
+	case SubmitModeFull, SubmitModeDiff:
+	default:
+		return fmt.Errorf("unknown submitMode %q, expected %s or %s", c.SubmitMode, SubmitModeFull, SubmitModeDiff)
+	}
+	switch c.EpochSource {
+	case "":
+		c.EpochSource = EpochSourceSnapshot
+		if len(c.EthereumConfig.Contracts) > 0 {
+			c.EpochSource = EpochSourceEvents
+		}
+	case EpochSourceSnapshot, EpochSourceEvents, EpochSourceMerged:
+	default:
+		return fmt.Errorf("unknown epochSource %q, expected %s, %s or %s", c.EpochSource, EpochSourceSnapshot, EpochSourceEvents, EpochSourceMerged)
+	}
+	switch c.UndersizedPolicy {
+	case "":
+		c.UndersizedPolicy = UndersizedWarn
+	case UndersizedPad, UndersizedWarn, UndersizedAbort:
+	default:
+		return fmt.Errorf("unknown undersizedPolicy %q, expected %s, %s or %s", c.UndersizedPolicy, UndersizedPad, UndersizedWarn, UndersizedAbort)
+	}
+	switch c.TopNTies {
+	case "":
+		c.TopNTies = TopNTiesWorkBase
+	case TopNTiesWorkBase, TopNTiesExclude:
+	default:
+		return fmt.Errorf("unknown topNTies %q, expected %s or %s", c.TopNTies, TopNTiesWorkBase, TopNTiesExclude)
+	}
+	switch c.OverflowPolicy {
+	case "":
+		c.OverflowPolicy = OverflowClamp
+	case OverflowClamp, OverflowSkip:
+	default:
+		return fmt.Errorf("unknown overflowPolicy %q, expected %s or %s", c.OverflowPolicy, OverflowClamp, OverflowSkip)
+	}
+	switch c.ResumeGapPolicy {
+	case "":
+		c.ResumeGapPolicy = ResumeGapScan
+	case ResumeGapScan, ResumeGapJump:
+	default:
+		return fmt.Errorf("unknown resumeGapPolicy %q, expected %s or %s", c.ResumeGapPolicy, ResumeGapScan, ResumeGapJump)
+	}
+	switch c.ZeroStakersPolicy {
+	case "":
+		c.ZeroStakersPolicy = ZeroStakersSkip
+	case ZeroStakersSubmitEmpty, ZeroStakersSkip, ZeroStakersAbort:
+	default:
+		return fmt.Errorf("unknown zeroStakersPolicy %q, expected %s, %s or %s", c.ZeroStakersPolicy, ZeroStakersSubmitEmpty, ZeroStakersSkip, ZeroStakersAbort)
+	}
+	switch c.OnSnapshotFailure {
+	case "":
+		c.OnSnapshotFailure = SnapshotFailureSkip
+	case SnapshotFailureSkip, SnapshotFailureResubmitLast, SnapshotFailureAbort:
+	default:
+		return fmt.Errorf("unknown onSnapshotFailure %q, expected %s, %s or %s", c.OnSnapshotFailure, SnapshotFailureSkip, SnapshotFailureResubmitLast, SnapshotFailureAbort)
+	}
+	switch c.LockPeriods {
+	case "":
+		c.LockPeriods = LockPeriodsIgnore
+	case LockPeriodsIgnore, LockPeriodsCountdown, LockPeriodsExpiry:
+	default:
+		return fmt.Errorf("unknown lockPeriods %q, expected %s, %s or %s", c.LockPeriods, LockPeriodsIgnore, LockPeriodsCountdown, LockPeriodsExpiry)
+	}
+	if c.Notify.FailureThreshold <= 0 {
+		c.Notify.FailureThreshold = NotifyFailureThreshold
+	}
+	if c.Notify.Timeout.Duration <= 0 {
+		c.Notify.Timeout.Duration = NotifyTimeout
+	}
+	if c.FullResyncEpochs == 0 {
+		c.FullResyncEpochs = FullResyncEpochs
+	}
+	if c.PollInterval.Duration <= 0 {
+		c.PollInterval.Duration = PollInterval
+	}
+	if c.RetryInterval.Duration <= 0 {
+		c.RetryInterval.Duration = RetryInterval
+	}
+	if c.EpochRetries < 0 {
+		return fmt.Errorf("epochRetries must not be negative")
+	}
+	if c.EpochRetryBackoff.Duration <= 0 {
+		c.EpochRetryBackoff.Duration = EpochRetryBackoff
+	}
+	if c.MaxEpochRetryBackoff.Duration <= 0 {
+		c.MaxEpochRetryBackoff.Duration = MaxEpochRetryBackoff
+	}
+	if c.MaxEpochRetryBackoff.Duration < c.EpochRetryBackoff.Duration {
+		return fmt.Errorf("maxEpochRetryBackoff %s must not be below epochRetryBackoff %s", c.MaxEpochRetryBackoff.Duration, c.EpochRetryBackoff.Duration)
+	}
+	if c.RegressionTolerance <= 0 {
+		c.RegressionTolerance = RegressionTolerance
+	}
+	if c.SubmissionDeadline.Duration < 0 {
+		return fmt.Errorf("submissionDeadline must not be negative")
+	}
+	if c.MaxStateAge.Duration < 0 {
+		return fmt.Errorf("maxStateAge must not be negative")
+	}
+	if c.MaxClockSkew.Duration <= 0 {
+		c.MaxClockSkew.Duration = MaxClockSkew
+	}
+	switch c.LatestBlockFormat {
+	case "":
+		c.LatestBlockFormat = LatestBlockDec
+	case LatestBlockDec, LatestBlockHex:
+	default:
+		return fmt.Errorf("unknown latestBlockFormat %q, expected %s or %s", c.LatestBlockFormat, LatestBlockDec, LatestBlockHex)
+	}
+	switch c.PayloadVersion {
+	case 0:
+		c.PayloadVersion = PayloadVersion
+	case 1, 2:
+	default:
+		return fmt.Errorf("unknown payloadVersion %d, expected 1 or 2", c.PayloadVersion)
+	}
+	if c.Retention.MaxFiles < 0 || c.Retention.MaxAge.Duration < 0 {
+		return fmt.Errorf("retention maxFiles and maxAge must not be negative")
+	}
+	if c.Retention.Interval.Duration <= 0 {
+		c.Retention.Interval.Duration = PruneInterval
+	}
+	if _, err := c.StakerFilter.Load(); err != nil {
+		return fmt.Errorf("invalid stakerFilter: %w", err)
+	}
+	switch c.StakeSource.Type {
+	case "":
+		c.StakeSource.Type = StakeSourceRPC
+	case StakeSourceRPC:
+	case StakeSourceSubgraph:
+		if IsEmpty(c.StakeSource.URL) {
+			return fmt.Errorf("required field url for the subgraph stakeSource")
+		}
+		if c.StakeSource.PageSize < 0 {
+			return fmt.Errorf("stakeSource pageSize must not be negative")
+		}
+		if c.StakeSource.PageSize == 0 {
+			c.StakeSource.PageSize = SubgraphPageSize
+		}
+		if c.StakeSource.Timeout.Duration <= 0 {
+			c.StakeSource.Timeout.Duration = SubgraphTimeout
+		}
+	default:
+		return fmt.Errorf("unknown stakeSource type %q, expected %s or %s", c.StakeSource.Type, StakeSourceRPC, StakeSourceSubgraph)
+	}
+	for i := range c.Sinks {
+		sc := &c.Sinks[i]
+		switch sc.Type {
+		case SinkHTTP:
+			if IsEmpty(sc.URL) {
+				return fmt.Errorf("required field url for http sink %d", i)
+			}
+		case SinkFile:
+			if IsEmpty(sc.Path) {
+				return fmt.Errorf("required field path for file sink %d", i)
+			}
+		default:
+			return fmt.Errorf("unknown type %q of sink %d, expected %s or %s", sc.Type, i, SinkHTTP, SinkFile)
+		}
+		if sc.Timeout.Duration <= 0 {
+			sc.Timeout.Duration = SinkTimeout
+		}
+	}
+	if c.EventExport.MaxBytes < 0 {
+		return fmt.Errorf("eventExport maxBytes must not be negative")
+	}
+	if c.StakerFetchRetries < 0 {
+		return fmt.Errorf("stakerFetchRetries must not be negative")
+	}
+	if c.MaxStoppedPerEpoch < 0 {
+		return fmt.Errorf("maxStoppedPerEpoch must not be negative")
+	}
+	if c.MaxChurnPercent < 0 {
+		return fmt.Errorf("maxChurnPercent must not be negative")
+	}
+	if c.StakerCache.Size < 0 {
+		return fmt.Errorf("stakerCache size must not be negative")
+	}
+	if c.SafetyGuards.MinSetSize < 0 {
+		return fmt.Errorf("safetyGuards minSetSize must not be negative")
+	}
+	if !c.SafetyGuards.Disabled {
+		if c.SafetyGuards.MinEpochSize == 0 {
+			c.SafetyGuards.MinEpochSize = SafetyMinEpochSize
+		}
+		if c.SafetyGuards.MaxStartBlockAhead == 0 {
+			c.SafetyGuards.MaxStartBlockAhead = SafetyMaxStartBlockAhead
+		}
+		if c.SafetyGuards.MinSetSize == 0 {
+			c.SafetyGuards.MinSetSize = SafetyMinSetSize
+		}
+	}
+	if c.StakerCache.StaleEpochs == 0 {
+		c.StakerCache.StaleEpochs = StakerCacheStaleEpochs
+	}
+	if c.WarmPreload.CallInterval.Duration < 0 {
+		return fmt.Errorf("warmPreload.callInterval must not be negative")
+	}
+	if c.ParallelSnapshot {
+		if c.SnapshotLead == 0 {
+			c.SnapshotLead = SnapshotLead
+		}
+		if c.SnapshotLead >= c.EpochSize {
+			return fmt.Errorf("snapshotLead %d must be less than epochSize %d", c.SnapshotLead, c.EpochSize)
+		}
+	}
+	if c.DepositSpill.MaxEntries < 0 || c.DepositSpill.MaxBytes < 0 {
+		return fmt.Errorf("depositSpill thresholds must not be negative")
+	}
+	if c.DepositSpill.MaxEntries == 0 {
+		c.DepositSpill.MaxEntries = DepositSpillEntries
+	}
+	if c.DepositSpill.MaxBytes == 0 {
+		c.DepositSpill.MaxBytes = DepositSpillBytes
+	}
+	if c.MaxEventsPerBlock <= 0 {
+		c.MaxEventsPerBlock = MaxEventsPerBlock
+	}
+	if c.MinWorkCount > 0 && c.PayloadVersion == 2 {
+		return fmt.Errorf("minWorkCount needs payloadVersion 1, version 2 stores no work count")
+	}
+	if c.MinLockedBalance != nil && c.MinLockedBalance.Sign() < 0 {
+		return fmt.Errorf("minLockedBalance must not be negative")
+	}
+	if c.EthereumConfig.BlockConfirmations == nil {
+		c.EthereumConfig.BlockConfirmations = big.NewInt(BlockConfirmations)
+	} else if c.EthereumConfig.BlockConfirmations.Sign() < 0 {
+		return fmt.Errorf("blockConfirmations must not be negative")
+	}
+	if c.EthereumConfig.StartBlock != nil && c.EthereumConfig.StartBlock.Sign() < 0 {
+		return fmt.Errorf("startBlock must not be negative")
+	}
+	for i := range c.EthereumConfig.Contracts {
+		cc := &c.EthereumConfig.Contracts[i]
+		if !common.IsHexAddress(cc.Address) {
+			return fmt.Errorf("invalid address %q of contract %d", cc.Address, i)
+		}
+		if cc.StartBlock == nil {
+			cc.StartBlock = c.EthereumConfig.StartBlock
+		}
+		if cc.Confirmations != nil && cc.Confirmations.Sign() < 0 {
+			return fmt.Errorf("confirmations of contract %d must not be negative", i)
+		}
+	}
+	switch c.EthereumConfig.CrossContractAggregation {
+	case "":
+		c.EthereumConfig.CrossContractAggregation = AggregateSum
+	case AggregateSum, AggregateMax, AggregateSeparate:
+	default:
+		return fmt.Errorf("unknown crossContractAggregation %q, expected %s, %s or %s", c.EthereumConfig.CrossContractAggregation, AggregateSum, AggregateMax, AggregateSeparate)
+	}
+	if c.EthereumConfig.StakerTopic == nil {
+		c.EthereumConfig.StakerTopic = DefaultStakerTopic()
+	} else if err := c.EthereumConfig.StakerTopic.validate(); err != nil {
+		return fmt.Errorf("invalid stakerTopic: %w", err)
+	}
+	if c.EthereumConfig.StakerDecoder == "" {
+		c.EthereumConfig.StakerDecoder = StakerDecoderRaw
+	}
+	if c.EthereumConfig.StakerDecoder == StakerDecoderRaw && c.EthereumConfig.StakerTopic.Length > common.AddressLength {
+		return fmt.Errorf("invalid stakerTopic: length %d exceeds the %d byte address the %s stakerDecoder expects", c.EthereumConfig.StakerTopic.Length, common.AddressLength, StakerDecoderRaw)
+	}
+	if c.EthereumConfig.ChainID != nil && c.EthereumConfig.ChainID.Sign() <= 0 {
+		return fmt.Errorf("chainId must be positive")
+	}
 	if IsEmpty(c.EthereumConfig.URL) {
 		return fmt.Errorf("required field URL for ethereum")
 	}
 	if IsEmpty(c.EthereumConfig.DepositContractAddr) {
 		return fmt.Errorf("required field DepositContractAddr for ethereum")
 	}
+	if to := c.EthereumConfig.MigrateTo; !IsEmpty(to) {
+		if !common.IsHexAddress(to) {
+			return fmt.Errorf("invalid migrateTo address %q", to)
+		}
+		if common.HexToAddress(to) == common.HexToAddress(c.EthereumConfig.DepositContractAddr) {
+			return fmt.Errorf("migrateTo must differ from depositContractAddr")
+		}
+	}
+	if isPlaceholder(c.EthereumConfig.URL) || isPlaceholder(c.EthereumConfig.DepositContractAddr) {
+		return fmt.Errorf("fill in the placeholders of the sample config for ethereum")
+	}
+	if h := &c.NuLinkChainConfig.Halt; h.Enabled() {
+		if h.Key == "" && (h.Pallet == "" || h.Item == "") {
+			return fmt.Errorf("halt needs a key or both pallet and item")
+		}
+		if h.Interval.Duration <= 0 {
+			h.Interval.Duration = HaltInterval
+		}
+	}
+	if c.Replica.Enabled && c.Replica.Timeout.Duration <= 0 {
+		c.Replica.Timeout.Duration = ReplicaTimeout
+	}
+	if c.VerifySubmission && c.VerifyDelay.Duration <= 0 {
+		c.VerifyDelay.Duration = VerifyDelay
+	}
+	if c.NuLinkChainConfig.UpgradeRetries < 0 {
+		return fmt.Errorf("upgradeRetries must not be negative")
+	}
+	if tip := c.NuLinkChainConfig.Tip; tip != nil && tip.Sign() < 0 {
+		return fmt.Errorf("tip must not be negative")
+	}
+	if min := c.NuLinkChainConfig.MinOperatorBalance; min != nil {
+		if min.Sign() < 0 {
+			return fmt.Errorf("minOperatorBalance must not be negative")
+		}
+		if c.NuLinkChainConfig.BalanceCheckEpochs == 0 {
+			c.NuLinkChainConfig.BalanceCheckEpochs = BalanceCheckEpochs
+		}
+	}
+	if k := c.NuLinkChainConfig.NextKey; k != nil {
+		if IsEmpty(k.Seed) {
+			return fmt.Errorf("nextKey needs a seed")
+		}
+		if k.Seed == c.NuLinkChainConfig.Seed {
+			return fmt.Errorf("nextKey must differ from the current key")
+		}
+		if (k.ActivationBlock == nil) == (k.ActivationEpoch == nil) {
+			return fmt.Errorf("nextKey needs either an activationBlock or an activationEpoch")
+		}
+		if k.ActivationBlock != nil && k.ActivationBlock.Sign() < 0 {
+			return fmt.Errorf("nextKey activationBlock must not be negative")
+		}
+	}
 	if IsEmpty(c.NuLinkChainConfig.URL) {
 		return fmt.Errorf("required field URL for nuLinkChain")
 	}
+	if isPlaceholder(c.NuLinkChainConfig.URL) {
+		return fmt.Errorf("fill in the placeholder of the sample config for nuLinkChain")
+	}
 	//if IsEmpty(c.NuLinkChainConfig.Seed) {
 	//	return fmt.Errorf("required field Seed for substrate")
 	//}
@@ -69,13 +696,25 @@ func GetConfig(ctx *cli.Context) (*Config, error) {
 	if file := ctx.String(ConfigFileFlag.Name); file != "" {
 		path = file
 	}
+	network := ctx.String(NetworkFlag.Name)
 	err := loadConfig(path, &cfg)
-	if err != nil {
+	if err != nil && !(network != "" && os.IsNotExist(err)) {
 		log.Warn("failed to loading json file", "err", err.Error())
 		return &cfg, err
 	}
+	if err == nil {
+		log.Debug("Loaded config", "path", path)
+	}
 
-	log.Debug("Loaded config", "path", path)
+	if network != "" {
+		n, err := LookupNetwork(network, ctx.String(NetworksFileFlag.Name))
+		if err != nil {
+			return nil, err
+		}
+		n.apply(&cfg)
+		cfg.Network = network
+		log.Debug("Applied network preset", "network", network)
+	}
 	err = cfg.validate()
 	if err != nil {
 		return nil, err
@@ -92,13 +731,14 @@ func loadConfig(file string, config *Config) error {
 
 	log.Debug("Loading configuration", "path", filepath.Clean(fp))
 
-	f, err := os.Open(filepath.Clean(fp))
+	data, err := ioutil.ReadFile(filepath.Clean(fp))
 	if err != nil {
 		return err
 	}
 
+	// lines starting with // are comments, as in the sample config
 	if ext == ".json" {
-		if err = json.NewDecoder(f).Decode(&config); err != nil {
+		if err = json.Unmarshal(stripComments(data), &config); err != nil {
 			return err
 		}
 	} else {
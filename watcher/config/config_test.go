@@ -0,0 +1,93 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfig_IsEpochBoundary(t *testing.T) {
+	tests := []struct {
+		name      string
+		offset    uint64
+		block     uint64
+		want      bool
+		wantEpoch uint64
+	}{
+		{name: "no-offset", offset: 0, block: 2000, want: true, wantEpoch: 2},
+		{name: "no-offset-inside", offset: 0, block: 2001, want: false, wantEpoch: 2},
+		{name: "offset-origin", offset: 100, block: 100, want: true, wantEpoch: 0},
+		{name: "offset", offset: 100, block: 2100, want: true, wantEpoch: 2},
+		{name: "offset-unaligned", offset: 100, block: 2000, want: false, wantEpoch: 1},
+		{name: "before-offset", offset: 100, block: 0, want: false, wantEpoch: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{EpochSize: 1000, EpochOffset: tt.offset}
+			if got := c.IsEpochBoundary(tt.block); got != tt.want {
+				t.Errorf("IsEpochBoundary(%d) = %v, want %v", tt.block, got, tt.want)
+			}
+			if got := c.Epoch(tt.block); got != tt.wantEpoch {
+				t.Errorf("Epoch(%d) = %d, want %d", tt.block, got, tt.wantEpoch)
+			}
+		})
+	}
+}
+
+func TestConfig_validateEpochOffset(t *testing.T) {
+	c := &Config{
+		EpochSize:         1000,
+		EpochOffset:       1000,
+		EthereumConfig:    EthereumConfig{URL: "http://127.0.0.1:8545", DepositContractAddr: "0x0"},
+		NuLinkChainConfig: NuLinkChainConfig{URL: "ws://127.0.0.1:9944"},
+	}
+	if err := c.validate(); err == nil {
+		t.Errorf("validate() accepted epochOffset equal to epochSize")
+	}
+	c.EpochOffset = 999
+	if err := c.validate(); err != nil {
+		t.Errorf("validate() error = %v", err)
+	}
+}
+
+func TestConfig_validateEpochSource(t *testing.T) {
+	newConfig := func(contracts ...ContractConfig) *Config {
+		return &Config{
+			EpochSize:         1000,
+			EthereumConfig:    EthereumConfig{URL: "http://127.0.0.1:8545", DepositContractAddr: "0x0", Contracts: contracts},
+			NuLinkChainConfig: NuLinkChainConfig{URL: "ws://127.0.0.1:9944"},
+		}
+	}
+	c := newConfig()
+	if err := c.validate(); err != nil || c.EpochSource != EpochSourceSnapshot {
+		t.Errorf("validate() epochSource = %q, %v, want %q", c.EpochSource, err, EpochSourceSnapshot)
+	}
+	c = newConfig(ContractConfig{Address: "0xbbD3C0C794F40c4f993B03F65343aCC6fcfCb2e2"})
+	if err := c.validate(); err != nil || c.EpochSource != EpochSourceEvents {
+		t.Errorf("validate() epochSource with contracts = %q, %v, want %q", c.EpochSource, err, EpochSourceEvents)
+	}
+	c = newConfig()
+	c.EpochSource = "blocks"
+	if err := c.validate(); err == nil {
+		t.Errorf("validate() accepted epochSource %q", c.EpochSource)
+	}
+}
+
+func TestConfig_validateMaxEpochRetryBackoff(t *testing.T) {
+	newConfig := func(backoff, max time.Duration) *Config {
+		return &Config{
+			EpochSize:            1000,
+			EpochRetryBackoff:    Duration{Duration: backoff},
+			MaxEpochRetryBackoff: Duration{Duration: max},
+			EthereumConfig:       EthereumConfig{URL: "http://127.0.0.1:8545", DepositContractAddr: "0x0"},
+			NuLinkChainConfig:    NuLinkChainConfig{URL: "ws://127.0.0.1:9944"},
+		}
+	}
+	c := newConfig(0, 0)
+	if err := c.validate(); err != nil || c.MaxEpochRetryBackoff.Duration != MaxEpochRetryBackoff {
+		t.Errorf("validate() maxEpochRetryBackoff = %s, %v, want %s", c.MaxEpochRetryBackoff.Duration, err, MaxEpochRetryBackoff)
+	}
+	c = newConfig(time.Minute, time.Second)
+	if err := c.validate(); err == nil {
+		t.Errorf("validate() accepted maxEpochRetryBackoff below epochRetryBackoff")
+	}
+}
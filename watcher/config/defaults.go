@@ -5,15 +5,179 @@ import (
 	"os/user"
 	"path/filepath"
 	"runtime"
+	"time"
 )
 
 const (
 	defaultStakeInfoFile   = "/stake_info.json"
 	defaultLatestBlockFile = "/latest_block"
+	defaultStartBlockFile  = "/start_block.json"
+	defaultCheckpointFile  = "/deposits.json"
 )
 
 const (
 	EpochSize uint64 = 1000
+	// FullResyncEpochs is how often a full set is submitted in diff mode to correct any drift
+	FullResyncEpochs uint64 = 10
+)
+
+const (
+	// PollInterval is the wait before checking again when no new block is available, roughly the ethereum block time
+	PollInterval = 12 * time.Second
+	// RetryInterval is the backoff after a failed attempt to fetch the latest block
+	RetryInterval = 2 * time.Second
+	// EpochRetryBackoff is the first wait before an epoch's failed stake info update is tried again
+	EpochRetryBackoff = 5 * time.Second
+	// MaxEpochRetryBackoff caps the doubling wait between the attempts of an epoch's stake info update
+	MaxEpochRetryBackoff = 5 * time.Minute
+	// MaxClockSkew is how far ahead of the local clock a persisted timestamp may be
+	MaxClockSkew = time.Minute
+	// NotifyTimeout bounds a single webhook notification
+	NotifyTimeout = 5 * time.Second
+	// PruneInterval is how often the history is pruned when a retention is configured
+	PruneInterval = time.Hour
+	// HaltInterval is how often the halt flag of the NuLink chain is polled
+	HaltInterval = 30 * time.Second
+	// VerifyDelay is the wait for a submission to be included before its stake infos are read back
+	VerifyDelay = 12 * time.Second
+	// ReplicaTimeout is how long the primary may be silent before a replica takes over, longer than an epoch
+	// on mainnet
+	ReplicaTimeout = time.Hour
+	// SinkTimeout bounds the publication of an update to an http sink
+	SinkTimeout = 5 * time.Second
+	// SubgraphTimeout bounds a single page query of the subgraph stake source
+	SubgraphTimeout = 10 * time.Second
+)
+
+// SubgraphPageSize is how many stakers a page query of the subgraph stake source returns, the most The
+// Graph allows
+const SubgraphPageSize = 1000
+
+// BalanceCheckEpochs is how often the free balance of the signing account is checked by default
+const BalanceCheckEpochs = 10
+
+// NotifyFailureThreshold is the number of consecutive failed submissions before a notification is sent
+const NotifyFailureThreshold = 3
+
+// RegressionTolerance is how often the latest block may fall below the current block before reconnecting
+const RegressionTolerance = 3
+
+// PayloadVersion is the UpdateStakeInfo payload layout submitted by default
+const PayloadVersion = 1
+
+// MaxEventsPerBlock bounds the deposit events accumulated from a single block
+const MaxEventsPerBlock = 10000
+
+// DepositSpillEntries and DepositSpillBytes are the default thresholds of the accumulated deposits spilled
+// to disk, high enough that they stay in memory on all but the busiest chains
+const (
+	DepositSpillEntries = 1000000
+	DepositSpillBytes   = 512 << 20
+)
+
+// SnapshotLead is how many blocks before an epoch boundary the parallel snapshot of its stake infos starts
+const SnapshotLead = 50
+
+// StakerCacheStaleEpochs is how many epochs a cached StakerInfo is reused by default
+const StakerCacheStaleEpochs = 4
+
+// Default thresholds of the safety guards
+const (
+	// SafetyMinEpochSize is the shortest epoch submitted without tripping a guard
+	SafetyMinEpochSize uint64 = 100
+	// SafetyMaxStartBlockAhead is how far above the safe head the start block may be, about a week of blocks
+	SafetyMaxStartBlockAhead uint64 = 50000
+	// SafetyMinSetSize is the fewest stakers a set may shrink to from a larger one
+	SafetyMinSetSize = 2
+)
+
+// BlockConfirmations is how far behind the latest block the listener stays when not using the finalized tag
+const BlockConfirmations = 10
+
+const (
+	SubmitModeFull = "full"
+	SubmitModeDiff = "diff"
+)
+
+// Policies for a selected set with fewer stakers than substrate.TopN
+const (
+	UndersizedPad   = "pad"
+	UndersizedWarn  = "warn-and-submit"
+	UndersizedAbort = "abort"
+)
+
+// Sources of the stake infos of an epoch boundary update
+const (
+	EpochSourceSnapshot = "snapshot"
+	EpochSourceEvents   = "events"
+	EpochSourceMerged   = "merged"
+)
+
+// Policies for stakers of equal balance tied at the cutoff of the TopN set, some of them in, some out
+const (
+	TopNTiesWorkBase = "workBase"
+	TopNTiesExclude  = "exclude"
+)
+
+// Policies for a deposit or staked value that doesn't fit the U128 balance of a stake info
+const (
+	OverflowClamp = "clamp"
+	OverflowSkip  = "skip"
+)
+
+// Policies for a resume block further than MaxResumeGap behind the latest block
+const (
+	ResumeGapScan = "scan"
+	ResumeGapJump = "jump"
+)
+
+// Policies for a deposit contract reporting no stakers at an epoch boundary
+const (
+	ZeroStakersSubmitEmpty = "submit-empty"
+	ZeroStakersSkip        = "skip"
+	ZeroStakersAbort       = "abort"
+)
+
+// Policies for an epoch boundary whose stake infos couldn't be read in any attempt
+const (
+	SnapshotFailureSkip         = "skip"
+	SnapshotFailureResubmitLast = "resubmit-last"
+	SnapshotFailureAbort        = "abort"
+)
+
+// Meanings of the periods of a deposit event
+const (
+	LockPeriodsIgnore    = "ignore"
+	LockPeriodsCountdown = "countdown"
+	LockPeriodsExpiry    = "expiry"
+)
+
+// Formats of the latest block file
+const (
+	LatestBlockDec = "dec"
+	LatestBlockHex = "hex"
+)
+
+// StakerDecoderRaw is the stakerDecoder reading the staker topic bytes as the address itself
+const StakerDecoderRaw = "raw"
+
+// Sources of the stake infos read at an epoch boundary
+const (
+	StakeSourceRPC      = "rpc"
+	StakeSourceSubgraph = "subgraph"
+)
+
+// Types of the outputs receiving the submitted stake info sets
+const (
+	SinkHTTP = "http"
+	SinkFile = "file"
+)
+
+// Policies for the deposits of a staker into several contracts
+const (
+	AggregateSum      = "sum"
+	AggregateMax      = "max"
+	AggregateSeparate = "separate"
 )
 
 func DefaultStakeInfoFile() string {
@@ -24,6 +188,14 @@ func DefaultLatestBlockFile() string {
 	return DefaultDir() + defaultLatestBlockFile
 }
 
+func DefaultStartBlockFile() string {
+	return DefaultDir() + defaultStartBlockFile
+}
+
+func DefaultCheckpointFile() string {
+	return DefaultDir() + defaultCheckpointFile
+}
+
 func DefaultDir() string {
 	// Try to place the data folder in the user's home dir
 	home := homeDir()
@@ -65,8 +237,8 @@ func isNonEmptyDir(dir string) bool {
 	if err != nil {
 		return false
 	}
+	defer f.Close()
 	names, _ := f.Readdir(1)
-	f.Close()
 	return len(names) > 0
 }
 
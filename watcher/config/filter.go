@@ -0,0 +1,88 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// StakerFilterConfig restricts the tracked stakers. A non-empty allowlist keeps only the listed stakers and
+// the blocklist removes the listed ones, a staker on both lists is removed. Each list combines the inline
+// addresses with the ones of its file, which holds one address per line and # comments.
+type StakerFilterConfig struct {
+	Allowlist     []string `json:"allowlist"`
+	AllowlistFile string   `json:"allowlistFile"`
+	Blocklist     []string `json:"blocklist"`
+	BlocklistFile string   `json:"blocklistFile"`
+}
+
+// StakerFilter decides which stakers are tracked, see StakerFilterConfig. A nil StakerFilter allows all.
+type StakerFilter struct {
+	allow map[common.Address]struct{}
+	block map[common.Address]struct{}
+}
+
+// Load reads the lists and their files into a StakerFilter
+func (c StakerFilterConfig) Load() (*StakerFilter, error) {
+	allow, err := loadAddresses(c.Allowlist, c.AllowlistFile)
+	if err != nil {
+		return nil, fmt.Errorf("allowlist: %w", err)
+	}
+	block, err := loadAddresses(c.Blocklist, c.BlocklistFile)
+	if err != nil {
+		return nil, fmt.Errorf("blocklist: %w", err)
+	}
+	return &StakerFilter{allow: allow, block: block}, nil
+}
+
+// Allowed reports whether staker is tracked
+func (f *StakerFilter) Allowed(staker common.Address) bool {
+	if f == nil {
+		return true
+	}
+	if _, ok := f.block[staker]; ok {
+		return false
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	_, ok := f.allow[staker]
+	return ok
+}
+
+func loadAddresses(list []string, file string) (map[common.Address]struct{}, error) {
+	addrs := make(map[common.Address]struct{}, len(list))
+	for _, a := range list {
+		if !common.IsHexAddress(a) {
+			return nil, fmt.Errorf("invalid address %q", a)
+		}
+		addrs[common.HexToAddress(a)] = struct{}{}
+	}
+	if file == "" {
+		return addrs, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !common.IsHexAddress(line) {
+			return nil, fmt.Errorf("invalid address %q on line %d of %s", line, n, file)
+		}
+		addrs[common.HexToAddress(line)] = struct{}{}
+	}
+	return addrs, s.Err()
+}
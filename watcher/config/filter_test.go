@@ -0,0 +1,60 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestStakerFilter_Allowed(t *testing.T) {
+	a := common.HexToAddress("0x0a")
+	b := common.HexToAddress("0x0b")
+	c := common.HexToAddress("0x0c")
+	file := filepath.Join(t.TempDir(), "blocklist.txt")
+	if err := ioutil.WriteFile(file, []byte("# internal\n"+c.Hex()+"  # treasury\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		cfg     StakerFilterConfig
+		allowed []common.Address
+		blocked []common.Address
+	}{
+		{name: "empty allows all", allowed: []common.Address{a, b, c}},
+		{name: "allowlist", cfg: StakerFilterConfig{Allowlist: []string{a.Hex()}}, allowed: []common.Address{a}, blocked: []common.Address{b, c}},
+		{name: "blocklist file", cfg: StakerFilterConfig{BlocklistFile: file}, allowed: []common.Address{a, b}, blocked: []common.Address{c}},
+		{name: "blocklist wins", cfg: StakerFilterConfig{Allowlist: []string{a.Hex(), c.Hex()}, BlocklistFile: file}, allowed: []common.Address{a}, blocked: []common.Address{b, c}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := tt.cfg.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tt.allowed {
+				if !f.Allowed(s) {
+					t.Errorf("Allowed(%s) = false, want true", s.Hex())
+				}
+			}
+			for _, s := range tt.blocked {
+				if f.Allowed(s) {
+					t.Errorf("Allowed(%s) = true, want false", s.Hex())
+				}
+			}
+		})
+	}
+
+	var nilFilter *StakerFilter
+	if !nilFilter.Allowed(a) {
+		t.Errorf("nil filter blocked %s", a.Hex())
+	}
+	if _, err := (StakerFilterConfig{Allowlist: []string{"0x0a"}}).Load(); err == nil {
+		t.Errorf("Load() accepted an invalid address")
+	}
+	if _, err := (StakerFilterConfig{AllowlistFile: filepath.Join(t.TempDir(), "missing")}).Load(); err == nil {
+		t.Errorf("Load() accepted a missing file")
+	}
+}
@@ -12,11 +12,31 @@ var (
 		Value: log.LvlInfo.String(),
 	}
 
+	QuietFlag = &cli.BoolFlag{
+		Name:  "quiet",
+		Usage: "Only log errors, overrides --verbosity",
+	}
+
+	TraceFlag = &cli.BoolFlag{
+		Name:  "trace",
+		Usage: "Log everything at detail level, overrides --verbosity",
+	}
+
 	ConfigFileFlag = &cli.StringFlag{
 		Name:  "config",
 		Usage: "JSON configuration file",
 	}
 
+	NetworkFlag = &cli.StringFlag{
+		Name:  "network",
+		Usage: "Network preset filling in the endpoints and contracts missing from the config: mainnet, testnet, local or a custom one",
+	}
+
+	NetworksFileFlag = &cli.StringFlag{
+		Name:  "networks-file",
+		Usage: "JSON file of custom network presets by name",
+	}
+
 	//BlockStoreFileFlag = &cli.StringFlag{
 	//	Name:  "blockstore",
 	//	Usage: "Store last block umber file",
@@ -28,8 +48,106 @@ var (
 		Usage: "Store last stake info file",
 		Value: DefaultStakeInfoFile(),
 	}
+	StartBlockFileFlag = &cli.StringFlag{
+		Name:  "startblock-file",
+		Usage: "Store the detected deployment block of the deposit contract",
+		Value: DefaultStartBlockFile(),
+	}
+	CheckpointFileFlag = &cli.StringFlag{
+		Name:  "checkpoint-file",
+		Usage: "Checkpoint the deposits accumulated since the last epoch flush, empty disables it",
+		Value: DefaultCheckpointFile(),
+	}
+	HistoryDirFlag = &cli.StringFlag{
+		Name:  "history-dir",
+		Usage: "Keep the stake infos submitted for every epoch in this directory, empty disables the history",
+	}
+	AuditLogFlag = &cli.StringFlag{
+		Name:  "audit-log",
+		Usage: "Append a json line for every stake info submission to this file, empty disables the audit log",
+	}
+	AttestationLogFlag = &cli.StringFlag{
+		Name:  "attestation-log",
+		Usage: "Append the top n set computed at every epoch boundary, signed with the watcher key, as a json line to this file, empty disables attestations",
+	}
+	DumpScaleFlag = &cli.BoolFlag{
+		Name:  "dump-scale",
+		Usage: "Log the SCALE encoded UpdateStakeInfo payload of every submission instead of sending it",
+	}
+	MetricsFileFlag = &cli.StringFlag{
+		Name:  "metrics-file",
+		Usage: "Periodically write a json metrics snapshot to this file, empty disables it",
+	}
+	StatusAddrFlag = &cli.StringFlag{
+		Name:  "status-addr",
+		Usage: "Serve the json status of the listener at /status on this address, e.g. 127.0.0.1:8090, empty disables it",
+	}
+	NoPersistFlag = &cli.BoolFlag{
+		Name:  "no-persist",
+		Usage: "Keep all state in memory and write no files, overrides the file, log and directory flags",
+	}
+	AcceptChurnFlag = &cli.BoolFlag{
+		Name:  "accept-churn",
+		Usage: "Submit the first stake info set beyond maxChurnPercent after startup, as SIGUSR2 does while running",
+	}
+	CapBalanceFlag = &cli.StringFlag{
+		Name:  "cap-balance",
+		Usage: "Cap the locked balance submitted for every staker at this value in the smallest unit, empty disables the cap",
+	}
+	RecordFlag = &cli.StringFlag{
+		Name:  "record",
+		Usage: "Record every block header, log query and contract call answered by the ethereum node to this directory",
+	}
+	ReplayFlag = &cli.StringFlag{
+		Name:  "replay",
+		Usage: "Replay the ethereum node from a directory written by --record, submitting to a mock instead of the NuLink chain",
+	}
+	MaintenanceFlag = &cli.BoolFlag{
+		Name:  "maintenance",
+		Usage: "Start in maintenance mode: keep scanning but hold submissions back until SIGUSR1 toggles it off",
+	}
 	MockFlag = &cli.BoolFlag{
 		Name:  "mock",
 		Usage: "mock mode startup project",
 	}
+	EpochFlag = &cli.Uint64Flag{
+		Name:     "epoch",
+		Usage:    "epoch to resubmit",
+		Required: true,
+	}
+	BlockFlag = &cli.Uint64Flag{
+		Name:     "block",
+		Usage:    "block to read the stake infos at",
+		Required: true,
+	}
+	DryRunFlag = &cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "print the stake infos instead of submitting them",
+	}
+	PublicKeyFlag = &cli.StringFlag{
+		Name:  "public-key",
+		Usage: "hex sr25519 public key the attestations must be signed with, empty accepts any signer",
+	}
+	FileFlag = &cli.StringFlag{
+		Name:     "file",
+		Usage:    "stake info file to verify",
+		Required: true,
+	}
+	MaxDivergenceFlag = &cli.Float64Flag{
+		Name:  "max-divergence",
+		Usage: "percentage of the stakers or of the locked balance the file may diverge from the chain by",
+	}
+	JSONFlag = &cli.BoolFlag{
+		Name:  "json",
+		Usage: "print the output as json",
+	}
+	FormatFlag = &cli.StringFlag{
+		Name:  "format",
+		Usage: "format of the sample config: json with comments or plain json",
+		Value: SampleJSON,
+	}
+	ForceFlag = &cli.BoolFlag{
+		Name:  "force",
+		Usage: "overwrite an existing file",
+	}
 )
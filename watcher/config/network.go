@@ -0,0 +1,106 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// Network is a named preset of the chain endpoints and contracts. Its values only fill in the fields left
+// empty by the configuration file, fields the preset leaves empty still have to be configured.
+type Network struct {
+	EthereumURL         string   `json:"ethereumUrl"`
+	Http                bool     `json:"http"`
+	DepositContractAddr string   `json:"depositContractAddr"`
+	StartBlock          *big.Int `json:"startBlock"`
+	ChainID             *big.Int `json:"chainId"`
+	NuLinkURL           string   `json:"nuLinkUrl"`
+}
+
+const (
+	NetworkMainnet = "mainnet"
+	NetworkTestnet = "testnet"
+	NetworkLocal   = "local"
+)
+
+// networks are the built-in presets, the mainnet ethereum endpoint needs a provider key and is not preset
+var networks = map[string]Network{
+	NetworkMainnet: {
+		DepositContractAddr: "0xbbD3C0C794F40c4f993B03F65343aCC6fcfCb2e2",
+		ChainID:             big.NewInt(1),
+	},
+	NetworkTestnet: {
+		ChainID: big.NewInt(5),
+	},
+	NetworkLocal: {
+		EthereumURL: "http://127.0.0.1:8545",
+		Http:        true,
+		ChainID:     big.NewInt(1337),
+		NuLinkURL:   "ws://127.0.0.1:9944",
+	},
+}
+
+// Networks returns the built-in presets merged with the ones of the json file at path, which maps names to
+// presets and replaces built-in presets of the same name. An empty path only returns the built-in presets.
+func Networks(path string) (map[string]Network, error) {
+	all := make(map[string]Network, len(networks))
+	for name, n := range networks {
+		all[name] = n
+	}
+	if path == "" {
+		return all, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read networks file: %w", err)
+	}
+	var custom map[string]Network
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return nil, fmt.Errorf("failed to decode networks file %s: %w", path, err)
+	}
+	for name, n := range custom {
+		all[name] = n
+	}
+	return all, nil
+}
+
+// LookupNetwork returns the preset called name among the built-in ones and the ones of the file at path
+func LookupNetwork(name, path string) (Network, error) {
+	all, err := Networks(path)
+	if err != nil {
+		return Network{}, err
+	}
+	n, ok := all[name]
+	if !ok {
+		names := make([]string, 0, len(all))
+		for name := range all {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return Network{}, fmt.Errorf("unknown network %q, expected one of %s", name, strings.Join(names, ", "))
+	}
+	return n, nil
+}
+
+// apply fills the fields of c left empty with the values of the preset
+func (n Network) apply(c *Config) {
+	if IsEmpty(c.EthereumConfig.URL) {
+		c.EthereumConfig.URL = n.EthereumURL
+		c.EthereumConfig.Http = n.Http
+	}
+	if IsEmpty(c.EthereumConfig.DepositContractAddr) {
+		c.EthereumConfig.DepositContractAddr = n.DepositContractAddr
+	}
+	if c.EthereumConfig.StartBlock == nil && n.StartBlock != nil {
+		c.EthereumConfig.StartBlock = new(big.Int).Set(n.StartBlock)
+	}
+	if c.EthereumConfig.ChainID == nil && n.ChainID != nil {
+		c.EthereumConfig.ChainID = new(big.Int).Set(n.ChainID)
+	}
+	if IsEmpty(c.NuLinkChainConfig.URL) {
+		c.NuLinkChainConfig.URL = n.NuLinkURL
+	}
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLookupNetwork(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "networks.json")
+	custom := `{
+		"staging": {"ethereumUrl": "ws://eth.staging:8546", "depositContractAddr": "0x01", "startBlock": 100, "chainId": 5, "nuLinkUrl": "ws://nulink.staging:9944"},
+		"local": {"ethereumUrl": "http://10.0.0.1:8545", "http": true, "chainId": 31337}
+	}`
+	if err := ioutil.WriteFile(path, []byte(custom), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LookupNetwork("staging", ""); err == nil || !strings.Contains(err.Error(), `unknown network "staging"`) {
+		t.Errorf("LookupNetwork(staging) without the file error = %v", err)
+	}
+	n, err := LookupNetwork(NetworkMainnet, path)
+	if err != nil || n.ChainID.Int64() != 1 {
+		t.Errorf("LookupNetwork(mainnet) = %+v, %v", n, err)
+	}
+	n, err = LookupNetwork("staging", path)
+	if err != nil || n.StartBlock.Int64() != 100 || n.NuLinkURL != "ws://nulink.staging:9944" {
+		t.Errorf("LookupNetwork(staging) = %+v, %v", n, err)
+	}
+	n, err = LookupNetwork(NetworkLocal, path)
+	if err != nil || n.ChainID.Int64() != 31337 || n.NuLinkURL != "" {
+		t.Errorf("LookupNetwork(local) = %+v, %v, want the custom preset", n, err)
+	}
+}
+
+func TestNetwork_apply(t *testing.T) {
+	n := Network{
+		EthereumURL:         "http://127.0.0.1:8545",
+		Http:                true,
+		DepositContractAddr: "0x01",
+		StartBlock:          big.NewInt(100),
+		ChainID:             big.NewInt(1337),
+		NuLinkURL:           "ws://127.0.0.1:9944",
+	}
+
+	var c Config
+	n.apply(&c)
+	if err := c.validate(); err != nil {
+		t.Fatalf("validate() of the preset error = %v", err)
+	}
+	if !c.EthereumConfig.Http || c.EthereumConfig.StartBlock.Int64() != 100 || c.EthereumConfig.ChainID.Int64() != 1337 {
+		t.Errorf("apply() = %+v", c.EthereumConfig)
+	}
+
+	c = Config{
+		EthereumConfig:    EthereumConfig{URL: "ws://127.0.0.1:8546", DepositContractAddr: "0x02", StartBlock: big.NewInt(5)},
+		NuLinkChainConfig: NuLinkChainConfig{URL: "ws://10.0.0.1:9944"},
+	}
+	n.apply(&c)
+	if c.EthereumConfig.URL != "ws://127.0.0.1:8546" || c.EthereumConfig.Http || c.EthereumConfig.DepositContractAddr != "0x02" ||
+		c.EthereumConfig.StartBlock.Int64() != 5 || c.NuLinkChainConfig.URL != "ws://10.0.0.1:9944" {
+		t.Errorf("apply() overrode the configured values: %+v, %+v", c.EthereumConfig, c.NuLinkChainConfig)
+	}
+	if c.EthereumConfig.ChainID.Int64() != 1337 {
+		t.Errorf("apply() chainId = %v, want 1337", c.EthereumConfig.ChainID)
+	}
+}
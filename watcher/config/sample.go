@@ -0,0 +1,334 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"text/template"
+)
+
+// Formats of the sample config written by init-config
+const (
+	SampleJSON  = "json"
+	SamplePlain = "plain"
+)
+
+// Placeholders of the required fields in the sample config, a config still holding one is rejected
+const (
+	placeholderEthereumURL = "<ethereum rpc url>"
+	placeholderContract    = "<deposit contract address>"
+	placeholderNuLinkURL   = "<nulink rpc url>"
+)
+
+// isPlaceholder reports whether s is a placeholder of the sample config that was never filled in
+func isPlaceholder(s string) bool {
+	s = strings.TrimSpace(s)
+	return strings.HasPrefix(s, "<") && strings.HasSuffix(s, ">")
+}
+
+var sampleTemplate = template.Must(template.New("sample").Funcs(template.FuncMap{"json": sampleValue}).Parse(`{
+  // stake info sync frequency, 100 means sync every 100 blocks
+  "epochSize": {{json .EpochSize}},
+  // epochs start at epochOffset + n * epochSize, must be less than epochSize
+  "epochOffset": {{json .EpochOffset}},
+  // submit the set computed at the boundary of epoch n at the boundary of epoch n + epochSubmissionOffset,
+  // for pallets expecting the update of an epoch during a later one; 0 submits it right away
+  "epochSubmissionOffset": {{json .EpochSubmissionOffset}},
+  // submit once every submitEveryNEpochs epochs the final state of the epochs since the last submission,
+  // the audit log lists the epochs a submission covers; 0 or 1 submits every epoch
+  "submitEveryNEpochs": {{json .SubmitEveryNEpochs}},
+  // hold the update of an epoch until the node reports its boundary block finalized, false submits it as
+  // soon as the boundary is processed
+  "submitOnFinalizedEpoch": {{json .SubmitOnFinalizedEpoch}},
+  // "full" submits the whole top n every epoch, "diff" submits only the stakers that joined, left or changed
+  // balance since the last submission
+  "submitMode": {{json .SubmitMode}},
+  // where the stake infos of an epoch boundary update come from: "snapshot" reads the deposit contract at
+  // the boundary, "events" takes the deposit events of the contracts below polled block by block during
+  // the epoch, "merged" adds the stakers only the events hold to the snapshot. Defaults to "events" when
+  // contracts are configured, "snapshot" otherwise
+  "epochSource": {{json .EpochSource}},
+  // in diff mode, submit the full set every fullResyncEpochs epochs to correct any drift
+  "fullResyncEpochs": {{json .FullResyncEpochs}},
+  // in diff mode, submit an empty update after this many epochs in a row without a change, 0 disables it
+  "heartbeatEpochs": {{json .HeartbeatEpochs}},
+  // when the watcher falls behind by more than a block, sync at every epoch boundary it skipped, in order,
+  // instead of only checking the newest block
+  "catchUpEpochs": {{json .CatchUpEpochs}},
+  // at startup, submit the set of every epoch boundary missed while the watcher was down, read at that block,
+  // within maxResumeGap blocks of the safe head when it is set
+  "catchUpMissedEpochs": {{json .CatchUpMissedEpochs}},
+  // while catching up with catchUpEpochs or catchUpMissedEpochs, suppress the updates of the boundaries
+  // behind the safe head and submit the current set once caught up
+  "suppressSubmitUntilCaughtUp": {{json .SuppressSubmitUntilCaughtUp}},
+  // when resuming more than maxResumeGap blocks behind the latest block, scan the gap or jump to the safe
+  // head, 0 disables the check
+  "maxResumeGap": {{json .MaxResumeGap}},
+  "resumeGapPolicy": {{json .ResumeGapPolicy}},
+  // wait between polls when no new ethereum block is available
+  "pollInterval": {{json .PollInterval}},
+  // backoff after a failed attempt to fetch the latest ethereum block
+  "retryInterval": {{json .RetryInterval}},
+  // try a failed stake info update of an epoch again this many times, waiting a backoff doubling from
+  // epochRetryBackoff up to maxEpochRetryBackoff; the state only advances once the whole update succeeded
+  "epochRetries": {{json .EpochRetries}},
+  "epochRetryBackoff": {{json .EpochRetryBackoff}},
+  "maxEpochRetryBackoff": {{json .MaxEpochRetryBackoff}},
+  // how often the node may report a latest block below the one already processed before the watcher
+  // reconnects
+  "regressionTolerance": {{json .RegressionTolerance}},
+  // log staker import progress every n stakers at debug verbosity, 0 only logs a summary
+  "stakerLogInterval": {{json .StakerLogInterval}},
+  // retry a failed read of a staker from the deposit contract this many times, with a growing backoff
+  "stakerFetchRetries": {{json .StakerFetchRetries}},
+  // abort an epoch's stake info update when more stakers than this couldn't be read, null never aborts
+  "maxStakerFetchFailures": {{json .MaxStakerFetchFailures}},
+  // gzip the persisted stake info file, compressed files are read back transparently
+  "compressState": {{json .CompressState}},
+  // don't reuse the coinbase assignments of a stake info file older than this, "0s" disables the check
+  "maxStateAge": {{json .MaxStateAge}},
+  // how far ahead of the local clock a persisted timestamp may be
+  "maxClockSkew": {{json .MaxClockSkew}},
+  // abandon an epoch's update that isn't submitted within this time after its boundary, "0s" disables it
+  "submissionDeadline": {{json .SubmissionDeadline}},
+  // stakers locking less than this are never selected
+  "minLockedBalance": {{json .MinLockedBalance}},
+  // stakers whose work count stored by the NuProxy pallet is below this are never selected, 0 disables it
+  "minWorkCount": {{json .MinWorkCount}},
+  // check that the selected top stakers are sorted and unique before every submission
+  "verifyTopN": {{json .VerifyTopN}},
+  // read the stake infos back from the NuProxy pallet verifyDelay after every submission and compare them
+  "verifySubmission": {{json .VerifySubmission}},
+  "verifyDelay": {{json .VerifyDelay}},
+  // what to do when too few stakers are found: "warn-and-submit", "abort" or "pad"
+  "undersizedPolicy": {{json .UndersizedPolicy}},
+  // stakers tied at the TopN cutoff: rank them by "workBase" or "exclude" them all
+  "topNTies": {{json .TopNTies}},
+  // what to do when the deposit contract reports no stakers: "submit-empty", "skip" the epoch or "abort"
+  "zeroStakersPolicy": {{json .ZeroStakersPolicy}},
+  // what to do when the stake infos of an epoch boundary can't be read: "skip" the epoch, "resubmit-last"
+  // submits the last persisted set again or "abort"
+  "onSnapshotFailure": {{json .OnSnapshotFailure}},
+  // what to do with a value above the U128 range of a balance: "clamp" it to the largest U128 or "skip" it
+  "overflowPolicy": {{json .OverflowPolicy}},
+  // keep a staker that dropped out of the top n for up to this many epochs before it is reported stopped
+  "stoppedGraceEpochs": {{json .StoppedGraceEpochs}},
+  // check a staker missing from the top n again this many blocks below the synced block, 0 disables it
+  "stoppedConfirmations": {{json .StoppedConfirmations}},
+  // in diff mode, submit at most this many stopped stakers per epoch and defer the rest, 0 submits all
+  "maxStoppedPerEpoch": {{json .MaxStoppedPerEpoch}},
+  // the periods of a deposit event are the epochs its lock lasts, "countdown", the epoch it expires in,
+  // "expiry", or are ignored, "ignore"; stakers whose lock is over are reported stopped
+  "lockPeriods": {{json .LockPeriods}},
+  // hold back a set replacing more than this percentage of the last stakers or changing their total locked
+  // balance by more, until confirmed with SIGUSR2 or stable for churnStableEpochs epochs, 0 disables it
+  "maxChurnPercent": {{json .MaxChurnPercent}},
+  "churnStableEpochs": {{json .ChurnStableEpochs}},
+  // read the stake infos of the next epoch boundary in the background from snapshotLead blocks before it
+  "parallelSnapshot": {{json .ParallelSnapshot}},
+  "snapshotLead": {{json .SnapshotLead}},
+  // deposit events accumulated from a single block at most, haltOnEventLimit stops the watcher beyond it
+  "maxEventsPerBlock": {{json .MaxEventsPerBlock}},
+  "haltOnEventLimit": {{json .HaltOnEventLimit}},
+  // layout of the UpdateStakeInfo payload matching the NuProxy pallet version, 1 or 2
+  "payloadVersion": {{json .PayloadVersion}},
+  // format of the latest block file, "dec" or "hex"
+  "latestBlockFormat": {{json .LatestBlockFormat}},
+  // prune the per epoch files of the --history-dir, 0 disables a limit
+  "retention": {
+    "maxFiles": {{json .Retention.MaxFiles}},
+    "maxAge": {{json .Retention.MaxAge}},
+    "interval": {{json .Retention.Interval}}
+  },
+  // cache the StakerInfo of up to size stakers for staleEpochs epochs, size 0 disables the cache
+  "stakerCache": {
+    "size": {{json .StakerCache.Size}},
+    "staleEpochs": {{json .StakerCache.StaleEpochs}}
+  },
+  // read the stake infos in the background at startup for the first epoch flush, one contract call every
+  // callInterval at most
+  "warmPreload": {
+    "enabled": {{json .WarmPreload.Enabled}},
+    "callInterval": {{json .WarmPreload.CallInterval}}
+  },
+  // hold every submission back with an alert when an epoch is shorter than minEpochSize, the start block
+  // is more than maxStartBlockAhead blocks ahead or a set shrinks below minSetSize stakers
+  "safetyGuards": {
+    "disabled": {{json .SafetyGuards.Disabled}},
+    "minEpochSize": {{json .SafetyGuards.MinEpochSize}},
+    "maxStartBlockAhead": {{json .SafetyGuards.MaxStartBlockAhead}},
+    "minSetSize": {{json .SafetyGuards.MinSetSize}}
+  },
+  // read the stake infos from the deposit contract ("rpc") or a subgraph at url ("subgraph"), falling back
+  // to the contract when the subgraph fails; an empty query uses the default one
+  "stakeSource": {
+    "type": {{json .StakeSource.Type}},
+    "url": {{json .StakeSource.URL}},
+    "query": {{json .StakeSource.Query}},
+    "pageSize": {{json .StakeSource.PageSize}},
+    "timeout": {{json .StakeSource.Timeout}}
+  },
+  // restrict the tracked stakers, the files hold one address per line
+  "stakerFilter": {
+    "allowlist": [],
+    "allowlistFile": {{json .StakerFilter.AllowlistFile}},
+    "blocklist": [],
+    "blocklistFile": {{json .StakerFilter.BlocklistFile}}
+  },
+  // spill the deposits accumulated between epoch flushes to disk beyond these thresholds
+  "depositSpill": {
+    "maxEntries": {{json .DepositSpill.MaxEntries}},
+    "maxBytes": {{json .DepositSpill.MaxBytes}},
+    "dir": {{json .DepositSpill.Dir}}
+  },
+  // further outputs of the submitted sets, e.g.
+  // {"type": "http", "url": "http://127.0.0.1:8082/topics/stake-infos", "headers": {}, "timeout": "5s"} or
+  // {"type": "file", "path": "./stake-infos.jsonl"}
+  "sinks": [],
+  // write the deposit events to a csv file for reporting, rotated beyond maxBytes and daily; empty path disables it
+  "eventExport": {
+    "path": {{json .EventExport.Path}},
+    "maxBytes": {{json .EventExport.MaxBytes}},
+    "daily": {{json .EventExport.Daily}}
+  },
+  // post to a webhook once failureThreshold consecutive submissions failed, an empty url disables it
+  "notify": {
+    "url": {{json .Notify.URL}},
+    "template": {{json .Notify.Template}},
+    "failureThreshold": {{json .Notify.FailureThreshold}},
+    "timeout": {{json .Notify.Timeout}}
+  },
+  // stand by for a primary watcher and only submit once it was silent for timeout, its submissions are read
+  // from primaryAuditLog or, left empty, from the pallet storage
+  "replica": {
+    "enabled": {{json .Replica.Enabled}},
+    "timeout": {{json .Replica.Timeout}},
+    "primaryAuditLog": {{json .Replica.PrimaryAuditLog}}
+  },
+  "ethereumConfig": {
+    // the url of the ethereum RPC node
+    "url": {{json .EthereumConfig.URL}},
+    // connect to the url over http instead of a websocket
+    "http": {{json .EthereumConfig.Http}},
+    // the address of the deposit contract
+    "depositContractAddr": {{json .EthereumConfig.DepositContractAddr}},
+    // how many blocks behind the latest block the watcher stays
+    "blockConfirmations": {{json .EthereumConfig.BlockConfirmations}},
+    // use the node's "finalized" block as the safe head instead of blockConfirmations
+    "useFinalizedTag": {{json .EthereumConfig.UseFinalizedTag}},
+    // which bytes of which deposit event topic hold the staker address
+    "stakerTopic": {{json .EthereumConfig.StakerTopic}},
+    // how the staker is derived from the bytes of stakerTopic, "raw" reads them as the address itself
+    "stakerDecoder": {{json .EthereumConfig.StakerDecoder}},
+    // the first block to process, null starts from block 1
+    "startBlock": {{json .EthereumConfig.StartBlock}},
+    // the chain id the ethereum node must report, null skips the check
+    "chainId": {{json .EthereumConfig.ChainID}},
+    // search for the deployment block of the deposit contract when startBlock is unset, needs an archive node
+    "detectStartBlock": {{json .EthereumConfig.DetectStartBlock}},
+    // use the operator bonded in stakerInfo as workBase and the staker as coinbase
+    "separateOperator": {{json .EthereumConfig.SeparateOperator}},
+    // read deposit events from several contracts instead of depositContractAddr alone, e.g.
+    // {"address": "0x...", "startBlock": null, "confirmations": 0, "eventSig": "Deposited(address,uint256)"}
+    "contracts": [],
+    // how the deposits of a staker into several contracts count: "sum", "max" or "separate"
+    "crossContractAggregation": {{json .EthereumConfig.CrossContractAggregation}},
+    // the contract to follow once the deposit contract loses its code or emits migrationEventSig, e.g.
+    // "Migrated(address)"; without one submissions are paused
+    "migrateTo": {{json .EthereumConfig.MigrateTo}},
+    "migrationEventSig": {{json .EthereumConfig.MigrationEventSig}},
+    // the most blocks a single log query covers, longer ranges are split; 0 leaves it to the provider
+    "maxLogQuerySpan": {{json .EthereumConfig.MaxLogQuerySpan}},
+    // check the processed blocks for a reorg every poll: one up to reorgTolerance blocks deep, null for
+    // blockConfirmations, is scanned again from the fork point, a deeper one resyncs from a full snapshot
+    "detectReorgs": {{json .EthereumConfig.DetectReorgs}},
+    "reorgTolerance": {{json .EthereumConfig.ReorgTolerance}},
+    // ping an idle websocket connection this often between polls and reconnect when it fails, for proxies
+    // dropping idle connections; 0 disables it
+    "keepAlive": {{json .EthereumConfig.KeepAlive}}
+  },
+  "nuLinkChainConfig": {
+    // the url of the NuLink RPC node
+    "url": {{json .NuLinkChainConfig.URL}},
+    // how often a submission failing during a runtime upgrade is retried
+    "upgradeRetries": {{json .NuLinkChainConfig.UpgradeRetries}},
+    // dry run every extrinsic first to report the pallet and error a rejected call fails with
+    "dryRun": {{json .NuLinkChainConfig.DryRun}},
+    // tip paid on every stake info update for a faster inclusion during congestion, null tips nothing
+    "tip": {{json .NuLinkChainConfig.Tip}},
+    // a storage flag the NuLink chain signals a halt with, as a raw hex key or a pallet and item
+    "halt": {
+      "key": {{json .NuLinkChainConfig.Halt.Key}},
+      "pallet": {{json .NuLinkChainConfig.Halt.Pallet}},
+      "item": {{json .NuLinkChainConfig.Halt.Item}},
+      "interval": {{json .NuLinkChainConfig.Halt.Interval}}
+    },
+    // storage item of the NuProxy pallet read back by verifySubmission, empty reads StakerInfos
+    "stakeInfoItem": {{json .NuLinkChainConfig.StakeInfoItem}},
+    // alert when the free balance of the signing account falls below this, checked at startup and every
+    // balanceCheckEpochs epochs, null disables the check
+    "minOperatorBalance": {{json .NuLinkChainConfig.MinOperatorBalance}},
+    "balanceCheckEpochs": {{json .NuLinkChainConfig.BalanceCheckEpochs}},
+    // the secret seed or mnemonic of the signing key, empty signs with the built-in watcher key
+    "seed": {{json .NuLinkChainConfig.Seed}},
+    // rotate to this key, {"seed": ..., "activationBlock": ...} or with an "activationEpoch", signing the
+    // submissions from its activation on with it; null keeps the one key
+    "nextKey": {{json .NuLinkChainConfig.NextKey}}
+  }
+}
+`))
+
+// sampleValue writes a value of the sample config as json
+func sampleValue(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// Sample returns a sample config holding the defaults of every field and placeholders for the endpoints and
+// the deposit contract. The json format documents every field with // comments, as read by the watcher,
+// the plain format leaves them out for tools reading strict json.
+func Sample(format string) ([]byte, error) {
+	if format != SampleJSON && format != SamplePlain {
+		return nil, fmt.Errorf("unknown sample format %q, expected %s or %s", format, SampleJSON, SamplePlain)
+	}
+	c := Config{
+		EthereumConfig:    EthereumConfig{URL: "http://127.0.0.1:8545", DepositContractAddr: "0x0"},
+		NuLinkChainConfig: NuLinkChainConfig{URL: "ws://127.0.0.1:9944", UpgradeRetries: 1},
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	// defaults validate only applies once their feature is enabled
+	c.VerifyDelay.Duration = VerifyDelay
+	c.SnapshotLead = SnapshotLead
+	c.NuLinkChainConfig.Halt.Interval.Duration = HaltInterval
+	c.NuLinkChainConfig.BalanceCheckEpochs = BalanceCheckEpochs
+	c.Replica.Timeout.Duration = ReplicaTimeout
+	c.MinLockedBalance = new(big.Int)
+	c.EthereumConfig.URL = placeholderEthereumURL
+	c.EthereumConfig.DepositContractAddr = placeholderContract
+	c.NuLinkChainConfig.URL = placeholderNuLinkURL
+
+	var buf bytes.Buffer
+	if err := sampleTemplate.Execute(&buf, &c); err != nil {
+		return nil, err
+	}
+	if format == SamplePlain {
+		return stripComments(buf.Bytes()), nil
+	}
+	return buf.Bytes(), nil
+}
+
+// stripComments removes the lines of data starting with //, the comments of a config file
+func stripComments(data []byte) []byte {
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	out := make([]byte, 0, len(data))
+	for _, line := range lines {
+		if !bytes.HasPrefix(bytes.TrimSpace(line), []byte("//")) {
+			out = append(out, line...)
+		}
+	}
+	return out
+}
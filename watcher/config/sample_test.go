@@ -0,0 +1,73 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSample(t *testing.T) {
+	for _, format := range []string{SampleJSON, SamplePlain} {
+		t.Run(format, func(t *testing.T) {
+			sample, err := Sample(format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Contains(string(sample), "//"); got != (format == SampleJSON) {
+				t.Errorf("sample holds comments = %v", got)
+			}
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := ioutil.WriteFile(path, sample, 0600); err != nil {
+				t.Fatal(err)
+			}
+			var c Config
+			if err := loadConfig(path, &c); err != nil {
+				t.Fatalf("loadConfig() of the sample: %v", err)
+			}
+			if err := c.validate(); err == nil {
+				t.Fatal("validate() accepted the placeholders of the sample")
+			}
+
+			c.EthereumConfig.URL = "http://127.0.0.1:8545"
+			c.EthereumConfig.DepositContractAddr = "0xbbD3C0C794F40c4f993B03F65343aCC6fcfCb2e2"
+			c.NuLinkChainConfig.URL = "ws://127.0.0.1:9944"
+			if err := c.validate(); err != nil {
+				t.Fatalf("validate() of the filled in sample: %v", err)
+			}
+			if c.EpochSize != EpochSize || c.PollInterval.Duration != PollInterval || c.EthereumConfig.BlockConfirmations.Int64() != BlockConfirmations {
+				t.Errorf("sample defaults = %d, %s, %s", c.EpochSize, c.PollInterval, c.EthereumConfig.BlockConfirmations)
+			}
+		})
+	}
+	if _, err := Sample("yaml"); err == nil {
+		t.Error("Sample() accepted an unknown format")
+	}
+}
+
+// every field of the config is documented in the sample
+func TestSampleFields(t *testing.T) {
+	sample, err := Sample(SampleJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var check func(typ reflect.Type)
+	check = func(typ reflect.Type) {
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			name := strings.Split(f.Tag.Get("json"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			if !strings.Contains(string(sample), `"`+name+`":`) {
+				t.Errorf("field %s.%s is missing from the sample", typ.Name(), name)
+			}
+			// only descend into the structs the sample writes field by field
+			if f.Type.Kind() == reflect.Struct && f.Type != reflect.TypeOf(Duration{}) {
+				check(f.Type)
+			}
+		}
+	}
+	check(reflect.TypeOf(Config{}))
+}
@@ -0,0 +1,171 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	KindFailure  = "failure"
+	KindRecovery = "recovery"
+	// KindContract reports a deposit contract that was migrated or lost
+	KindContract = "contract"
+	// KindBalance reports a signing account running low on funds
+	KindBalance = "balance"
+	// KindChurn reports a stake info set held back for changing beyond maxChurnPercent
+	KindChurn = "churn"
+	// KindReplica reports a replica taking over from the primary or stepping down
+	KindReplica = "replica"
+	// KindSafety reports a safety guard tripped by a config that looks unsafe, submissions are held back
+	KindSafety = "safety"
+	// KindEpoch reports an epoch whose stake info update failed after all its retries
+	KindEpoch = "epoch"
+	// KindKey reports a next signing key that isn't a registered watcher, the signing key isn't rotated to it
+	KindKey = "key"
+)
+
+// DefaultTemplate renders a Slack compatible webhook payload
+const DefaultTemplate = `{"text": {{printf "%q" .Message}}}`
+
+// Event describes a change in the submission health of the watcher
+type Event struct {
+	Kind     string
+	Failures int
+	Error    string
+	Message  string
+	Time     time.Time
+}
+
+// Notifier delivers an Event to an external system
+type Notifier interface {
+	Notify(ctx context.Context, e Event) error
+}
+
+// Webhook posts every Event rendered with Template to URL
+type Webhook struct {
+	URL      string
+	Template *template.Template
+	Client   *http.Client
+}
+
+// NewWebhook parses tmpl, an empty tmpl uses DefaultTemplate
+func NewWebhook(url, tmpl string) (*Webhook, error) {
+	if tmpl == "" {
+		tmpl = DefaultTemplate
+	}
+	t, err := template.New("webhook").Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook template: %w", err)
+	}
+	return &Webhook{URL: url, Template: t, Client: http.DefaultClient}, nil
+}
+
+func (w *Webhook) Notify(ctx context.Context, e Event) error {
+	var body bytes.Buffer
+	if err := w.Template.Execute(&body, e); err != nil {
+		return fmt.Errorf("failed to render webhook template: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Alerter notifies once a number of consecutive submissions failed and again when they succeed. Events
+// are sent in the background with a timeout, so recording a result never blocks the caller.
+type Alerter struct {
+	Now func() time.Time // time source of the events, time.Now if nil
+
+	notifier  Notifier
+	threshold int
+	timeout   time.Duration
+
+	mu       sync.Mutex
+	failures int
+	alerted  bool
+}
+
+func NewAlerter(n Notifier, threshold int, timeout time.Duration) *Alerter {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &Alerter{notifier: n, threshold: threshold, timeout: timeout}
+}
+
+// Record records the result of a submission. A nil Alerter ignores it.
+func (a *Alerter) Record(err error) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err != nil {
+		a.failures++
+		if a.failures < a.threshold || a.alerted {
+			return
+		}
+		a.alerted = true
+		go a.send(Event{
+			Kind:     KindFailure,
+			Failures: a.failures,
+			Error:    err.Error(),
+			Message:  fmt.Sprintf("nulink watcher: %d consecutive stake info submissions failed, last error: %v", a.failures, err),
+			Time:     a.now(),
+		})
+		return
+	}
+
+	if a.alerted {
+		go a.send(Event{
+			Kind:     KindRecovery,
+			Failures: a.failures,
+			Message:  fmt.Sprintf("nulink watcher: stake info submission recovered after %d failures", a.failures),
+			Time:     a.now(),
+		})
+	}
+	a.failures = 0
+	a.alerted = false
+}
+
+// Alert sends an Event of kind with message right away, regardless of the recorded submissions. A nil
+// Alerter ignores it.
+func (a *Alerter) Alert(kind, message string) {
+	if a == nil {
+		return
+	}
+	go a.send(Event{Kind: kind, Message: message, Time: a.now()})
+}
+
+// now returns the current UTC time of the Alerter
+func (a *Alerter) now() time.Time {
+	if a.Now != nil {
+		return a.Now().UTC()
+	}
+	return time.Now().UTC()
+}
+
+func (a *Alerter) send(e Event) {
+	ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+	defer cancel()
+	if err := a.notifier.Notify(ctx, e); err != nil {
+		log.Warn("failed to send notification", "kind", e.Kind, "error", err)
+	}
+}
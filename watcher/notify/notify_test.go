@@ -0,0 +1,144 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAlerter(t *testing.T) {
+	bodies := make(chan map[string]string, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		var body map[string]string
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Errorf("webhook body %s is not json: %v", data, err)
+		}
+		bodies <- body
+	}))
+	defer srv.Close()
+
+	webhook, err := NewWebhook(srv.URL, `{"kind": "{{.Kind}}", "text": {{printf "%q" .Message}}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewAlerter(webhook, 2, time.Second)
+
+	expect := func(kind string) {
+		t.Helper()
+		select {
+		case body := <-bodies:
+			if body["kind"] != kind {
+				t.Errorf("notification kind = %q, want %q", body["kind"], kind)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no %s notification", kind)
+		}
+	}
+	expectNone := func() {
+		t.Helper()
+		select {
+		case body := <-bodies:
+			t.Fatalf("unexpected notification %v", body)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	failed := errors.New("submit failed")
+	a.Record(failed)
+	expectNone()
+	a.Record(failed)
+	expect(KindFailure)
+	a.Record(failed)
+	expectNone()
+	a.Record(nil)
+	expect(KindRecovery)
+	a.Record(nil)
+	expectNone()
+
+	// alerts are sent right away and don't count as failed submissions
+	a.Alert(KindContract, "deposit contract lost")
+	expect(KindContract)
+	a.Record(failed)
+	expectNone()
+}
+
+func TestAlerter_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	webhook, err := NewWebhook(srv.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := NewAlerter(webhook, 1, 10*time.Millisecond)
+
+	start := time.Now()
+	a.Record(errors.New("submit failed"))
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("Record() blocked for %v", d)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- webhook.Notify(ctx, Event{Kind: KindFailure})
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("Notify() of a hanging webhook returned no error")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Notify() did not time out")
+	}
+}
+
+func TestNewWebhook_InvalidTemplate(t *testing.T) {
+	if _, err := NewWebhook("http://127.0.0.1", "{{"); err == nil {
+		t.Errorf("NewWebhook() with an invalid template returned no error")
+	}
+}
+
+func TestAlerter_Nil(t *testing.T) {
+	var a *Alerter
+	a.Record(errors.New("submit failed"))
+}
+
+// chanNotifier sends every event it is notified of on the channel
+type chanNotifier chan Event
+
+func (c chanNotifier) Notify(ctx context.Context, e Event) error {
+	c <- e
+	return nil
+}
+
+func TestAlerter_Now(t *testing.T) {
+	events := make(chanNotifier, 2)
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.FixedZone("UTC+1", 3600))
+	a := NewAlerter(events, 1, time.Second)
+	a.Now = func() time.Time { return now }
+
+	a.Record(errors.New("submit failed"))
+	a.Alert(KindContract, "deposit contract lost")
+	for i := 0; i < 2; i++ {
+		select {
+		case e := <-events:
+			if !e.Time.Equal(now) || e.Time.Location() != time.UTC {
+				t.Errorf("%s event at %s, want the injected %s in UTC", e.Kind, e.Time, now)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no notification")
+		}
+	}
+}
@@ -2,7 +2,6 @@ package params
 
 import (
 	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
-	"time"
 
 	"github.com/centrifuge/go-substrate-rpc-client/v4/signature"
 )
@@ -11,8 +10,6 @@ var (
 	BlockRetryLimit = 5
 )
 
-var BlockRetryInterval = time.Second * 2
-
 var (
 	Watcher = &signature.KeyringPair{
 		URI:       "0xe1d5a01954b8320d8c5ceb88199487b5a3821bbc4b520286360a71a946f22c33",
@@ -0,0 +1,150 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/NuLink-network/watcher/watcher/config"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// eventHeader is the first row of every event csv file
+var eventHeader = []string{"block", "txHash", "staker", "value", "periods", "timestamp"}
+
+// DepositEvent is a deposit event found by the listener, Time is the zero time when the block time is unknown
+type DepositEvent struct {
+	Block   *big.Int
+	TxHash  common.Hash
+	Staker  common.Address
+	Value   *big.Int
+	Periods *big.Int
+	Time    time.Time
+}
+
+// record returns the csv row of e
+func (e DepositEvent) record() []string {
+	timestamp := ""
+	if !e.Time.IsZero() {
+		timestamp = e.Time.UTC().Format(time.RFC3339)
+	}
+	return []string{e.Block.String(), e.TxHash.Hex(), e.Staker.Hex(), e.Value.String(), e.Periods.String(), timestamp}
+}
+
+// EventCSV appends deposit events as csv rows to Path, reopening it per write so it can be rotated. The file
+// is moved aside before a write that would grow it beyond MaxBytes and, with Daily, before the first write
+// of a new UTC day.
+type EventCSV struct {
+	Path     string
+	MaxBytes int64
+	Daily    bool
+	Now      func() time.Time // time source of the rotations, time.Now if nil
+}
+
+// NewEventCSV creates the configured event export, nil if it is disabled
+func NewEventCSV(cfg config.EventExportConfig) *EventCSV {
+	if cfg.Path == "" {
+		return nil
+	}
+	return &EventCSV{Path: cfg.Path, MaxBytes: cfg.MaxBytes, Daily: cfg.Daily}
+}
+
+func (w *EventCSV) Name() string {
+	return "csv " + w.Path
+}
+
+// Write appends events to the file, creating it with the header row first
+func (w *EventCSV) Write(events []DepositEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	var rows bytes.Buffer
+	cw := csv.NewWriter(&rows)
+	for _, e := range events {
+		if err := cw.Write(e.record()); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(w.Path), os.ModePerm); err != nil {
+		return err
+	}
+	if err := w.rotate(int64(rows.Len())); err != nil {
+		return fmt.Errorf("failed to rotate %s: %w", w.Path, err)
+	}
+	fp, err := os.OpenFile(w.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := fp.Stat()
+	if err != nil {
+		fp.Close()
+		return err
+	}
+	if fi.Size() == 0 {
+		header := csv.NewWriter(fp)
+		header.Write(eventHeader)
+		header.Flush()
+		if err := header.Error(); err != nil {
+			fp.Close()
+			return err
+		}
+	}
+	if _, err := fp.Write(rows.Bytes()); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
+
+// rotate moves the file aside when appending n more bytes would exceed MaxBytes or, with Daily, when it was
+// last written on an earlier UTC day. A file holding no more than the header is never rotated.
+func (w *EventCSV) rotate(n int64) error {
+	fi, err := os.Stat(w.Path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fi.Size() <= int64(len(strings.Join(eventHeader, ","))+1) {
+		return nil
+	}
+	now := w.clock().UTC()
+	full := w.MaxBytes > 0 && fi.Size()+n > w.MaxBytes
+	if !full && !(w.Daily && !sameDay(fi.ModTime().UTC(), now)) {
+		return nil
+	}
+
+	ext := filepath.Ext(w.Path)
+	base := strings.TrimSuffix(w.Path, ext) + "-" + now.Format("20060102T150405")
+	rotated := base + ext
+	for i := 1; ; i++ {
+		if _, err := os.Stat(rotated); os.IsNotExist(err) {
+			break
+		}
+		rotated = fmt.Sprintf("%s.%d%s", base, i, ext)
+	}
+	return os.Rename(w.Path, rotated)
+}
+
+func (w *EventCSV) clock() time.Time {
+	if w.Now != nil {
+		return w.Now()
+	}
+	return time.Now()
+}
+
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
@@ -0,0 +1,114 @@
+package sink
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NuLink-network/watcher/watcher/config"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func testEvent(block int64, t time.Time) DepositEvent {
+	return DepositEvent{
+		Block:   big.NewInt(block),
+		TxHash:  common.HexToHash("0xab"),
+		Staker:  common.HexToAddress("0x01"),
+		Value:   new(big.Int).Lsh(big.NewInt(1), 100),
+		Periods: big.NewInt(3),
+		Time:    t,
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestEventCSV_Write(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reports", "deposits.csv")
+	w := NewEventCSV(config.EventExportConfig{Path: path})
+	at := time.Date(2022, 3, 4, 5, 6, 7, 0, time.FixedZone("CET", 3600))
+	if err := w.Write([]DepositEvent{testEvent(1000, at), testEvent(1001, time.Time{})}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write([]DepositEvent{testEvent(1002, at)}); err != nil {
+		t.Fatal(err)
+	}
+	row := ",0x00000000000000000000000000000000000000000000000000000000000000ab,0x0000000000000000000000000000000000000001,1267650600228229401496703205376,3,"
+	want := "block,txHash,staker,value,periods,timestamp\n" +
+		"1000" + row + "2022-03-04T04:06:07Z\n" +
+		"1001" + row + "\n" +
+		"1002" + row + "2022-03-04T04:06:07Z\n"
+	if got := readFile(t, path); got != want {
+		t.Errorf("csv =\n%s\nwant\n%s", got, want)
+	}
+	if NewEventCSV(config.EventExportConfig{}) != nil {
+		t.Error("NewEventCSV() without a path should disable the export")
+	}
+}
+
+func TestEventCSV_rotate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "deposits.csv")
+	// the daily rotation compares with the modification time of the file, so the clock stays real
+	now := time.Now().UTC()
+	w := &EventCSV{Path: path, MaxBytes: 400, Daily: true, Now: func() time.Time { return now }}
+	event := testEvent(1000, now)
+	rotated := func() []string {
+		t.Helper()
+		files, err := filepath.Glob(filepath.Join(dir, "deposits-*.csv"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return files
+	}
+
+	// header and two rows fit into maxBytes, the third row doesn't
+	for i := 0; i < 2; i++ {
+		if err := w.Write([]DepositEvent{event}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if files := rotated(); len(files) != 0 {
+		t.Fatalf("rotated %v below maxBytes", files)
+	}
+	if err := w.Write([]DepositEvent{event}); err != nil {
+		t.Fatal(err)
+	}
+	files := rotated()
+	want := "deposits-" + now.Format("20060102T150405") + ".csv"
+	if len(files) != 1 || filepath.Base(files[0]) != want {
+		t.Fatalf("rotated files = %v, want %s", files, want)
+	}
+	if got := readFile(t, path); got != "block,txHash,staker,value,periods,timestamp\n"+strings.Join(event.record(), ",")+"\n" {
+		t.Errorf("csv after rotation =\n%s", got)
+	}
+
+	// a file last written the day before is rotated at the first write of the day, next to the earlier one
+	// rotated in the same second
+	yesterday := now.Add(-24 * time.Hour)
+	if err := os.Chtimes(path, yesterday, yesterday); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Write([]DepositEvent{event}); err != nil {
+		t.Fatal(err)
+	}
+	if files := rotated(); len(files) != 2 {
+		t.Errorf("rotated files = %v, want a second one", files)
+	}
+	if err := w.Write([]DepositEvent{event}); err != nil {
+		t.Fatal(err)
+	}
+	if files := rotated(); len(files) != 2 {
+		t.Errorf("rotated files = %v, want no rotation within the day", files)
+	}
+}
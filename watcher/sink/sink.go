@@ -0,0 +1,137 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// Staker is the published form of a StakeInfo
+type Staker struct {
+	Coinbase      hexutil.Bytes `json:"coinbase"`
+	WorkBase      hexutil.Bytes `json:"workBase"`
+	IsWork        bool          `json:"isWork"`
+	LockedBalance string        `json:"lockedBalance"`
+}
+
+// Update is the stake info set submitted for an epoch
+type Update struct {
+	Epoch   uint64    `json:"epoch"`
+	Block   *big.Int  `json:"block"`
+	Stakers []Staker  `json:"stakers"`
+	Time    time.Time `json:"time"`
+}
+
+// NewUpdate converts the stake infos submitted at block of epoch at time now
+func NewUpdate(epoch uint64, block *big.Int, infos substrate.StakeInfos, now time.Time) Update {
+	u := Update{Epoch: epoch, Block: block, Stakers: make([]Staker, 0, len(infos)), Time: now.UTC()}
+	for _, info := range infos {
+		balance := "0"
+		if info.LockedBalance.Int != nil {
+			balance = info.LockedBalance.String()
+		}
+		u.Stakers = append(u.Stakers, Staker{
+			Coinbase:      info.Coinbase[:],
+			WorkBase:      info.WorkBase,
+			IsWork:        info.IsWork,
+			LockedBalance: balance,
+		})
+	}
+	return u
+}
+
+// Sink receives the stake info set of every epoch next to the submission to the NuLink chain, which stays
+// the primary output
+type Sink interface {
+	Name() string
+	Publish(ctx context.Context, u Update) error
+}
+
+// HTTP posts every Update as json to URL, e.g. to a Kafka REST proxy or a NATS bridge
+type HTTP struct {
+	URL     string
+	Headers map[string]string
+	Client  *http.Client
+}
+
+func (h *HTTP) Name() string {
+	return "http " + h.URL
+}
+
+func (h *HTTP) Publish(ctx context.Context, u Update) error {
+	body, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range h.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("sink returned %s", resp.Status)
+	}
+	return nil
+}
+
+// File appends every Update as a json line to Path, reopening it per update so it can be rotated
+type File struct {
+	Path string
+}
+
+func (f *File) Name() string {
+	return "file " + f.Path
+}
+
+func (f *File) Publish(ctx context.Context, u Update) error {
+	line, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f.Path), os.ModePerm); err != nil {
+		return err
+	}
+	fp, err := os.OpenFile(f.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := fp.Write(append(line, '\n')); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
+
+// FromConfig creates the configured sinks
+func FromConfig(cfgs []config.SinkConfig) ([]Sink, error) {
+	sinks := make([]Sink, 0, len(cfgs))
+	for i, c := range cfgs {
+		switch c.Type {
+		case config.SinkHTTP:
+			sinks = append(sinks, &HTTP{URL: c.URL, Headers: c.Headers, Client: &http.Client{Timeout: c.Timeout.Duration}})
+		case config.SinkFile:
+			sinks = append(sinks, &File{Path: c.Path})
+		default:
+			return nil, fmt.Errorf("unknown type %q of sink %d", c.Type, i)
+		}
+	}
+	return sinks, nil
+}
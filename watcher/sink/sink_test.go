@@ -0,0 +1,86 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NuLink-network/watcher/watcher/chains/substrate"
+	"github.com/NuLink-network/watcher/watcher/config"
+	"github.com/centrifuge/go-substrate-rpc-client/v4/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func testUpdate() Update {
+	staker := common.HexToAddress("0x01")
+	return NewUpdate(3, big.NewInt(3000), substrate.StakeInfos{{
+		Coinbase:      substrate.EthAddrToAccountID(staker),
+		WorkBase:      staker[:],
+		IsWork:        true,
+		LockedBalance: types.NewU128(*big.NewInt(7)),
+	}}, time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+}
+
+func TestHTTP_Publish(t *testing.T) {
+	var got Update
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	sinks, err := FromConfig([]config.SinkConfig{{Type: config.SinkHTTP, URL: srv.URL, Headers: map[string]string{"Authorization": "Bearer x"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sinks[0].Publish(context.Background(), testUpdate()); err != nil {
+		t.Fatal(err)
+	}
+	if got.Epoch != 3 || len(got.Stakers) != 1 || got.Stakers[0].LockedBalance != "7" || auth != "Bearer x" {
+		t.Errorf("posted %+v with authorization %q", got, auth)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	h := &HTTP{URL: failing.URL, Client: http.DefaultClient}
+	if err := h.Publish(context.Background(), testUpdate()); err == nil {
+		t.Errorf("Publish() to a failing endpoint succeeded")
+	}
+}
+
+func TestFile_Publish(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sink", "updates.jsonl")
+	f := &File{Path: path}
+	for i := 0; i < 2; i++ {
+		if err := f.Publish(context.Background(), testUpdate()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fp, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fp.Close()
+	var lines int
+	for s := bufio.NewScanner(fp); s.Scan(); lines++ {
+		var u Update
+		if err := json.Unmarshal(s.Bytes(), &u); err != nil || u.Block.Int64() != 3000 {
+			t.Errorf("line %d = %s, %v", lines, s.Text(), err)
+		}
+	}
+	if lines != 2 {
+		t.Errorf("file holds %d updates, want 2", lines)
+	}
+}